@@ -21,20 +21,117 @@
 package common
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/lib/netext"
 	"github.com/loadimpact/k6/stats"
 )
 
 // Provides volatile state for a VU.
 type State struct {
+	// Current options.
+	Options lib.Options
+
+	// ID of the VU this state belongs to.
+	VUID int64
+
 	// Current group; all emitted metrics are tagged with this.
 	Group *lib.Group
 
 	// Networking equipment.
 	HTTPTransport http.RoundTripper
+	Dialer        *netext.Dialer
+
+	// Wrapped around transports made on the fly, eg. for pinned connections.
+	Middleware []netext.Middleware
+
+	// Per-host connection efficiency, shared between VUs; nil if disabled.
+	Efficiency *stats.EfficiencyAggregator
+
+	// Each VU's longest wait for a connection, shared between VUs.
+	Fairness *stats.FairnessAggregator
+
+	// Per-host request duration baselines, shared between VUs; nil if disabled.
+	Baseline *stats.BaselineAggregator
+
+	// Correlations between request phases, shared between VUs; nil if disabled.
+	Correlation *stats.CorrelationAggregator
+
+	// Request durations by tags, shared between VUs; nil if disabled.
+	TagGroups *stats.TagGroupAggregator
+
+	// Per-host availability, shared between VUs; nil if disabled.
+	Availability *stats.AvailabilityAggregator
+
+	// Each connection's requests, shared between VUs; nil if disabled.
+	ConnTimelines *netext.ConnTimelineAggregator
+
+	// Caps concurrent TLS handshakes, shared between VUs; nil if there's no cap.
+	Handshakes *netext.HandshakeLimiter
+
+	// Every request's Trail is published to this; nil if nobody could be listening.
+	Trails *netext.TrailStream
+
+	// Filters the tags of published Trails; nil if they're let through as they are.
+	TagFilter *stats.TagFilter
+
+	// Tags every HTTP sample gets, unless it has its own; see Options.SampleBaseTags.
+	BaseTags map[string]string
+
+	// Rounds the timings of HTTP samples; nil to leave them be.
+	Rounding netext.DurationRounding
+
+	// Metrics' aggregates so far, for the script to read; nil outside of a test run.
+	Metrics lib.MetricReader
 
 	// Sample buffer, emitted at the end of the iteration.
 	Samples []stats.Sample
+
+	// Work the iteration left running in the background, eg. requests sent with
+	// http.requestAsync(); see SettlePending.
+	Pending []Pending
+}
+
+// Pending is work running alongside a VU, that emits samples of its own.
+type Pending interface {
+	// Settle waits for the work to be done, or cancels it and waits for that if ctx is
+	// done first; it returns the samples it's emitted that weren't already collected.
+	Settle(ctx context.Context) []stats.Sample
+}
+
+// PendingFunc lets a func be used as Pending.
+type PendingFunc func(ctx context.Context) []stats.Sample
+
+func (f PendingFunc) Settle(ctx context.Context) []stats.Sample {
+	return f(ctx)
+}
+
+// SettlePending settles the work left running in the background, adding its samples to
+// the buffer; an iteration must do so before it ends, so none of it outlives it.
+func (s *State) SettlePending(ctx context.Context) {
+	for _, p := range s.Pending {
+		s.Samples = append(s.Samples, p.Settle(ctx)...)
+	}
+	s.Pending = nil
+}
+
+// Fork returns a state for work running alongside the VU, eg. a request sent in the
+// background. It has its own sample buffer, and copies of the top-level options, the
+// group, base tags and middleware as they are now, so either side can change them
+// without the other seeing it; the aggregators, dialer and transport are shared, and safe
+// for concurrent use.
+func (s *State) Fork() *State {
+	fork := *s
+	fork.Samples = nil
+	fork.Pending = nil
+	if s.BaseTags != nil {
+		fork.BaseTags = make(map[string]string, len(s.BaseTags))
+		for k, v := range s.BaseTags {
+			fork.BaseTags[k] = v
+		}
+	}
+	fork.Middleware = append([]netext.Middleware(nil), s.Middleware...)
+	return &fork
 }
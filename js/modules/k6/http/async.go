@@ -0,0 +1,119 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package http
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dop251/goja"
+	"github.com/loadimpact/k6/js/common"
+	"github.com/loadimpact/k6/stats"
+	"gopkg.in/guregu/null.v3"
+)
+
+// An HTTPPendingRequest is a request sent with http.requestAsync(), which is sent in the
+// background while the script goes on, eg. to send a few at once; wait() blocks until
+// its response is in. The response always has its trail, as if the responseTrail option
+// was set, as concurrent requests' timings are usually what it's for.
+//
+// There's no event loop for it to be resolved on, so then() is like a promise's, except
+// that it waits for the response there and then. The request's samples are emitted once
+// it's been waited for; one that isn't is settled at the end of the iteration, and
+// cancelled if the VU is stopped first (see common.State.SettlePending).
+type HTTPPendingRequest struct {
+	rt     *goja.Runtime
+	state  *common.State
+	cancel context.CancelFunc
+
+	// The request's own state, that it emits samples to; nil once they've been collected.
+	sub *common.State
+
+	done chan struct{}
+	res  *HTTPResponse
+	err  error
+}
+
+func newHTTPPendingRequest(rt *goja.Runtime, state *common.State, cancel context.CancelFunc, send func(*common.State) (*HTTPResponse, error)) *HTTPPendingRequest {
+	sub := state.Fork()
+	sub.Options.ResponseTrail = null.BoolFrom(true)
+
+	p := &HTTPPendingRequest{rt: rt, state: state, cancel: cancel, sub: sub, done: make(chan struct{})}
+	go func() {
+		p.res, p.err = send(sub)
+		close(p.done)
+	}()
+	state.Pending = append(state.Pending, common.PendingFunc(p.settle))
+	return p
+}
+
+// Done returns whether the response is in, without waiting for it.
+func (p *HTTPPendingRequest) Done() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait waits for the response, and returns it; throws if the request failed.
+func (p *HTTPPendingRequest) Wait() (*HTTPResponse, error) {
+	<-p.done
+	p.state.Samples = append(p.state.Samples, p.collect()...)
+	return p.res, p.err
+}
+
+// Waits for the response at the end of the iteration, unless ctx is done first, and
+// returns the samples that weren't collected by Wait. Unexported, so scripts can't call it.
+func (p *HTTPPendingRequest) settle(ctx context.Context) []stats.Sample {
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		p.cancel()
+		<-p.done
+	}
+	return p.collect()
+}
+
+// Returns the request's samples, once it's done, unless they've already been collected.
+func (p *HTTPPendingRequest) collect() []stats.Sample {
+	p.cancel()
+	if p.sub == nil {
+		return nil
+	}
+	samples := p.sub.Samples
+	p.sub = nil
+	return samples
+}
+
+// Then waits for the response, and returns what fn does when called with it.
+func (p *HTTPPendingRequest) Then(fn goja.Value) (goja.Value, error) {
+	call, ok := goja.AssertFunction(fn)
+	if !ok {
+		return nil, errors.New("then() needs a function")
+	}
+	res, err := p.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return call(goja.Undefined(), p.rt.ToValue(res))
+}
@@ -24,28 +24,129 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	neturl "net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"reflect"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/dop251/goja"
 	"github.com/loadimpact/k6/js/common"
 	"github.com/loadimpact/k6/js/modules/k6/html"
+	"github.com/loadimpact/k6/lib/metrics"
 	"github.com/loadimpact/k6/lib/netext"
 	"github.com/loadimpact/k6/stats"
 )
 
+// Tags taken from response headers are capped to this many, to bound their cardinality.
+const maxResponseHeaderTags = 10
+
 type HTTPResponseTimings struct {
 	Duration, Blocked, LookingUp, Connecting, Sending, Waiting, Receiving float64
 }
 
+// The whole of a response's Trail, for scripts that want more than its Timings; times are
+// in milliseconds, like theirs. See netext.Trail for what each field means.
+type HTTPResponseTrail struct {
+	Duration, Blocked, LookingUp, Connecting, TLSHandshaking, TLSQueued, ProxyHandshake float64
+	Sending, Waiting, Receiving, StreamBlocked, PreWrite, ExpectContinue                float64
+	ScriptReadDelay, SerializationTime, DecompressionTime                               float64
+
+	BytesRead, BytesWritten, TLSBytesRead, TLSBytesWritten, DecompressedBytes int64
+
+	ConnReused, ConnWarmed    bool
+	ConnID                    uint64
+	ConnRemoteAddr            string
+	Proto, NegotiatedProtocol string
+
+	// Named like the JSON output's keys; the default would mangle the digits.
+	QUIC0RTT      bool `js:"quic_0rtt"`
+	HTTP3Fallback bool `js:"http3_fallback"`
+
+	RedirectCount, RetryCount int
+
+	// Nil unless the phaseTimestamps option is set.
+	PhaseTimestamps *HTTPPhaseTimestamps
+}
+
+// A Trail's PhaseTimestamps, as Unix times in milliseconds like Date.now()'s, but with
+// the fraction; zero for phases that never happened.
+type HTTPPhaseTimestamps struct {
+	GetConn, GotConn, ConnectStart, ConnectDone, WroteRequest, GotFirstResponseByte, Done float64
+}
+
+func newHTTPPhaseTimestamps(ts *netext.PhaseTimestamps) *HTTPPhaseTimestamps {
+	millis := func(t time.Time) float64 {
+		if t.IsZero() {
+			return 0
+		}
+		return float64(t.UnixNano()) / float64(time.Millisecond)
+	}
+	return &HTTPPhaseTimestamps{
+		GetConn:              millis(ts.GetConn),
+		GotConn:              millis(ts.GotConn),
+		ConnectStart:         millis(ts.ConnectStart),
+		ConnectDone:          millis(ts.ConnectDone),
+		WroteRequest:         millis(ts.WroteRequest),
+		GotFirstResponseByte: millis(ts.GotFirstResponseByte),
+		Done:                 millis(ts.Done),
+	}
+}
+
+func newHTTPResponseTrail(tr netext.Trail) *HTTPResponseTrail {
+	res := &HTTPResponseTrail{
+		Duration:          stats.D(tr.Duration),
+		Blocked:           stats.D(tr.Blocked),
+		LookingUp:         stats.D(tr.LookingUp),
+		Connecting:        stats.D(tr.Connecting),
+		TLSHandshaking:    stats.D(tr.TLSHandshaking),
+		TLSQueued:         stats.D(tr.TLSQueued),
+		ProxyHandshake:    stats.D(tr.ProxyHandshake),
+		Sending:           stats.D(tr.Sending),
+		Waiting:           stats.D(tr.Waiting),
+		Receiving:         stats.D(tr.Receiving),
+		StreamBlocked:     stats.D(tr.StreamBlocked),
+		PreWrite:          stats.D(tr.PreWrite),
+		ExpectContinue:    stats.D(tr.ExpectContinue),
+		ScriptReadDelay:   stats.D(tr.ScriptReadDelay),
+		SerializationTime: stats.D(tr.SerializationTime),
+		DecompressionTime: stats.D(tr.DecompressionTime),
+
+		BytesRead:         tr.BytesRead,
+		BytesWritten:      tr.BytesWritten,
+		TLSBytesRead:      tr.TLSBytesRead,
+		TLSBytesWritten:   tr.TLSBytesWritten,
+		DecompressedBytes: tr.DecompressedBytes,
+
+		ConnReused:         tr.ConnReused,
+		ConnWarmed:         tr.ConnWarmed,
+		QUIC0RTT:           tr.QUIC0RTT,
+		HTTP3Fallback:      tr.HTTP3Fallback,
+		ConnID:             tr.ConnID,
+		Proto:              tr.Proto,
+		NegotiatedProtocol: tr.NegotiatedProtocol,
+
+		RedirectCount: tr.RedirectCount,
+		RetryCount:    tr.RetryCount,
+	}
+	if tr.ConnRemoteAddr != nil {
+		res.ConnRemoteAddr = tr.ConnRemoteAddr.String()
+	}
+	if tr.PhaseTimestamps != nil {
+		res.PhaseTimestamps = newHTTPPhaseTimestamps(tr.PhaseTimestamps)
+	}
+	return res
+}
+
 type HTTPResponse struct {
 	ctx context.Context
 
@@ -57,6 +158,12 @@ type HTTPResponse struct {
 	Body       string
 	Timings    HTTPResponseTimings
 
+	// Set if the request was pinned; pass it as a follow-up's connection to reuse this one.
+	Connection *netext.Pin
+
+	// Set if the responseTrail option is.
+	Trail *HTTPResponseTrail
+
 	cachedJSON goja.Value
 }
 
@@ -85,8 +192,32 @@ func (res *HTTPResponse) Html(selector ...string) html.Selection {
 type HTTP struct{}
 
 func (*HTTP) Request(ctx context.Context, method, url string, args ...goja.Value) (*HTTPResponse, error) {
+	send, err := prepareRequest(ctx, method, url, args...)
+	if err != nil {
+		return nil, err
+	}
+	return send(common.GetState(ctx))
+}
+
+// RequestAsync sends a request like Request, but in the background; see HTTPPendingRequest.
+func (*HTTP) RequestAsync(ctx context.Context, method, url string, args ...goja.Value) (map[string]interface{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	send, err := prepareRequest(ctx, method, url, args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	rt := common.GetRuntime(ctx)
+	return common.Bind(rt, newHTTPPendingRequest(rt, common.GetState(ctx), cancel, send), nil), nil
+}
+
+// Turns a request's arguments into an http.Request, and returns a func that sends it and
+// emits its samples to state. Only this part touches the JS runtime, so that the request
+// can be sent off of it, in the background.
+func prepareRequest(ctx context.Context, method, url string, args ...goja.Value) (func(state *common.State) (*HTTPResponse, error), error) {
 	rt := common.GetRuntime(ctx)
 	state := common.GetState(ctx)
+	serializeStart := time.Now()
 
 	var bodyReader io.Reader
 	var contentType string
@@ -116,9 +247,24 @@ func (*HTTP) Request(ctx context.Context, method, url string, args ...goja.Value
 		"status": "0",
 		"method": method,
 		"url":    url,
+		"name":   url,
 		"group":  state.Group.Path,
 	}
 
+	var timeout, readTimeout, writeTimeout time.Duration
+	var budget netext.Budget
+	var logBudget bool
+	var pin *netext.Pin
+	var priority *netext.Priority
+	var bodyFile string
+	var metricPrefix string
+	var retry netext.RetryPolicy
+	var hedge netext.HedgePolicy
+	var warmup bool
+	redirects := &netext.RedirectLimiter{Max: netext.DefaultMaxRedirects}
+	if state.Options.MaxRedirects.Valid {
+		redirects.Max = int(state.Options.MaxRedirects.Int64)
+	}
 	if len(args) > 1 {
 		paramsV := args[1]
 		if !goja.IsUndefined(paramsV) && !goja.IsNull(paramsV) {
@@ -149,56 +295,559 @@ func (*HTTP) Request(ctx context.Context, method, url string, args ...goja.Value
 					for _, key := range tagObj.Keys() {
 						tags[key] = tagObj.Get(key).String()
 					}
+				case "name":
+					// Groups requests to eg. "/users/{id}" under one name, whatever the ID.
+					nameV := params.Get(k)
+					if goja.IsUndefined(nameV) || goja.IsNull(nameV) {
+						continue
+					}
+					tags["name"] = nameV.String()
+				case "timeout":
+					timeoutV := params.Get(k)
+					if goja.IsUndefined(timeoutV) || goja.IsNull(timeoutV) {
+						continue
+					}
+					timeout = time.Duration(timeoutV.ToFloat() * float64(time.Millisecond))
+				case "budget":
+					budgetV := params.Get(k)
+					if goja.IsUndefined(budgetV) || goja.IsNull(budgetV) {
+						continue
+					}
+					budgetObj := budgetV.ToObject(rt)
+					if budgetObj == nil {
+						continue
+					}
+					for _, key := range budgetObj.Keys() {
+						v := budgetObj.Get(key)
+						d := time.Duration(v.ToFloat() * float64(time.Millisecond))
+						switch key {
+						case "duration":
+							budget.Duration = d
+						case "blocked":
+							budget.Blocked = d
+						case "connecting":
+							budget.Connecting = d
+						case "sending":
+							budget.Sending = d
+						case "waiting":
+							budget.Waiting = d
+						case "receiving":
+							budget.Receiving = d
+						case "log":
+							logBudget = v.ToBoolean()
+						}
+					}
+				case "redirects":
+					redirectsV := params.Get(k)
+					if goja.IsUndefined(redirectsV) || goja.IsNull(redirectsV) {
+						continue
+					}
+					redirects.Max = int(redirectsV.ToInteger())
+				case "pin":
+					if params.Get(k).ToBoolean() && pin == nil {
+						pin = netext.NewPin(state.Dialer)
+					}
+				case "connection":
+					connV := params.Get(k)
+					if goja.IsUndefined(connV) || goja.IsNull(connV) {
+						continue
+					}
+					p, ok := connV.Export().(*netext.Pin)
+					if !ok {
+						return nil, errors.New("connection must be a response's connection")
+					}
+					pin = p
+				case "metricPrefix":
+					// Records the request under eg. "warmup_http_req_duration", for phases of a test.
+					prefixV := params.Get(k)
+					if goja.IsUndefined(prefixV) || goja.IsNull(prefixV) {
+						continue
+					}
+					metricPrefix = prefixV.String()
+				case "priority":
+					// An urgency (0-7), or a whole Priority header value, eg. "u=1, i".
+					priorityV := params.Get(k)
+					if goja.IsUndefined(priorityV) || goja.IsNull(priorityV) {
+						continue
+					}
+					var p netext.Priority
+					if s, ok := priorityV.Export().(string); ok {
+						if p, err = netext.ParsePriority(s); err != nil {
+							return nil, err
+						}
+					} else {
+						p.Urgency = int(priorityV.ToInteger())
+						if err := p.Validate(); err != nil {
+							return nil, err
+						}
+					}
+					priority = &p
+				case "warmup":
+					// Opens a connection ahead of the test proper; see netext.WithWarmup.
+					warmup = params.Get(k).ToBoolean()
+				case "bodyFile":
+					// Streams the body from a file, instead of taking it as an argument.
+					fileV := params.Get(k)
+					if goja.IsUndefined(fileV) || goja.IsNull(fileV) {
+						continue
+					}
+					if bodyReader != nil {
+						return nil, errors.New("a request can't have both a body and a bodyFile")
+					}
+					bodyFile = fileV.String()
+				case "retries":
+					// Idempotent requests that get a 5xx are tried again up to this many times.
+					retriesV := params.Get(k)
+					if goja.IsUndefined(retriesV) || goja.IsNull(retriesV) {
+						continue
+					}
+					retry.Max = int(retriesV.ToInteger())
+				case "retryBackoff":
+					backoffV := params.Get(k)
+					if goja.IsUndefined(backoffV) || goja.IsNull(backoffV) {
+						continue
+					}
+					retry.Backoff = time.Duration(backoffV.ToFloat() * float64(time.Millisecond))
+				case "hedgeDelay":
+					// Idempotent requests without a response by then are sent again.
+					delayV := params.Get(k)
+					if goja.IsUndefined(delayV) || goja.IsNull(delayV) {
+						continue
+					}
+					hedge.Delay = time.Duration(delayV.ToFloat() * float64(time.Millisecond))
+				case "readTimeout", "writeTimeout":
+					// Unlike timeout, these apply to each individual read or write.
+					timeoutV := params.Get(k)
+					if goja.IsUndefined(timeoutV) || goja.IsNull(timeoutV) {
+						continue
+					}
+					d := time.Duration(timeoutV.ToFloat() * float64(time.Millisecond))
+					if k == "readTimeout" {
+						readTimeout = d
+					} else {
+						writeTimeout = d
+					}
 				}
 			}
 		}
 	}
 
-	client := http.Client{Transport: state.HTTPTransport}
-	tracer := netext.Tracer{}
-	res, err := client.Do(req.WithContext(netext.WithTracer(ctx, &tracer)))
-	if err != nil {
-		state.Samples = append(state.Samples, tracer.Done().Samples(tags)...)
-		return nil, err
+	// Everything up to here turned the script's values into a request; what's left may run
+	// a while later, in the background, which would only add scheduling delays to this.
+	serialization := time.Since(serializeStart)
+	if serialization < netext.SerializationResolution {
+		serialization = 0
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		state.Samples = append(state.Samples, tracer.Done().Samples(tags)...)
-		return nil, err
-	}
-	_ = res.Body.Close()
-	trail := tracer.Done()
+	return func(state *common.State) (*HTTPResponse, error) {
+		if bodyFile != "" {
+			body, err := netext.OpenFileBody(bodyFile)
+			if err != nil {
+				return nil, err
+			}
+			defer func() { _ = body.Close() }()
+			body.Attach(req)
+		}
 
-	tags["status"] = strconv.Itoa(res.StatusCode)
-	state.Samples = append(state.Samples, trail.Samples(tags)...)
+		// Offer gzip, like the transport would if left to it, but decompress it here instead,
+		// where that can be timed; see Trail.DecompressionTime.
+		decompress := req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" && req.Method != "HEAD"
+		if decompress {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+
+		// A body of unknown length is sent chunked; this affects how send timings read.
+		chunked := req.ContentLength <= 0 && req.Body != nil && req.Body != http.NoBody
+		for _, te := range req.TransferEncoding {
+			if te == "chunked" {
+				chunked = true
+			}
+		}
+		if chunked {
+			tags["chunked"] = "true"
+		}
+		if priority != nil {
+			priority.Apply(req)
+			tags["priority"] = strconv.Itoa(priority.Urgency)
+		}
+		// Unless the script propagates its own.
+		var traceContext netext.TraceContext
+		if state.Options.TraceContext.Bool && req.Header.Get("traceparent") == "" {
+			traceContext = netext.NewTraceContext()
+			traceContext.Apply(req)
+		}
+
+		reqCtx := ctx
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			reqCtx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		if warmup {
+			reqCtx = netext.WithWarmup(reqCtx)
+			tags["warmup"] = "true"
+		}
+
+		transport := state.HTTPTransport
+		if pin != nil {
+			transport = netext.Chain(pin, state.Middleware...)
+			tags["pinned"] = "true"
+		}
+
+		// Trails of tries that got retried; they're merged into the last one's.
+		var retried []netext.Trail
+
+		// Whether the last try was hedged, and which request won it.
+		var hedged netext.Hedge
 
-	headers := make(map[string]string, len(res.Header))
-	for k, vs := range res.Header {
-		headers[k] = strings.Join(vs, ", ")
-	}
-	remoteHost, remotePortStr, _ := net.SplitHostPort(trail.ConnRemoteAddr.String())
-	remotePort, _ := strconv.Atoi(remotePortStr)
-	return &HTTPResponse{
-		ctx: ctx,
-
-		RemoteIP:   remoteHost,
-		RemotePort: remotePort,
-		URL:        res.Request.URL.String(),
-		Status:     res.StatusCode,
-		Headers:    headers,
-		Body:       string(body),
-		Timings: HTTPResponseTimings{
-			Duration:   stats.D(trail.Duration),
-			Blocked:    stats.D(trail.Blocked),
-			Connecting: stats.D(trail.Connecting),
-			Sending:    stats.D(trail.Sending),
-			Waiting:    stats.D(trail.Waiting),
-			Receiving:  stats.D(trail.Receiving),
-		},
+		// res is nil if the request didn't get a response.
+		emit := func(trail netext.Trail, res *http.Response) {
+			if len(retried) > 0 {
+				trail = netext.MergeTrails(append(retried, trail))
+			}
+			trail.Method, trail.URL = req.Method, req.URL.String()
+			if res != nil && res.Request != nil {
+				trail.Method, trail.URL = res.Request.Method, res.Request.URL.String()
+			}
+			if res != nil {
+				trail.Proto = netext.ProtoOf(res)
+			}
+			if priority != nil {
+				trail.Priority = priority.String()
+			}
+			trail.TraceContext = traceContext
+			trail.SerializationTime = serialization
+			trail.RedirectCount = redirects.Count
+			trail.RedirectLimitHit = redirects.LimitHit
+			trail.Hedged, trail.HedgeWon = hedged.Sent, hedged.Won
+			if pin != nil && pin.Bind(trail.ConnID) {
+				// Not an error; the backend will likely just see a new session.
+				tags["pin_lost"] = "true"
+			}
+			trail.OmitReusedConnTimings = state.Options.OmitReusedConnTimings.Bool
+			trail.EmitSetup = state.Options.SetupTiming.Bool
+			trail.MetricPrefix = metricPrefix
+			trail.BaseTags = state.BaseTags
+			trail.Rounding = state.Rounding
+			if trail.ALPNFallback {
+				tags["alpn_fallback"] = "true"
+			}
+			if trail.ServerCipherOverride {
+				tags["server_cipher_override"] = "true"
+			}
+			if trail.TCPFastOpen {
+				tags["tcp_fast_open"] = "true"
+			}
+			if trail.QUIC0RTT {
+				tags["quic_0rtt"] = "true"
+			}
+			if trail.HTTP3Fallback {
+				tags["http3_fallback"] = "true"
+			}
+			if trail.FramingAnomaly {
+				tags["framing_anomaly"] = trail.FramingAnomalyReason
+			}
+			if buckets := state.Options.SizeBuckets; len(buckets) > 0 {
+				tags["size_bucket"] = buckets.Bucket(trail.BytesRead)
+			}
+			if logBudget && len(trail.BudgetExceeded) > 0 {
+				log.WithFields(log.Fields{
+					"url":    url,
+					"phases": strings.Join(trail.BudgetExceeded, ","),
+				}).Warn("Request went over its timing budget")
+			}
+			failed := 1.0
+			if netext.IsExpectedResponse(res, trail) {
+				failed = 0
+			}
+			if state.Options.DetailOnlyFailed.Bool && failed == 0 && len(trail.BudgetExceeded) == 0 {
+				trail.CountsOnly = true
+			}
+			emitTrail(state, req.URL.Host, trail, tags)
+
+			state.Samples = append(state.Samples, stats.Sample{Metric: metrics.Prefixed(metricPrefix, metrics.HTTPReqFailed), Time: trail.EndTime, Tags: netext.MergeTags(trail.BaseTags, tags), Value: failed})
+			if state.Availability != nil {
+				state.Samples = append(state.Samples, state.Availability.Add(req.URL.Host, failed == 0, trail.EndTime))
+			}
+		}
+
+		client := http.Client{Transport: transport, CheckRedirect: redirects.CheckRedirect}
+		// Each try gets a Tracer of its own.
+		newTracer := func() *netext.Tracer {
+			return &netext.Tracer{
+				ReadTimeout:      readTimeout,
+				WriteTimeout:     writeTimeout,
+				Budget:           budget,
+				OfferedProtocols: offeredProtocols(transport),
+
+				SampleSocketQueues:    state.Options.SocketQueues.Bool,
+				DetectPMTUDBlackholes: state.Options.DetectPMTUDBlackholes.Bool,
+				MeasurePoolLookup:     state.Options.PoolLookup.Bool,
+				MeasurePreWrite:       state.Options.PreWrite.Bool,
+				RecordPhaseTimestamps: state.Options.PhaseTimestamps.Bool,
+				Handshakes:            state.Handshakes,
+			}
+		}
+		tracer := newTracer()
+		clientRedirects := redirects
+		do := func() (*http.Response, error) {
+			if hedge.Delay <= 0 {
+				return client.Do(req.WithContext(netext.WithTracer(reqCtx, tracer)))
+			}
+			// The hedge follows redirects of its own; the winner's are the ones that count.
+			redirects = clientRedirects
+			hedgeRedirects := &netext.RedirectLimiter{Max: redirects.Max}
+			hedgeClient := http.Client{Transport: transport, CheckRedirect: hedgeRedirects.CheckRedirect}
+			res, h, err := hedge.Do(&client, &hedgeClient, req.WithContext(reqCtx), tracer)
+			hedged, tracer = h, h.Tracer
+			if h.Won == 1 {
+				redirects = hedgeRedirects
+			}
+			return res, err
+		}
+		res, err := do()
+		for try := 1; err == nil && retry.ShouldRetry(req.Method, res.StatusCode, try); try++ {
+			tracer.GotHeaders()
+			_, _ = io.Copy(ioutil.Discard, tracer.Body(res.Body))
+			_ = res.Body.Close()
+			retried = append(retried, tracer.Done())
+			tracer = newTracer()
+
+			// If the request's deadline passes meanwhile, the next try fails right away.
+			select {
+			case <-time.After(retry.Delay(try)):
+			case <-reqCtx.Done():
+			}
+			if req.GetBody != nil {
+				if req.Body, err = req.GetBody(); err != nil {
+					return nil, err
+				}
+			}
+			res, err = do()
+		}
+		if err != nil {
+			trail := tracer.Done()
+			if trail.ErrorClass != "" {
+				tags["error"] = trail.ErrorClass
+			}
+			emit(trail, nil)
+			return nil, err
+		}
+		tracer.GotHeaders()
+
+		body, err := ioutil.ReadAll(tracer.Body(res.Body))
+		if err != nil {
+			trail := tracer.Done()
+			if trail.ErrorClass != "" {
+				tags["error"] = trail.ErrorClass
+			}
+			emit(trail, res)
+			return nil, err
+		}
+		_ = res.Body.Close()
+		trail := tracer.Done()
+		trail.RequestChunked = chunked
+
+		// Before decompression, which drops some of them.
+		if state.Options.DetectHeaderAnomalies.Bool {
+			if reason := netext.HeaderAnomaly(res.Header); reason != "" {
+				trail.HeaderAnomaly, trail.HeaderAnomalyReason = true, reason
+				tags["header_anomaly"] = reason
+			}
+		}
+
+		trail.ContentEncoding = res.Header.Get("Content-Encoding")
+		if trail.ContentEncoding != "" {
+			tags["content_encoding"] = trail.ContentEncoding
+		}
+		if decompress && netext.CanDecompress(trail.ContentEncoding) {
+			if body, trail.DecompressionTime, err = netext.Decompress(trail.ContentEncoding, body); err != nil {
+				trail.Failed = true
+				tags["error"] = "decompression_failed"
+				emit(trail, res)
+				return nil, err
+			}
+			trail.DecompressedBytes = int64(len(body))
+			// Like the transport does; these described the body as it was sent.
+			res.Header.Del("Content-Encoding")
+			res.Header.Del("Content-Length")
+		}
+
+		if state.Options.CacheStatus.Bool {
+			trail.CacheStatus = cacheStatus(res.Header)
+			tags["cache_status"] = trail.CacheStatus
+		}
+
+		// The Date header only has a resolution of one second, so anything within that is
+		// noise; it's truncated to whole seconds, leaving sub-second skew at zero.
+		if state.Options.ServerClockSkew.Bool {
+			if date, err := http.ParseTime(res.Header.Get("Date")); err == nil {
+				trail.ServerDate = date
+				trail.ServerClockSkew = date.Sub(trail.EndTime.Add(-trail.Receiving)).Truncate(time.Second)
+			}
+		}
+
+		tags["status"] = strconv.Itoa(res.StatusCode)
+		tagResponseHeaders(tags, res.Header, state.Options.ResponseHeaderTags)
+		emit(trail, res)
+
+		var resTrail *HTTPResponseTrail
+		if state.Options.ResponseTrail.Bool {
+			resTrail = newHTTPResponseTrail(trail)
+		}
+
+		headers := make(map[string]string, len(res.Header))
+		for k, vs := range res.Header {
+			headers[k] = strings.Join(vs, ", ")
+		}
+		remoteHost, remotePortStr, _ := net.SplitHostPort(trail.ConnRemoteAddr.String())
+		remotePort, _ := strconv.Atoi(remotePortStr)
+		return &HTTPResponse{
+			ctx: ctx,
+
+			RemoteIP:   remoteHost,
+			RemotePort: remotePort,
+			URL:        res.Request.URL.String(),
+			Status:     res.StatusCode,
+			Headers:    headers,
+			Body:       string(body),
+			Connection: pin,
+			Trail:      resTrail,
+			Timings: HTTPResponseTimings{
+				Duration:   stats.D(trail.Duration),
+				Blocked:    stats.D(trail.Blocked),
+				Connecting: stats.D(trail.Connecting),
+				Sending:    stats.D(trail.Sending),
+				Waiting:    stats.D(trail.Waiting),
+				Receiving:  stats.D(trail.Receiving),
+			},
+		}, nil
 	}, nil
 }
 
+// Emits samples for a finished request, and for anything that aggregates them.
+func emitTrail(state *common.State, host string, trail netext.Trail, tags map[string]string) {
+	state.Samples = append(state.Samples, trail.Samples(tags)...)
+	if state.Trails != nil && !trail.CountsOnly {
+		state.Trails.Publish(trail, state.TagFilter.Apply(tags))
+	}
+	if state.Efficiency != nil {
+		state.Efficiency.Add(host, trail.ConnReused, trail.Failed, trail.Connecting)
+		state.Samples = append(state.Samples, state.Efficiency.Tick(trail.EndTime)...)
+	}
+	if state.Dialer != nil && state.Dialer.MaxOpenConns > 0 {
+		state.Samples = append(state.Samples, stats.Sample{Metric: metrics.HTTPConnsOpen, Time: trail.EndTime, Tags: tags, Value: float64(state.Dialer.OpenConns())})
+	}
+	if state.ConnTimelines != nil {
+		state.ConnTimelines.Add(trail)
+	}
+	if state.Fairness != nil {
+		state.Fairness.Add(strconv.FormatInt(state.VUID, 10), stats.D(trail.Blocked))
+	}
+	if state.Correlation != nil && !trail.Failed {
+		state.Correlation.Add(trail.Phases()...)
+	}
+	if state.TagGroups != nil {
+		state.TagGroups.Add(stats.Sample{Metric: metrics.HTTPReqDuration, Time: trail.EndTime, Tags: tags, Value: stats.D(trail.Duration)})
+	}
+	if state.Baseline != nil && !trail.Failed {
+		duration := stats.Sample{Metric: metrics.HTTPReqDuration, Time: trail.EndTime, Tags: tags, Value: stats.D(trail.Duration)}
+		if s, ok := state.Baseline.Add(host, duration); ok {
+			state.Samples = append(state.Samples, s)
+		}
+	}
+}
+
+// Returns the ALPN protocols a transport offers; wrapped transports (see netext.Middleware)
+// can't be inspected.
+func offeredProtocols(rt http.RoundTripper) []string {
+	// HTTP/3 isn't offered with ALPN, only requests falling back from it are.
+	if h3, ok := rt.(*netext.HTTP3Transport); ok {
+		return offeredProtocols(h3.Fallback)
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return nil
+	}
+	if t.TLSClientConfig != nil && len(t.TLSClientConfig.NextProtos) > 0 {
+		return t.TLSClientConfig.NextProtos
+	}
+	// http.Transport only fills them in once it's first used; these, if it'll try HTTP/2.
+	if attemptsHTTP2(t) {
+		return []string{"h2", "http/1.1"}
+	}
+	return nil
+}
+
+// Whether t will try HTTP/2, by the same rules as http.Transport itself: custom dialers
+// or TLS configs turn it off unless ForceAttemptHTTP2 is set, and so does a non-nil but
+// empty TLSNextProto.
+func attemptsHTTP2(t *http.Transport) bool {
+	if t.TLSNextProto != nil && len(t.TLSNextProto) == 0 {
+		return false
+	}
+	if t.ForceAttemptHTTP2 {
+		return true
+	}
+	return t.TLSClientConfig == nil && t.DialContext == nil && t.DialTLSContext == nil
+}
+
+// Values of Trail.CacheStatus.
+const (
+	cacheHit     = "hit"
+	cacheMiss    = "miss"
+	cacheUnknown = "unknown"
+)
+
+// Guesses whether a response came from a CDN's cache, from the headers they commonly add:
+// Cloudflare's CF-Cache-Status, then X-Cache (CloudFront, Fastly, Varnish, Squid, ...),
+// then Age, which only caches set, to more than zero. X-Cache may list several layers of
+// caching, eg. "MISS, HIT"; the last one is the edge closest to the client, which counts.
+func cacheStatus(header http.Header) string {
+	switch strings.ToUpper(header.Get("CF-Cache-Status")) {
+	case "HIT", "STALE", "UPDATING", "REVALIDATED":
+		return cacheHit
+	case "MISS", "EXPIRED", "BYPASS", "DYNAMIC":
+		return cacheMiss
+	}
+
+	if xCache := header.Get("X-Cache"); xCache != "" {
+		layers := strings.Split(xCache, ",")
+		edge := strings.ToUpper(strings.TrimSpace(layers[len(layers)-1]))
+		switch {
+		case strings.Contains(edge, "HIT"):
+			return cacheHit
+		case strings.Contains(edge, "MISS"):
+			return cacheMiss
+		}
+	}
+
+	if age, err := strconv.Atoi(strings.TrimSpace(header.Get("Age"))); err == nil && age > 0 {
+		return cacheHit
+	}
+	return cacheUnknown
+}
+
+// Copies the values of mapped response headers into tags; missing headers are left out.
+func tagResponseHeaders(tags map[string]string, header http.Header, mapping map[string]string) {
+	names := make([]string, 0, len(mapping))
+	for name := range mapping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	n := 0
+	for _, name := range names {
+		if n >= maxResponseHeaderTags {
+			return
+		}
+		if v := header.Get(name); v != "" {
+			tags[mapping[name]] = v
+			n++
+		}
+	}
+}
+
 func (http *HTTP) Get(ctx context.Context, url string, args ...goja.Value) (*HTTPResponse, error) {
 	// The body argument is always undefined for GETs and HEADs.
 	args = append([]goja.Value{goja.Undefined()}, args...)
@@ -23,8 +23,10 @@ package http
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"testing"
@@ -37,6 +39,7 @@ import (
 	"github.com/loadimpact/k6/lib/netext"
 	"github.com/loadimpact/k6/stats"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
 )
 
 func assertRequestMetricsEmitted(t *testing.T, samples []stats.Sample, method, url string, status int, group string) {
@@ -82,15 +85,15 @@ func TestRequest(t *testing.T) {
 
 	rt := goja.New()
 	rt.SetFieldNameMapper(common.FieldNameMapper{})
+	dialer := netext.NewDialer(net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 60 * time.Second,
+		DualStack: true,
+	})
 	state := &common.State{
-		Group: root,
-		HTTPTransport: &http.Transport{
-			DialContext: (netext.NewDialer(net.Dialer{
-				Timeout:   10 * time.Second,
-				KeepAlive: 60 * time.Second,
-				DualStack: true,
-			})).DialContext,
-		},
+		Group:         root,
+		HTTPTransport: &http.Transport{DialContext: dialer.DialContext},
+		Dialer:        dialer,
 	}
 
 	ctx := context.Background()
@@ -165,6 +168,82 @@ func TestRequest(t *testing.T) {
 		assert.Error(t, err)
 	})
 
+	t.Run("ContentEncoding", func(t *testing.T) {
+		state.Samples = nil
+		_, err := common.RunString(rt, `
+		let res = http.request("GET", "https://httpbin.org/gzip");
+		if (res.json().gzipped !== true) { throw new Error("not gzipped: " + res.body); }
+		if (res.headers["Content-Encoding"] !== undefined) { throw new Error("still encoded"); }
+		`)
+		assert.NoError(t, err)
+		var decompressed bool
+		for _, sample := range state.Samples {
+			assert.Equal(t, "gzip", sample.Tags["content_encoding"])
+			if sample.Metric == metrics.HTTPReqDecompression {
+				decompressed = true
+			}
+		}
+		assert.True(t, decompressed, "no http_req_decompression sample")
+
+		state.Samples = nil
+		_, err = common.RunString(rt, `
+		let res = http.request("GET", "https://httpbin.org/deflate");
+		if (res.json().deflated !== true) { throw new Error("not deflated: " + res.body); }
+		`)
+		assert.NoError(t, err)
+		for _, sample := range state.Samples {
+			assert.Equal(t, "deflate", sample.Tags["content_encoding"])
+		}
+
+		state.Samples = nil
+		_, err = common.RunString(rt, `http.request("GET", "https://httpbin.org/get");`)
+		assert.NoError(t, err)
+		for _, sample := range state.Samples {
+			assert.NotContains(t, sample.Tags, "content_encoding")
+		}
+	})
+
+	t.Run("ServerClockSkew", func(t *testing.T) {
+		state.Options.ServerClockSkew = null.BoolFrom(true)
+		defer func() { state.Options.ServerClockSkew = null.Bool{} }()
+
+		state.Samples = nil
+		_, err := common.RunString(rt, `http.request("GET", "https://httpbin.org/get");`)
+		assert.NoError(t, err)
+		seenSkew := false
+		for _, sample := range state.Samples {
+			if sample.Metric == metrics.HTTPReqServerClockSkew {
+				seenSkew = true
+				assert.Equal(t, float64(int64(sample.Value)/1000*1000), sample.Value, "not in whole seconds")
+			}
+		}
+		assert.True(t, seenSkew, "didn't emit clock skew")
+	})
+
+	t.Run("HeaderAnomaly", func(t *testing.T) {
+		state.Options.DetectHeaderAnomalies = null.BoolFrom(true)
+		defer func() { state.Options.DetectHeaderAnomalies = null.Bool{} }()
+
+		state.Samples = nil
+		_, err := common.RunString(rt, `http.request("GET", "https://httpbin.org/response-headers?X-Custom=a&X-Custom=b");`)
+		assert.NoError(t, err)
+		for _, sample := range state.Samples {
+			assert.NotEqual(t, metrics.HTTPReqHeaderAnomaly, sample.Metric)
+		}
+
+		state.Samples = nil
+		_, err = common.RunString(rt, `http.request("GET", "https://httpbin.org/response-headers?Location=/a&Location=/b");`)
+		assert.NoError(t, err)
+		seenAnomaly := false
+		for _, sample := range state.Samples {
+			if sample.Metric == metrics.HTTPReqHeaderAnomaly {
+				seenAnomaly = true
+				assert.Equal(t, "conflicting_location", sample.Tags["header_anomaly"])
+			}
+		}
+		assert.True(t, seenAnomaly, "didn't flag the conflicting Locations")
+	})
+
 	t.Run("Params", func(t *testing.T) {
 		for _, literal := range []string{`undefined`, `null`} {
 			t.Run(literal, func(t *testing.T) {
@@ -231,6 +310,424 @@ func TestRequest(t *testing.T) {
 				}
 			})
 		})
+
+		t.Run("name", func(t *testing.T) {
+			state.Samples = nil
+			_, err := common.RunString(rt, `
+			for (let id = 1; id <= 3; id++) {
+				let res = http.request("GET", "https://httpbin.org/anything/users/" + id, null, { name: "/users/{id}" });
+				if (res.status != 200) { throw new Error("wrong status: " + res.status); }
+			}
+			`)
+			assert.NoError(t, err)
+			urls := map[string]bool{}
+			for _, sample := range state.Samples {
+				assert.Equal(t, "/users/{id}", sample.Tags["name"])
+				urls[sample.Tags["url"]] = true
+			}
+			assert.Len(t, urls, 3)
+		})
+
+		t.Run("metricPrefix", func(t *testing.T) {
+			state.Samples = nil
+			_, err := common.RunString(rt, `http.request("GET", "https://httpbin.org/get", null, { metricPrefix: "warmup_" });`)
+			assert.NoError(t, err)
+			names := map[string]bool{}
+			for _, sample := range state.Samples {
+				names[sample.Metric.Name] = true
+			}
+			assert.True(t, names["warmup_http_req_duration"])
+			assert.True(t, names["warmup_http_req_failed"])
+			assert.False(t, names["http_req_duration"])
+		})
+
+		t.Run("priority", func(t *testing.T) {
+			state.Samples = nil
+			_, err := common.RunString(rt, `
+			let res = http.request("GET", "https://httpbin.org/headers", null, { priority: "u=1, i" });
+			if (res.json().headers["Priority"] != "u=1, i") { throw new Error("wrong priority: " + res.body); }
+			http.request("GET", "https://httpbin.org/get", null, { priority: 6 });
+			`)
+			assert.NoError(t, err)
+			priorities := map[string]bool{}
+			for _, sample := range state.Samples {
+				priorities[sample.Tags["priority"]] = true
+			}
+			assert.Equal(t, map[string]bool{"1": true, "6": true}, priorities)
+
+			_, err = common.RunString(rt, `http.request("GET", "https://httpbin.org/get", null, { priority: 8 });`)
+			assert.EqualError(t, err, "GoError: priority urgency must be between 0 and 7: 8")
+		})
+
+		t.Run("detailOnlyFailed", func(t *testing.T) {
+			state.Options.DetailOnlyFailed = null.BoolFrom(true)
+			defer func() { state.Options.DetailOnlyFailed = null.Bool{} }()
+
+			// Metrics sampled per status.
+			sampled := func() map[string]map[string]bool {
+				m := map[string]map[string]bool{}
+				for _, sample := range state.Samples {
+					status := sample.Tags["status"]
+					if m[status] == nil {
+						m[status] = map[string]bool{}
+					}
+					m[status][sample.Metric.Name] = true
+				}
+				return m
+			}
+
+			state.Samples = nil
+			_, err := common.RunString(rt, `
+			http.request("GET", "https://httpbin.org/status/200");
+			http.request("GET", "https://httpbin.org/status/500");
+			`)
+			assert.NoError(t, err)
+			m := sampled()
+			assert.True(t, m["200"]["http_reqs"])
+			assert.True(t, m["200"]["data_received"])
+			assert.False(t, m["200"]["http_req_duration"])
+			assert.False(t, m["200"]["http_req_waiting"])
+			assert.True(t, m["500"]["http_reqs"])
+			assert.True(t, m["500"]["http_req_duration"])
+			assert.True(t, m["500"]["http_req_waiting"])
+		})
+
+		t.Run("serialization", func(t *testing.T) {
+			serialization := func() (n int) {
+				for _, sample := range state.Samples {
+					if sample.Metric == metrics.HTTPReqSerialization {
+						n++
+					}
+				}
+				return n
+			}
+
+			state.Samples = nil
+			_, err := common.RunString(rt, `http.request("GET", "https://httpbin.org/get");`)
+			assert.NoError(t, err)
+			assert.Equal(t, 0, serialization())
+
+			state.Samples = nil
+			_, err = common.RunString(rt, `
+			let form = {};
+			for (let i = 0; i < 100000; i++) { form["field" + i] = "value" + i; }
+			http.request("POST", "https://httpbin.org/post", form);
+			`)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, serialization())
+		})
+
+		t.Run("traceContext", func(t *testing.T) {
+			state.Options.TraceContext = null.BoolFrom(true)
+			defer func() { state.Options.TraceContext = null.Bool{} }()
+			_, err := common.RunString(rt, `
+			let res = http.request("GET", "https://httpbin.org/headers");
+			let tp = res.json().headers["Traceparent"];
+			if (!/^00-[0-9a-f]{32}-[0-9a-f]{16}-01$/.test(tp)) { throw new Error("wrong traceparent: " + tp); }
+			res = http.request("GET", "https://httpbin.org/headers", null, { headers: { "traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01" } });
+			tp = res.json().headers["Traceparent"];
+			if (tp != "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01") { throw new Error("overwrote traceparent: " + tp); }
+			`)
+			assert.NoError(t, err)
+		})
+
+		t.Run("redirects", func(t *testing.T) {
+			countLimitHit := func() int {
+				n := 0
+				for _, sample := range state.Samples {
+					if sample.Metric == metrics.HTTPReqRedirectLimit {
+						n++
+					}
+				}
+				return n
+			}
+
+			t.Run("under", func(t *testing.T) {
+				state.Samples = nil
+				_, err := common.RunString(rt, `
+				let res = http.request("GET", "https://httpbin.org/redirect/2", null, { redirects: 2 });
+				if (res.status != 200) { throw new Error("wrong status: " + res.status); }
+				`)
+				assert.NoError(t, err)
+				assert.Equal(t, 0, countLimitHit())
+			})
+			t.Run("over", func(t *testing.T) {
+				state.Samples = nil
+				_, err := common.RunString(rt, `
+				let res = http.request("GET", "https://httpbin.org/redirect/3", null, { redirects: 1 });
+				if (res.status != 302) { throw new Error("wrong status: " + res.status); }
+				`)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, countLimitHit())
+			})
+		})
+
+		t.Run("responseTrail", func(t *testing.T) {
+			_, err := common.RunString(rt, `
+			let res = http.request("GET", "https://httpbin.org/get");
+			if (res.trail !== null) { throw new Error("trail without the option: " + res.trail); }
+			`)
+			assert.NoError(t, err)
+
+			state.Options.ResponseTrail = null.BoolFrom(true)
+			defer func() { state.Options.ResponseTrail = null.Bool{} }()
+			_, err = common.RunString(rt, `
+			let res = http.request("GET", "https://httpbin.org/get");
+			let phases = [
+				"duration", "blocked", "looking_up", "connecting", "tls_handshaking", "tls_queued",
+				"proxy_handshake", "sending", "waiting", "receiving", "stream_blocked", "pre_write",
+				"expect_continue", "script_read_delay", "serialization_time",
+			];
+			for (let i = 0; i < phases.length; i++) {
+				if (typeof res.trail[phases[i]] !== "number") { throw new Error("missing " + phases[i]); }
+			}
+			if (res.trail.waiting <= 0) { throw new Error("no waiting: " + res.trail.waiting); }
+			if (res.trail.waiting != res.timings.waiting) { throw new Error("waiting differs from timings"); }
+			if (res.trail.bytes_read <= 0) { throw new Error("no bytes read"); }
+			if (res.trail.tls_bytes_read <= 0 && !res.trail.conn_reused) { throw new Error("no handshake bytes"); }
+			if (typeof res.trail.conn_reused !== "boolean") { throw new Error("no conn_reused"); }
+			if (res.trail.conn_remote_addr.indexOf(res.remote_ip) < 0) {
+				throw new Error("wrong conn_remote_addr: " + res.trail.conn_remote_addr);
+			}
+			if (res.trail.phase_timestamps !== null) { throw new Error("timestamps without the option"); }
+			`)
+			assert.NoError(t, err)
+
+			t.Run("phaseTimestamps", func(t *testing.T) {
+				state.Options.PhaseTimestamps = null.BoolFrom(true)
+				defer func() { state.Options.PhaseTimestamps = null.Bool{} }()
+				_, err := common.RunString(rt, `
+				let before = Date.now();
+				let res = http.request("GET", "https://httpbin.org/get");
+				let ts = res.trail.phase_timestamps;
+				if (ts.get_conn < before - 1) { throw new Error("get_conn before the request: " + ts.get_conn); }
+				if (ts.got_conn < ts.get_conn) { throw new Error("got_conn before get_conn"); }
+				if (ts.wrote_request < ts.got_conn) { throw new Error("wrote_request before got_conn"); }
+				if (ts.got_first_response_byte < ts.wrote_request) { throw new Error("response before request"); }
+				if (ts.done < ts.got_first_response_byte || ts.done > Date.now() + 1) { throw new Error("wrong done: " + ts.done); }
+				let waiting = ts.got_first_response_byte - ts.wrote_request;
+				if (Math.abs(waiting - res.trail.waiting) > 0.01) { throw new Error("waiting differs: " + waiting); }
+				`)
+				assert.NoError(t, err)
+			})
+		})
+		t.Run("retries", func(t *testing.T) {
+			retries := func() (n float64) {
+				for _, sample := range state.Samples {
+					if sample.Metric == metrics.HTTPReqRetries {
+						n += sample.Value
+					}
+				}
+				return n
+			}
+
+			t.Run("idempotent", func(t *testing.T) {
+				state.Samples = nil
+				_, err := common.RunString(rt, `
+				let res = http.request("GET", "https://httpbin.org/status/503", null, { retries: 2, retryBackoff: 10 });
+				if (res.status != 503) { throw new Error("wrong status: " + res.status); }
+				`)
+				assert.NoError(t, err)
+				assert.Equal(t, 2.0, retries())
+			})
+			t.Run("not idempotent", func(t *testing.T) {
+				state.Samples = nil
+				_, err := common.RunString(rt, `
+				let res = http.request("POST", "https://httpbin.org/status/503", "body", { retries: 2, retryBackoff: 10 });
+				if (res.status != 503) { throw new Error("wrong status: " + res.status); }
+				`)
+				assert.NoError(t, err)
+				assert.Equal(t, 0.0, retries())
+			})
+			t.Run("not 5xx", func(t *testing.T) {
+				state.Samples = nil
+				_, err := common.RunString(rt, `
+				let res = http.request("GET", "https://httpbin.org/status/404", null, { retries: 2 });
+				if (res.status != 404) { throw new Error("wrong status: " + res.status); }
+				`)
+				assert.NoError(t, err)
+				assert.Equal(t, 0.0, retries())
+			})
+		})
+		t.Run("requestAsync", func(t *testing.T) {
+			state.Samples = nil
+			_, err := common.RunString(rt, `
+			let start = Date.now();
+			let pending = [];
+			for (let i = 0; i < 3; i++) {
+				pending.push(http.requestAsync("GET", "https://httpbin.org/delay/1"));
+			}
+			if (pending[0].done()) { throw new Error("done too soon"); }
+			for (let i = 0; i < pending.length; i++) {
+				let res = pending[i].wait();
+				if (res.status != 200) { throw new Error("wrong status: " + res.status); }
+				if (res.trail.waiting < 1000) { throw new Error("waiting too short: " + res.trail.waiting); }
+				if (!pending[i].done()) { throw new Error("not done after wait"); }
+			}
+			let waiting = pending[0].then(function(res) { return res.trail.waiting; });
+			if (waiting < 1000) { throw new Error("wrong then() result: " + waiting); }
+			if (Date.now() - start > 2500) { throw new Error("requests weren't concurrent"); }
+			`)
+			assert.NoError(t, err)
+			reqs := 0
+			for _, sample := range state.Samples {
+				if sample.Metric == metrics.HTTPReqs {
+					reqs++
+				}
+			}
+			assert.Equal(t, 3, reqs)
+		})
+		t.Run("hedgeDelay", func(t *testing.T) {
+			state.Samples = nil
+			_, err := common.RunString(rt, `
+			let res = http.request("GET", "https://httpbin.org/delay/1", null, { hedgeDelay: 100 });
+			if (res.status != 200) { throw new Error("wrong status: " + res.status); }
+			`)
+			assert.NoError(t, err)
+			hedged := 0
+			for _, sample := range state.Samples {
+				if sample.Metric == metrics.HTTPReqHedged {
+					hedged++
+					assert.Contains(t, []string{"original", "hedge"}, sample.Tags["hedge_won"])
+				}
+			}
+			assert.Equal(t, 1, hedged)
+		})
+		t.Run("bodyFile", func(t *testing.T) {
+			f, err := ioutil.TempFile("", "k6-bodyfile")
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = os.Remove(f.Name()) }()
+			_, err = f.WriteString("file contents")
+			assert.NoError(t, err)
+			assert.NoError(t, f.Close())
+
+			_, err = common.RunString(rt, fmt.Sprintf(`
+			let res = http.request("PUT", "https://httpbin.org/put", null, { bodyFile: %q });
+			if (res.status != 200) { throw new Error("wrong status: " + res.status); }
+			if (res.json().data != "file contents") { throw new Error("wrong body: " + res.json().data); }
+			`, f.Name()))
+			assert.NoError(t, err)
+
+			t.Run("with body", func(t *testing.T) {
+				_, err := common.RunString(rt, fmt.Sprintf(`
+				http.request("PUT", "https://httpbin.org/put", "body", { bodyFile: %q });
+				`, f.Name()))
+				assert.Error(t, err)
+			})
+			t.Run("missing", func(t *testing.T) {
+				_, err := common.RunString(rt, `
+				http.request("PUT", "https://httpbin.org/put", null, { bodyFile: "/nonexistent/k6-bodyfile" });
+				`)
+				assert.Error(t, err)
+			})
+		})
+
+		t.Run("expected response", func(t *testing.T) {
+			failedRate := func() (n, failed int) {
+				for _, sample := range state.Samples {
+					if sample.Metric == metrics.HTTPReqFailed {
+						n++
+						if sample.Value != 0 {
+							failed++
+						}
+					}
+				}
+				return n, failed
+			}
+
+			t.Run("default", func(t *testing.T) {
+				state.Samples = nil
+				_, err := common.RunString(rt, `http.request("HEAD", "https://httpbin.org/status/404");`)
+				assert.NoError(t, err)
+				n, failed := failedRate()
+				assert.Equal(t, 1, n)
+				assert.Equal(t, 1, failed)
+			})
+			t.Run("custom", func(t *testing.T) {
+				netext.SetExpectedResponse(func(res *http.Response, tr netext.Trail) bool {
+					return res.StatusCode < 400 || res.StatusCode == 404
+				})
+				defer netext.SetExpectedResponse(nil)
+
+				state.Samples = nil
+				_, err := common.RunString(rt, `
+				http.request("HEAD", "https://httpbin.org/status/404");
+				http.request("HEAD", "https://httpbin.org/status/500");
+				`)
+				assert.NoError(t, err)
+				n, failed := failedRate()
+				assert.Equal(t, 2, n)
+				assert.Equal(t, 1, failed)
+			})
+		})
+
+		t.Run("pin", func(t *testing.T) {
+			state.Samples = nil
+			_, err := common.RunString(rt, `
+			let first = http.request("GET", "https://httpbin.org/get", null, { pin: true });
+			if (!first.connection) { throw new Error("no connection"); }
+			let connID = first.connection.connID;
+			for (let i = 0; i < 3; i++) {
+				let res = http.request("GET", "https://httpbin.org/get", null, { connection: first.connection });
+				if (res.connection.connID !== connID) { throw new Error("different connection: " + res.connection.connID); }
+			}
+			`)
+			assert.NoError(t, err)
+			n := 0
+			for _, sample := range state.Samples {
+				if sample.Metric != metrics.HTTPReqs {
+					continue
+				}
+				assert.Equal(t, "true", sample.Tags["pinned"])
+				assert.Empty(t, sample.Tags["pin_lost"])
+				n++
+			}
+			assert.Equal(t, 4, n)
+
+			_, err = common.RunString(rt, `http.request("GET", "https://httpbin.org/get", null, { connection: "nope" });`)
+			assert.Error(t, err)
+		})
+
+		t.Run("budget", func(t *testing.T) {
+			countExceeded := func() int {
+				n := 0
+				for _, sample := range state.Samples {
+					if sample.Metric == metrics.HTTPReqBudgetExceeded {
+						assert.Equal(t, "waiting", sample.Tags["phase"])
+						n++
+					}
+				}
+				return n
+			}
+
+			t.Run("under", func(t *testing.T) {
+				state.Samples = nil
+				_, err := common.RunString(rt, `http.request("GET", "https://httpbin.org/delay/1", null, { budget: { waiting: 5000 } });`)
+				assert.NoError(t, err)
+				assert.Equal(t, 0, countExceeded())
+			})
+			t.Run("over", func(t *testing.T) {
+				state.Samples = nil
+				_, err := common.RunString(rt, `http.request("GET", "https://httpbin.org/delay/1", null, { budget: { waiting: 900 } });`)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, countExceeded())
+			})
+		})
+
+		t.Run("timeout", func(t *testing.T) {
+			state.Samples = nil
+			_, err := common.RunString(rt, `http.request("GET", "https://httpbin.org/delay/10", null, { timeout: 1000 });`)
+			assert.Error(t, err)
+			seenTimeout := false
+			for _, sample := range state.Samples {
+				if sample.Metric == metrics.HTTPReqTimeouts {
+					seenTimeout = true
+				}
+			}
+			assert.True(t, seenTimeout, "didn't emit a timeout")
+		})
 	})
 
 	t.Run("GET", func(t *testing.T) {
@@ -272,6 +769,9 @@ func TestRequest(t *testing.T) {
 			`, fn, strings.ToLower(method)))
 			assert.NoError(t, err)
 			assertRequestMetricsEmitted(t, state.Samples, method, "https://httpbin.org/"+strings.ToLower(method), 200, "")
+			for _, sample := range state.Samples {
+				assert.Equal(t, "", sample.Tags["chunked"], "buffered body sent chunked")
+			}
 
 			t.Run("object", func(t *testing.T) {
 				state.Samples = nil
@@ -349,3 +849,60 @@ func TestRequest(t *testing.T) {
 		})
 	})
 }
+
+func TestCacheStatus(t *testing.T) {
+	testdata := map[string]struct {
+		header http.Header
+		status string
+	}{
+		"none":               {http.Header{}, "unknown"},
+		"cloudflare hit":     {http.Header{"Cf-Cache-Status": {"HIT"}}, "hit"},
+		"cloudflare expired": {http.Header{"Cf-Cache-Status": {"EXPIRED"}}, "miss"},
+		"cloudflare dynamic": {http.Header{"Cf-Cache-Status": {"DYNAMIC"}, "Age": {"10"}}, "miss"},
+		"cloudflare unknown": {http.Header{"Cf-Cache-Status": {"NONE"}, "X-Cache": {"HIT"}}, "hit"},
+		"cloudfront hit":     {http.Header{"X-Cache": {"Hit from cloudfront"}}, "hit"},
+		"cloudfront refresh": {http.Header{"X-Cache": {"RefreshHit from cloudfront"}}, "hit"},
+		"cloudfront miss":    {http.Header{"X-Cache": {"Miss from cloudfront"}}, "miss"},
+		"cloudfront error":   {http.Header{"X-Cache": {"Error from cloudfront"}}, "unknown"},
+		"squid":              {http.Header{"X-Cache": {"TCP_MISS"}}, "miss"},
+		"fastly shield miss": {http.Header{"X-Cache": {"MISS, HIT"}}, "hit"},
+		"fastly edge miss":   {http.Header{"X-Cache": {"HIT, MISS"}}, "miss"},
+		"x-cache beats age":  {http.Header{"X-Cache": {"MISS"}, "Age": {"100"}}, "miss"},
+		"age":                {http.Header{"Age": {"100"}}, "hit"},
+		"age zero":           {http.Header{"Age": {"0"}}, "unknown"},
+		"age invalid":        {http.Header{"Age": {"soon"}}, "unknown"},
+		"lowercase":          {http.Header{"Cf-Cache-Status": {"hit"}}, "hit"},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, data.status, cacheStatus(data.header))
+		})
+	}
+}
+
+func TestTagResponseHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Served-By", "backend-1")
+
+	t.Run("present", func(t *testing.T) {
+		tags := map[string]string{}
+		tagResponseHeaders(tags, header, map[string]string{"X-Served-By": "backend"})
+		assert.Equal(t, map[string]string{"backend": "backend-1"}, tags)
+	})
+	t.Run("missing", func(t *testing.T) {
+		tags := map[string]string{}
+		tagResponseHeaders(tags, header, map[string]string{"X-Missing": "missing"})
+		assert.Empty(t, tags)
+	})
+	t.Run("capped", func(t *testing.T) {
+		mapping := map[string]string{}
+		for i := 0; i < maxResponseHeaderTags*2; i++ {
+			name := fmt.Sprintf("X-Header-%02d", i)
+			header.Set(name, "value")
+			mapping[name] = name
+		}
+		tags := map[string]string{}
+		tagResponseHeaders(tags, header, mapping)
+		assert.Len(t, tags, maxResponseHeaderTags)
+	})
+}
@@ -87,3 +87,21 @@ func (*Metrics) XTrend(ctx *context.Context, name string, isTime ...bool) (inter
 func (*Metrics) XRate(ctx *context.Context, name string, isTime ...bool) (interface{}, error) {
 	return newMetric(ctx, name, stats.Rate, isTime)
 }
+
+// Value returns a metric's aggregates so far in the test, eg. value("http_req_duration").p95;
+// null if nothing's been recorded to it yet. Samples from the current iteration aren't
+// counted until it's over.
+func (*Metrics) Value(ctx context.Context, name string) (goja.Value, error) {
+	state := common.GetState(ctx)
+	if state == nil {
+		return nil, errors.New("Metric values can't be read in the init context")
+	}
+	if state.Metrics == nil {
+		return goja.Null(), nil
+	}
+	values, ok := state.Metrics.MetricValues(name)
+	if !ok {
+		return goja.Null(), nil
+	}
+	return common.GetRuntime(ctx).ToValue(values), nil
+}
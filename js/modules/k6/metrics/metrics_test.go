@@ -129,3 +129,46 @@ func TestMetrics(t *testing.T) {
 		})
 	}
 }
+
+type metricReader map[string]map[string]float64
+
+func (r metricReader) MetricValues(name string) (map[string]float64, bool) {
+	values, ok := r[name]
+	return values, ok
+}
+
+func TestMetricsValue(t *testing.T) {
+	rt := goja.New()
+	rt.SetFieldNameMapper(common.FieldNameMapper{})
+
+	ctxPtr := new(context.Context)
+	*ctxPtr = common.WithRuntime(context.Background(), rt)
+	rt.Set("metrics", common.Bind(rt, &Metrics{}, ctxPtr))
+
+	t.Run("Init", func(t *testing.T) {
+		_, err := common.RunString(rt, `metrics.value("http_req_duration")`)
+		assert.EqualError(t, err, "GoError: Metric values can't be read in the init context at apply (native)")
+	})
+
+	root, _ := lib.NewGroup("", nil)
+	state := &common.State{Group: root}
+	*ctxPtr = common.WithState(*ctxPtr, state)
+
+	t.Run("NoReader", func(t *testing.T) {
+		v, err := common.RunString(rt, `metrics.value("http_req_duration")`)
+		assert.NoError(t, err)
+		assert.True(t, goja.IsNull(v))
+	})
+
+	state.Metrics = metricReader{"http_req_duration": {"p95": 120.5, "max": 300}}
+	t.Run("Present", func(t *testing.T) {
+		v, err := common.RunString(rt, `metrics.value("http_req_duration").p95`)
+		assert.NoError(t, err)
+		assert.Equal(t, 120.5, v.ToFloat())
+	})
+	t.Run("Missing", func(t *testing.T) {
+		v, err := common.RunString(rt, `metrics.value("my_metric")`)
+		assert.NoError(t, err)
+		assert.True(t, goja.IsNull(v))
+	})
+}
@@ -24,13 +24,17 @@ import (
 	"context"
 	"net"
 	"net/http"
+	"os"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/dop251/goja"
 	"github.com/loadimpact/k6/js/common"
 	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/lib/metrics"
 	"github.com/loadimpact/k6/lib/netext"
 	"github.com/loadimpact/k6/stats"
+	"github.com/pkg/errors"
 	"github.com/spf13/afero"
 )
 
@@ -38,7 +42,48 @@ type Runner struct {
 	Bundle       *Bundle
 	defaultGroup *lib.Group
 
-	Dialer *netext.Dialer
+	Dialer   *netext.Dialer
+	Fairness *stats.FairnessAggregator
+	Baseline *stats.BaselineAggregator
+
+	// Per-host connection efficiency scores; nil unless the connEfficiency option is set.
+	Efficiency *stats.EfficiencyAggregator
+
+	// Correlations between request phases; nil unless the phaseCorrelation option is set.
+	Correlation *stats.CorrelationAggregator
+
+	// Request durations by the groupTrailsBy option's tags; nil if it isn't set.
+	TagGroups *stats.TagGroupAggregator
+
+	// Per-host availability; nil unless the availability option is set.
+	Availability *stats.AvailabilityAggregator
+
+	// Each connection's requests, written to connTimelinesFile; nil unless the
+	// connTimelines option is set.
+	ConnTimelines     *netext.ConnTimelineAggregator
+	connTimelinesFile *os.File
+
+	// Caps concurrent TLS handshakes; nil unless the maxTLSHandshakes option is set.
+	Handshakes *netext.HandshakeLimiter
+
+	// Every request's Trail, for outputs that want them; see lib.TrailCollector.
+	Trails *netext.TrailStream
+
+	// Filters the tags Trails are published with, like the Engine does samples'; nil
+	// unless the tagWhitelist or normalizeURLTags options are set.
+	TagFilter *stats.TagFilter
+
+	// Tags for every HTTP sample; the baseTags option, plus the instanceID's.
+	BaseTags map[string]string
+
+	// Rounds HTTP timings as they're emitted; nil unless the durationPrecision option is set.
+	Rounding netext.DurationRounding
+
+	// Where scripts read metrics' aggregates from mid-test; set by the Engine.
+	Metrics lib.MetricReader
+
+	// Wrapped around every VU's HTTP transport, outermost first; see netext.Middleware.
+	Middleware []netext.Middleware
 }
 
 func New(src *lib.SourceData, fs afero.Fs) (*Runner, error) {
@@ -52,14 +97,22 @@ func New(src *lib.SourceData, fs afero.Fs) (*Runner, error) {
 		return nil, err
 	}
 
+	dialer := netext.NewDialer(net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		DualStack: true,
+	})
+	dialer.OnFDLimit = func(open, limit int64) {
+		log.WithFields(log.Fields{"open": open, "limit": limit}).Warn(
+			"Close to the open file limit; raise it (ulimit -n), or cap connections with maxOpenConns")
+	}
+
 	return &Runner{
 		Bundle:       bundle,
 		defaultGroup: defaultGroup,
-		Dialer: netext.NewDialer(net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}),
+		Dialer:       dialer,
+		Fairness:     stats.NewFairnessAggregator(),
+		Trails:       &netext.TrailStream{},
 	}, nil
 }
 
@@ -82,9 +135,27 @@ func (r *Runner) newVU() (*VU, error) {
 	vu := &VU{
 		BundleInstance: *bi,
 		Runner:         r,
-		HTTPTransport:  &http.Transport{DialContext: r.Dialer.DialContext},
-		VUContext:      NewVUContext(),
+		HTTPTransport: &http.Transport{
+			DialContext:           r.Dialer.DialContext,
+			ExpectContinueTimeout: 1 * time.Second,
+			// A custom DialContext turns off HTTP/2, unless it's explicitly asked for.
+			ForceAttemptHTTP2: true,
+		},
+		VUContext: NewVUContext(),
+	}
+	if r.Bundle.Options.TLSRenegotiation.Bool {
+		// HTTP/2 is still attempted with a TLS config of our own, as it's forced above.
+		vu.HTTPTransport.TLSClientConfig = netext.TLSRenegotiationConfig(nil)
 	}
+	var transport http.RoundTripper = vu.HTTPTransport
+	if r.Bundle.Options.HTTP3.Bool {
+		transport = &netext.HTTP3Transport{
+			Dialer:          r.Dialer,
+			Fallback:        vu.HTTPTransport,
+			TLSClientConfig: vu.HTTPTransport.TLSClientConfig,
+		}
+	}
+	vu.HTTPRoundTripper = netext.Chain(transport, r.Middleware...)
 	common.BindToGlobal(vu.Runtime, common.Bind(vu.Runtime, vu.VUContext, vu.Context))
 
 	// Give the VU an initial sense of identity.
@@ -103,25 +174,171 @@ func (r *Runner) GetOptions() lib.Options {
 	return r.Bundle.Options
 }
 
-func (r *Runner) ApplyOptions(opts lib.Options) {
+func (r *Runner) ApplyOptions(opts lib.Options) error {
 	r.Bundle.Options = r.Bundle.Options.Apply(opts)
+	r.Dialer.TCPFastOpen = r.Bundle.Options.TCPFastOpen.Bool
+	r.Dialer.MaxOpenConns = r.Bundle.Options.MaxOpenConns.Int64
+	r.Dialer.HostOverrides = r.Bundle.Options.HostOverrides
+	r.Dialer.Nagle = r.Bundle.Options.TCPNoDelay.Valid && !r.Bundle.Options.TCPNoDelay.Bool
+	r.Dialer.DetectFramingAnomalies = r.Bundle.Options.DetectFramingAnomalies.Bool
+	r.Dialer.MeasureHeaderBytes = r.Bundle.Options.MeasureHeaderBytes.Bool
+	r.TagFilter = stats.NewTagFilter(r.Bundle.Options.TagWhitelist, r.Bundle.Options.NormalizeURLTags.Bool)
+	r.BaseTags = r.Bundle.Options.SampleBaseTags()
+
+	r.Rounding = nil
+	if precision := r.Bundle.Options.DurationPrecision; precision.Valid {
+		d, err := time.ParseDuration(precision.String)
+		if err != nil {
+			return errors.Wrap(err, "durationPrecision")
+		}
+		r.Rounding = netext.Granularity(d)
+	}
+
+	r.Dialer.ConnectRetries = int(r.Bundle.Options.ConnectRetries.Int64)
+	if backoff := r.Bundle.Options.ConnectRetryBackoff; backoff.Valid {
+		d, err := time.ParseDuration(backoff.String)
+		if err != nil {
+			return errors.Wrap(err, "connectRetryBackoff")
+		}
+		r.Dialer.ConnectRetryBackoff = d
+	}
+
+	if proxy := r.Bundle.Options.SOCKS5Proxy; proxy.Valid {
+		chain, err := netext.ParseSOCKS5ProxyChain(proxy.String)
+		if err != nil {
+			// Connecting directly instead would load the target from the wrong place.
+			return errors.Wrap(err, "socks5Proxy")
+		}
+		r.Dialer.ProxyChain = chain
+	}
+
+	if err := r.Bundle.Options.SizeBuckets.Validate(); err != nil {
+		return errors.Wrap(err, "sizeBuckets")
+	}
+
+	if path := r.Bundle.Options.ConnTimelines; path.Valid && r.ConnTimelines == nil {
+		f, err := os.Create(path.String)
+		if err != nil {
+			log.WithError(err).Warn("Couldn't create the connTimelines file, not recording them")
+		} else {
+			r.ConnTimelines = netext.NewConnTimelineAggregator(f)
+			r.connTimelinesFile = f
+			r.Dialer.OnConnClose = func(c *netext.Conn) { r.ConnTimelines.Closed(c.ConnID) }
+		}
+	}
+
+	if n := r.Bundle.Options.MaxTLSHandshakes; n.Valid && r.Handshakes == nil {
+		r.Handshakes = netext.NewHandshakeLimiter(int(n.Int64))
+	}
+
+	if r.Bundle.Options.PhaseCorrelation.Bool && r.Correlation == nil {
+		r.Correlation = stats.NewCorrelationAggregator(netext.PhaseNames...)
+	}
+
+	if r.Bundle.Options.Availability.Bool && r.Availability == nil {
+		r.Availability = stats.NewAvailabilityAggregator(metrics.HTTPAvailability, "host")
+	}
+
+	if w := r.Bundle.Options.ConnEfficiency; w != nil && r.Efficiency == nil {
+		r.Efficiency = stats.NewEfficiencyAggregator(metrics.HTTPConnEfficiency, "host", *w, lib.MetricsRate)
+	}
+
+	if spec := r.Bundle.Options.GroupTrailsBy; spec.Valid {
+		groups, err := stats.NewTagGroupAggregator(spec.String, stats.DefaultMaxTagGroups)
+		if err != nil {
+			return errors.Wrap(err, "groupTrailsBy")
+		}
+		if r.TagGroups == nil {
+			r.TagGroups = groups
+		}
+	}
+
+	if warmup := r.Bundle.Options.BaselineWarmup; warmup.Valid {
+		d, err := time.ParseDuration(warmup.String)
+		if err != nil {
+			return errors.Wrap(err, "baselineWarmup")
+		}
+		if r.Baseline == nil {
+			r.Baseline = stats.NewBaselineAggregator(d, metrics.HTTPReqDeviation)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) SetMetricReader(mr lib.MetricReader) {
+	r.Metrics = mr
+}
+
+func (r *Runner) TrailStream() *netext.TrailStream {
+	return r.Trails
+}
+
+func (r *Runner) SummarySamples() []stats.Sample {
+	t := time.Now()
+	samples := r.Dialer.PoolSamples(t)
+	if ratio, ok := r.Fairness.Ratio(); ok {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPConnFairness, Time: t, Value: ratio})
+	}
+	if r.Correlation != nil {
+		samples = append(samples, r.Correlation.Samples("http_req_correlation_", t)...)
+	}
+	if r.TagGroups != nil {
+		if n := r.TagGroups.Overflowed(); n > 0 {
+			log.WithFields(log.Fields{"max": r.TagGroups.MaxGroups, "requests": n}).Warn(
+				"Too many groups for groupTrailsBy; the rest were lumped together as \"" + stats.OverflowTagGroup + "\"")
+		}
+		samples = append(samples, r.TagGroups.Samples(metrics.HTTPReqDuration, t)...)
+	}
+	if r.Availability != nil {
+		samples = append(samples, r.Availability.Samples(t)...)
+	}
+	if r.Efficiency != nil {
+		samples = append(samples, r.Efficiency.Samples(t)...)
+	}
+	if r.connTimelinesFile != nil {
+		// Connections still open at the end don't get closed before this.
+		if err := r.ConnTimelines.Flush(); err != nil {
+			log.WithError(err).Warn("Couldn't write all connection timelines")
+		}
+		_ = r.connTimelinesFile.Close()
+		r.connTimelinesFile = nil
+	}
+	return samples
 }
 
 type VU struct {
 	BundleInstance
 
-	Runner        *Runner
-	HTTPTransport *http.Transport
-	ID            int64
-	Iteration     int64
+	Runner           *Runner
+	HTTPTransport    *http.Transport
+	HTTPRoundTripper http.RoundTripper // HTTPTransport, wrapped in the Runner's Middleware.
+	ID               int64
+	Iteration        int64
 
 	VUContext *VUContext
 }
 
 func (u *VU) RunOnce(ctx context.Context) ([]stats.Sample, error) {
 	state := &common.State{
+		Options:       u.Runner.Bundle.Options,
+		VUID:          u.ID,
 		Group:         u.Runner.defaultGroup,
-		HTTPTransport: u.HTTPTransport,
+		HTTPTransport: u.HTTPRoundTripper,
+		Dialer:        u.Runner.Dialer,
+		Middleware:    u.Runner.Middleware,
+		Efficiency:    u.Runner.Efficiency,
+		Fairness:      u.Runner.Fairness,
+		Baseline:      u.Runner.Baseline,
+		Correlation:   u.Runner.Correlation,
+		TagGroups:     u.Runner.TagGroups,
+		Availability:  u.Runner.Availability,
+		Handshakes:    u.Runner.Handshakes,
+		ConnTimelines: u.Runner.ConnTimelines,
+		Trails:        u.Runner.Trails,
+		TagFilter:     u.Runner.TagFilter,
+		BaseTags:      u.Runner.BaseTags,
+		Rounding:      u.Runner.Rounding,
+		Metrics:       u.Runner.Metrics,
 	}
 
 	ctx = common.WithRuntime(ctx, u.Runtime)
@@ -132,6 +349,7 @@ func (u *VU) RunOnce(ctx context.Context) ([]stats.Sample, error) {
 	u.Iteration++
 
 	_, err := u.Default(goja.Undefined())
+	state.SettlePending(ctx)
 
 	return state.Samples, err
 }
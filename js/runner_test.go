@@ -22,12 +22,17 @@ package js
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/loadimpact/k6/js/common"
 	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/loadimpact/k6/lib/netext"
 	"github.com/loadimpact/k6/stats"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
@@ -86,12 +91,64 @@ func TestRunnerOptions(t *testing.T) {
 
 	assert.Equal(t, r.Bundle.Options, r.GetOptions())
 	assert.Equal(t, null.NewBool(false, false), r.Bundle.Options.Paused)
-	r.ApplyOptions(lib.Options{Paused: null.BoolFrom(true)})
+	assert.NoError(t, r.ApplyOptions(lib.Options{Paused: null.BoolFrom(true)}))
 	assert.Equal(t, r.Bundle.Options, r.GetOptions())
 	assert.Equal(t, null.NewBool(true, true), r.Bundle.Options.Paused)
-	r.ApplyOptions(lib.Options{Paused: null.BoolFrom(false)})
+	assert.NoError(t, r.ApplyOptions(lib.Options{Paused: null.BoolFrom(false)}))
 	assert.Equal(t, r.Bundle.Options, r.GetOptions())
 	assert.Equal(t, null.NewBool(false, true), r.Bundle.Options.Paused)
+
+	t.Run("durationPrecision", func(t *testing.T) {
+		err := r.ApplyOptions(lib.Options{DurationPrecision: null.StringFrom("fine")})
+		assert.EqualError(t, err, `durationPrecision: time: invalid duration "fine"`)
+
+		assert.NoError(t, r.ApplyOptions(lib.Options{DurationPrecision: null.StringFrom("1ms")}))
+		assert.NotNil(t, r.Rounding)
+	})
+	t.Run("connectRetryBackoff", func(t *testing.T) {
+		err := r.ApplyOptions(lib.Options{ConnectRetryBackoff: null.StringFrom("a bit")})
+		assert.EqualError(t, err, `connectRetryBackoff: time: invalid duration "a bit"`)
+
+		assert.NoError(t, r.ApplyOptions(lib.Options{ConnectRetryBackoff: null.StringFrom("50ms")}))
+		assert.Equal(t, 50*time.Millisecond, r.Dialer.ConnectRetryBackoff)
+	})
+	t.Run("sizeBuckets", func(t *testing.T) {
+		err := r.ApplyOptions(lib.Options{SizeBuckets: netext.SizeBuckets{100, 10}})
+		assert.EqualError(t, err, "sizeBuckets: size bucket boundaries must be ascending: 10 after 100")
+
+		assert.NoError(t, r.ApplyOptions(lib.Options{SizeBuckets: netext.SizeBuckets{10, 100}}))
+	})
+	t.Run("groupTrailsBy", func(t *testing.T) {
+		err := r.ApplyOptions(lib.Options{GroupTrailsBy: null.StringFrom("method++status")})
+		assert.EqualError(t, err, "groupTrailsBy: invalid group-by spec 'method++status': empty tag key")
+		assert.Nil(t, r.TagGroups)
+
+		assert.NoError(t, r.ApplyOptions(lib.Options{GroupTrailsBy: null.StringFrom("method+status")}))
+		assert.NotNil(t, r.TagGroups)
+
+		err = r.ApplyOptions(lib.Options{GroupTrailsBy: null.StringFrom("method+method")})
+		assert.EqualError(t, err, "groupTrailsBy: invalid group-by spec 'method+method': 'method' is repeated")
+		assert.NoError(t, r.ApplyOptions(lib.Options{GroupTrailsBy: null.StringFrom("method+status")}))
+	})
+	t.Run("baselineWarmup", func(t *testing.T) {
+		err := r.ApplyOptions(lib.Options{BaselineWarmup: null.StringFrom("a while")})
+		assert.EqualError(t, err, `baselineWarmup: time: invalid duration "a while"`)
+		assert.Nil(t, r.Baseline)
+
+		assert.NoError(t, r.ApplyOptions(lib.Options{BaselineWarmup: null.StringFrom("10s")}))
+		assert.NotNil(t, r.Baseline)
+	})
+	t.Run("socks5Proxy", func(t *testing.T) {
+		assert.NoError(t, r.ApplyOptions(lib.Options{SOCKS5Proxy: null.StringFrom("socks5://localhost:1080")}))
+		assert.Len(t, r.Dialer.ProxyChain, 1)
+
+		err := r.ApplyOptions(lib.Options{SOCKS5Proxy: null.StringFrom("http://localhost:1080")})
+		assert.EqualError(t, err, "socks5Proxy: unsupported proxy scheme: http")
+
+		// Not just the proxies up to the bad one, either.
+		err = r.ApplyOptions(lib.Options{SOCKS5Proxy: null.StringFrom("socks5://localhost:1080,socks5://localhost")})
+		assert.EqualError(t, err, "socks5Proxy: proxy 2 of 2: proxy URL has no port: socks5://localhost")
+	})
 }
 
 func TestRunnerIntegrationImports(t *testing.T) {
@@ -279,3 +336,211 @@ func TestVUIntegrationMetrics(t *testing.T) {
 		assert.Equal(t, stats.Trend, samples[0].Metric.Type)
 	}
 }
+
+// Makes vu trust srv's self-signed certificate, leaving the rest of its transport as is.
+func trustTestServer(vu *VU, srv *httptest.Server) {
+	if vu.HTTPTransport.TLSClientConfig == nil {
+		vu.HTTPTransport.TLSClientConfig = &tls.Config{}
+	}
+	vu.HTTPTransport.TLSClientConfig.RootCAs = srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+}
+
+func TestVUIntegrationHTTP2(t *testing.T) {
+	protos := make(chan string, 1)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protos <- r.Proto
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	testdata := map[string]lib.Options{
+		"Default":          {},
+		"TLSRenegotiation": {TLSRenegotiation: null.BoolFrom(true)},
+		// Nothing answers QUIC on srv's port, so this falls back to HTTP/2.
+		"HTTP3": {HTTP3: null.BoolFrom(true)},
+	}
+	for name, opts := range testdata {
+		t.Run(name, func(t *testing.T) {
+			r, err := New(&lib.SourceData{
+				Filename: "/script.js",
+				Data: []byte(fmt.Sprintf(`
+				import http from "k6/http";
+				export default function() { http.get("%s"); }
+				`, srv.URL)),
+			}, afero.NewMemMapFs())
+			if !assert.NoError(t, err) || !assert.NoError(t, r.ApplyOptions(opts)) {
+				return
+			}
+
+			vu, err := r.newVU()
+			if !assert.NoError(t, err) {
+				return
+			}
+			trustTestServer(vu, srv)
+
+			_, err = vu.RunOnce(context.Background())
+			if assert.NoError(t, err) {
+				assert.Equal(t, "HTTP/2.0", <-protos)
+			}
+		})
+	}
+}
+
+func TestVUIntegrationStreamBlocked(t *testing.T) {
+	// Samples for each of two requests, the second reusing the first's connection.
+	testdata := map[string]struct {
+		http2   bool
+		samples int
+	}{
+		"HTTP/2":   {true, 2},
+		"HTTP/1.1": {false, 0},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			srv.EnableHTTP2 = data.http2
+			srv.StartTLS()
+			defer srv.Close()
+
+			r, err := New(&lib.SourceData{
+				Filename: "/script.js",
+				Data: []byte(fmt.Sprintf(`
+				import http from "k6/http";
+				export default function() { http.get("%s"); http.get("%s"); }
+				`, srv.URL, srv.URL)),
+			}, afero.NewMemMapFs())
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			vu, err := r.newVU()
+			if !assert.NoError(t, err) {
+				return
+			}
+			trustTestServer(vu, srv)
+
+			samples, err := vu.RunOnce(context.Background())
+			if !assert.NoError(t, err) {
+				return
+			}
+			n := 0
+			for _, s := range samples {
+				if s.Metric == metrics.HTTPReqStreamBlocked {
+					n++
+				}
+			}
+			assert.Equal(t, data.samples, n)
+		})
+	}
+}
+
+func TestVUIntegrationRequestAsync(t *testing.T) {
+	t.Run("Unawaited", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+		}))
+		defer srv.Close()
+
+		r, err := New(&lib.SourceData{
+			Filename: "/script.js",
+			Data: []byte(fmt.Sprintf(`
+			import http from "k6/http";
+			export default function() { http.requestAsync("GET", "%s"); }
+			`, srv.URL)),
+		}, afero.NewMemMapFs())
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		vu, err := r.newVU()
+		if !assert.NoError(t, err) {
+			return
+		}
+		samples, err := vu.RunOnce(context.Background())
+		if !assert.NoError(t, err) {
+			return
+		}
+		reqs := 0
+		for _, s := range samples {
+			if s.Metric == metrics.HTTPReqs {
+				reqs++
+			}
+		}
+		assert.Equal(t, 1, reqs)
+	})
+	t.Run("Cancelled", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+		defer srv.Close()
+
+		r, err := New(&lib.SourceData{
+			Filename: "/script.js",
+			Data: []byte(fmt.Sprintf(`
+			import http from "k6/http";
+			export default function() { http.requestAsync("GET", "%s"); }
+			`, srv.URL)),
+		}, afero.NewMemMapFs())
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		vu, err := r.newVU()
+		if !assert.NoError(t, err) {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err = vu.RunOnce(ctx)
+		assert.NoError(t, err)
+		assert.True(t, time.Since(start) < 5*time.Second, "the request wasn't cancelled")
+	})
+}
+
+func TestVUIntegrationALPNFallback(t *testing.T) {
+	testdata := map[string]struct {
+		http2, fallback bool
+	}{
+		"HTTP/2":   {true, false},
+		"HTTP/1.1": {false, true},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			srv.EnableHTTP2 = data.http2
+			srv.StartTLS()
+			defer srv.Close()
+
+			r, err := New(&lib.SourceData{
+				Filename: "/script.js",
+				Data: []byte(fmt.Sprintf(`
+				import http from "k6/http";
+				export default function() { http.get("%s"); }
+				`, srv.URL)),
+			}, afero.NewMemMapFs())
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			vu, err := r.newVU()
+			if !assert.NoError(t, err) {
+				return
+			}
+			trustTestServer(vu, srv)
+
+			samples, err := vu.RunOnce(context.Background())
+			if !assert.NoError(t, err) {
+				return
+			}
+			for _, s := range samples {
+				if s.Metric == metrics.HTTPReqs {
+					assert.Equal(t, data.fallback, s.Tags["alpn_fallback"] == "true")
+					return
+				}
+			}
+			t.Fatal("no http_reqs sample")
+		})
+	}
+}
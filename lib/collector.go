@@ -23,6 +23,7 @@ package lib
 import (
 	"context"
 
+	"github.com/loadimpact/k6/lib/netext"
 	"github.com/loadimpact/k6/stats"
 )
 
@@ -40,3 +41,28 @@ type Collector interface {
 	// the context for Run() is valid, but should defer as much work as possible to Run().
 	Collect(samples []stats.Sample)
 }
+
+// A TrailCollector is a Collector that also wants the full Trail of every HTTP request,
+// rather than just the samples derived from it. It's only given them if the Runner is
+// a TrailSource.
+type TrailCollector interface {
+	Collector
+
+	// CollectTrails is called before Run() with a channel that receives Trails until it's
+	// closed, after the last request. Trails are dropped rather than queued up if it
+	// falls TrailBuffer behind, so it should be drained promptly, eg. from Run().
+	CollectTrails(trails <-chan netext.TaggedTrail)
+}
+
+// An EventCollector is a Collector that also wants to know about the test's lifecycle: when
+// it starts and stops, moves between stages, or changes its number of VUs; eg. to annotate
+// its backend with them.
+type EventCollector interface {
+	Collector
+
+	// CollectEvents is called before Run() with a channel that receives Events in the order
+	// they happened, until it's closed after TestStop. They're queued up for it, so they
+	// never hold up samples, but the last ones are dropped if it isn't drained within
+	// EventDrainTimeout of the test ending.
+	CollectEvents(events <-chan Event)
+}
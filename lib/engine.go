@@ -30,6 +30,7 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/loadimpact/k6/lib/netext"
 	"github.com/loadimpact/k6/stats"
 	"github.com/pkg/errors"
 	"gopkg.in/guregu/null.v3"
@@ -40,8 +41,12 @@ const (
 	MetricsRate     = 1 * time.Second
 	CollectRate     = 10 * time.Millisecond
 	ThresholdsRate  = 2 * time.Second
+	SchedProbeRate  = 100 * time.Millisecond
+	TrailBuffer     = 1000
 	ShutdownTimeout = 10 * time.Second
 
+	EventDrainTimeout = 1 * time.Second
+
 	BackoffAmount = 50 * time.Millisecond
 	BackoffMax    = 10 * time.Second
 )
@@ -62,6 +67,10 @@ type Engine struct {
 	Collector Collector
 	Logger    *log.Logger
 
+	// Aggregates trends in ApproxTrendSinks, which estimate percentiles in bounded memory,
+	// rather than keeping every value; set it before any samples are collected.
+	ApproxTrends bool
+
 	Stages      []Stage
 	Metrics     map[string]*stats.Metric
 	MetricsLock sync.RWMutex
@@ -70,6 +79,15 @@ type Engine struct {
 	thresholds map[string]stats.Thresholds
 	submetrics map[string][]stats.Submetric
 
+	// Applied to every sample's tags before anything else sees them; nil if there's none.
+	tagFilter *stats.TagFilter
+
+	// Added to every sample's tags after that, as InstanceIDTag, if set.
+	instanceID string
+
+	// Emits the load generator's own resource use; nil unless RuntimeMetrics is set.
+	runtimeStats *runtimeSampler
+
 	// Stage tracking.
 	atTime          time.Duration
 	atStage         int
@@ -89,8 +107,36 @@ type Engine struct {
 	numIterations int64
 	numErrors     int64
 
+	// How far apart each VU's iterations start, if set; see Options.IterationPacing.
+	iterationPacing time.Duration
+
+	// Scores request durations against Options.ApdexThreshold, if set; guarded by
+	// MetricsLock.
+	apdex *stats.ApdexSink
+
+	// With an arrivalRate, iterations that are due but waiting for a free VU, and how many
+	// have been dropped since the last emitMetrics(), atomically.
+	arrivals          chan struct{}
+	droppedIterations int64
+
+	// Batches samples on their way to the Collector, if Options.OutputBuffer is set.
+	outputBuffer *stats.SampleBuffer
+
+	// netext.Transferred() when the engine started running.
+	startTransferred int64
+
 	thresholdsTainted bool
 
+	// Lifecycle events for the Collector, if it's an EventCollector; guarded by lock.
+	events *eventStream
+
+	// When the engine started running, and how long after that new connections taint the
+	// test, if steadyState is set; guarded by MetricsLock, like the count of them.
+	startTime        time.Time
+	steadyState      bool
+	steadyStateAfter time.Duration
+	steadyStateConns int64
+
 	// Subsystem-related.
 	lock      sync.RWMutex
 	subctx    context.Context
@@ -109,6 +155,14 @@ func NewEngine(r Runner, o Options) (*Engine, error) {
 		vuStop: make(chan interface{}),
 	}
 	e.clearSubcontext()
+	e.tagFilter = stats.NewTagFilter(o.TagWhitelist, o.NormalizeURLTags.Bool)
+	e.instanceID = o.InstanceID.String
+	if o.RuntimeMetrics.Bool {
+		e.runtimeStats = newRuntimeSampler()
+	}
+	if mc, ok := r.(MetricConsumer); ok {
+		mc.SetMetricReader(e)
+	}
 
 	if o.Stages != nil {
 		e.Stages = o.Stages
@@ -121,6 +175,59 @@ func NewEngine(r Runner, o Options) (*Engine, error) {
 	} else {
 		e.Stages = []Stage{{Duration: 0}}
 	}
+	if o.SteadyStateAfter.Valid {
+		d, err := time.ParseDuration(o.SteadyStateAfter.String)
+		if err != nil {
+			return nil, errors.Wrap(err, "options.steadyStateAfter")
+		}
+		e.steadyState, e.steadyStateAfter = true, d
+	}
+	if o.IterationPacing.Valid {
+		d, err := time.ParseDuration(o.IterationPacing.String)
+		if err != nil {
+			return nil, errors.Wrap(err, "options.iterationPacing")
+		}
+		if d < 0 {
+			return nil, errors.New("options.iterationPacing: can't be negative")
+		}
+		e.iterationPacing = d
+	}
+	if o.ApdexThreshold.Valid {
+		t, err := time.ParseDuration(o.ApdexThreshold.String)
+		if err != nil {
+			return nil, errors.Wrap(err, "options.apdexThreshold")
+		}
+		e.apdex = &stats.ApdexSink{T: t}
+	}
+	if o.OutputBuffer.Int64 < 0 {
+		return nil, errors.New("options.outputBuffer: can't be negative")
+	}
+	if o.OutputBuffer.Int64 > 0 {
+		e.outputBuffer = stats.NewSampleBuffer(int(o.OutputBuffer.Int64), CollectRate)
+	}
+	switch o.OutputBufferOverflow.String {
+	case "", "block":
+	case "dropOldest":
+		if e.outputBuffer != nil {
+			e.outputBuffer.Overflow = stats.DropOldest
+		}
+	default:
+		return nil, errors.Errorf("options.outputBufferOverflow: unknown policy %q", o.OutputBufferOverflow.String)
+	}
+	if o.ArrivalRate.Int64 > int64(time.Second) {
+		// Any faster, and iterations would be due less than a nanosecond apart.
+		return nil, errors.Errorf("options.arrivalRate: can't be over %d per second", int64(time.Second))
+	}
+	if o.ArrivalRate.Int64 > 0 {
+		queue := o.VUsMax.Int64
+		if queue < o.VUs.Int64 {
+			queue = o.VUs.Int64
+		}
+		if queue < 1 {
+			queue = 1
+		}
+		e.arrivals = make(chan struct{}, queue)
+	}
 	if o.VUsMax.Valid {
 		if err := e.SetVUsMax(o.VUsMax.Int64); err != nil {
 			return nil, err
@@ -153,6 +260,23 @@ func NewEngine(r Runner, o Options) (*Engine, error) {
 func (e *Engine) Run(ctx context.Context) error {
 	collectorctx, collectorcancel := context.WithCancel(context.Background())
 	collectorch := make(chan interface{})
+
+	var trailStream *netext.TrailStream
+	var trails <-chan netext.TaggedTrail
+	if tc, ok := e.Collector.(TrailCollector); ok {
+		if ts, ok := e.Runner.(TrailSource); ok {
+			trailStream = ts.TrailStream()
+			trails = trailStream.Subscribe(TrailBuffer)
+			tc.CollectTrails(trails)
+		}
+	}
+
+	var events *eventStream
+	if ec, ok := e.Collector.(EventCollector); ok {
+		events = newEventStream()
+		ec.CollectEvents(events.C())
+	}
+
 	if e.Collector != nil {
 		go func() {
 			e.Collector.Run(collectorctx)
@@ -162,6 +286,19 @@ func (e *Engine) Run(ctx context.Context) error {
 		close(collectorch)
 	}
 
+	// Samples are flushed to the Collector from the buffer, if there is one, until it's
+	// shut down; the last ones just before it is.
+	bufferctx, buffercancel := context.WithCancel(context.Background())
+	bufferch := make(chan interface{})
+	if e.Collector != nil && e.outputBuffer != nil {
+		go func() {
+			e.outputBuffer.Run(bufferctx, e.Collector.Collect)
+			close(bufferch)
+		}()
+	} else {
+		close(bufferch)
+	}
+
 	e.lock.Lock()
 	{
 		// Run metrics emission.
@@ -184,6 +321,24 @@ func (e *Engine) Run(ctx context.Context) error {
 			e.runThresholds(ctx)
 			e.subwg.Done()
 		}(e.subctx)
+
+		// Run the scheduler latency probe, if requested.
+		if e.Options.SchedulerLatency.Bool {
+			e.subwg.Add(1)
+			go func(ctx context.Context) {
+				e.runSchedulerProbe(ctx)
+				e.subwg.Done()
+			}(e.subctx)
+		}
+
+		// Schedule iterations, if they're started at an arrival rate.
+		if e.arrivals != nil {
+			e.subwg.Add(1)
+			go func(ctx context.Context) {
+				e.runArrivals(ctx)
+				e.subwg.Done()
+			}(e.subctx)
+		}
 	}
 	e.lock.Unlock()
 
@@ -200,14 +355,44 @@ func (e *Engine) Run(ctx context.Context) error {
 
 		// Emit final metrics.
 		e.emitMetrics()
+		if ss, ok := e.Runner.(SummarySampler); ok {
+			e.processSamples(ss.SummarySamples()...)
+		}
 
-		// Process any leftover samples.
+		// Process any leftover samples, and score the durations of all requests.
 		e.processSamples(e.collect()...)
+		e.processSamples(e.apdexSamples(time.Now())...)
 
 		// Process final thresholds.
 		e.processThresholds()
 
-		// Shut down collector
+		// No more requests will be made, so no more trails are coming.
+		if trailStream != nil {
+			trailStream.Unsubscribe(trails)
+			if n := trailStream.Dropped(); n > 0 {
+				e.Logger.WithField("dropped", n).Warn("Output fell behind, some request trails were dropped")
+			}
+		}
+
+		// The test's over, as far as the collector's concerned.
+		if events != nil {
+			e.lock.Lock()
+			e.sendEventNoLock(TestStop)
+			e.events = nil
+			e.lock.Unlock()
+			if !events.Close(EventDrainTimeout) {
+				e.Logger.Warn("Output didn't take the test's last events in time, they were dropped")
+			}
+		}
+
+		// Shut down collector, once it's got every buffered sample.
+		buffercancel()
+		<-bufferch
+		if e.outputBuffer != nil {
+			if n := e.outputBuffer.Dropped(); n > 0 {
+				e.Logger.WithField("dropped", n).Warn("Output fell behind, some samples were dropped")
+			}
+		}
 		collectorcancel()
 		<-collectorch
 	}()
@@ -220,9 +405,17 @@ func (e *Engine) Run(ctx context.Context) error {
 	e.atStageStartVUs = e.vus
 	e.nextVUID = 0
 	e.numErrors = 0
+	e.events = events
+	e.sendEventNoLock(TestStart)
 	e.lock.Unlock()
 
 	atomic.StoreInt64(&e.numIterations, 0)
+	atomic.StoreInt64(&e.startTransferred, netext.Transferred())
+
+	e.MetricsLock.Lock()
+	e.startTime = time.Now()
+	e.steadyStateConns = 0
+	e.MetricsLock.Unlock()
 
 	var lastTick time.Time
 	ticker := time.NewTicker(TickRate)
@@ -252,6 +445,15 @@ func (e *Engine) Run(ctx context.Context) error {
 			return nil
 		}
 
+		// Likewise for a cap on data transferred.
+		if transferred, reached := e.transferCapReached(); reached {
+			e.Logger.WithFields(log.Fields{
+				"total": transferred,
+				"cap":   e.Options.MaxDataTransfer.Int64,
+			}).Warn("Data transfer cap reached, stopping the test")
+			return nil
+		}
+
 		// Calculate the time delta between now and the last tick.
 		now := time.Now()
 		if lastTick.IsZero() {
@@ -360,7 +562,11 @@ func (e *Engine) setVUsNoLock(v int64) error {
 		vu.Cancel = nil
 	}
 
+	changed := v != e.vus
 	e.vus = v
+	if changed {
+		e.sendEventNoLock(VUsChanged)
+	}
 	return nil
 }
 
@@ -416,7 +622,53 @@ func (e *Engine) IsTainted() bool {
 	e.MetricsLock.RLock()
 	defer e.MetricsLock.RUnlock()
 
-	return e.thresholdsTainted
+	return e.thresholdsTainted || e.steadyStateConns > 0
+}
+
+// SteadyStateConns returns how many connections were opened after SteadyStateAfter, which
+// taints the test if there were any.
+func (e *Engine) SteadyStateConns() int64 {
+	e.MetricsLock.RLock()
+	defer e.MetricsLock.RUnlock()
+
+	return e.steadyStateConns
+}
+
+// MetricValues returns a metric's aggregates as of the last samples collected from VUs;
+// see MetricReader. Safe to call while the test is running, but not free: a trend has to
+// sort its values for percentiles.
+func (e *Engine) MetricValues(name string) (map[string]float64, bool) {
+	// Formatting a sink can mutate it, so this can't share the lock.
+	e.MetricsLock.Lock()
+	defer e.MetricsLock.Unlock()
+
+	m, ok := e.Metrics[name]
+	if !ok {
+		return nil, false
+	}
+	return m.Sink.Format(), true
+}
+
+// ResetMetrics discards every metric's aggregates, so later ones only reflect samples
+// collected from here on, eg. between phases of a test; it's safe to call while the test
+// is running. Samples VUs have already emitted are counted first, towards the old phase.
+// The Apdex score starts over too. Thresholds stay tainted if they were; the Collector
+// gets a MetricsReset event.
+func (e *Engine) ResetMetrics() {
+	e.processSamples(e.collect()...)
+
+	e.MetricsLock.Lock()
+	for _, m := range e.Metrics {
+		m.Sink.Reset()
+	}
+	if e.apdex != nil {
+		e.apdex.Reset()
+	}
+	e.MetricsLock.Unlock()
+
+	e.lock.Lock()
+	e.sendEventNoLock(MetricsReset)
+	e.lock.Unlock()
 }
 
 func (e *Engine) AtTime() time.Duration {
@@ -486,8 +738,12 @@ func (e *Engine) processStages(dT time.Duration) (bool, error) {
 			return false, nil
 		}
 
+		changed := stageIdx != e.atStage
 		e.atStage = stageIdx
 		e.atStageSince = stageStart
+		if changed {
+			e.sendEventNoLock(StageChanged)
+		}
 
 		e.Logger.WithField("vus", stageStartVUs).Debug("processStages: normalizing VU count...")
 		if err := e.setVUsNoLock(stageStartVUs); err != nil {
@@ -538,6 +794,12 @@ func (e *Engine) runVU(ctx context.Context, vu *vuEntry) {
 			return
 		}
 
+		// Or if the test has used up its data transfer cap; iterations that are already
+		// running still finish, so it overshoots by up to an iteration's worth per VU.
+		if _, reached := e.transferCapReached(); reached {
+			return
+		}
+
 		// If the engine is paused, sleep until it resumes.
 		e.lock.RLock()
 		vuPause := e.vuPause
@@ -552,7 +814,25 @@ func (e *Engine) runVU(ctx context.Context, vu *vuEntry) {
 		default:
 		}
 
+		// With an arrival rate, wait until an iteration's due.
+		if e.arrivals != nil {
+			select {
+			case <-e.arrivals:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		started := time.Now()
 		succ := e.runVUOnce(ctx, vu)
+
+		// Pacing happens between iterations, so it's never part of any request's timings.
+		if e.iterationPacing > 0 && pace(ctx, started, e.iterationPacing) > 0 {
+			vu.lock.Lock()
+			vu.Samples = append(vu.Samples, stats.Sample{Time: time.Now(), Metric: metrics.IterationPacingOverrun, Value: 1})
+			vu.lock.Unlock()
+		}
+
 		if !succ {
 			backoff += BackoffAmount * time.Duration(backoffCounter)
 			if backoff > BackoffMax {
@@ -569,6 +849,35 @@ func (e *Engine) runVU(ctx context.Context, vu *vuEntry) {
 	}
 }
 
+// Sends the Collector an event of type t, if it wants them; e.lock must be held.
+func (e *Engine) sendEventNoLock(t EventType) {
+	if e.events != nil {
+		e.events.Send(Event{Type: t, Time: time.Now(), Stage: e.atStage, VUs: e.vus})
+	}
+}
+
+// Returns the bytes transferred since the engine started running, and whether that's
+// reached MaxDataTransfer, if it's set.
+func (e *Engine) transferCapReached() (int64, bool) {
+	transferred := netext.Transferred() - atomic.LoadInt64(&e.startTransferred)
+	max := e.Options.MaxDataTransfer.Int64
+	return transferred, max > 0 && transferred >= max
+}
+
+// Sleeps off whatever's left of period since an iteration started, or ctx is done; if it
+// took longer than that, returns by how much, without trying to make up for it later.
+func pace(ctx context.Context, started time.Time, period time.Duration) time.Duration {
+	elapsed := time.Since(started)
+	if elapsed > period {
+		return elapsed - period
+	}
+	select {
+	case <-time.After(period - elapsed):
+	case <-ctx.Done():
+	}
+	return 0
+}
+
 func (e *Engine) runVUOnce(ctx context.Context, vu *vuEntry) bool {
 	samples, err := vu.VU.RunOnce(ctx)
 
@@ -614,6 +923,34 @@ func (e *Engine) runVUOnce(ctx context.Context, vu *vuEntry) bool {
 	return err == nil
 }
 
+// Makes an iteration due every 1/arrivalRate seconds, for whichever VU's free first; any
+// that come due while the queue's full are dropped, not caught up on later.
+func (e *Engine) runArrivals(ctx context.Context) {
+	ticker := time.NewTicker(time.Second / time.Duration(e.Options.ArrivalRate.Int64))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		// Nothing comes due while the engine's paused.
+		e.lock.RLock()
+		paused := e.vuPause != nil
+		e.lock.RUnlock()
+		if paused {
+			continue
+		}
+
+		select {
+		case e.arrivals <- struct{}{}:
+		default:
+			atomic.AddInt64(&e.droppedIterations, 1)
+		}
+	}
+}
+
 func (e *Engine) runMetricsEmission(ctx context.Context) {
 	ticker := time.NewTicker(MetricsRate)
 	for {
@@ -631,18 +968,82 @@ func (e *Engine) emitMetrics() {
 	defer e.lock.RUnlock()
 
 	t := time.Now()
-	e.processSamples(
-		stats.Sample{
+	samples := []stats.Sample{
+		{
 			Time:   t,
 			Metric: metrics.VUs,
 			Value:  float64(e.vus),
 		},
-		stats.Sample{
+		{
 			Time:   t,
 			Metric: metrics.VUsMax,
 			Value:  float64(e.vusMax),
 		},
-	)
+	}
+	if e.Options.InFlightRequests.Bool {
+		samples = append(samples, stats.Sample{
+			Time:   t,
+			Metric: metrics.HTTPReqsInFlight,
+			Value:  float64(netext.InFlight()),
+		})
+	}
+	if e.runtimeStats != nil {
+		samples = append(samples, e.runtimeStats.Samples(t)...)
+	}
+	samples = append(samples, e.apdexSamples(t)...)
+	if e.arrivals != nil {
+		samples = append(samples, stats.Sample{
+			Time:   t,
+			Metric: metrics.IterationsQueued,
+			Value:  float64(len(e.arrivals)),
+		})
+		if n := atomic.SwapInt64(&e.droppedIterations, 0); n > 0 {
+			samples = append(samples, stats.Sample{
+				Time:   t,
+				Metric: metrics.DroppedIterations,
+				Value:  float64(n),
+			})
+		}
+	}
+	e.processSamples(samples...)
+}
+
+// Returns an http_req_apdex sample scoring the requests so far, if there's a threshold
+// and there have been any.
+func (e *Engine) apdexSamples(t time.Time) []stats.Sample {
+	e.MetricsLock.Lock()
+	defer e.MetricsLock.Unlock()
+
+	if e.apdex == nil || e.apdex.Total == 0 {
+		return nil
+	}
+	return []stats.Sample{{Time: t, Metric: metrics.HTTPReqApdex, Value: e.apdex.Score()}}
+}
+
+// Repeatedly sets a timer and measures how late it fires, emitting the worst lateness
+// seen every MetricsRate. Timers are scheduled like everything else, so this is how
+// late tracer hooks and the like run, too.
+func (e *Engine) runSchedulerProbe(ctx context.Context) {
+	var worst time.Duration
+	lastEmit := time.Now()
+	for {
+		expected := time.Now().Add(SchedProbeRate)
+		select {
+		case <-time.After(SchedProbeRate):
+		case <-ctx.Done():
+			return
+		}
+
+		now := time.Now()
+		if late := now.Sub(expected); late > worst {
+			worst = late
+		}
+		if now.Sub(lastEmit) >= MetricsRate {
+			e.processSamples(stats.Sample{Time: now, Metric: metrics.SchedulerLatency, Value: stats.D(worst)})
+			worst = 0
+			lastEmit = now
+		}
+	}
 }
 
 func (e *Engine) runThresholds(ctx context.Context) {
@@ -715,6 +1116,22 @@ func (e *Engine) processSamples(samples ...stats.Sample) {
 		return
 	}
 
+	if e.tagFilter != nil {
+		for i := range samples {
+			samples[i].Tags = e.tagFilter.Apply(samples[i].Tags)
+		}
+	}
+	// HTTP samples already have it, but everything else (vus, iterations, ...) needs it
+	// too, and it mustn't be filtered out; a distributed run's outputs need to tell them
+	// apart by it.
+	if e.instanceID != "" {
+		for i := range samples {
+			if samples[i].Tags[InstanceIDTag] != e.instanceID {
+				samples[i].Tags = netext.MergeTags(samples[i].Tags, map[string]string{InstanceIDTag: e.instanceID})
+			}
+		}
+	}
+
 	e.MetricsLock.Lock()
 	defer e.MetricsLock.Unlock()
 
@@ -722,12 +1139,33 @@ func (e *Engine) processSamples(samples ...stats.Sample) {
 		m, ok := e.Metrics[sample.Metric.Name]
 		if !ok {
 			m = sample.Metric
+			if e.ApproxTrends && m.Type == stats.Trend {
+				// Metrics like http_req_duration are shared by every Engine in the process;
+				// this one's sink mustn't be swapped out from under the others.
+				m = stats.New(m.Name, m.Type, m.Contains)
+				m.Sink = &stats.ApproxTrendSink{}
+			}
 			m.Thresholds = e.thresholds[m.Name]
 			m.Submetrics = e.submetrics[m.Name]
 			e.Metrics[m.Name] = m
 		}
 		m.Sink.Add(sample)
 
+		if e.apdex != nil && sample.Metric == metrics.HTTPReqDuration {
+			e.apdex.Add(sample)
+		}
+		if e.steadyState && m == metrics.HTTPConnsNew && !e.startTime.IsZero() {
+			if after := sample.Time.Sub(e.startTime); after >= e.steadyStateAfter {
+				if e.steadyStateConns == 0 {
+					e.Logger.WithFields(log.Fields{
+						"after": after,
+						"tags":  sample.Tags,
+					}).Error("New connection opened after steadyStateAfter, failing the test")
+				}
+				e.steadyStateConns += int64(sample.Value)
+			}
+		}
+
 		for _, sm := range m.Submetrics {
 			passing := true
 			for k, v := range sm.Tags {
@@ -742,6 +1180,9 @@ func (e *Engine) processSamples(samples ...stats.Sample) {
 
 			if sm.Metric == nil {
 				sm.Metric = stats.New(sm.Name, sample.Metric.Type, sample.Metric.Contains)
+				if e.ApproxTrends && sm.Metric.Type == stats.Trend {
+					sm.Metric.Sink = &stats.ApproxTrendSink{}
+				}
 				sm.Metric.Thresholds = e.thresholds[sm.Name]
 				e.Metrics[sm.Name] = sm.Metric
 			}
@@ -749,7 +1190,9 @@ func (e *Engine) processSamples(samples ...stats.Sample) {
 		}
 	}
 
-	if e.Collector != nil {
+	if e.outputBuffer != nil && e.Collector != nil {
+		e.outputBuffer.Add(samples...)
+	} else if e.Collector != nil {
 		e.Collector.Collect(samples)
 	}
 }
@@ -22,12 +22,20 @@ package lib
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	logtest "github.com/Sirupsen/logrus/hooks/test"
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/loadimpact/k6/lib/netext"
 	"github.com/loadimpact/k6/stats"
 	"github.com/loadimpact/k6/stats/dummy"
 	"github.com/pkg/errors"
@@ -310,6 +318,356 @@ func TestEngineRun(t *testing.T) {
 	})
 }
 
+type summarySamplerRunner struct {
+	RunnerFunc
+	samples []stats.Sample
+}
+
+func (r summarySamplerRunner) SummarySamples() []stats.Sample { return r.samples }
+
+func TestEngineRunSummarySamples(t *testing.T) {
+	testMetric := stats.New("test_summary", stats.Gauge)
+	e, err, _ := newTestEngine(summarySamplerRunner{
+		samples: []stats.Sample{{Metric: testMetric, Value: 5}},
+	}, Options{})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, e.Run(ctx))
+
+	if assert.NotNil(t, e.Metrics["test_summary"]) {
+		assert.Equal(t, 5.0, e.Metrics["test_summary"].Sink.(*stats.GaugeSink).Value)
+	}
+}
+
+func TestEngineSchedulerLatency(t *testing.T) {
+	e, err, _ := newTestEngine(nil, Options{SchedulerLatency: null.BoolFrom(true)})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), MetricsRate+3*SchedProbeRate)
+	defer cancel()
+	assert.NoError(t, e.Run(ctx))
+
+	if assert.NotNil(t, e.Metrics["scheduler_latency"]) {
+		late := e.Metrics["scheduler_latency"].Sink.(*stats.GaugeSink).Value
+		assert.True(t, late >= 0)
+		assert.True(t, late < stats.D(MetricsRate), "implausibly late: %vms", late)
+	}
+
+	t.Run("off", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, Options{})
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), MetricsRate+3*SchedProbeRate)
+		defer cancel()
+		assert.NoError(t, e.Run(ctx))
+		assert.Nil(t, e.Metrics["scheduler_latency"])
+	})
+}
+
+func TestEngineIterationPacing(t *testing.T) {
+	run := func(iterTime time.Duration, pacing string) *Engine {
+		e, err, _ := newTestEngine(RunnerFunc(func(ctx context.Context) ([]stats.Sample, error) {
+			time.Sleep(iterTime)
+			return nil, nil
+		}), Options{
+			VUs:             null.IntFrom(1),
+			VUsMax:          null.IntFrom(1),
+			IterationPacing: null.StringFrom(pacing),
+		})
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+		defer cancel()
+		assert.NoError(t, e.Run(ctx))
+		return e
+	}
+
+	t.Run("paced", func(t *testing.T) {
+		e := run(1*time.Millisecond, "100ms")
+		assert.InDelta(t, 3, e.numIterations, 1)
+		assert.Nil(t, e.Metrics["iteration_pacing_overrun"])
+	})
+	t.Run("overrun", func(t *testing.T) {
+		e := run(20*time.Millisecond, "10ms")
+		assert.True(t, e.numIterations > 5, "iterations: %d", e.numIterations)
+		if assert.NotNil(t, e.Metrics["iteration_pacing_overrun"]) {
+			assert.True(t, e.Metrics["iteration_pacing_overrun"].Sink.(*stats.CounterSink).Value > 0)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, Options{IterationPacing: null.StringFrom("fast")})
+		assert.EqualError(t, err, "options.iterationPacing: time: invalid duration \"fast\"")
+
+		_, err, _ = newTestEngine(nil, Options{IterationPacing: null.StringFrom("-1s")})
+		assert.EqualError(t, err, "options.iterationPacing: can't be negative")
+	})
+}
+
+func TestEngineArrivalRate(t *testing.T) {
+	run := func(iterTime time.Duration, rate int64) *Engine {
+		e, err, _ := newTestEngine(RunnerFunc(func(ctx context.Context) ([]stats.Sample, error) {
+			time.Sleep(iterTime)
+			return nil, nil
+		}), Options{
+			VUs:         null.IntFrom(1),
+			VUsMax:      null.IntFrom(1),
+			ArrivalRate: null.IntFrom(rate),
+		})
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+		defer cancel()
+		assert.NoError(t, e.Run(ctx))
+		return e
+	}
+
+	t.Run("keeping up", func(t *testing.T) {
+		e := run(1*time.Millisecond, 20)
+		assert.InDelta(t, 5, e.numIterations, 1)
+		assert.NotNil(t, e.Metrics["iterations_queued"])
+		assert.Nil(t, e.Metrics["dropped_iterations"])
+	})
+	t.Run("backlog", func(t *testing.T) {
+		e := run(50*time.Millisecond, 200)
+		assert.InDelta(t, 5, e.numIterations, 1)
+		if assert.NotNil(t, e.Metrics["iterations_queued"]) {
+			assert.True(t, e.Metrics["iterations_queued"].Sink.(*stats.GaugeSink).Value <= 1, "more queued than vusMax")
+		}
+		if assert.NotNil(t, e.Metrics["dropped_iterations"]) {
+			assert.True(t, e.Metrics["dropped_iterations"].Sink.(*stats.CounterSink).Value > 30)
+		}
+	})
+	t.Run("too fast", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, Options{ArrivalRate: null.IntFrom(int64(time.Second) + 1)})
+		assert.EqualError(t, err, "options.arrivalRate: can't be over 1000000000 per second")
+	})
+}
+
+func TestEngineApdex(t *testing.T) {
+	t.Run("unset", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, Options{})
+		assert.NoError(t, err)
+		e.processSamples(stats.Sample{Metric: metrics.HTTPReqDuration, Value: 5})
+		e.emitMetrics()
+		assert.Nil(t, e.Metrics["http_req_apdex"])
+	})
+	t.Run("scored", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, Options{ApdexThreshold: null.StringFrom("10ms")})
+		assert.NoError(t, err)
+		e.emitMetrics()
+		assert.Nil(t, e.Metrics["http_req_apdex"], "scored without requests")
+
+		e.processSamples(
+			stats.Sample{Metric: metrics.HTTPReqDuration, Value: 5},
+			stats.Sample{Metric: metrics.HTTPReqDuration, Value: 20},
+			stats.Sample{Metric: metrics.HTTPReqWaiting, Value: 50},
+		)
+		e.emitMetrics()
+		if assert.NotNil(t, e.Metrics["http_req_apdex"]) {
+			assert.Equal(t, 0.75, e.Metrics["http_req_apdex"].Sink.(*stats.GaugeSink).Value)
+		}
+	})
+	t.Run("end of test", func(t *testing.T) {
+		e, err, _ := newTestEngine(RunnerFunc(func(ctx context.Context) ([]stats.Sample, error) {
+			return []stats.Sample{{Metric: metrics.HTTPReqDuration, Value: 100}}, nil
+		}), Options{
+			VUs:            null.IntFrom(1),
+			VUsMax:         null.IntFrom(1),
+			Iterations:     null.IntFrom(1),
+			ApdexThreshold: null.StringFrom("10ms"),
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, e.Run(context.Background()))
+		if assert.NotNil(t, e.Metrics["http_req_apdex"]) {
+			assert.Equal(t, 0.0, e.Metrics["http_req_apdex"].Sink.(*stats.GaugeSink).Value)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, Options{ApdexThreshold: null.StringFrom("quick")})
+		assert.EqualError(t, err, "options.apdexThreshold: time: invalid duration \"quick\"")
+	})
+}
+
+func TestPace(t *testing.T) {
+	started := time.Now()
+	assert.Equal(t, time.Duration(0), pace(context.Background(), started, 20*time.Millisecond))
+	assert.True(t, time.Since(started) >= 20*time.Millisecond)
+
+	started = time.Now().Add(-30 * time.Millisecond)
+	assert.True(t, pace(context.Background(), started, 20*time.Millisecond) >= 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	started = time.Now()
+	pace(ctx, started, 1*time.Second)
+	assert.True(t, time.Since(started) < 1*time.Second)
+}
+
+func TestEngineRuntimeMetrics(t *testing.T) {
+	e, err, _ := newTestEngine(nil, Options{RuntimeMetrics: null.BoolFrom(true)})
+	assert.NoError(t, err)
+	runtime.GC()
+	e.emitMetrics()
+
+	if assert.NotNil(t, e.Metrics["goroutines"]) {
+		assert.True(t, e.Metrics["goroutines"].Sink.(*stats.GaugeSink).Value >= 1)
+	}
+	if assert.NotNil(t, e.Metrics["heap_in_use"]) {
+		assert.True(t, e.Metrics["heap_in_use"].Sink.(*stats.GaugeSink).Value > 0)
+	}
+	if assert.NotNil(t, e.Metrics["gc_pause"]) {
+		assert.True(t, e.Metrics["gc_pause"].Sink.(*stats.GaugeSink).Value > 0)
+	}
+
+	t.Run("off", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, Options{})
+		assert.NoError(t, err)
+		e.emitMetrics()
+		assert.Nil(t, e.Metrics["goroutines"])
+		assert.Nil(t, e.Metrics["heap_in_use"])
+	})
+}
+
+func TestRuntimeSamplerGCPause(t *testing.T) {
+	s := newRuntimeSampler()
+	pause := func() (float64, bool) {
+		for _, sample := range s.Samples(time.Now()) {
+			if sample.Metric == metrics.GCPause {
+				return sample.Value, true
+			}
+		}
+		return 0, false
+	}
+
+	runtime.GC()
+	_, ok := pause()
+	assert.True(t, ok, "no pause since the start")
+	_, ok = pause()
+	assert.False(t, ok, "pause without a collection")
+	runtime.GC()
+	_, ok = pause()
+	assert.True(t, ok, "no pause after a collection")
+}
+
+func TestEngineInFlightRequests(t *testing.T) {
+	for _, on := range []bool{false, true} {
+		t.Run(fmt.Sprint(on), func(t *testing.T) {
+			e, err, _ := newTestEngine(nil, Options{InFlightRequests: null.BoolFrom(on)})
+			assert.NoError(t, err)
+			e.emitMetrics()
+			assert.NotNil(t, e.Metrics["vus"])
+			assert.Equal(t, on, e.Metrics["http_reqs_in_flight"] != nil)
+		})
+	}
+}
+
+func TestEngineMaxDataTransfer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 10000))
+	}))
+	defer srv.Close()
+
+	client := http.Client{Transport: &http.Transport{DialContext: netext.NewDialer(net.Dialer{}).DialContext}}
+	e, err, hook := newTestEngine(RunnerFunc(func(ctx context.Context) ([]stats.Sample, error) {
+		tracer := &netext.Tracer{}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := client.Do(req.WithContext(netext.WithTracer(ctx, tracer)))
+		if err == nil {
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+		}
+		tracer.Done()
+		return nil, err
+	}), Options{
+		VUs:             null.IntFrom(1),
+		VUsMax:          null.IntFrom(1),
+		MaxDataTransfer: null.IntFrom(50000),
+	})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := netext.Transferred()
+	assert.NoError(t, e.Run(ctx))
+	assert.NoError(t, ctx.Err(), "the test should've been stopped by the cap")
+
+	transferred := netext.Transferred() - start
+	assert.True(t, transferred >= 50000, "transferred: %d", transferred)
+	// It may overshoot by a request (~10kB) that was already running, but not more.
+	assert.True(t, transferred < 65000, "transferred: %d", transferred)
+	if entry := hook.LastEntry(); assert.NotNil(t, entry) {
+		assert.Equal(t, "Data transfer cap reached, stopping the test", entry.Message)
+		assert.Equal(t, int64(50000), entry.Data["cap"])
+	}
+}
+
+func TestEngineSteadyState(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	// Runs a test that starts closing connections churnAfter into it, if that's set.
+	run := func(churnAfter time.Duration) (*Engine, *logtest.Hook) {
+		client := http.Client{Transport: &http.Transport{DialContext: netext.NewDialer(net.Dialer{}).DialContext}}
+		start := time.Now()
+		e, err, hook := newTestEngine(RunnerFunc(func(ctx context.Context) ([]stats.Sample, error) {
+			tracer := &netext.Tracer{}
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Close = churnAfter > 0 && time.Since(start) >= churnAfter
+			res, err := client.Do(req.WithContext(netext.WithTracer(ctx, tracer)))
+			if err == nil {
+				_, _ = ioutil.ReadAll(res.Body)
+				_ = res.Body.Close()
+			}
+			time.Sleep(5 * time.Millisecond)
+			return tracer.Done().Samples(nil), err
+		}), Options{
+			VUs:              null.IntFrom(1),
+			VUsMax:           null.IntFrom(1),
+			Duration:         null.StringFrom("400ms"),
+			SteadyStateAfter: null.StringFrom("100ms"),
+		})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.NoError(t, e.Run(context.Background()))
+		return e, hook
+	}
+
+	t.Run("reused", func(t *testing.T) {
+		e, _ := run(0)
+		assert.Equal(t, int64(0), e.SteadyStateConns())
+		assert.False(t, e.IsTainted())
+		// The one made in warm-up is fine.
+		assert.NotNil(t, e.Metrics["http_conns_new"])
+	})
+	t.Run("churn", func(t *testing.T) {
+		e, hook := run(200 * time.Millisecond)
+		assert.True(t, e.SteadyStateConns() > 0)
+		assert.True(t, e.IsTainted())
+		found := false
+		for _, entry := range hook.Entries {
+			if entry.Message == "New connection opened after steadyStateAfter, failing the test" {
+				found = true
+			}
+		}
+		assert.True(t, found)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, Options{SteadyStateAfter: null.StringFrom("soon")})
+		assert.EqualError(t, err, "options.steadyStateAfter: time: invalid duration \"soon\"")
+	})
+}
+
 func TestEngineIsRunning(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	e, err, _ := newTestEngine(nil, Options{})
@@ -880,6 +1238,218 @@ func TestEngineCollector(t *testing.T) {
 	assert.Equal(t, numEngineSamples, numCollectorSamples)
 }
 
+func TestEngineOutputBuffer(t *testing.T) {
+	t.Run("buffered", func(t *testing.T) {
+		testMetric := stats.New("test_metric", stats.Counter)
+		c := &dummy.Collector{}
+
+		e, err, _ := newTestEngine(RunnerFunc(func(ctx context.Context) ([]stats.Sample, error) {
+			return []stats.Sample{{Metric: testMetric, Value: 1}}, nil
+		}), Options{
+			VUs:          null.IntFrom(1),
+			VUsMax:       null.IntFrom(1),
+			Iterations:   null.IntFrom(100),
+			OutputBuffer: null.IntFrom(10),
+		})
+		assert.NoError(t, err)
+		e.Collector = c
+		assert.NoError(t, e.Run(context.Background()))
+
+		n := 0
+		for _, sample := range c.Samples {
+			if sample.Metric == testMetric {
+				n++
+			}
+		}
+		assert.Equal(t, 100, n)
+		assert.Equal(t, int64(0), e.outputBuffer.Dropped())
+	})
+	t.Run("dropOldest", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, Options{
+			OutputBuffer:         null.IntFrom(10),
+			OutputBufferOverflow: null.StringFrom("dropOldest"),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, stats.DropOldest, e.outputBuffer.Overflow)
+	})
+	t.Run("unknown policy", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, Options{OutputBufferOverflow: null.StringFrom("explode")})
+		assert.EqualError(t, err, `options.outputBufferOverflow: unknown policy "explode"`)
+	})
+	t.Run("negative", func(t *testing.T) {
+		_, err, _ := newTestEngine(nil, Options{OutputBuffer: null.IntFrom(-1)})
+		assert.EqualError(t, err, "options.outputBuffer: can't be negative")
+	})
+}
+
+// A Collector that also drains trails, into a slice that's complete once done is closed.
+type trailCollector struct {
+	dummy.Collector
+	trails   <-chan netext.TaggedTrail
+	received []netext.TaggedTrail
+	done     chan struct{}
+}
+
+func (c *trailCollector) CollectTrails(trails <-chan netext.TaggedTrail) {
+	c.trails = trails
+	c.done = make(chan struct{})
+	go func() {
+		for tt := range trails {
+			c.received = append(c.received, tt)
+		}
+		close(c.done)
+	}()
+}
+
+type trailRunner struct {
+	RunnerFunc
+	stream netext.TrailStream
+}
+
+func (r *trailRunner) TrailStream() *netext.TrailStream { return &r.stream }
+
+func TestEngineTrailCollector(t *testing.T) {
+	r := &trailRunner{}
+	var published int64
+	r.RunnerFunc = RunnerFunc(func(ctx context.Context) ([]stats.Sample, error) {
+		r.stream.Publish(netext.Trail{ConnReused: true}, map[string]string{"name": "test"})
+		atomic.AddInt64(&published, 1)
+		time.Sleep(1 * time.Millisecond)
+		return nil, nil
+	})
+	e, err, _ := newTestEngine(r, Options{VUs: null.IntFrom(1), VUsMax: null.IntFrom(1)})
+	assert.NoError(t, err)
+	c := &trailCollector{}
+	e.Collector = c
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, e.Run(ctx))
+
+	<-c.done
+	assert.NotEmpty(t, c.received)
+	assert.Equal(t, atomic.LoadInt64(&published), int64(len(c.received)))
+	for _, tt := range c.received {
+		assert.True(t, tt.Trail.ConnReused)
+		assert.Equal(t, "test", tt.Tags["name"])
+	}
+
+	t.Run("not a source", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, Options{})
+		assert.NoError(t, err)
+		c := &trailCollector{}
+		e.Collector = c
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.NoError(t, e.Run(ctx))
+		assert.Nil(t, c.trails)
+	})
+}
+
+// A Collector that also records lifecycle events; complete once done is closed.
+type eventCollector struct {
+	dummy.Collector
+	events []Event
+	done   chan struct{}
+}
+
+func (c *eventCollector) CollectEvents(events <-chan Event) {
+	c.done = make(chan struct{})
+	go func() {
+		for ev := range events {
+			// A slow consumer mustn't slow the test down.
+			time.Sleep(time.Millisecond)
+			c.events = append(c.events, ev)
+		}
+		close(c.done)
+	}()
+}
+
+func TestEngineEventCollector(t *testing.T) {
+	e, err, _ := newTestEngine(RunnerFunc(func(ctx context.Context) ([]stats.Sample, error) {
+		time.Sleep(time.Millisecond)
+		return nil, nil
+	}), Options{
+		VUsMax: null.IntFrom(2),
+		Stages: []Stage{
+			{Duration: 50 * time.Millisecond, Target: null.IntFrom(1)},
+			{Duration: 50 * time.Millisecond, Target: null.IntFrom(2)},
+		},
+	})
+	assert.NoError(t, err)
+	c := &eventCollector{}
+	e.Collector = c
+	assert.NoError(t, e.Run(context.Background()))
+	<-c.done
+
+	if !assert.True(t, len(c.events) >= 4, "%v", c.events) {
+		return
+	}
+	assert.Equal(t, TestStart, c.events[0].Type)
+	assert.Equal(t, TestStop, c.events[len(c.events)-1].Type)
+
+	stageChanged, vusChanged := false, false
+	for i, ev := range c.events {
+		if i > 0 {
+			assert.False(t, ev.Time.Before(c.events[i-1].Time), "out of order")
+		}
+		switch ev.Type {
+		case StageChanged:
+			assert.Equal(t, 1, ev.Stage)
+			stageChanged = true
+		case VUsChanged:
+			assert.True(t, ev.VUs > 0)
+			vusChanged = true
+		}
+	}
+	assert.True(t, stageChanged, "no stage change")
+	assert.True(t, vusChanged, "no VU change")
+}
+
+type metricConsumerRunner struct {
+	RunnerFunc
+	reader MetricReader
+}
+
+func (r *metricConsumerRunner) SetMetricReader(mr MetricReader) { r.reader = mr }
+
+func TestEngineMetricValues(t *testing.T) {
+	r := &metricConsumerRunner{RunnerFunc: RunnerFunc(nil)}
+	e, err, _ := newTestEngine(r, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, e, r.reader)
+
+	metric := stats.New("my_trend", stats.Trend)
+	_, ok := e.MetricValues("my_trend")
+	assert.False(t, ok)
+
+	for i := 1; i <= 100; i++ {
+		e.processSamples(stats.Sample{Metric: metric, Value: float64(i)})
+	}
+	values, ok := e.MetricValues("my_trend")
+	assert.True(t, ok)
+	assert.Equal(t, 100.0, values["max"])
+	assert.Equal(t, 96.0, values["p95"])
+
+	// Keeps up with samples added since, including while it's being read.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_, _ = e.MetricValues("my_trend")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		e.processSamples(stats.Sample{Metric: metric, Value: 1000})
+	}
+	wg.Wait()
+	values, _ = e.MetricValues("my_trend")
+	assert.Equal(t, 1000.0, values["max"])
+	assert.Equal(t, 1000.0, values["p95"])
+}
+
 func TestEngine_processSamples(t *testing.T) {
 	metric := stats.New("my_metric", stats.Gauge)
 
@@ -916,6 +1486,125 @@ func TestEngine_processSamples(t *testing.T) {
 		assert.IsType(t, &stats.GaugeSink{}, e.Metrics["my_metric"].Sink)
 		assert.IsType(t, &stats.GaugeSink{}, e.Metrics["my_metric{a:1}"].Sink)
 	})
+	t.Run("prefixed", func(t *testing.T) {
+		ths, err := stats.NewThresholds([]string{`1+1==2`})
+		assert.NoError(t, err)
+
+		e, err, _ := newTestEngine(nil, Options{
+			Thresholds: map[string]stats.Thresholds{
+				"warmup_my_metric":      ths,
+				"warmup_my_metric{a:1}": ths,
+			},
+		})
+		assert.NoError(t, err)
+
+		e.processSamples(
+			stats.Sample{Metric: metric, Value: 1, Tags: map[string]string{"a": "1"}},
+			stats.Sample{Metric: metrics.Prefixed("warmup_", metric), Value: 2, Tags: map[string]string{"a": "1"}},
+		)
+
+		assert.Len(t, e.Metrics["my_metric"].Thresholds.Thresholds, 0)
+		assert.Equal(t, ths, e.Metrics["warmup_my_metric"].Thresholds)
+		assert.Equal(t, 2.0, e.Metrics["warmup_my_metric"].Sink.(*stats.GaugeSink).Value)
+		assert.Equal(t, ths, e.Metrics["warmup_my_metric{a:1}"].Thresholds)
+	})
+	t.Run("tag filter", func(t *testing.T) {
+		ths, err := stats.NewThresholds([]string{`1+1==2`})
+		assert.NoError(t, err)
+
+		e, err, _ := newTestEngine(nil, Options{
+			TagWhitelist:     []string{"a", "url"},
+			NormalizeURLTags: null.BoolFrom(true),
+			Thresholds: map[string]stats.Thresholds{
+				"my_metric{url:/users/:id}": ths,
+				"my_metric{b:2}":            ths,
+			},
+		})
+		assert.NoError(t, err)
+		c := &dummy.Collector{}
+		e.Collector = c
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go c.Run(ctx)
+		for !c.IsRunning() {
+			time.Sleep(time.Millisecond)
+		}
+
+		tags := map[string]string{"a": "1", "b": "2", "url": "/users/42"}
+		e.processSamples(stats.Sample{Metric: metric, Value: 1, Tags: tags})
+
+		if assert.Len(t, c.Samples, 1) {
+			assert.Equal(t, map[string]string{"a": "1", "url": "/users/:id"}, c.Samples[0].Tags)
+		}
+		assert.Equal(t, "/users/42", tags["url"], "modified the original")
+		assert.NotNil(t, e.Metrics["my_metric{url:/users/:id}"])
+		assert.Nil(t, e.Metrics["my_metric{b:2}"])
+	})
+	t.Run("approx trends", func(t *testing.T) {
+		ths, err := stats.NewThresholds([]string{`1+1==2`})
+		assert.NoError(t, err)
+
+		e, err, _ := newTestEngine(nil, Options{
+			Thresholds: map[string]stats.Thresholds{
+				"my_trend{a:1}": ths,
+			},
+		})
+		assert.NoError(t, err)
+		e.ApproxTrends = true
+
+		trend := stats.New("my_trend", stats.Trend)
+		e.processSamples(
+			stats.Sample{Metric: trend, Value: 1, Tags: map[string]string{"a": "1"}},
+			stats.Sample{Metric: metric, Value: 1, Tags: map[string]string{"a": "1"}},
+		)
+
+		assert.IsType(t, &stats.ApproxTrendSink{}, e.Metrics["my_trend"].Sink)
+		assert.IsType(t, &stats.ApproxTrendSink{}, e.Metrics["my_trend{a:1}"].Sink)
+		assert.IsType(t, &stats.GaugeSink{}, e.Metrics["my_metric"].Sink)
+		assert.Equal(t, 1.0, e.Metrics["my_trend"].Sink.Format()["max"])
+
+		e.ResetMetrics()
+		assert.Equal(t, 0.0, e.Metrics["my_trend"].Sink.Format()["max"])
+	})
+	t.Run("approx trends in another engine", func(t *testing.T) {
+		quiet, err, _ := newTestEngine(nil, Options{})
+		assert.NoError(t, err)
+		quiet.ApproxTrends = true
+		quiet.processSamples(stats.Sample{Metric: metrics.HTTPReqDuration, Value: 1})
+		assert.IsType(t, &stats.ApproxTrendSink{}, quiet.Metrics[metrics.HTTPReqDuration.Name].Sink)
+
+		e, err, _ := newTestEngine(nil, Options{})
+		assert.NoError(t, err)
+		e.processSamples(stats.Sample{Metric: metrics.HTTPReqDuration, Value: 1})
+		assert.IsType(t, &stats.TrendSink{}, e.Metrics[metrics.HTTPReqDuration.Name].Sink)
+	})
+	t.Run("instance id", func(t *testing.T) {
+		e, err, _ := newTestEngine(nil, Options{
+			InstanceID:   null.StringFrom("gen-2"),
+			TagWhitelist: []string{"a"},
+		})
+		assert.NoError(t, err)
+		c := &dummy.Collector{}
+		e.Collector = c
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go c.Run(ctx)
+		for !c.IsRunning() {
+			time.Sleep(time.Millisecond)
+		}
+
+		// As an HTTP request's would be, and an engine metric's.
+		trail := netext.Trail{BaseTags: Options{InstanceID: null.StringFrom("gen-2")}.SampleBaseTags()}
+		tags := map[string]string{"a": "1", "b": "2"}
+		e.processSamples(append(trail.Samples(tags), stats.Sample{Metric: metrics.VUs, Value: 1})...)
+
+		if assert.NotEmpty(t, c.Samples) {
+			for _, s := range c.Samples {
+				assert.Equal(t, "gen-2", s.Tags[InstanceIDTag], s.Metric.Name)
+			}
+		}
+		assert.Equal(t, map[string]string{"a": "1", "b": "2"}, tags, "modified the original")
+	})
 }
 
 func TestEngine_processThresholds(t *testing.T) {
@@ -955,3 +1644,65 @@ func TestEngine_processThresholds(t *testing.T) {
 		})
 	}
 }
+
+func TestEngineResetMetrics(t *testing.T) {
+	metric := stats.New("my_trend", stats.Trend, stats.Time)
+	e, err, _ := newTestEngine(nil, Options{ApdexThreshold: null.StringFrom("10ms")})
+	assert.NoError(t, err)
+	e.events = newEventStream()
+
+	e.processSamples(stats.Sample{Metric: metrics.HTTPReqDuration, Value: 50})
+	e.emitMetrics()
+	if assert.NotNil(t, e.Metrics["http_req_apdex"]) {
+		assert.Equal(t, 0.0, e.Metrics["http_req_apdex"].Sink.(*stats.GaugeSink).Value)
+	}
+
+	for i := 1; i <= 100; i++ {
+		e.processSamples(stats.Sample{Metric: metric, Value: float64(1000 * i)})
+	}
+	values, ok := e.MetricValues("my_trend")
+	assert.True(t, ok)
+	assert.Equal(t, 96000.0, values["p95"])
+
+	e.ResetMetrics()
+	values, _ = e.MetricValues("my_trend")
+	assert.Equal(t, 0.0, values["max"])
+
+	// Samples can keep coming in while it's reset.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			e.processSamples(stats.Sample{Metric: metric, Value: 5})
+		}
+	}()
+	e.ResetMetrics()
+	wg.Wait()
+
+	e.ResetMetrics()
+	for i := 1; i <= 100; i++ {
+		e.processSamples(stats.Sample{Metric: metric, Value: float64(i)})
+	}
+	values, _ = e.MetricValues("my_trend")
+	assert.Equal(t, 96.0, values["p95"])
+	assert.Equal(t, 100.0, values["max"])
+	assert.Equal(t, 1.0, values["min"])
+
+	// The Apdex score only counts requests since the last reset, too.
+	e.processSamples(stats.Sample{Metric: metrics.HTTPReqDuration, Value: 5})
+	e.emitMetrics()
+	assert.Equal(t, 1.0, e.Metrics["http_req_apdex"].Sink.(*stats.GaugeSink).Value)
+
+	var events []EventType
+	done := make(chan struct{})
+	go func() {
+		for ev := range e.events.C() {
+			events = append(events, ev.Type)
+		}
+		close(done)
+	}()
+	assert.True(t, e.events.Close(time.Second))
+	<-done
+	assert.Equal(t, []EventType{MetricsReset, MetricsReset, MetricsReset}, events)
+}
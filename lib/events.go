@@ -0,0 +1,166 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType is what happened in an Event.
+type EventType int
+
+const (
+	// TestStart is sent once the engine starts running, before any iterations.
+	TestStart EventType = iota + 1
+
+	// StageChanged is sent when the test moves on to another stage; see Event.Stage.
+	StageChanged
+
+	// VUsChanged is sent when the number of active VUs changes.
+	VUsChanged
+
+	// TestStop is sent once the test is over and its VUs are done; it's the last event.
+	TestStop
+
+	// MetricsReset is sent when the metrics are reset with Engine.ResetMetrics(), marking
+	// the boundary between two phases of a test.
+	MetricsReset
+)
+
+func (t EventType) String() string {
+	switch t {
+	case TestStart:
+		return "test_start"
+	case StageChanged:
+		return "stage_changed"
+	case VUsChanged:
+		return "vus_changed"
+	case MetricsReset:
+		return "metrics_reset"
+	case TestStop:
+		return "test_stop"
+	default:
+		return "unknown"
+	}
+}
+
+// An Event is a point in a test's lifecycle, as given to an EventCollector.
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	// The index of the current stage in Options.Stages, and the number of active VUs, as
+	// of the event.
+	Stage int
+	VUs   int64
+}
+
+// Queues events up for a consumer, in order, without ever blocking the sender; the engine
+// sends them while holding locks that sample processing needs too.
+type eventStream struct {
+	out chan Event
+
+	lock    sync.Mutex
+	queue   []Event
+	closed  bool
+	pending chan struct{} // Signalled when there's something new in the queue.
+	done    chan struct{} // Closed once everything's been delivered, and out closed.
+	abandon chan struct{} // Closed if the consumer took too long at the end.
+}
+
+func newEventStream() *eventStream {
+	s := &eventStream{
+		out:     make(chan Event),
+		pending: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		abandon: make(chan struct{}),
+	}
+	go s.deliver()
+	return s
+}
+
+// C returns the channel events are delivered on; it's closed after the last one.
+func (s *eventStream) C() <-chan Event {
+	return s.out
+}
+
+// Send queues an event up; it's dropped if the stream's been closed.
+func (s *eventStream) Send(ev Event) {
+	s.lock.Lock()
+	if !s.closed {
+		s.queue = append(s.queue, ev)
+	}
+	s.lock.Unlock()
+	s.signal()
+}
+
+// Close ends the stream once what's queued up has been delivered, waiting up to timeout
+// for it to be; returns false if it wasn't, in which case the rest are dropped.
+func (s *eventStream) Close(timeout time.Duration) bool {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+	s.signal()
+
+	select {
+	case <-s.done:
+		return true
+	case <-time.After(timeout):
+		close(s.abandon)
+		<-s.done
+		return false
+	}
+}
+
+func (s *eventStream) signal() {
+	select {
+	case s.pending <- struct{}{}:
+	default:
+	}
+}
+
+func (s *eventStream) deliver() {
+	defer close(s.done)
+	defer close(s.out)
+	for range s.pending {
+		for {
+			s.lock.Lock()
+			if len(s.queue) == 0 {
+				closed := s.closed
+				s.lock.Unlock()
+				if closed {
+					return
+				}
+				break
+			}
+			ev := s.queue[0]
+			s.queue = s.queue[1:]
+			s.lock.Unlock()
+
+			select {
+			case s.out <- ev:
+			case <-s.abandon:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventStream(t *testing.T) {
+	t.Run("in order", func(t *testing.T) {
+		s := newEventStream()
+		// Nobody's reading yet, which mustn't hold up the sender.
+		for i := 0; i < 1000; i++ {
+			s.Send(Event{Type: VUsChanged, VUs: int64(i)})
+		}
+		s.Send(Event{Type: TestStop})
+
+		got := make(chan []Event)
+		go func() {
+			var events []Event
+			for ev := range s.C() {
+				events = append(events, ev)
+			}
+			got <- events
+		}()
+		assert.True(t, s.Close(time.Second))
+
+		events := <-got
+		if assert.Len(t, events, 1001) {
+			for i, ev := range events[:1000] {
+				assert.Equal(t, int64(i), ev.VUs)
+			}
+			assert.Equal(t, TestStop, events[1000].Type)
+		}
+
+		// Too late.
+		s.Send(Event{Type: TestStart})
+	})
+	t.Run("not drained", func(t *testing.T) {
+		s := newEventStream()
+		s.Send(Event{Type: TestStart})
+		s.Send(Event{Type: TestStop})
+		assert.False(t, s.Close(10*time.Millisecond))
+		_, open := <-s.C()
+		assert.False(t, open)
+	})
+}
+
+func TestEventTypeString(t *testing.T) {
+	assert.Equal(t, "test_start", TestStart.String())
+	assert.Equal(t, "stage_changed", StageChanged.String())
+	assert.Equal(t, "vus_changed", VUsChanged.String())
+	assert.Equal(t, "metrics_reset", MetricsReset.String())
+	assert.Equal(t, "test_stop", TestStop.String())
+	assert.Equal(t, "unknown", EventType(0).String())
+}
@@ -11,17 +11,86 @@ var (
 	Iterations = stats.New("iterations", stats.Counter)
 	Errors     = stats.New("errors", stats.Counter)
 
+	// How late a timer on the load generator fires; if this is high, so are timings.
+	SchedulerLatency = stats.New("scheduler_latency", stats.Gauge, stats.Time)
+
+	// The load generator's own goroutines, heap in use, and longest GC pause lately; if
+	// these climb, client-side saturation may be what's slowing requests down.
+	Goroutines = stats.New("goroutines", stats.Gauge)
+	HeapInUse  = stats.New("heap_in_use", stats.Gauge, stats.Data)
+	GCPause    = stats.New("gc_pause", stats.Gauge, stats.Time)
+
+	// Iterations that took longer than the iterationPacing period, so the next one was late.
+	IterationPacingOverrun = stats.New("iteration_pacing_overrun", stats.Counter)
+
+	// With an arrivalRate, how many iterations are waiting for a free VU, and how many
+	// weren't started at all because too many already were.
+	IterationsQueued  = stats.New("iterations_queued", stats.Gauge)
+	DroppedIterations = stats.New("dropped_iterations", stats.Counter)
+
 	// Runner-emitted.
 	Checks = stats.New("checks", stats.Rate)
 
 	// HTTP-related.
-	HTTPReqs          = stats.New("http_reqs", stats.Counter)
-	HTTPReqDuration   = stats.New("http_req_duration", stats.Trend, stats.Time)
-	HTTPReqBlocked    = stats.New("http_req_blocked", stats.Trend, stats.Time)
-	HTTPReqConnecting = stats.New("http_req_connecting", stats.Trend, stats.Time)
-	HTTPReqSending    = stats.New("http_req_sending", stats.Trend, stats.Time)
-	HTTPReqWaiting    = stats.New("http_req_waiting", stats.Trend, stats.Time)
-	HTTPReqReceiving  = stats.New("http_req_receiving", stats.Trend, stats.Time)
+	HTTPReqs               = stats.New("http_reqs", stats.Counter)
+	HTTPReqFailed          = stats.New("http_req_failed", stats.Rate)
+	HTTPAvailability       = stats.New("http_availability", stats.Rate)
+	HTTPReqsInFlight       = stats.New("http_reqs_in_flight", stats.Gauge)
+	HTTPReqDuration        = stats.New("http_req_duration", stats.Trend, stats.Time)
+	HTTPReqBlocked         = stats.New("http_req_blocked", stats.Trend, stats.Time)
+	HTTPReqConnecting      = stats.New("http_req_connecting", stats.Trend, stats.Time)
+	HTTPReqSetup           = stats.New("http_req_setup", stats.Trend, stats.Time)
+	HTTPReqSending         = stats.New("http_req_sending", stats.Trend, stats.Time)
+	HTTPReqWaiting         = stats.New("http_req_waiting", stats.Trend, stats.Time)
+	HTTPReqWaitingHeaders  = stats.New("http_req_waiting_headers", stats.Trend, stats.Time)
+	HTTPReqProxyHandshake  = stats.New("http_req_proxy_handshake", stats.Trend, stats.Time)
+	HTTPReqScriptReadDelay = stats.New("http_req_script_read_delay", stats.Trend, stats.Time)
+	HTTPReqSerialization   = stats.New("http_req_serialization", stats.Trend, stats.Time)
+	HTTPReqDecompression   = stats.New("http_req_decompression", stats.Trend, stats.Time)
+	HTTPReqEarlyHints      = stats.New("http_req_early_hints", stats.Trend, stats.Time)
+	HTTPReqReceiving       = stats.New("http_req_receiving", stats.Trend, stats.Time)
+	HTTPReqMaxReceiveGap   = stats.New("http_req_max_receive_gap", stats.Gauge, stats.Time)
+	HTTPReqStreamBlocked   = stats.New("http_req_stream_blocked", stats.Trend, stats.Time)
+	HTTPReqPreWrite        = stats.New("http_req_pre_write", stats.Gauge, stats.Time)
+	HTTPReqPoolLookup      = stats.New("http_req_pool_lookup", stats.Gauge, stats.Time)
+	HTTPReqExpectContinue  = stats.New("http_req_expect_continue", stats.Trend, stats.Time)
+	HTTPReqTimeouts        = stats.New("http_req_timeout", stats.Counter)
+	HTTPReqDNSFailed       = stats.New("http_req_dns_failed", stats.Counter)
+	HTTPReqRedirectLimit   = stats.New("http_req_redirect_limit", stats.Counter)
+	HTTPReqHedged          = stats.New("http_req_hedged", stats.Counter)
+	HTTPReqRetries         = stats.New("http_req_retries", stats.Counter)
+	HTTPReqConnectRetries  = stats.New("http_req_connect_retries", stats.Counter)
+	HTTPReqTLSRenegotiated = stats.New("http_req_tls_renegotiated", stats.Counter)
+	HTTPReqFramingAnomaly  = stats.New("http_req_framing_anomaly", stats.Counter)
+	HTTPReqHeaderAnomaly   = stats.New("http_req_header_anomaly", stats.Counter)
+	HTTPReqServerClockSkew = stats.New("http_req_server_clock_skew", stats.Gauge, stats.Time)
+	HTTPReqBudgetExceeded  = stats.New("http_req_budget_exceeded", stats.Counter)
+	HTTPReqDeviation       = stats.New("http_req_duration_deviation", stats.Trend)
+	HTTPReqApdex           = stats.New("http_req_apdex", stats.Gauge)
+	HTTPReqSendQueue       = stats.New("http_req_send_queue", stats.Gauge, stats.Data)
+	HTTPReqRecvQueue       = stats.New("http_req_recv_queue", stats.Gauge, stats.Data)
+	HTTPReqResHeaderBytes  = stats.New("http_req_response_header_bytes", stats.Trend, stats.Data)
+	HTTPConnsPeak          = stats.New("http_conns_peak", stats.Gauge)
+	HTTPConnsOpen          = stats.New("http_conns_open", stats.Gauge)
+	HTTPConnsNew           = stats.New("http_conns_new", stats.Counter)
+	HTTPConnsClosed        = stats.New("http_conns_closed", stats.Counter)
+	HTTPConnReset          = stats.New("http_conn_reset", stats.Counter)
+	HTTPConnEstimatedRTT   = stats.New("http_conn_estimated_rtt", stats.Gauge, stats.Time)
+	HTTPReqNagleDelay      = stats.New("http_req_possible_nagle_delay", stats.Counter)
+	HTTPReqPMTUDBlackhole  = stats.New("http_req_possible_pmtud_blackhole", stats.Counter)
+	HTTPConnFairness       = stats.New("http_conn_fairness", stats.Gauge)
+	HTTPConnEfficiency     = stats.New("http_conn_efficiency", stats.Gauge)
+
+	// HTTP transactions, made up of several requests; see netext.TrailBatch.
+	HTTPTxns            = stats.New("http_txns", stats.Counter)
+	HTTPTxnDuration     = stats.New("http_txn_duration", stats.Trend, stats.Time)
+	HTTPTxnWorstPhase   = stats.New("http_txn_worst_phase", stats.Trend, stats.Time)
+	HTTPTxnDataSent     = stats.New("http_txn_data_sent", stats.Counter, stats.Data)
+	HTTPTxnDataReceived = stats.New("http_txn_data_received", stats.Counter, stats.Data)
+
+	// gRPC unary calls, on top of their requests' metrics; see netext.GRPCCall.
+	GRPCMsgSent     = stats.New("grpc_msg_sent", stats.Counter, stats.Data)
+	GRPCMsgReceived = stats.New("grpc_msg_received", stats.Counter, stats.Data)
 
 	// Network-related; used for future protocols as well.
 	DataSent     = stats.New("data_sent", stats.Counter, stats.Data)
@@ -0,0 +1,114 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/loadimpact/k6/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPMetricUnits(t *testing.T) {
+	testdata := map[*stats.Metric]stats.Unit{
+		HTTPReqs:               stats.UnitCount,
+		HTTPReqsInFlight:       stats.UnitCount,
+		HTTPReqDuration:        stats.UnitMilliseconds,
+		HTTPReqBlocked:         stats.UnitMilliseconds,
+		HTTPReqSetup:           stats.UnitMilliseconds,
+		HTTPReqConnecting:      stats.UnitMilliseconds,
+		HTTPReqSending:         stats.UnitMilliseconds,
+		HTTPReqWaiting:         stats.UnitMilliseconds,
+		HTTPReqWaitingHeaders:  stats.UnitMilliseconds,
+		HTTPReqProxyHandshake:  stats.UnitMilliseconds,
+		HTTPReqScriptReadDelay: stats.UnitMilliseconds,
+		HTTPReqSerialization:   stats.UnitMilliseconds,
+		HTTPReqDecompression:   stats.UnitMilliseconds,
+		HTTPReqEarlyHints:      stats.UnitMilliseconds,
+		HTTPReqReceiving:       stats.UnitMilliseconds,
+		HTTPReqMaxReceiveGap:   stats.UnitMilliseconds,
+		HTTPReqStreamBlocked:   stats.UnitMilliseconds,
+		HTTPReqPoolLookup:      stats.UnitMilliseconds,
+		HTTPReqPreWrite:        stats.UnitMilliseconds,
+		HTTPReqExpectContinue:  stats.UnitMilliseconds,
+		HTTPReqTimeouts:        stats.UnitCount,
+		HTTPReqDNSFailed:       stats.UnitCount,
+		HTTPReqFailed:          stats.UnitRate,
+		HTTPAvailability:       stats.UnitRate,
+		HTTPReqRedirectLimit:   stats.UnitCount,
+		HTTPReqHedged:          stats.UnitCount,
+		HTTPReqRetries:         stats.UnitCount,
+		HTTPReqConnectRetries:  stats.UnitCount,
+		HTTPReqTLSRenegotiated: stats.UnitCount,
+		HTTPReqFramingAnomaly:  stats.UnitCount,
+		HTTPReqHeaderAnomaly:   stats.UnitCount,
+		HTTPReqServerClockSkew: stats.UnitMilliseconds,
+		HTTPReqBudgetExceeded:  stats.UnitCount,
+		HTTPReqDeviation:       stats.UnitCount,
+		HTTPReqApdex:           stats.UnitCount,
+		HTTPReqSendQueue:       stats.UnitBytes,
+		HTTPReqRecvQueue:       stats.UnitBytes,
+		HTTPReqResHeaderBytes:  stats.UnitBytes,
+		HTTPConnsPeak:          stats.UnitCount,
+		HTTPConnsOpen:          stats.UnitCount,
+		HTTPConnsNew:           stats.UnitCount,
+		HTTPConnsClosed:        stats.UnitCount,
+		HTTPConnReset:          stats.UnitCount,
+		HTTPConnEstimatedRTT:   stats.UnitMilliseconds,
+		HTTPReqNagleDelay:      stats.UnitCount,
+		HTTPReqPMTUDBlackhole:  stats.UnitCount,
+		HTTPConnFairness:       stats.UnitCount,
+		HTTPTxns:               stats.UnitCount,
+		HTTPTxnDuration:        stats.UnitMilliseconds,
+		HTTPTxnWorstPhase:      stats.UnitMilliseconds,
+		HTTPTxnDataSent:        stats.UnitBytes,
+		HTTPTxnDataReceived:    stats.UnitBytes,
+		GRPCMsgSent:            stats.UnitBytes,
+		GRPCMsgReceived:        stats.UnitBytes,
+		DataSent:               stats.UnitBytes,
+		DataReceived:           stats.UnitBytes,
+		Checks:                 stats.UnitRate,
+		SchedulerLatency:       stats.UnitMilliseconds,
+		Goroutines:             stats.UnitCount,
+		HeapInUse:              stats.UnitBytes,
+		GCPause:                stats.UnitMilliseconds,
+		IterationPacingOverrun: stats.UnitCount,
+		IterationsQueued:       stats.UnitCount,
+		DroppedIterations:      stats.UnitCount,
+	}
+	for m, unit := range testdata {
+		t.Run(m.Name, func(t *testing.T) {
+			assert.Equal(t, unit, m.Unit)
+		})
+	}
+}
+
+func TestPrefixed(t *testing.T) {
+	assert.Equal(t, HTTPReqDuration, Prefixed("", HTTPReqDuration))
+
+	m := Prefixed("warmup_", HTTPReqDuration)
+	assert.Equal(t, "warmup_http_req_duration", m.Name)
+	assert.Equal(t, HTTPReqDuration.Type, m.Type)
+	assert.Equal(t, HTTPReqDuration.Contains, m.Contains)
+	assert.Equal(t, stats.UnitMilliseconds, m.Unit)
+	assert.True(t, m == Prefixed("warmup_", HTTPReqDuration))
+	assert.False(t, m == Prefixed("steady_", HTTPReqDuration))
+}
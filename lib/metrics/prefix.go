@@ -0,0 +1,54 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import (
+	"sync"
+
+	"github.com/loadimpact/k6/stats"
+)
+
+var (
+	prefixedLock sync.Mutex
+	prefixed     = map[string]*stats.Metric{}
+)
+
+// Prefixed returns a metric like m, but named prefix+m.Name, eg. "warmup_http_req_duration";
+// the same one for every call with the same name, like the metrics above. This records the
+// same samples under a separate family, eg. for different phases of a test; thresholds and
+// submetrics then go by the prefixed name. An empty prefix returns m itself.
+func Prefixed(prefix string, m *stats.Metric) *stats.Metric {
+	if prefix == "" {
+		return m
+	}
+
+	prefixedLock.Lock()
+	defer prefixedLock.Unlock()
+
+	name := prefix + m.Name
+	pm, ok := prefixed[name]
+	if !ok {
+		pm = stats.New(name, m.Type, m.Contains)
+		pm.Unit = m.Unit
+		prefixed[name] = pm
+	}
+	return pm
+}
@@ -0,0 +1,100 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"time"
+
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/loadimpact/k6/stats"
+)
+
+// A TrailBatch sums up the Trails of several requests that together make up a logical
+// transaction, eg. loading a page and its assets, or a checkout flow.
+type TrailBatch struct {
+	Trails []Trail
+}
+
+func (b *TrailBatch) Add(tr Trail) {
+	b.Trails = append(b.Trails, tr)
+}
+
+// Duration returns the sum of the requests' durations; time spent between requests,
+// eg. sleeping or running script code, isn't counted.
+func (b *TrailBatch) Duration() time.Duration {
+	var d time.Duration
+	for _, tr := range b.Trails {
+		d += tr.Duration
+	}
+	return d
+}
+
+// WorstPhase returns the longest single phase of any request in the batch, and its name.
+func (b *TrailBatch) WorstPhase() (string, time.Duration) {
+	var name string
+	var worst time.Duration
+	for _, tr := range b.Trails {
+		for _, p := range []struct {
+			name string
+			d    time.Duration
+		}{
+			{"blocked", tr.Blocked},
+			{"connecting", tr.Connecting},
+			{"sending", tr.Sending},
+			{"waiting", tr.Waiting},
+			{"receiving", tr.Receiving},
+		} {
+			if p.d > worst {
+				name, worst = p.name, p.d
+			}
+		}
+	}
+	return name, worst
+}
+
+// Samples emits transaction-level metrics; the requests' own are left to their Trails.
+func (b *TrailBatch) Samples(tags map[string]string) []stats.Sample {
+	if len(b.Trails) == 0 {
+		return nil
+	}
+
+	var end time.Time
+	var bytesRead, bytesWritten int64
+	for _, tr := range b.Trails {
+		if tr.EndTime.After(end) {
+			end = tr.EndTime
+		}
+		bytesRead += tr.BytesRead
+		bytesWritten += tr.BytesWritten
+	}
+
+	samples := []stats.Sample{
+		{Metric: metrics.HTTPTxns, Time: end, Tags: tags, Value: 1},
+		{Metric: metrics.HTTPTxnDuration, Time: end, Tags: tags, Value: stats.D(b.Duration())},
+		{Metric: metrics.HTTPTxnDataReceived, Time: end, Tags: tags, Value: float64(bytesRead)},
+		{Metric: metrics.HTTPTxnDataSent, Time: end, Tags: tags, Value: float64(bytesWritten)},
+	}
+	if phase, d := b.WorstPhase(); phase != "" {
+		phaseTags := MergeTags(tags, map[string]string{"phase": phase})
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPTxnWorstPhase, Time: end, Tags: phaseTags, Value: stats.D(d)})
+	}
+	return samples
+}
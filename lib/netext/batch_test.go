@@ -0,0 +1,74 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/loadimpact/k6/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrailBatch(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		b := &TrailBatch{}
+		assert.Equal(t, time.Duration(0), b.Duration())
+		assert.Nil(t, b.Samples(nil))
+	})
+
+	now := time.Now()
+	b := &TrailBatch{}
+	b.Add(Trail{
+		EndTime: now, Duration: 100 * time.Millisecond,
+		Connecting: 30 * time.Millisecond, Waiting: 70 * time.Millisecond,
+		BytesRead: 1000, BytesWritten: 100,
+	})
+	b.Add(Trail{
+		EndTime: now.Add(1 * time.Second), Duration: 250 * time.Millisecond,
+		Waiting: 50 * time.Millisecond, Receiving: 200 * time.Millisecond,
+		BytesRead: 5000, BytesWritten: 200,
+	})
+	b.Add(Trail{
+		EndTime: now.Add(500 * time.Millisecond), Duration: 50 * time.Millisecond,
+		Waiting:   50 * time.Millisecond,
+		BytesRead: 10, BytesWritten: 1,
+	})
+
+	assert.Equal(t, 400*time.Millisecond, b.Duration())
+	phase, d := b.WorstPhase()
+	assert.Equal(t, "receiving", phase)
+	assert.Equal(t, 200*time.Millisecond, d)
+
+	values := make(map[*stats.Metric]stats.Sample)
+	for _, s := range b.Samples(map[string]string{"txn": "checkout"}) {
+		values[s.Metric] = s
+		assert.Equal(t, now.Add(1*time.Second), s.Time)
+		assert.Equal(t, "checkout", s.Tags["txn"])
+	}
+	assert.Equal(t, 1.0, values[metrics.HTTPTxns].Value)
+	assert.Equal(t, 400.0, values[metrics.HTTPTxnDuration].Value)
+	assert.Equal(t, 6010.0, values[metrics.HTTPTxnDataReceived].Value)
+	assert.Equal(t, 301.0, values[metrics.HTTPTxnDataSent].Value)
+	assert.Equal(t, 200.0, values[metrics.HTTPTxnWorstPhase].Value)
+	assert.Equal(t, "receiving", values[metrics.HTTPTxnWorstPhase].Tags["phase"])
+}
@@ -0,0 +1,76 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// A FileBody streams a request body from a file, rather than reading it all into memory
+// first, so that uploading a large file costs a VU no more than the buffers it's copied
+// through. Like the bodies http.NewRequest makes from in-memory readers, it can be sent
+// again from the start, for redirects and retries; see Attach.
+type FileBody struct {
+	file *os.File
+	size int64
+}
+
+// OpenFileBody opens the file at path, to be used as a request body.
+func OpenFileBody(path string) (*FileBody, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &FileBody{file: f, size: info.Size()}, nil
+}
+
+// Size returns the size of the file, which is what the body's Content-Length will be.
+func (b *FileBody) Size() int64 {
+	return b.size
+}
+
+// Attach makes the file req's body. Every read of it, including ones GetBody starts over,
+// reads the file at offsets of its own, as the transport may still be sending the last
+// one when a redirect or retry asks for it again.
+func (b *FileBody) Attach(req *http.Request) {
+	req.ContentLength = b.size
+	req.GetBody = func() (io.ReadCloser, error) {
+		// The transport closes bodies once it's sent them; that's up to Close.
+		return ioutil.NopCloser(io.NewSectionReader(b.file, 0, b.size)), nil
+	}
+	req.Body, _ = req.GetBody()
+	if b.size == 0 {
+		req.Body = http.NoBody
+	}
+}
+
+// Close closes the file, once the request (and everything it was redirected to) is done.
+func (b *FileBody) Close() error {
+	return b.file.Close()
+}
@@ -0,0 +1,111 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileBody(t *testing.T) {
+	const size = 256 << 20
+
+	dir, err := ioutil.TempDir("", "k6-filebody")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "upload.bin")
+	f, err := os.Create(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// Sparse, so writing it out doesn't take longer than the test itself.
+	assert.NoError(t, f.Truncate(size))
+	assert.NoError(t, f.Close())
+
+	var received int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/upload", http.StatusTemporaryRedirect)
+			return
+		}
+		n, _ := io.Copy(ioutil.Discard, r.Body)
+		atomic.StoreInt64(&received, n)
+	}))
+	defer srv.Close()
+
+	client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+	upload := func(urlPath string) Trail {
+		body, err := OpenFileBody(path)
+		if !assert.NoError(t, err) {
+			return Trail{}
+		}
+		defer func() { _ = body.Close() }()
+		assert.Equal(t, int64(size), body.Size())
+
+		req, err := http.NewRequest("POST", srv.URL+urlPath, nil)
+		assert.NoError(t, err)
+		body.Attach(req)
+
+		tracer := &Tracer{}
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+
+	t.Run("upload", func(t *testing.T) {
+		atomic.StoreInt64(&received, 0)
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		trail := upload("/upload")
+		runtime.ReadMemStats(&after)
+
+		assert.Equal(t, int64(size), atomic.LoadInt64(&received))
+		assert.True(t, trail.BytesWritten > size, "bytes written: %d", trail.BytesWritten)
+		assert.True(t, trail.Sending > 0)
+		// Both ends of it, even; anywhere near size, and it would've been read into memory.
+		assert.True(t, after.TotalAlloc-before.TotalAlloc < size/8, "allocated: %d", after.TotalAlloc-before.TotalAlloc)
+	})
+	t.Run("redirect", func(t *testing.T) {
+		atomic.StoreInt64(&received, 0)
+		upload("/redirect")
+		assert.Equal(t, int64(size), atomic.LoadInt64(&received))
+	})
+	t.Run("missing", func(t *testing.T) {
+		_, err := OpenFileBody(filepath.Join(dir, "missing.bin"))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
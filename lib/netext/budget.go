@@ -0,0 +1,62 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"time"
+)
+
+// A Budget caps how long a request may spend in each phase; zero means no cap.
+type Budget struct {
+	Duration   time.Duration
+	Blocked    time.Duration
+	Connecting time.Duration
+	Sending    time.Duration
+	Waiting    time.Duration
+	Receiving  time.Duration
+}
+
+// IsZero returns whether the budget doesn't cap anything.
+func (b Budget) IsZero() bool {
+	return b == Budget{}
+}
+
+// Exceeded returns the names of the phases of tr that took longer than budgeted, in
+// the order they happen; "duration" comes last. Exactly meeting a budget is fine.
+func (b Budget) Exceeded(tr Trail) []string {
+	var phases []string
+	for _, p := range []struct {
+		name          string
+		budget, spent time.Duration
+	}{
+		{"blocked", b.Blocked, tr.Blocked},
+		{"connecting", b.Connecting, tr.Connecting},
+		{"sending", b.Sending, tr.Sending},
+		{"waiting", b.Waiting, tr.Waiting},
+		{"receiving", b.Receiving, tr.Receiving},
+		{"duration", b.Duration, tr.Duration},
+	} {
+		if p.budget > 0 && p.spent > p.budget {
+			phases = append(phases, p.name)
+		}
+	}
+	return phases
+}
@@ -0,0 +1,70 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudget(t *testing.T) {
+	budget := Budget{Waiting: 200 * time.Millisecond, Duration: 500 * time.Millisecond}
+
+	t.Run("zero", func(t *testing.T) {
+		assert.True(t, Budget{}.IsZero())
+		assert.False(t, budget.IsZero())
+		assert.Empty(t, Budget{}.Exceeded(Trail{Waiting: 1 * time.Hour}))
+	})
+
+	testdata := map[string]struct {
+		trail    Trail
+		exceeded []string
+	}{
+		"under": {Trail{Waiting: 199 * time.Millisecond, Duration: 499 * time.Millisecond}, nil},
+		"exact": {Trail{Waiting: 200 * time.Millisecond, Duration: 500 * time.Millisecond}, nil},
+		"over":  {Trail{Waiting: 201 * time.Millisecond, Duration: 300 * time.Millisecond}, []string{"waiting"}},
+		"both":  {Trail{Waiting: 201 * time.Millisecond, Duration: 501 * time.Millisecond}, []string{"waiting", "duration"}},
+		"other": {Trail{Receiving: 1 * time.Hour}, nil},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, data.exceeded, budget.Exceeded(data.trail))
+		})
+	}
+
+	t.Run("samples", func(t *testing.T) {
+		tags := map[string]string{"url": "http://example.com/"}
+		trail := Trail{BudgetExceeded: []string{"waiting", "duration"}}
+
+		var phases []string
+		for _, s := range trail.Samples(tags) {
+			if s.Metric == metrics.HTTPReqBudgetExceeded {
+				assert.Equal(t, "http://example.com/", s.Tags["url"])
+				phases = append(phases, s.Tags["phase"])
+			}
+		}
+		assert.Equal(t, []string{"waiting", "duration"}, phases)
+		assert.NotContains(t, tags, "phase")
+	})
+}
@@ -0,0 +1,99 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"crypto/tls"
+	"strings"
+)
+
+// TLS handshake message type of a ClientHello; see RFC 5246, section 7.4.
+const tlsClientHello = 1
+
+// Returns the cipher suites offered by the ClientHello that b starts with, in the client's
+// order of preference; nil if it doesn't start with a whole one. Go's client has no hook
+// for what it offers, and ignores the order configured in tls.Config.CipherSuites, so
+// looking at what it sent is the only way to know. Go writes the whole record at once.
+func parseClientHelloCipherSuites(b []byte) []uint16 {
+	// Record header, handshake header, client version and random.
+	const skip = tlsRecordHeaderLen + 4 + 2 + 32
+	if len(b) < skip+1 || b[0] != tlsRecordHandshake || b[tlsRecordHeaderLen] != tlsClientHello {
+		return nil
+	}
+	b = b[skip:]
+
+	// Then the session ID, and the cipher suites; both prefixed with their length.
+	sessionIDLen := int(b[0])
+	if len(b) < 1+sessionIDLen+2 {
+		return nil
+	}
+	b = b[1+sessionIDLen:]
+	n := int(b[0])<<8 | int(b[1])
+	b = b[2:]
+	if n%2 != 0 || len(b) < n {
+		return nil
+	}
+	suites := make([]uint16, 0, n/2)
+	for i := 0; i < n; i += 2 {
+		suites = append(suites, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return suites
+}
+
+// Reports whether the server negotiated a cipher suite other than the client's favourite
+// of the ones it could have: only TLS 1.3 suites can be used with TLS 1.3, and none of them
+// with older versions, which also need one that works with the server's certificate. So
+// getting eg. ECDHE-RSA when the client's first choice was ECDHE-ECDSA isn't an override.
+// Suites Go doesn't know (eg. signaling ones) are skipped, as is everything if nothing was
+// offered.
+func serverCipherOverride(offered []uint16, version, negotiated uint16) bool {
+	negotiatedAuth := cipherSuiteAuth(negotiated)
+	for _, id := range offered {
+		if isTLS13CipherSuite(id) != (version == tls.VersionTLS13) {
+			continue
+		}
+		if auth := cipherSuiteAuth(id); auth != "" && auth == negotiatedAuth {
+			return id != negotiated
+		}
+	}
+	return false
+}
+
+func isTLS13CipherSuite(id uint16) bool {
+	return id>>8 == 0x13
+}
+
+// Returns how a cipher suite authenticates the server: "ECDSA", "RSA", or "any" for
+// TLS 1.3 ones (which leave that to the signature algorithm); empty if Go doesn't know it.
+func cipherSuiteAuth(id uint16) string {
+	if isTLS13CipherSuite(id) {
+		return "any"
+	}
+	name := tls.CipherSuiteName(id)
+	switch {
+	case strings.HasPrefix(name, "0x"):
+		return ""
+	case strings.Contains(name, "_ECDSA_"):
+		return "ECDSA"
+	default:
+		return "RSA"
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseClientHelloCipherSuites(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer func() { _ = c1.Close() }()
+	defer func() { _ = c2.Close() }()
+	go func() {
+		_ = tls.Client(c1, &tls.Config{
+			ServerName:   "example.com",
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		}).Handshake()
+	}()
+	hello := make([]byte, 4096)
+	n, err := c2.Read(hello)
+	assert.NoError(t, err)
+	hello = hello[:n]
+
+	suites := parseClientHelloCipherSuites(hello)
+	assert.Len(t, suites, 2)
+	assert.Contains(t, suites, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384)
+	assert.Contains(t, suites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)
+
+	assert.Nil(t, parseClientHelloCipherSuites(hello[:50]))
+	assert.Nil(t, parseClientHelloCipherSuites([]byte("GET / HTTP/1.1\r\n\r\n")))
+	assert.Nil(t, parseClientHelloCipherSuites(nil))
+}
+
+func TestServerCipherOverride(t *testing.T) {
+	offered := []uint16{
+		tls.TLS_AES_128_GCM_SHA256,
+		tls.TLS_CHACHA20_POLY1305_SHA256,
+		0x5600, // TLS_FALLBACK_SCSV, which Go doesn't know as a suite.
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+	testdata := map[string]struct {
+		version, negotiated uint16
+		override            bool
+	}{
+		"1.3 first":       {tls.VersionTLS13, tls.TLS_AES_128_GCM_SHA256, false},
+		"1.3 second":      {tls.VersionTLS13, tls.TLS_CHACHA20_POLY1305_SHA256, true},
+		"1.2 ecdsa first": {tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, false},
+		"1.2 rsa first":   {tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, false},
+		"1.2 rsa second":  {tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, true},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, data.override, serverCipherOverride(offered, data.version, data.negotiated))
+		})
+	}
+	assert.False(t, serverCipherOverride(nil, tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256))
+}
+
+func TestTracerServerCipherOverride(t *testing.T) {
+	get := func(cfg *tls.Config) Trail {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		srv.TLS = cfg
+		srv.StartTLS()
+		defer srv.Close()
+
+		transport := srv.Client().Transport.(*http.Transport)
+		transport.DialContext = NewDialer(net.Dialer{}).DialContext
+		client := http.Client{Transport: transport}
+
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+
+	t.Run("default", func(t *testing.T) {
+		trail := get(nil)
+		assert.NotEmpty(t, trail.OfferedCipherSuites)
+		assert.NotZero(t, trail.CipherSuite)
+		assert.False(t, trail.ServerCipherOverride)
+	})
+	t.Run("override", func(t *testing.T) {
+		// Whatever the client prefers, this isn't its first choice of ECDHE-RSA suites.
+		trail := get(&tls.Config{
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+		})
+		assert.Equal(t, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, trail.CipherSuite)
+		assert.Contains(t, trail.OfferedCipherSuites, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384)
+		assert.True(t, trail.ServerCipherOverride)
+	})
+	t.Run("plaintext", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer srv.Close()
+		client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_ = res.Body.Close()
+		}
+		trail := tracer.Done()
+		assert.Nil(t, trail.OfferedCipherSuites)
+		assert.False(t, trail.ServerCipherOverride)
+	})
+}
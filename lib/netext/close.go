@@ -0,0 +1,75 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// Why a Conn was closed; see Trail.ConnCloseReason.
+const (
+	connClosedByServer int32 = iota + 1
+	connClosedOnError
+	connClosedNoReuse
+	connClosedIdle
+)
+
+var connCloseReasons = map[int32]string{
+	connClosedByServer: "server_closed",
+	connClosedOnError:  "error",
+	connClosedNoReuse:  "no_reuse",
+	connClosedIdle:     "idle",
+}
+
+// Records how reads and writes over the connection ended, for closeCause.
+func (c *Conn) sawIOError(err error) {
+	switch err {
+	case nil:
+	case io.EOF:
+		atomic.StoreInt32(&c.sawEOF, 1)
+	default:
+		atomic.StoreInt32(&c.sawError, 1)
+	}
+}
+
+// Works out why the connection's being closed. Only the transport closes it, so unless the
+// server already had, or something went wrong, it's because it decided not to reuse it:
+// either right after a request (eg. "Connection: close", or with keep-alives disabled),
+// or later, from the idle pool.
+func (c *Conn) closeCause() int32 {
+	switch {
+	case atomic.LoadInt32(&c.sawEOF) != 0:
+		return connClosedByServer
+	case atomic.LoadInt32(&c.sawError) != 0:
+		return connClosedOnError
+	case atomic.LoadInt32(&c.inRequest) != 0:
+		return connClosedNoReuse
+	default:
+		return connClosedIdle
+	}
+}
+
+// CloseReason returns why the connection was closed, as one of "server_closed", "error",
+// "no_reuse" or "idle"; or "" if it's still open.
+func (c *Conn) CloseReason() string {
+	return connCloseReasons[atomic.LoadInt32(&c.closeReason)]
+}
@@ -0,0 +1,104 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracerConnClose(t *testing.T) {
+	get := func(transport *http.Transport, url string) Trail {
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		res, err := (&http.Client{Transport: transport}).Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_, _ = ioutil.ReadAll(tracer.Body(res.Body))
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hi"))
+	}))
+	defer srv.Close()
+
+	t.Run("reused", func(t *testing.T) {
+		closed := make(chan string, 1)
+		dialer := NewDialer(net.Dialer{})
+		dialer.OnConnClose = func(c *Conn) { closed <- c.CloseReason() }
+		transport := &http.Transport{DialContext: dialer.DialContext}
+
+		trail := get(transport, srv.URL)
+		assert.False(t, trail.ConnClosedByClient)
+		assert.Equal(t, "", trail.ConnCloseReason)
+		for _, s := range trail.Samples(nil) {
+			assert.NotEqual(t, metrics.HTTPConnsClosed, s.Metric)
+		}
+
+		transport.CloseIdleConnections()
+		assert.Equal(t, "idle", <-closed)
+	})
+	t.Run("no reuse", func(t *testing.T) {
+		transport := &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext, DisableKeepAlives: true}
+
+		trail := get(transport, srv.URL)
+		assert.True(t, trail.ConnClosedByClient)
+		assert.Equal(t, "no_reuse", trail.ConnCloseReason)
+		seen := false
+		for _, s := range trail.Samples(map[string]string{"name": "test"}) {
+			if s.Metric == metrics.HTTPConnsClosed {
+				seen = true
+				assert.Equal(t, map[string]string{"name": "test", "close_reason": "no_reuse"}, s.Tags)
+			}
+		}
+		assert.True(t, seen, "no http_conns_closed sample")
+	})
+	t.Run("server closed", func(t *testing.T) {
+		// A body without a length ends when the server closes the connection.
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer func() { _ = l.Close() }()
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Read(make([]byte, 4096))
+			_, _ = conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\nhi"))
+			_ = conn.Close()
+		}()
+
+		trail := get(&http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}, "http://"+l.Addr().String())
+		assert.False(t, trail.ConnClosedByClient)
+		assert.Equal(t, "server_closed", trail.ConnCloseReason)
+	})
+}
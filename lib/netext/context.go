@@ -29,10 +29,24 @@ type ctxKey int
 
 const (
 	ctxKeyTracer ctxKey = iota
+	ctxKeyWarmup
 )
 
 func WithTracer(ctx context.Context, tracer *Tracer) context.Context {
 	ctx = httptrace.WithClientTrace(ctx, tracer.Trace())
 	ctx = context.WithValue(ctx, ctxKeyTracer, tracer)
+	tracer.ctx = ctx
 	return ctx
 }
+
+// WithWarmup marks requests made with ctx as warming connections up ahead of the test
+// proper: ones they open are flagged as warmed (see Trail.ConnWarmed), and don't count
+// towards http_conns_new, which is for connections the test itself had to open.
+func WithWarmup(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKeyWarmup, true)
+}
+
+func isWarmup(ctx context.Context) bool {
+	v, _ := ctx.Value(ctxKeyWarmup).(bool)
+	return v
+}
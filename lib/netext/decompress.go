@@ -0,0 +1,61 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// CanDecompress returns whether Decompress can decode bodies with a Content-Encoding.
+// There's no "br": the standard library has no Brotli decoder.
+func CanDecompress(encoding string) bool {
+	return encoding == "gzip" || encoding == "deflate"
+}
+
+// Decompress decodes a response body sent with a Content-Encoding CanDecompress, and
+// returns how long that took, for Trail.DecompressionTime.
+func Decompress(encoding string, body []byte) ([]byte, time.Duration, error) {
+	start := time.Now()
+	var r io.Reader
+	var err error
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// Meant to be zlib-wrapped, but it's often sent raw; take either.
+		if r, err = zlib.NewReader(bytes.NewReader(body)); err == zlib.ErrHeader {
+			r, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	default:
+		return body, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	decoded, err := ioutil.ReadAll(r)
+	return decoded, time.Since(start), err
+}
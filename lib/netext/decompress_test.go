@@ -0,0 +1,73 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecompress(t *testing.T) {
+	plain := bytes.Repeat([]byte("hello, world. "), 1000)
+	compress := func(w io.WriteCloser, buf *bytes.Buffer) []byte {
+		_, _ = w.Write(plain)
+		_ = w.Close()
+		return buf.Bytes()
+	}
+	var gzipped, zlibbed, deflated bytes.Buffer
+	fw, _ := flate.NewWriter(&deflated, flate.DefaultCompression)
+	testdata := map[string]struct {
+		encoding string
+		body     []byte
+	}{
+		"gzip":        {"gzip", compress(gzip.NewWriter(&gzipped), &gzipped)},
+		"deflate":     {"deflate", compress(zlib.NewWriter(&zlibbed), &zlibbed)},
+		"deflate,raw": {"deflate", compress(fw, &deflated)},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			assert.True(t, CanDecompress(data.encoding))
+			decoded, took, err := Decompress(data.encoding, data.body)
+			assert.NoError(t, err)
+			assert.Equal(t, plain, decoded)
+			assert.True(t, took > 0)
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		assert.False(t, CanDecompress("br"))
+		assert.False(t, CanDecompress(""))
+		decoded, took, err := Decompress("br", []byte("as is"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("as is"), decoded)
+		assert.Zero(t, took)
+	})
+	t.Run("corrupt", func(t *testing.T) {
+		_, _, err := Decompress("gzip", []byte("not gzip"))
+		assert.Error(t, err)
+	})
+}
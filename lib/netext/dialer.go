@@ -22,17 +22,112 @@ package netext
 
 import (
 	"context"
+	"math/rand"
 	"net"
+	"os"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/loadimpact/k6/stats"
+	"github.com/pkg/errors"
 	"github.com/viki-org/dnscache"
 )
 
+// PoolStats describes the connections a Dialer has opened to a single host.
+type PoolStats struct {
+	Open int64 // Currently open connections.
+	Peak int64 // Most connections that were ever open at the same time.
+}
+
+// Fraction of the file descriptor limit at which a Dialer's OnFDLimit is called; any
+// higher, and some headroom for files, pipes, etc. has to remain.
+const FDWarnRatio = 0.9
+
 type Dialer struct {
 	net.Dialer
 
 	Resolver *dnscache.Resolver
+
+	// Resolves hosts instead of the Resolver, if set; eg. to use a custom resolver.
+	Lookup func(host string) ([]net.IP, error)
+
+	// IPs to connect to for hosts instead of looking them up, like an in-process
+	// /etc/hosts; eg. {"staging.example.com": "127.0.0.1"}.
+	HostOverrides map[string]string
+
+	// Called once for every newly established connection, never for reused ones.
+	OnNewConn func(net.Conn)
+
+	// Called once a connection the Dialer made is closed.
+	OnConnClose func(*Conn)
+
+	// Fraction (0-1) of connection attempts to fail with a synthetic "connection refused",
+	// for testing how scripts cope with unreliable networks.
+	DialFailureRate float64
+
+	// Called before every dial with the requested address; may return a different
+	// "host:port" to connect to instead, or an error to refuse the connection.
+	DialHook func(network, addr string) (string, error)
+
+	// Try to use TCP Fast Open, where supported (currently Linux only). Note that the
+	// handshake then happens with the first write, so it's counted as Sending rather
+	// than Connecting.
+	TCPFastOpen bool
+
+	// Connect through a SOCKS5 proxy, rather than directly. Hosts are still resolved
+	// locally, and the proxy asked to connect to an IP; the time that takes shows up
+	// as Trail.ProxyHandshake, with Connecting covering only the connection to the proxy.
+	SOCKS5 *SOCKS5Proxy
+
+	// Connect through a chain of SOCKS5 proxies instead: the first is connected to, and
+	// each asked to connect to the next, the last to the target. Handshakes all add up to
+	// Trail.ProxyHandshake. Takes precedence over SOCKS5, which is a chain of one.
+	ProxyChain []*SOCKS5Proxy
+
+	// Follow the framing of plain HTTP/1.x responses, to flag ones that could be used to
+	// smuggle responses; see Trail.FramingAnomaly. This costs a little CPU for every read.
+	DetectFramingAnomalies bool
+
+	// Count the bytes of plain HTTP/1.x response headers, as Trail.ResponseHeaderBytes;
+	// this follows the framing just the same, so it costs as much, but flags nothing.
+	MeasureHeaderBytes bool
+
+	// Leave Nagle's algorithm on for new connections, rather than setting TCP_NODELAY
+	// like Go does by default; for reproducing how other clients behave.
+	Nagle bool
+
+	// Soft cap on connections open at once, across all hosts; 0 for none. Once it's hit,
+	// dials wait for another connection to close, which shows up as time Blocked, rather
+	// than erroring with "too many open files" when the process runs out of descriptors.
+	MaxOpenConns int64
+
+	// Times to retry a connect that timed out or was refused, before giving up; the first
+	// retry waits ConnectRetryBackoff, each one after that twice as long as the last, up to
+	// 30s, like a RetryPolicy's. The retries show up as Trail.ConnectRetries, the time
+	// waited as Trail.ConnectRetryDelay.
+	ConnectRetries      int
+	ConnectRetryBackoff time.Duration
+
+	// Called once, when the number of open connections reaches FDWarnRatio of the
+	// process' file descriptor limit; nil to not be warned.
+	OnFDLimit func(open, limit int64)
+
+	poolLock  sync.Mutex
+	poolStats map[string]*PoolStats
+
+	// Total open connections, including ones still being dialed; guarded by poolLock.
+	openConns int64
+	connFreed chan struct{} // Closed when a connection is; nil if nobody's waiting.
+	fdWarned  bool
+
+	// The Resolver caches forever, so only the first lookup of a host through it really
+	// resolves it.
+	resolvedLock sync.Mutex
+	resolved     map[string]bool
 }
 
 func NewDialer(dialer net.Dialer) *Dialer {
@@ -42,41 +137,516 @@ func NewDialer(dialer net.Dialer) *Dialer {
 	}
 }
 
-func (d Dialer) DialContext(ctx context.Context, proto, addr string) (net.Conn, error) {
-	delimiter := strings.LastIndex(addr, ":")
-	ip, err := d.Resolver.FetchOne(addr[:delimiter])
+func (d *Dialer) DialContext(ctx context.Context, proto, addr string) (net.Conn, error) {
+	addr, ipAddr, err := d.resolve(ctx, proto, addr)
 	if err != nil {
 		return nil, err
 	}
+	if d.DialFailureRate > 0 && rand.Float64() < d.DialFailureRate {
+		return nil, d.failDial(ctx, proto, ipAddr, os.NewSyscallError("connect", syscall.ECONNREFUSED))
+	}
+	if err := d.acquireConn(ctx); err != nil {
+		return nil, err
+	}
+	dialer := d.Dialer
+	if d.TCPFastOpen {
+		dialer.Control = enableFastOpen
+	}
+	var conn net.Conn
+	for try := 1; ; try++ {
+		if chain := d.proxyChain(); len(chain) == 0 {
+			conn, err = dialer.DialContext(ctx, proto, ipAddr)
+		} else {
+			conn, err = dialProxies(ctx, dialer, proto, ipAddr, chain)
+		}
+		if err == nil || try > d.ConnectRetries || ctx.Err() != nil || !isTransientDialError(err) {
+			break
+		}
+		delay := exponentialBackoff(d.ConnectRetryBackoff, try)
+		if v := ctx.Value(ctxKeyTracer); v != nil {
+			v.(*Tracer).retryingConnect(delay)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+	if err != nil {
+		d.releaseConn()
+		return nil, err
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok && d.Nagle {
+		_ = tcpConn.SetNoDelay(false)
+	}
+
+	var framing *httpFraming
+	if d.DetectFramingAnomalies || d.MeasureHeaderBytes {
+		framing = &httpFraming{ignoreAnomalies: !d.DetectFramingAnomalies}
+	}
+	return d.track(ctx, addr, &Conn{
+		Conn:     conn,
+		fastOpen: d.TCPFastOpen,
+		framing:  framing,
+	}), nil
+}
+
+// Resolves the host of addr, which a DialHook may first rewrite, the way the Dialer is set
+// up to: returns the address to count the connection towards, and the IP and port to
+// connect to. Failures are recorded on the Tracer in ctx, if there is one.
+func (d *Dialer) resolve(ctx context.Context, proto, addr string) (string, string, error) {
+	updateTracer(ctx, func(tracer *Tracer) {
+		if tracer.MeasurePoolLookup && tracer.dialStart.IsZero() {
+			tracer.dialStart = time.Now()
+		}
+	})
+	if d.DialHook != nil {
+		target, err := d.DialHook(proto, addr)
+		if err != nil {
+			return "", "", d.failDial(ctx, proto, addr, err)
+		}
+		if target != addr {
+			updateTracer(ctx, func(tracer *Tracer) { tracer.dialRewrite = target })
+			addr = target
+		}
+	}
+
+	delimiter := strings.LastIndex(addr, ":")
+	host := addr[:delimiter]
+	var ips []net.IP
+	var err error
+	var lookup time.Duration
+	var looked bool // Whether host was really looked up, rather than cached, an IP or overridden.
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		ips = []net.IP{ip}
+	} else if override, ok := d.HostOverrides[host]; ok {
+		ip := net.ParseIP(override)
+		if ip == nil {
+			return "", "", errors.Errorf("invalid host override for %s: %q isn't an IP", host, override)
+		}
+		ips = []net.IP{ip}
+		updateTracer(ctx, func(tracer *Tracer) { tracer.hostOverride = override })
+	} else {
+		fetch := d.Resolver.Fetch
+		if d.Lookup != nil {
+			fetch = d.Lookup
+		}
+		start := time.Now()
+		ips, err = fetch(host)
+		lookup = time.Since(start)
+		if err == nil && len(ips) == 0 {
+			err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		if err != nil {
+			updateTracer(ctx, func(tracer *Tracer) { tracer.dnsError = ClassifyDNSError(err) })
+			return "", "", err
+		}
+		// Whatever a custom Lookup does, it's a lookup; the Resolver only has its cache to
+		// fill the first time.
+		looked = d.Lookup != nil || d.firstResolution(host)
+	}
+	ip := ips[0]
+	if looked {
+		updateTracer(ctx, func(tracer *Tracer) {
+			tracer.dnsAnswerCount = len(ips)
+			tracer.dnsRecord = ip
+			tracer.lookingUp = lookup
+		})
+	}
 	ipStr := ip.String()
 	if strings.ContainsRune(ipStr, ':') {
 		ipStr = "[" + ipStr + "]"
 	}
-	conn, err := d.Dialer.DialContext(ctx, proto, ipStr+":"+addr[delimiter+1:])
+	return addr, ipStr + ":" + addr[delimiter+1:], nil
+}
+
+// Sets up c, a connection the Dialer just made to addr: gives it an ID, counts it as open
+// until it's closed, and has it count its traffic towards the request that's dialing it,
+// if there is one.
+func (d *Dialer) track(ctx context.Context, addr string, c *Conn) *Conn {
+	d.connOpened(addr)
+	c.ConnID = atomic.AddUint64(&lastConnID, 1)
+	c.warmed = isWarmup(ctx)
+	c.onClose = func() {
+		d.connClosed(addr)
+		d.releaseConn()
+		if d.OnConnClose != nil {
+			d.OnConnClose(c)
+		}
+	}
+	if v := ctx.Value(ctxKeyTracer); v != nil {
+		tracer := v.(*Tracer)
+		// The handshake's traffic is the dialing request's, until one gets the connection.
+		c.hooks.Store(tracer.counters())
+	}
+	if d.OnNewConn != nil {
+		d.OnNewConn(c)
+	}
+	return c
+}
+
+// Calls update with the Tracer in ctx, if there is one, holding its lock; a dial can outlast
+// the request that started it.
+func updateTracer(ctx context.Context, update func(tracer *Tracer)) {
+	if v := ctx.Value(ctxKeyTracer); v != nil {
+		tracer := v.(*Tracer)
+		tracer.lock.Lock()
+		defer tracer.lock.Unlock()
+		update(tracer)
+	}
+}
+
+// Returns the proxies to connect through, if any.
+func (d *Dialer) proxyChain() []*SOCKS5Proxy {
+	if len(d.ProxyChain) > 0 {
+		return d.ProxyChain
+	}
+	if d.SOCKS5 != nil {
+		return []*SOCKS5Proxy{d.SOCKS5}
+	}
+	return nil
+}
+
+// Connects to addr through a chain of SOCKS5 proxies, tunnelling through each in turn. A
+// handshake that fails is reported as a *ProxyError, saying which hop it was.
+func dialProxies(ctx context.Context, dialer net.Dialer, proto, addr string, chain []*SOCKS5Proxy) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, proto, chain[0].Addr)
 	if err != nil {
 		return nil, err
 	}
+	updateTracer(ctx, func(tracer *Tracer) {
+		tracer.proxy = chain[0].Addr
+		tracer.proxyChain = len(chain)
+	})
+	for i, p := range chain {
+		next := addr
+		if i+1 < len(chain) {
+			next = chain[i+1].Addr
+		}
+		start := time.Now()
+		err := p.handshake(ctx, conn, next)
+		updateTracer(ctx, func(tracer *Tracer) {
+			tracer.proxyHandshake += time.Since(start)
+			if err != nil {
+				tracer.proxyFailed = true
+			}
+		})
+		if err != nil {
+			_ = conn.Close()
+			err = &ProxyError{Hop: i + 1, Hops: len(chain), Proxy: p.Addr, Err: err}
+			return nil, &net.OpError{Op: "proxyconnect", Net: proto, Addr: conn.RemoteAddr(), Err: err}
+		}
+	}
+	return conn, nil
+}
+
+// Kinds of DNS resolution failures; see ClassifyDNSError.
+const (
+	DNSErrorNXDomain = "nxdomain"
+	DNSErrorServFail = "servfail"
+	DNSErrorTimeout  = "timeout"
+	DNSErrorOther    = "other"
+)
+
+// ClassifyDNSError tells what kind of failure a lookup error was: the name doesn't exist
+// (NXDOMAIN), the server failed to answer it (SERVFAIL, which Go calls "server
+// misbehaving"), or didn't answer in time; anything else is DNSErrorOther.
+func ClassifyDNSError(err error) string {
+	dnsErr, ok := err.(*net.DNSError)
+	switch {
+	case !ok:
+		return DNSErrorOther
+	case dnsErr.IsNotFound:
+		return DNSErrorNXDomain
+	case dnsErr.IsTimeout:
+		return DNSErrorTimeout
+	case strings.Contains(dnsErr.Err, "server misbehaving"):
+		return DNSErrorServFail
+	default:
+		return DNSErrorOther
+	}
+}
+
+// Returns whether host is a name that hasn't been resolved before.
+func (d *Dialer) firstResolution(host string) bool {
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return false
+	}
+
+	d.resolvedLock.Lock()
+	defer d.resolvedLock.Unlock()
+
+	if d.resolved[host] {
+		return false
+	}
+	if d.resolved == nil {
+		d.resolved = make(map[string]bool)
+	}
+	d.resolved[host] = true
+	return true
+}
+
+// Fails a dial as if connecting had returned cause, including the tracer's view of it.
+func (d *Dialer) failDial(ctx context.Context, proto, addr string, cause error) error {
+	// Only fill in Addr for IPs; anything else would mean a DNS lookup just to fail.
+	var netAddr net.Addr
+	if host, _, err := net.SplitHostPort(addr); err == nil && net.ParseIP(host) != nil {
+		if tcpAddr, err := net.ResolveTCPAddr(proto, addr); err == nil {
+			netAddr = tcpAddr
+		}
+	}
+	err := &net.OpError{
+		Op:   "dial",
+		Net:  proto,
+		Addr: netAddr,
+		Err:  cause,
+	}
 	if v := ctx.Value(ctxKeyTracer); v != nil {
 		tracer := v.(*Tracer)
-		return &Conn{conn, &tracer.bytesRead, &tracer.bytesWritten}, nil
+		tracer.ConnectStart(proto, addr)
+		tracer.ConnectDone(proto, addr, err)
+	}
+	return err
+}
+
+// PoolStats returns a snapshot of connection statistics, keyed by "host:port".
+func (d *Dialer) PoolStats() map[string]PoolStats {
+	d.poolLock.Lock()
+	defer d.poolLock.Unlock()
+
+	pool := make(map[string]PoolStats, len(d.poolStats))
+	for host, ps := range d.poolStats {
+		pool[host] = *ps
 	}
-	return conn, err
+	return pool
 }
 
+// PoolSamples returns one connection high-water mark sample per host.
+func (d *Dialer) PoolSamples(t time.Time) []stats.Sample {
+	pool := d.PoolStats()
+	samples := make([]stats.Sample, 0, len(pool))
+	for host, ps := range pool {
+		samples = append(samples, stats.Sample{
+			Metric: metrics.HTTPConnsPeak,
+			Time:   t,
+			Tags:   map[string]string{"host": host},
+			Value:  float64(ps.Peak),
+		})
+	}
+	return samples
+}
+
+// OpenConns returns the number of connections currently open, across all hosts.
+func (d *Dialer) OpenConns() int64 {
+	d.poolLock.Lock()
+	defer d.poolLock.Unlock()
+	return d.openConns
+}
+
+// Reserves a connection under MaxOpenConns, waiting for one to be freed if need be.
+func (d *Dialer) acquireConn(ctx context.Context) error {
+	for {
+		d.poolLock.Lock()
+		if d.MaxOpenConns <= 0 || d.openConns < d.MaxOpenConns {
+			d.openConns++
+			open, limit, warn := d.openConns, int64(0), false
+			if d.OnFDLimit != nil && !d.fdWarned {
+				limit = fdLimit()
+				warn = limit > 0 && float64(open) >= FDWarnRatio*float64(limit)
+				d.fdWarned = warn
+			}
+			d.poolLock.Unlock()
+
+			if warn {
+				d.OnFDLimit(open, limit)
+			}
+			return nil
+		}
+		if d.connFreed == nil {
+			d.connFreed = make(chan struct{})
+		}
+		freed := d.connFreed
+		d.poolLock.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (d *Dialer) releaseConn() {
+	d.poolLock.Lock()
+	defer d.poolLock.Unlock()
+
+	d.openConns--
+	if d.connFreed != nil {
+		close(d.connFreed)
+		d.connFreed = nil
+	}
+}
+
+func (d *Dialer) connOpened(addr string) {
+	d.poolLock.Lock()
+	defer d.poolLock.Unlock()
+
+	if d.poolStats == nil {
+		d.poolStats = make(map[string]*PoolStats)
+	}
+	ps, ok := d.poolStats[addr]
+	if !ok {
+		ps = &PoolStats{}
+		d.poolStats[addr] = ps
+	}
+	ps.Open++
+	if ps.Open > ps.Peak {
+		ps.Peak = ps.Open
+	}
+}
+
+func (d *Dialer) connClosed(addr string) {
+	d.poolLock.Lock()
+	defer d.poolLock.Unlock()
+
+	if ps, ok := d.poolStats[addr]; ok {
+		ps.Open--
+	}
+}
+
+// Source of Conn.ConnID; shared by all Dialers, so IDs are unique within the process.
+var lastConnID uint64
+
 type Conn struct {
 	net.Conn
 
+	// Identifies the physical connection, for correlating the requests made over it.
+	ConnID uint64
+
+	// Those of the request the connection is serving, if any. The transport reads and writes
+	// from its own goroutines, so they're only ever swapped whole, atomically.
+	hooks atomic.Pointer[connHooks]
+
+	fastOpen bool // Dialed with TCP Fast Open.
+	warmed   bool // Dialed for a warm-up request; see WithWarmup.
+
+	// The RTT estimate from when the connection was made, in nanoseconds, atomically; see
+	// Trail.EstimatedRTT. Zero if there isn't one.
+	estimatedRTT int64
+
+	renegotiation tlsRenegotiation
+	framing       *httpFraming // Nil unless the Dialer's DetectFramingAnomalies or MeasureHeaderBytes was set.
+
+	// Cipher suites offered by the ClientHello, if the first write was one. Only touched
+	// by that write; the handshake it starts has to be done before anyone reads it.
+	wroteFirst          bool
+	offeredCipherSuites []uint16
+
+	// Why the connection was closed, once it has been, atomically; see closeCause, whose
+	// flags these are: whether a Tracer has it, and how reads and writes have gone.
+	closeReason                 int32
+	inRequest, sawEOF, sawError int32
+
+	onClose   func()
+	closeOnce sync.Once
+}
+
+// What a Tracer has a connection do, and record, while it's serving its request.
+type connHooks struct {
+	// If set, every Read or Write gets a deadline this far in the future.
+	ReadTimeout, WriteTimeout time.Duration
+
+	// If a deadline is hit or a read is reset by the peer, this is set to
+	// ioReadTimeout, ioWriteTimeout or ioConnReset, unless it already was.
+	IOError *int32
+
+	// Set to why a response's framing was suspect, if it's still zero; see httpFraming.
+	FramingAnomaly *int32
+
+	// Added to as response heads are read; see Trail.ResponseHeaderBytes.
+	ResponseHeaderBytes *int64
+
+	// Added to as bytes are read and written.
 	BytesRead, BytesWritten *int64
+
+	// Set to the UnixNano time of the next write, if it's still zero.
+	FirstWrite *int64
+
+	// Counts TLS renegotiations, and the nanoseconds they took; see Trail.TLSRenegotiated.
+	Renegotiations, RenegotiationTime *int64
+}
+
+// Returned by Conn.loadHooks when no request has set any.
+var noHooks connHooks
+
+func (c *Conn) loadHooks() *connHooks {
+	if hooks := c.hooks.Load(); hooks != nil {
+		return hooks
+	}
+	return &noHooks
 }
 
 func (c *Conn) Read(b []byte) (int, error) {
+	hooks := c.loadHooks()
+	if hooks.ReadTimeout > 0 {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(hooks.ReadTimeout))
+	}
 	n, err := c.Conn.Read(b)
-	atomic.AddInt64(c.BytesRead, int64(n))
+	// The transport's reads wait for responses from before requests are sent, so what's
+	// read is for whichever request has the connection by the time it arrives.
+	hooks = c.loadHooks()
+	if hooks.BytesRead != nil {
+		atomic.AddInt64(hooks.BytesRead, int64(n))
+	}
+	c.renegotiation.Read(b[:n], hooks.Renegotiations, hooks.RenegotiationTime)
+	if c.framing != nil {
+		c.framing.Read(b[:n], hooks.FramingAnomaly, hooks.ResponseHeaderBytes)
+	}
+	hooks.checkTimeout(err, ioReadTimeout)
+	c.sawIOError(err)
+	if hooks.IOError != nil && isConnReset(err) {
+		atomic.CompareAndSwapInt32(hooks.IOError, 0, ioConnReset)
+	}
 	return n, err
 }
 
 func (c *Conn) Write(b []byte) (int, error) {
+	hooks := c.loadHooks()
+	if hooks.FirstWrite != nil {
+		atomic.CompareAndSwapInt64(hooks.FirstWrite, 0, time.Now().UnixNano())
+	}
+	if hooks.WriteTimeout > 0 {
+		_ = c.Conn.SetWriteDeadline(time.Now().Add(hooks.WriteTimeout))
+	}
 	n, err := c.Conn.Write(b)
-	atomic.AddInt64(c.BytesWritten, int64(n))
+	if hooks.BytesWritten != nil {
+		atomic.AddInt64(hooks.BytesWritten, int64(n))
+	}
+	if !c.wroteFirst {
+		c.wroteFirst = true
+		c.offeredCipherSuites = parseClientHelloCipherSuites(b[:n])
+	}
+	c.renegotiation.Wrote(b[:n], hooks.Renegotiations, hooks.RenegotiationTime)
+	if c.framing != nil {
+		c.framing.Wrote(b[:n])
+	}
+	hooks.checkTimeout(err, ioWriteTimeout)
+	c.sawIOError(err)
 	return n, err
 }
+
+func (h *connHooks) checkTimeout(err error, kind int32) {
+	if h.IOError == nil || err == nil {
+		return
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		atomic.CompareAndSwapInt32(h.IOError, 0, kind)
+	}
+}
+
+func (c *Conn) Close() error {
+	atomic.CompareAndSwapInt32(&c.closeReason, 0, c.closeCause())
+	if c.onClose != nil {
+		c.closeOnce.Do(c.onClose)
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,593 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialerPoolStats(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+
+	dialer := NewDialer(net.Dialer{})
+	transport := &http.Transport{DialContext: dialer.DialContext}
+	client := http.Client{Transport: transport}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Get(srv.URL)
+			if assert.NoError(t, err) {
+				_ = res.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(5), dialer.PoolStats()[host].Peak)
+
+	transport.CloseIdleConnections()
+	ps := dialer.PoolStats()[host]
+	assert.Equal(t, int64(0), ps.Open)
+	assert.Equal(t, int64(5), ps.Peak)
+
+	samples := dialer.PoolSamples(time.Now())
+	if assert.Len(t, samples, 1) {
+		assert.Equal(t, metrics.HTTPConnsPeak, samples[0].Metric)
+		assert.Equal(t, host, samples[0].Tags["host"])
+		assert.Equal(t, float64(5), samples[0].Value)
+	}
+}
+
+func TestDialerOnNewConn(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var calls int64
+	dialer := NewDialer(net.Dialer{})
+	dialer.OnNewConn = func(conn net.Conn) {
+		assert.Equal(t, srv.Listener.Addr().String(), conn.RemoteAddr().String())
+		atomic.AddInt64(&calls, 1)
+	}
+	client := http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+
+	for i := 0; i < 3; i++ {
+		res, err := client.Get(srv.URL)
+		if assert.NoError(t, err) {
+			_ = res.Body.Close()
+		}
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&calls))
+}
+
+func TestDialerWarmup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	dialer := NewDialer(net.Dialer{})
+	get := func(ctx context.Context, client *http.Client) Trail {
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(ctx, tracer)))
+		if assert.NoError(t, err) {
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+	countsNew := func(trail Trail) bool {
+		for _, s := range trail.Samples(nil) {
+			if s.Metric == metrics.HTTPConnsNew {
+				return true
+			}
+		}
+		return false
+	}
+
+	warmed := &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+	trail := get(WithWarmup(context.Background()), warmed)
+	assert.False(t, trail.ConnReused)
+	assert.True(t, trail.ConnWarmed)
+	assert.False(t, countsNew(trail))
+
+	t.Run("warmed reuse", func(t *testing.T) {
+		trail := get(context.Background(), warmed)
+		assert.True(t, trail.ConnReused)
+		assert.True(t, trail.ConnWarmed)
+		assert.False(t, countsNew(trail))
+	})
+
+	organic := &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+	t.Run("new", func(t *testing.T) {
+		trail := get(context.Background(), organic)
+		assert.False(t, trail.ConnReused)
+		assert.False(t, trail.ConnWarmed)
+		assert.True(t, countsNew(trail))
+	})
+	t.Run("organic reuse", func(t *testing.T) {
+		trail := get(context.Background(), organic)
+		assert.True(t, trail.ConnReused)
+		assert.False(t, trail.ConnWarmed)
+		assert.False(t, countsNew(trail))
+	})
+}
+
+func TestDialerFailureRate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	t.Run("rate", func(t *testing.T) {
+		dialer := NewDialer(net.Dialer{})
+		dialer.DialFailureRate = 0.3
+
+		failures := 0
+		for i := 0; i < 1000; i++ {
+			conn, err := dialer.DialContext(context.Background(), "tcp", srv.Listener.Addr().String())
+			if err != nil {
+				assert.Contains(t, err.Error(), "connection refused")
+				failures++
+				continue
+			}
+			_ = conn.Close()
+		}
+		assert.InDelta(t, 300, failures, 75)
+	})
+	t.Run("trail", func(t *testing.T) {
+		dialer := NewDialer(net.Dialer{})
+		dialer.DialFailureRate = 1
+		client := http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		_, err = client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		assert.Error(t, err)
+
+		trail := tracer.Done()
+		assert.True(t, trail.Failed)
+		assert.True(t, trail.ConnectFailed)
+		assert.Equal(t, time.Duration(0), trail.Waiting)
+	})
+}
+
+func TestDialerDNSAnswers(t *testing.T) {
+	t.Run("firstResolution", func(t *testing.T) {
+		dialer := NewDialer(net.Dialer{})
+		assert.True(t, dialer.firstResolution("example.com"))
+		assert.False(t, dialer.firstResolution("example.com"))
+		assert.True(t, dialer.firstResolution("example.org"))
+		assert.False(t, dialer.firstResolution("127.0.0.1"))
+		assert.False(t, dialer.firstResolution("[::1]"))
+	})
+	t.Run("trail", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer srv.Close()
+		_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+		dialer := NewDialer(net.Dialer{})
+		dial := func() Trail {
+			tracer := &Tracer{}
+			conn, err := dialer.DialContext(WithTracer(context.Background(), tracer), "tcp", "localhost:"+port)
+			if err != nil {
+				t.Skipf("localhost doesn't resolve to the test server: %s", err)
+			}
+			_ = conn.Close()
+			return tracer.Done()
+		}
+
+		trail := dial()
+		assert.True(t, trail.DNSAnswerCount > 0)
+		assert.True(t, trail.DNSRecord.IsLoopback())
+
+		trail = dial()
+		assert.Equal(t, 0, trail.DNSAnswerCount)
+		assert.Nil(t, trail.DNSRecord)
+	})
+	t.Run("lookup", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer srv.Close()
+		_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+		dialer := NewDialer(net.Dialer{})
+		dialer.Lookup = func(host string) ([]net.IP, error) {
+			return []net.IP{net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2)}, nil
+		}
+		for i := 0; i < 2; i++ {
+			tracer := &Tracer{}
+			conn, err := dialer.DialContext(WithTracer(context.Background(), tracer), "tcp", "example.com:"+port)
+			if !assert.NoError(t, err) {
+				return
+			}
+			_ = conn.Close()
+			trail := tracer.Done()
+			assert.Equal(t, 2, trail.DNSAnswerCount, "dial %d", i)
+			assert.True(t, trail.DNSRecord.Equal(net.IPv4(127, 0, 0, 1)), "dial %d", i)
+		}
+	})
+}
+
+func TestDialerDNSErrors(t *testing.T) {
+	testdata := map[string]struct {
+		ips []net.IP
+		err error
+	}{
+		DNSErrorNXDomain: {err: &net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}},
+		DNSErrorServFail: {err: &net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true}},
+		DNSErrorTimeout:  {err: &net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true}},
+		DNSErrorOther:    {err: errors.New("resolver exploded")},
+		"empty":          {ips: []net.IP{}},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			dialer := NewDialer(net.Dialer{})
+			dialer.Lookup = func(host string) ([]net.IP, error) {
+				assert.Equal(t, "example.com", host)
+				return data.ips, data.err
+			}
+
+			tracer := &Tracer{}
+			_, err := dialer.DialContext(WithTracer(context.Background(), tracer), "tcp", "example.com:80")
+			assert.Error(t, err)
+
+			kind := name
+			if name == "empty" {
+				kind = DNSErrorNXDomain
+			}
+			trail := tracer.Done()
+			assert.Equal(t, kind, trail.DNSError)
+			assert.Equal(t, "dns_failed", trail.ErrorClass)
+			assert.True(t, trail.Failed)
+
+			var found bool
+			for _, s := range trail.Samples(map[string]string{"url": "http://example.com/"}) {
+				if s.Metric == metrics.HTTPReqDNSFailed {
+					found = true
+					assert.Equal(t, map[string]string{"url": "http://example.com/", "dns_error": kind}, s.Tags)
+				}
+			}
+			assert.True(t, found, "no http_req_dns_failed sample")
+		})
+	}
+}
+
+func TestDialerHostOverrides(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	dialer := NewDialer(net.Dialer{})
+	dialer.HostOverrides = map[string]string{"staging.example.com": "127.0.0.1", "broken.example.com": "nope"}
+	dialer.Lookup = func(host string) ([]net.IP, error) {
+		t.Errorf("looked up %s", host)
+		return nil, errors.New("no DNS here")
+	}
+	client := http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+
+	tracer := &Tracer{}
+	req, err := http.NewRequest("GET", "http://staging.example.com:"+port+"/", nil)
+	assert.NoError(t, err)
+	res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+	if assert.NoError(t, err) {
+		body, _ := ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		assert.Equal(t, "staging.example.com:"+port, string(body))
+	}
+	trail := tracer.Done()
+	assert.True(t, trail.ConnOverridden)
+	assert.Equal(t, "127.0.0.1", trail.HostOverride)
+	assert.Equal(t, time.Duration(0), trail.LookingUp)
+	assert.Equal(t, 0, trail.DNSAnswerCount)
+	assert.Equal(t, "127.0.0.1:"+port, trail.ConnRemoteAddr.String())
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := dialer.DialContext(context.Background(), "tcp", "broken.example.com:80")
+		assert.EqualError(t, err, `invalid host override for broken.example.com: "nope" isn't an IP`)
+	})
+	t.Run("not overridden", func(t *testing.T) {
+		tracer := &Tracer{}
+		conn, err := dialer.DialContext(WithTracer(context.Background(), tracer), "tcp", "127.0.0.1:"+port)
+		if assert.NoError(t, err) {
+			_ = conn.Close()
+		}
+		assert.False(t, tracer.Done().ConnOverridden)
+	})
+}
+
+func TestDialerDialHook(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("stub"))
+	}))
+	defer stub.Close()
+
+	dialer := NewDialer(net.Dialer{})
+	dialer.DialHook = func(network, addr string) (string, error) {
+		switch addr {
+		case "real.example.com:80":
+			return stub.Listener.Addr().String(), nil
+		case "blocked.example.com:80":
+			return "", errors.New("blocked by test")
+		}
+		return addr, nil
+	}
+	client := http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+
+	t.Run("rewrite", func(t *testing.T) {
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", "http://real.example.com/", nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if !assert.NoError(t, err) {
+			return
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		assert.Equal(t, "stub", string(body))
+
+		trail := tracer.Done()
+		assert.Equal(t, stub.Listener.Addr().String(), trail.DialRewrite)
+		assert.Equal(t, stub.Listener.Addr().String(), trail.ConnRemoteAddr.String())
+		assert.True(t, trail.Connecting > 0)
+		assert.True(t, trail.Waiting > 0)
+		assert.False(t, trail.Failed)
+	})
+	t.Run("block", func(t *testing.T) {
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", "http://blocked.example.com/", nil)
+		assert.NoError(t, err)
+		_, err = client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "blocked by test")
+		}
+
+		trail := tracer.Done()
+		assert.True(t, trail.ConnectFailed)
+		assert.Equal(t, "", trail.DialRewrite)
+	})
+}
+
+func TestDialerConnID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	dialer := NewDialer(net.Dialer{})
+	transport := &http.Transport{DialContext: dialer.DialContext}
+	client := http.Client{Transport: transport}
+	get := func() Trail {
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+
+	first := get()
+	assert.NotEqual(t, uint64(0), first.ConnID)
+	reused := get()
+	assert.True(t, reused.ConnReused)
+	assert.Equal(t, first.ConnID, reused.ConnID)
+
+	transport.CloseIdleConnections()
+	fresh := get()
+	assert.False(t, fresh.ConnReused)
+	assert.NotEqual(t, first.ConnID, fresh.ConnID)
+
+	other, err := NewDialer(net.Dialer{}).DialContext(context.Background(), "tcp", srv.Listener.Addr().String())
+	if assert.NoError(t, err) {
+		assert.NotEqual(t, fresh.ConnID, other.(*Conn).ConnID)
+		_ = other.Close()
+	}
+}
+
+func TestDialerTCPFastOpen(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	for _, tfo := range []bool{false, true} {
+		t.Run(fmt.Sprint(tfo), func(t *testing.T) {
+			dialer := NewDialer(net.Dialer{})
+			dialer.TCPFastOpen = tfo
+			client := http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+
+			tracer := &Tracer{}
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			assert.NoError(t, err)
+			res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+			if !assert.NoError(t, err) {
+				return
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+			assert.Equal(t, "ok", string(body))
+
+			// The test server doesn't enable Fast Open, so it can never be accepted.
+			trail := tracer.Done()
+			assert.False(t, trail.TCPFastOpen)
+			assert.False(t, trail.Failed)
+		})
+	}
+}
+
+func TestDialerMaxOpenConns(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	addr := srv.Listener.Addr().String()
+
+	dialer := NewDialer(net.Dialer{})
+	dialer.MaxOpenConns = 1
+	held, err := dialer.DialContext(context.Background(), "tcp", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(1), dialer.OpenConns())
+
+	t.Run("Deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := dialer.DialContext(ctx, "tcp", addr)
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Equal(t, int64(1), dialer.OpenConns())
+	})
+
+	t.Run("Blocked", func(t *testing.T) {
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			_ = held.Close()
+		}()
+
+		client := http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_ = res.Body.Close()
+		}
+		trail := tracer.Done()
+		assert.True(t, trail.Blocked >= 100*time.Millisecond, "%s", trail.Blocked)
+		assert.True(t, trail.Connecting < 100*time.Millisecond, "%s", trail.Connecting)
+		assert.Equal(t, int64(1), dialer.OpenConns())
+	})
+}
+
+func TestDialerConnectRetries(t *testing.T) {
+	// A server that only starts listening once it's refused n connects: Control runs
+	// before every connect, so the one after the nth finds it up.
+	refuseFirst := func(t *testing.T, dialer *Dialer, n int32) (url string, stop func()) {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		addr := srv.Listener.Addr().String()
+		_ = srv.Listener.Close()
+
+		var tries int32
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			if atomic.AddInt32(&tries, 1) == n+1 {
+				l, err := net.Listen("tcp", addr)
+				if err != nil {
+					return err
+				}
+				srv.Listener = l
+				srv.Start()
+			}
+			return nil
+		}
+		return "http://" + addr, func() {
+			if atomic.LoadInt32(&tries) > n {
+				srv.Close()
+			}
+		}
+	}
+	get := func(dialer *Dialer, url string) (Trail, error) {
+		client := http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return Trail{}, err
+		}
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if err == nil {
+			_ = res.Body.Close()
+		}
+		return tracer.Done(), err
+	}
+
+	t.Run("Refused", func(t *testing.T) {
+		dialer := NewDialer(net.Dialer{})
+		dialer.ConnectRetries = 3
+		dialer.ConnectRetryBackoff = 50 * time.Millisecond
+		url, stop := refuseFirst(t, dialer, 2)
+		defer stop()
+
+		trail, err := get(dialer, url)
+		assert.NoError(t, err)
+		assert.False(t, trail.Failed)
+		assert.False(t, trail.ConnectFailed)
+		assert.Equal(t, 2, trail.ConnectRetries)
+		assert.Equal(t, 150*time.Millisecond, trail.ConnectRetryDelay)
+		assert.True(t, trail.Connecting < 50*time.Millisecond, "%s", trail.Connecting)
+		assert.Equal(t, time.Duration(0), trail.EstimatedRTT)
+
+		var retries float64
+		for _, s := range trail.Samples(nil) {
+			if s.Metric == metrics.HTTPReqConnectRetries {
+				retries += s.Value
+			}
+		}
+		assert.Equal(t, 2.0, retries)
+	})
+	t.Run("Exhausted", func(t *testing.T) {
+		dialer := NewDialer(net.Dialer{})
+		dialer.ConnectRetries = 2
+		url, stop := refuseFirst(t, dialer, 5)
+		defer stop()
+
+		trail, err := get(dialer, url)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "connection refused")
+		}
+		assert.True(t, trail.Failed)
+		assert.True(t, trail.ConnectFailed)
+		assert.Equal(t, 2, trail.ConnectRetries)
+		assert.Equal(t, int64(0), dialer.OpenConns())
+	})
+	t.Run("Unreachable", func(t *testing.T) {
+		dialer := NewDialer(net.Dialer{})
+		dialer.ConnectRetries = 2
+		var tries int32
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			atomic.AddInt32(&tries, 1)
+			return syscall.EHOSTUNREACH
+		}
+
+		trail, err := get(dialer, "http://127.0.0.1:1")
+		assert.Error(t, err)
+		assert.True(t, trail.ConnectFailed)
+		assert.Equal(t, 0, trail.ConnectRetries)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&tries))
+	})
+}
@@ -0,0 +1,66 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"net/http"
+	"sync"
+)
+
+// An ExpectedResponse decides whether a response counts as a success, for the
+// http_req_failed rate; eg. a 404 can be just fine for a HEAD checking if something exists.
+// It's only consulted for requests that got a response; Trail.Failed ones always fail.
+type ExpectedResponse func(res *http.Response, tr Trail) bool
+
+// DefaultExpectedResponse accepts anything that isn't a client or server error.
+func DefaultExpectedResponse(res *http.Response, tr Trail) bool {
+	return res.StatusCode < 400
+}
+
+var (
+	expectedResponse     ExpectedResponse = DefaultExpectedResponse
+	expectedResponseLock sync.RWMutex
+)
+
+// SetExpectedResponse replaces the predicate IsExpectedResponse uses, process-wide, eg.
+// from a plugin's init(); nil restores DefaultExpectedResponse.
+func SetExpectedResponse(fn ExpectedResponse) {
+	if fn == nil {
+		fn = DefaultExpectedResponse
+	}
+
+	expectedResponseLock.Lock()
+	defer expectedResponseLock.Unlock()
+	expectedResponse = fn
+}
+
+// IsExpectedResponse returns whether a request was a success; res may be nil, if the
+// request didn't get as far as a response.
+func IsExpectedResponse(res *http.Response, tr Trail) bool {
+	if res == nil || tr.Failed {
+		return false
+	}
+
+	expectedResponseLock.RLock()
+	fn := expectedResponse
+	expectedResponseLock.RUnlock()
+	return fn(res, tr)
+}
@@ -0,0 +1,60 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsExpectedResponse(t *testing.T) {
+	res := func(status int) *http.Response {
+		return &http.Response{StatusCode: status}
+	}
+
+	t.Run("default", func(t *testing.T) {
+		assert.True(t, IsExpectedResponse(res(200), Trail{}))
+		assert.True(t, IsExpectedResponse(res(302), Trail{}))
+		assert.False(t, IsExpectedResponse(res(404), Trail{}))
+		assert.False(t, IsExpectedResponse(res(503), Trail{}))
+		assert.False(t, IsExpectedResponse(nil, Trail{}))
+		assert.False(t, IsExpectedResponse(res(200), Trail{Failed: true}))
+	})
+	t.Run("custom", func(t *testing.T) {
+		SetExpectedResponse(func(res *http.Response, tr Trail) bool {
+			return res.StatusCode < 400 || res.StatusCode == 404
+		})
+		defer SetExpectedResponse(nil)
+
+		assert.True(t, IsExpectedResponse(res(200), Trail{}))
+		assert.True(t, IsExpectedResponse(res(404), Trail{}))
+		assert.False(t, IsExpectedResponse(res(403), Trail{}))
+		assert.False(t, IsExpectedResponse(nil, Trail{}))
+		assert.False(t, IsExpectedResponse(res(404), Trail{Failed: true}))
+	})
+	t.Run("reset", func(t *testing.T) {
+		SetExpectedResponse(func(*http.Response, Trail) bool { return true })
+		SetExpectedResponse(nil)
+		assert.False(t, IsExpectedResponse(res(404), Trail{}))
+	})
+}
@@ -0,0 +1,291 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Why a response's framing was suspect; see Trail.FramingAnomaly.
+const (
+	framingBothLengths int32 = iota + 1
+	framingConflictingLengths
+	framingTrailingBytes
+	framingMalformedChunk
+)
+
+var framingAnomalyReasons = map[int32]string{
+	framingBothLengths:        "content_length_and_transfer_encoding",
+	framingConflictingLengths: "conflicting_content_length",
+	framingTrailingBytes:      "trailing_bytes",
+	framingMalformedChunk:     "malformed_chunk",
+}
+
+// Longest status, header or chunk size line we'll follow; anything longer, and we give up.
+const framingMaxLine = 64 * 1024
+
+// Where an httpFraming is in the response it's reading.
+const (
+	framingIdle = iota // Not expecting anything until the next request.
+	framingHead
+	framingBody
+	framingChunkSize
+	framingChunkData
+	framingChunkEnd
+	framingTrailers
+)
+
+// An httpFraming follows HTTP/1.x responses on a plain connection, to spot framing that
+// different parsers might disagree on, which is what response smuggling relies on. Go's
+// client settles it before anyone can tell (eg. dropping a Content-Length that comes with
+// Transfer-Encoding), so this looks at the bytes themselves: the headers that declare a
+// body's length, the chunks of chunked ones, and anything left over once the body ends,
+// before the next request has even been sent. Go doesn't pipeline requests, so that can
+// only be something the server meant as part of the response.
+//
+// It gives up on anything it can't follow: TLS (which it can't see into), HTTP/2,
+// bodies delimited by the connection closing, and upgraded connections.
+type httpFraming struct {
+	lock sync.Mutex
+
+	ignoreAnomalies bool // Only follow along, for headBytes.
+
+	checked, disabled bool
+	state             int
+	line              []byte // Partial line, in the states that read them.
+
+	method         string // Of the request being answered.
+	status         int
+	contentLengths []string
+	transferCoding bool // Any Transfer-Encoding, and whether its last coding is chunked.
+	chunked        bool
+	remaining      int64 // Bytes left in the body or chunk.
+
+	// Bytes of the head being read so far, and of the ones that have been read whole but
+	// not yet reported. Heads that turn out not to be HTTP are never reported.
+	headRead, headBytes int64
+}
+
+// Wrote and Read are fed everything written to and read from the connection; an anomaly
+// is stored in anomaly (if it's not nil, and there wasn't one already), and the size of
+// every response head read is added to headBytes (if it's not nil).
+func (f *httpFraming) Wrote(b []byte) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if len(b) == 0 || f.disabled {
+		return
+	}
+	// Connections that don't start with a request line are TLS, or something else entirely.
+	if !f.checked {
+		f.checked = true
+		if b[0] < 'A' || b[0] > 'Z' {
+			f.disabled = true
+			return
+		}
+	}
+	// The first write after a response starts the next request; the rest are its body.
+	if f.state == framingIdle {
+		f.method = ""
+		if i := bytes.IndexByte(b, ' '); i > 0 {
+			f.method = string(b[:i])
+		}
+		f.startResponse()
+	}
+}
+
+func (f *httpFraming) Read(b []byte, anomaly *int32, headBytes *int64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	report := func(reason int32) {
+		if anomaly != nil && !f.ignoreAnomalies {
+			atomic.CompareAndSwapInt32(anomaly, 0, reason)
+		}
+	}
+	defer func() {
+		if f.headBytes > 0 && headBytes != nil {
+			atomic.AddInt64(headBytes, f.headBytes)
+		}
+		f.headBytes = 0
+	}()
+	for len(b) > 0 && f.checked && !f.disabled {
+		switch f.state {
+		case framingIdle:
+			report(framingTrailingBytes)
+			f.disabled = true
+		case framingBody, framingChunkData:
+			n := int64(len(b))
+			if n > f.remaining {
+				n = f.remaining
+			}
+			f.remaining -= n
+			b = b[n:]
+			if f.remaining == 0 {
+				if f.state == framingBody {
+					f.state = framingIdle
+				} else {
+					f.state = framingChunkEnd
+				}
+			}
+		default:
+			inHead, n := f.state == framingHead, len(b)
+			var line []byte
+			var ok bool
+			line, b, ok = f.readLine(b)
+			if inHead {
+				f.headRead += int64(n - len(b))
+			}
+			if ok {
+				f.gotLine(string(line), report)
+			}
+		}
+	}
+}
+
+func (f *httpFraming) startResponse() {
+	f.state = framingHead
+	f.headRead = 0
+	f.status = 0
+	f.contentLengths = nil
+	f.transferCoding = false
+	f.chunked = false
+}
+
+// Adds b to the partial line, returning it once it's complete, along with the rest of b.
+func (f *httpFraming) readLine(b []byte) (line, rest []byte, ok bool) {
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		f.line = append(f.line, b...)
+		if len(f.line) > framingMaxLine {
+			f.disabled = true
+		}
+		return nil, nil, false
+	}
+	line = append(f.line, b[:i]...)
+	f.line = nil
+	return bytes.TrimSuffix(line, []byte("\r")), b[i+1:], true
+}
+
+func (f *httpFraming) gotLine(line string, report func(int32)) {
+	switch f.state {
+	case framingHead:
+		if f.status == 0 {
+			f.gotStatusLine(line)
+		} else if line == "" {
+			f.gotHead(report)
+		} else if i := strings.IndexByte(line, ':'); i > 0 {
+			value := strings.TrimSpace(line[i+1:])
+			switch strings.ToLower(line[:i]) {
+			case "content-length":
+				f.contentLengths = append(f.contentLengths, value)
+			case "transfer-encoding":
+				codings := strings.Split(value, ",")
+				f.transferCoding = true
+				f.chunked = strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+			}
+		}
+	case framingChunkSize:
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		if err != nil || size < 0 {
+			report(framingMalformedChunk)
+			f.disabled = true
+			return
+		}
+		if size == 0 {
+			f.state = framingTrailers
+		} else {
+			f.state = framingChunkData
+			f.remaining = size
+		}
+	case framingChunkEnd:
+		if line != "" {
+			report(framingMalformedChunk)
+			f.disabled = true
+			return
+		}
+		f.state = framingChunkSize
+	case framingTrailers:
+		if line == "" {
+			f.state = framingIdle
+		}
+	}
+}
+
+func (f *httpFraming) gotStatusLine(line string) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "HTTP/1.") {
+		f.disabled = true
+		return
+	}
+	status, err := strconv.Atoi(fields[1])
+	if err != nil || status <= 0 {
+		f.disabled = true
+		return
+	}
+	f.status = status
+}
+
+// Works out how the body is delimited, once all of the headers are in.
+func (f *httpFraming) gotHead(report func(int32)) {
+	f.headBytes += f.headRead
+	f.headRead = 0
+
+	for i := 1; i < len(f.contentLengths); i++ {
+		if f.contentLengths[i] != f.contentLengths[0] {
+			report(framingConflictingLengths)
+		}
+	}
+	if len(f.contentLengths) > 0 && f.transferCoding {
+		report(framingBothLengths)
+	}
+
+	switch {
+	case f.status == 101 || (f.method == "CONNECT" && f.status/100 == 2):
+		f.disabled = true // The connection's no longer HTTP.
+	case f.status/100 == 1:
+		f.startResponse() // The actual response is still to come.
+	case f.method == "HEAD" || f.status == 204 || f.status == 304:
+		f.state = framingIdle
+	case f.chunked:
+		f.state = framingChunkSize
+	case f.transferCoding || len(f.contentLengths) == 0:
+		f.disabled = true // Delimited by the connection closing.
+	default:
+		n, err := strconv.ParseInt(f.contentLengths[0], 10, 64)
+		if err != nil || n < 0 {
+			f.disabled = true
+			return
+		}
+		f.state = framingBody
+		f.remaining = n
+		if n == 0 {
+			f.state = framingIdle
+		}
+	}
+}
@@ -0,0 +1,270 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPFraming(t *testing.T) {
+	type exchange struct{ req, res string }
+	testdata := map[string]struct {
+		exchanges []exchange
+		reason    int32
+	}{
+		"length": {[]exchange{
+			{"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"},
+			{"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"},
+		}, 0},
+		"chunked": {[]exchange{
+			{"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n1\r\n!\r\n0\r\n\r\n"},
+			{"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\nX-Trailer: 1\r\n\r\n"},
+		}, 0},
+		"head": {[]exchange{
+			{"HEAD / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"},
+			{"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\n"},
+		}, 0},
+		"continue": {[]exchange{
+			{"POST / HTTP/1.1\r\nExpect: 100-continue\r\n\r\n", "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"},
+		}, 0},
+		"duplicate length": {[]exchange{
+			{"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Length: 2\r\n\r\nok"},
+		}, 0},
+		"both lengths": {[]exchange{
+			{"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"},
+		}, framingBothLengths},
+		"conflicting lengths": {[]exchange{
+			{"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Length: 20\r\n\r\nok"},
+		}, framingConflictingLengths},
+		"trailing bytes": {[]exchange{
+			{"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nokHTTP/1.1 200 OK\r\n"},
+		}, framingTrailingBytes},
+		"trailing bytes after chunks": {[]exchange{
+			{"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nX"},
+		}, framingTrailingBytes},
+		"malformed chunk": {[]exchange{
+			{"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nxyz\r\n"},
+		}, framingMalformedChunk},
+		"unterminated chunk": {[]exchange{
+			{"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nokay\r\n0\r\n\r\n"},
+		}, framingMalformedChunk},
+		"until close": {[]exchange{
+			{"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK\r\n\r\nanything goes"},
+		}, 0},
+		"upgrade": {[]exchange{
+			{"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 101 Switching Protocols\r\n\r\n\x81\x02hi"},
+		}, 0},
+		"tls": {[]exchange{
+			{"\x16\x03\x01\x00\x05hello", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\nX"},
+		}, 0},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			for _, split := range []bool{false, true} {
+				var f httpFraming
+				var reason int32
+				for _, ex := range data.exchanges {
+					f.Wrote([]byte(ex.req))
+					if split {
+						for i := 0; i < len(ex.res); i++ {
+							f.Read([]byte{ex.res[i]}, &reason, nil)
+						}
+					} else {
+						f.Read([]byte(ex.res), &reason, nil)
+					}
+				}
+				assert.Equal(t, data.reason, reason, "split: %v", split)
+			}
+		})
+	}
+}
+
+func TestHTTPFramingHeadBytes(t *testing.T) {
+	testdata := map[string]struct {
+		req, res string
+		head     int64
+	}{
+		"length":   {"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello", 38},
+		"chunked":  {"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\nX-Trailer: 1\r\n\r\n", 47},
+		"continue": {"POST / HTTP/1.1\r\n\r\n", "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", 25 + 38},
+		"not http": {"PRI * HTTP/2.0\r\n\r\n", "\x00\x00\x00\x04\x00\x00\x00\x00\x00\r\n", 0},
+		"tls":      {"\x16\x03\x01\x00\x05hello", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", 0},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			for _, split := range []bool{false, true} {
+				f := httpFraming{ignoreAnomalies: true}
+				var reason int32
+				var head int64
+				f.Wrote([]byte(data.req))
+				if split {
+					for i := 0; i < len(data.res); i++ {
+						f.Read([]byte{data.res[i]}, &reason, &head)
+					}
+				} else {
+					f.Read([]byte(data.res), &reason, &head)
+				}
+				assert.Equal(t, data.head, head, "split: %v", split)
+				assert.Equal(t, int32(0), reason, "split: %v", split)
+			}
+		})
+	}
+}
+
+func TestTracerFramingAnomaly(t *testing.T) {
+	// A server that answers every request with res, whatever it is.
+	serve := func(res string) string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		go func() {
+			defer func() { _ = l.Close() }()
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer func() { _ = conn.Close() }()
+			r := bufio.NewReader(conn)
+			for {
+				if _, err := http.ReadRequest(r); err != nil {
+					return
+				}
+				if _, err := conn.Write([]byte(res)); err != nil {
+					return
+				}
+			}
+		}()
+		return "http://" + l.Addr().String()
+	}
+
+	dialer := NewDialer(net.Dialer{})
+	dialer.DetectFramingAnomalies = true
+	client := http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+	get := func(url string) Trail {
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+
+	t.Run("chunked", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for i := 0; i < 3; i++ {
+				_, _ = w.Write([]byte("chunk"))
+				w.(http.Flusher).Flush()
+			}
+		}))
+		defer srv.Close()
+
+		for i := 0; i < 2; i++ {
+			trail := get(srv.URL)
+			assert.False(t, trail.FramingAnomaly)
+			assert.Equal(t, "", trail.FramingAnomalyReason)
+		}
+	})
+	t.Run("both lengths", func(t *testing.T) {
+		trail := get(serve("HTTP/1.1 200 OK\r\nContent-Length: 100\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n"))
+		assert.True(t, trail.FramingAnomaly)
+		assert.Equal(t, "content_length_and_transfer_encoding", trail.FramingAnomalyReason)
+	})
+	t.Run("trailing bytes", func(t *testing.T) {
+		trail := get(serve("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nokHTTP/1.1 200 OK\r\n\r\n"))
+		assert.True(t, trail.FramingAnomaly)
+		assert.Equal(t, "trailing_bytes", trail.FramingAnomalyReason)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", serve("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nokextra"), nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+		}
+		assert.False(t, tracer.Done().FramingAnomaly)
+	})
+}
+
+func TestTracerResponseHeaderBytes(t *testing.T) {
+	cookie := strings.Repeat("x", 4000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 10; i++ {
+			w.Header().Add("Set-Cookie", fmt.Sprintf("c%d=%s", i, cookie))
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	get := func(dialer *Dialer) Trail {
+		client := http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+
+	t.Run("measured", func(t *testing.T) {
+		dialer := NewDialer(net.Dialer{})
+		dialer.MeasureHeaderBytes = true
+		for i := 0; i < 2; i++ {
+			trail := get(dialer)
+			// Everything that was read but the 2 byte body.
+			assert.Equal(t, int(trail.BytesRead)-2, trail.ResponseHeaderBytes)
+			assert.True(t, trail.ResponseHeaderBytes > 40000, "%d", trail.ResponseHeaderBytes)
+
+			var header float64
+			for _, s := range trail.Samples(nil) {
+				if s.Metric == metrics.HTTPReqResHeaderBytes {
+					header += s.Value
+				}
+			}
+			assert.Equal(t, float64(trail.ResponseHeaderBytes), header)
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		trail := get(NewDialer(net.Dialer{}))
+		assert.Equal(t, 0, trail.ResponseHeaderBytes)
+		assert.True(t, trail.BytesRead > 40000)
+	})
+}
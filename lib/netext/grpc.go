@@ -0,0 +1,148 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/loadimpact/k6/stats"
+	"github.com/pkg/errors"
+)
+
+// gRPC runs over HTTP/2, so a unary call is just a POST carrying one length-prefixed
+// message each way, with the outcome in the trailers. Made through a traced transport,
+// it gets a Trail like any other request, with the same phases (blocked, connecting,
+// sending the message, waiting, receiving); these helpers add what's gRPC-specific.
+// Messages are passed around encoded (eg. as protobuf); that's up to the caller.
+
+// The gRPC status of a call that succeeded.
+const GRPCStatusOK = 0
+
+// Length of the prefix on each gRPC message: a compression flag, and a 4 byte length.
+const grpcPrefixLen = 5
+
+// GRPCFrame wraps an encoded message in gRPC's length prefix.
+func GRPCFrame(msg []byte) []byte {
+	frame := make([]byte, grpcPrefixLen+len(msg))
+	binary.BigEndian.PutUint32(frame[1:grpcPrefixLen], uint32(len(msg)))
+	copy(frame[grpcPrefixLen:], msg)
+	return frame
+}
+
+// NewGRPCRequest makes a request for a unary call, eg. to "https://host/pkg.Service/Method",
+// carrying msg; hdr is sent along as metadata, and may be nil.
+func NewGRPCRequest(ctx context.Context, url string, msg []byte, hdr http.Header) (*http.Request, error) {
+	return NewGRPCStreamRequest(ctx, url, bytes.NewReader(GRPCFrame(msg)), hdr)
+}
+
+// NewGRPCStreamRequest is like NewGRPCRequest, but for client streaming calls: body
+// carries any number of messages, each wrapped with GRPCFrame, and the call ends with it.
+func NewGRPCStreamRequest(ctx context.Context, url string, body io.Reader, hdr http.Header) (*http.Request, error) {
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	return req.WithContext(ctx), nil
+}
+
+// A GRPCCall is the outcome of a unary call.
+type GRPCCall struct {
+	Status        int    // gRPC status code; GRPCStatusOK on success.
+	StatusMessage string // Explanation from the server, if any.
+
+	// The response message; nil if the server didn't send one.
+	Message []byte
+
+	// Sizes of the encoded messages either way, without framing.
+	SentBytes     int
+	ReceivedBytes int
+}
+
+// ReadGRPCCall reads the response to a request made with NewGRPCRequest, and closes its
+// body; sent is the length of the message that was sent. The status comes from the
+// trailers, or the headers for "trailers-only" responses, which servers send for errors.
+func ReadGRPCCall(res *http.Response, sent int) (GRPCCall, error) {
+	call := GRPCCall{SentBytes: sent}
+
+	body, err := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		return call, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return call, errors.Errorf("grpc: unexpected HTTP status %d", res.StatusCode)
+	}
+
+	if len(body) > 0 {
+		if len(body) < grpcPrefixLen {
+			return call, errors.New("grpc: truncated message")
+		}
+		if body[0] != 0 {
+			return call, errors.New("grpc: compressed messages are not supported")
+		}
+		n := binary.BigEndian.Uint32(body[1:grpcPrefixLen])
+		if uint64(len(body)) != grpcPrefixLen+uint64(n) {
+			return call, errors.New("grpc: expected exactly one message")
+		}
+		call.Message = body[grpcPrefixLen:]
+		call.ReceivedBytes = len(call.Message)
+	}
+
+	status := res.Trailer.Get("Grpc-Status")
+	call.StatusMessage = res.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status = res.Header.Get("Grpc-Status")
+		call.StatusMessage = res.Header.Get("Grpc-Message")
+	}
+	if status == "" {
+		return call, errors.New("grpc: response has no status")
+	}
+	if call.Status, err = strconv.Atoi(status); err != nil {
+		return call, errors.Wrap(err, "grpc: invalid status")
+	}
+	return call, nil
+}
+
+// Tag adds the call's gRPC status to tags, as "grpc_status".
+func (c GRPCCall) Tag(tags map[string]string) {
+	tags["grpc_status"] = strconv.Itoa(c.Status)
+}
+
+// Samples returns the call's message sizes, to go with its Trail's samples.
+func (c GRPCCall) Samples(t time.Time, tags map[string]string) []stats.Sample {
+	return []stats.Sample{
+		{Metric: metrics.GRPCMsgSent, Time: t, Tags: tags, Value: float64(c.SentBytes)},
+		{Metric: metrics.GRPCMsgReceived, Time: t, Tags: tags, Value: float64(c.ReceivedBytes)},
+	}
+}
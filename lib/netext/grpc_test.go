@@ -0,0 +1,155 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGRPCCall(t *testing.T) {
+	// A minimal gRPC server: /Echo sends the message back, /Fail sends a trailers-only error.
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/grpc", r.Header.Get("Content-Type"))
+		assert.Equal(t, "trailers", r.Header.Get("TE"))
+		assert.Equal(t, "yes", r.Header.Get("X-Metadata"))
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/grpc")
+		switch r.URL.Path {
+		case "/test.Service/Echo":
+			w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+			_, _ = w.Write(body)
+			w.Header().Set("Grpc-Status", "0")
+		case "/test.Service/Fail":
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "not found")
+		}
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext:       NewDialer(net.Dialer{}).DialContext,
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	call := func(method string, msg []byte) (GRPCCall, Trail) {
+		tracer := &Tracer{}
+		ctx := WithTracer(context.Background(), tracer)
+		req, err := NewGRPCRequest(ctx, srv.URL+"/test.Service/"+method, msg, http.Header{"X-Metadata": {"yes"}})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		res, err := client.Do(req)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		c, err := ReadGRPCCall(res, len(msg))
+		assert.NoError(t, err)
+		return c, tracer.Done()
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		msg := []byte("\x0a\x05hello")
+		c, trail := call("Echo", msg)
+		assert.Equal(t, GRPCStatusOK, c.Status)
+		assert.Equal(t, msg, c.Message)
+		assert.Equal(t, len(msg), c.SentBytes)
+		assert.Equal(t, len(msg), c.ReceivedBytes)
+
+		assert.Equal(t, "h2", trail.NegotiatedProtocol)
+		assert.True(t, trail.Duration > 0)
+		assert.True(t, trail.Waiting > 0)
+		assert.True(t, trail.Connecting > 0)
+
+		tags := map[string]string{}
+		c.Tag(tags)
+		assert.Equal(t, map[string]string{"grpc_status": "0"}, tags)
+		samples := c.Samples(trail.EndTime, tags)
+		if assert.Len(t, samples, 2) {
+			assert.Equal(t, metrics.GRPCMsgSent, samples[0].Metric)
+			assert.Equal(t, float64(len(msg)), samples[0].Value)
+			assert.Equal(t, metrics.GRPCMsgReceived, samples[1].Metric)
+			assert.Equal(t, float64(len(msg)), samples[1].Value)
+		}
+	})
+	t.Run("trailers-only", func(t *testing.T) {
+		c, _ := call("Fail", []byte("x"))
+		assert.Equal(t, 5, c.Status)
+		assert.Equal(t, "not found", c.StatusMessage)
+		assert.Nil(t, c.Message)
+		assert.Equal(t, 0, c.ReceivedBytes)
+	})
+}
+
+func TestNewGRPCRequest(t *testing.T) {
+	req, err := NewGRPCRequest(context.Background(), "https://example.com/a.B/C", []byte("abc"), nil)
+	if assert.NoError(t, err) {
+		body, _ := ioutil.ReadAll(req.Body)
+		assert.Equal(t, []byte{0, 0, 0, 0, 3, 'a', 'b', 'c'}, body)
+		assert.Equal(t, "POST", req.Method)
+	}
+}
+
+func TestReadGRPCCall(t *testing.T) {
+	read := func(body []byte, code int, trailer http.Header) (GRPCCall, error) {
+		rec := httptest.NewRecorder()
+		rec.WriteHeader(code)
+		_, _ = rec.Write(body)
+		res := rec.Result()
+		res.Trailer = trailer
+		return ReadGRPCCall(res, 0)
+	}
+	frame := func(msg string) []byte {
+		b := make([]byte, grpcPrefixLen+len(msg))
+		binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+		copy(b[grpcPrefixLen:], msg)
+		return b
+	}
+	ok := http.Header{"Grpc-Status": {"0"}}
+
+	_, err := read(frame("hi"), 200, ok)
+	assert.NoError(t, err)
+	_, err = read(frame("hi"), 503, ok)
+	assert.EqualError(t, err, "grpc: unexpected HTTP status 503")
+	_, err = read(frame("hi")[:3], 200, ok)
+	assert.EqualError(t, err, "grpc: truncated message")
+	_, err = read(append(frame("a"), frame("b")...), 200, ok)
+	assert.EqualError(t, err, "grpc: expected exactly one message")
+	compressed := frame("hi")
+	compressed[0] = 1
+	_, err = read(compressed, 200, ok)
+	assert.EqualError(t, err, "grpc: compressed messages are not supported")
+	_, err = read(frame("hi"), 200, nil)
+	assert.EqualError(t, err, "grpc: response has no status")
+}
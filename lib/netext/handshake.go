@@ -0,0 +1,59 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import "context"
+
+// A HandshakeLimiter caps how many TLS handshakes Tracers let run at once, across all the
+// requests sharing it. Handshakes are CPU-heavy for the client too, so a burst of new
+// connections (eg. during ramp-up) can saturate it and inflate their own timings; with
+// a limiter, further ones wait their turn instead, which shows up as Trail.TLSQueued.
+type HandshakeLimiter struct {
+	slots chan struct{}
+}
+
+// NewHandshakeLimiter returns a limiter allowing n concurrent handshakes; nil if n <= 0,
+// which Tracers take to mean there's no limit.
+func NewHandshakeLimiter(n int) *HandshakeLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return &HandshakeLimiter{slots: make(chan struct{}, n)}
+}
+
+// Takes a slot, waiting for one to be freed if need be; returns false, without one, if
+// ctx is done first. A nil ctx waits forever.
+func (l *HandshakeLimiter) acquire(ctx context.Context) bool {
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+func (l *HandshakeLimiter) release() {
+	<-l.slots
+}
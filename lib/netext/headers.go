@@ -0,0 +1,78 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Headers a response may only send once, as http.Header keys them; any others are either lists, which may be split
+// over several headers, or (like Set-Cookie and WWW-Authenticate) meant to be repeated.
+var singletonHeaders = []string{
+	"Age",
+	"Content-Length",
+	"Content-Location",
+	"Content-Range",
+	"Content-Type",
+	"Date",
+	"Etag",
+	"Expires",
+	"Last-Modified",
+	"Location",
+	"Retry-After",
+	"Server",
+}
+
+// HeaderAnomaly looks for headers that a response repeated but shouldn't have, returning
+// why, eg. "conflicting_content_type" if it sent two different Content-Types, or
+// "duplicate_etag" if it sent the same ETag twice; or "conflicting_set_cookie", if it set
+// the same cookie (by name, domain and path) to different values. Empty if there's none.
+// Parsers pick one of the values in different ways, so what a client ends up with depends
+// on which one it is; when it only happens under load, it's usually responses (or parts
+// of them) getting mixed up somewhere along the way.
+func HeaderAnomaly(header http.Header) string {
+	for _, name := range singletonHeaders {
+		values := header[name]
+		if len(values) < 2 {
+			continue
+		}
+		reason := "duplicate_"
+		for _, v := range values[1:] {
+			if strings.TrimSpace(v) != strings.TrimSpace(values[0]) {
+				reason = "conflicting_"
+				break
+			}
+		}
+		return reason + strings.Replace(strings.ToLower(name), "-", "_", -1)
+	}
+
+	cookies := (&http.Response{Header: header}).Cookies()
+	set := make(map[string]string, len(cookies))
+	for _, c := range cookies {
+		key := c.Name + ";" + strings.ToLower(c.Domain) + ";" + c.Path
+		if v, ok := set[key]; ok && v != c.Value {
+			return "conflicting_set_cookie"
+		}
+		set[key] = c.Value
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderAnomaly(t *testing.T) {
+	testdata := map[string]struct {
+		header http.Header
+		reason string
+	}{
+		"none":               {http.Header{"Content-Type": {"text/html"}}, ""},
+		"empty":              {http.Header{}, ""},
+		"list":               {http.Header{"Cache-Control": {"no-cache", "max-age=0"}, "Vary": {"Accept", "Origin"}}, ""},
+		"auth":               {http.Header{"Www-Authenticate": {"Basic", "Bearer"}}, ""},
+		"cookies":            {http.Header{"Set-Cookie": {"a=1", "b=2", "a=3; Path=/other"}}, ""},
+		"same cookie":        {http.Header{"Set-Cookie": {"a=1", "a=1"}}, ""},
+		"conflicting":        {http.Header{"Content-Type": {"text/html", "application/json"}}, "conflicting_content_type"},
+		"duplicate":          {http.Header{"Etag": {`"abc"`, ` "abc"`}}, "duplicate_etag"},
+		"conflicting cookie": {http.Header{"Set-Cookie": {"session=1; Path=/", "other=2", "session=2; Path=/"}}, "conflicting_set_cookie"},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, data.reason, HeaderAnomaly(data.header))
+		})
+	}
+}
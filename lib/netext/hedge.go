@@ -0,0 +1,155 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// A HedgePolicy sends a second copy of a request, a hedge, if the first hasn't got a
+// response within Delay, and goes with whichever gets one first, cancelling the other.
+// This cuts the tail latency of eg. a backend instance having a slow moment, at the cost
+// of extra load; like retries, only idempotent requests are hedged, and only if their
+// body can be sent again (see http.Request.GetBody).
+type HedgePolicy struct {
+	Delay time.Duration
+}
+
+// A Hedge is what happened to a request sent with HedgePolicy.Do.
+type Hedge struct {
+	// Whether a hedge was sent, and which request the response is from: 0 for the
+	// original, 1 for the hedge.
+	Sent bool
+	Won  int
+
+	// The Tracer that traced the request that won; call Done() on this one.
+	Tracer *Tracer
+}
+
+// Do sends req with client, traced by tracer, which mustn't have been used yet; the hedge
+// gets a new one with the same settings, and is sent with hedgeClient, so that eg. their
+// CheckRedirects don't trip over each other. A request that fails doesn't win while the other might still
+// succeed; if both fail, the error is the last one's. The one that lost is cancelled, and
+// its tracer Done() once it's returned, discarding the Trail. The winner's response body
+// must be closed as usual, which also releases its context.
+func (p HedgePolicy) Do(client, hedgeClient *http.Client, req *http.Request, tracer *Tracer) (*http.Response, Hedge, error) {
+	canResend := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if p.Delay <= 0 || !IsIdempotent(req.Method) || !canResend {
+		res, err := client.Do(req.WithContext(WithTracer(req.Context(), tracer)))
+		return res, Hedge{Tracer: tracer}, err
+	}
+
+	spare := tracer.fresh()
+
+	results := make(chan *hedgeAttempt, 2)
+	original := sendHedgeAttempt(client, req, tracer, results)
+
+	timer := time.NewTimer(p.Delay)
+	defer timer.Stop()
+	select {
+	case <-results:
+		// Including if it failed; a quick failure isn't what hedging is for.
+		return original.won(Hedge{Tracer: tracer})
+	case <-timer.C:
+	}
+
+	hedgeReq := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			<-results
+			return original.won(Hedge{Tracer: tracer})
+		}
+		hedgeReq.Body = body
+	}
+	hedge := sendHedgeAttempt(hedgeClient, hedgeReq, spare, results)
+
+	winner, loser := <-results, original
+	if winner == original {
+		loser = hedge
+	}
+	if winner.err != nil {
+		// The other one might still make it; this one's done, either way.
+		winner, loser = <-results, winner
+		loser.discard()
+	} else {
+		loser.cancel()
+		go func() { (<-results).discard() }()
+	}
+
+	res := Hedge{Sent: true, Tracer: winner.tracer}
+	if winner == hedge {
+		res.Won = 1
+	}
+	return winner.won(res)
+}
+
+// One of the requests sent by HedgePolicy.Do; it's sent on results once it returns.
+type hedgeAttempt struct {
+	tracer *Tracer
+	cancel context.CancelFunc
+
+	res *http.Response
+	err error
+}
+
+func sendHedgeAttempt(client *http.Client, req *http.Request, tracer *Tracer, results chan<- *hedgeAttempt) *hedgeAttempt {
+	ctx, cancel := context.WithCancel(req.Context())
+	a := &hedgeAttempt{tracer: tracer, cancel: cancel}
+	go func() {
+		a.res, a.err = client.Do(req.WithContext(WithTracer(ctx, tracer)))
+		results <- a
+	}()
+	return a
+}
+
+// Returns the attempt's result as HedgePolicy.Do's, cancelling its context once it's done.
+func (a *hedgeAttempt) won(hedge Hedge) (*http.Response, Hedge, error) {
+	if a.res != nil {
+		a.res.Body = &cancelOnClose{ReadCloser: a.res.Body, cancel: a.cancel}
+	} else {
+		a.cancel()
+	}
+	return a.res, hedge, a.err
+}
+
+// Cleans up after an attempt that lost, once it's returned.
+func (a *hedgeAttempt) discard() {
+	a.cancel()
+	if a.res != nil {
+		_ = a.res.Body.Close()
+	}
+	_ = a.tracer.Done()
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
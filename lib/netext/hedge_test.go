@@ -0,0 +1,111 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHedgePolicy(t *testing.T) {
+	// The first request is slow, and so is every one to /slow; the rest are quick. Each
+	// writes its number, so the one that won can be told apart.
+	var reqs int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&reqs, 1)
+		_, _ = ioutil.ReadAll(r.Body)
+		if n == 1 || r.URL.Path == "/slow" {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		_, _ = w.Write([]byte(strings.Repeat("x", int(n))))
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+
+	do := func(p HedgePolicy, method string) (string, Trail, Hedge, error) {
+		req, err := http.NewRequest(method, srv.URL, strings.NewReader("body"))
+		assert.NoError(t, err)
+		res, hedge, err := p.Do(client, client, req, &Tracer{})
+		if err != nil {
+			return "", hedge.Tracer.Done(), hedge, err
+		}
+		body, err := ioutil.ReadAll(hedge.Tracer.Body(res.Body))
+		assert.NoError(t, err)
+		assert.NoError(t, res.Body.Close())
+		return string(body), hedge.Tracer.Done(), hedge, nil
+	}
+
+	t.Run("hedge wins", func(t *testing.T) {
+		atomic.StoreInt64(&reqs, 0)
+		start := time.Now()
+		body, trail, hedge, err := do(HedgePolicy{Delay: 50 * time.Millisecond}, "PUT")
+		assert.NoError(t, err)
+		assert.True(t, time.Since(start) < 500*time.Millisecond)
+		assert.True(t, hedge.Sent)
+		assert.Equal(t, 1, hedge.Won)
+		assert.Equal(t, "xx", body)
+
+		// Only the hedge's: it's quick, and didn't get the slow one's response.
+		assert.True(t, trail.Duration < 500*time.Millisecond, "%s", trail.Duration)
+		assert.True(t, trail.BytesRead > 0)
+		assert.False(t, trail.Failed)
+	})
+	t.Run("original wins", func(t *testing.T) {
+		atomic.StoreInt64(&reqs, 1)
+		body, _, hedge, err := do(HedgePolicy{Delay: 500 * time.Millisecond}, "GET")
+		assert.NoError(t, err)
+		assert.False(t, hedge.Sent)
+		assert.Equal(t, 0, hedge.Won)
+		assert.Equal(t, "xx", body)
+	})
+	t.Run("not idempotent", func(t *testing.T) {
+		atomic.StoreInt64(&reqs, 0)
+		start := time.Now()
+		body, _, hedge, err := do(HedgePolicy{Delay: 50 * time.Millisecond}, "POST")
+		assert.NoError(t, err)
+		assert.True(t, time.Since(start) >= time.Second)
+		assert.False(t, hedge.Sent)
+		assert.Equal(t, "x", body)
+	})
+	t.Run("both fail", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequest("GET", srv.URL+"/slow", nil)
+		assert.NoError(t, err)
+		_, hedge, err := HedgePolicy{Delay: 10 * time.Millisecond}.Do(client, client, req.WithContext(ctx), &Tracer{})
+		assert.Error(t, err)
+		assert.True(t, hedge.Sent)
+		assert.True(t, hedge.Tracer.Done().TimedOut)
+	})
+}
@@ -0,0 +1,392 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// An HTTP3Transport makes HTTPS requests over HTTP/3, on QUIC connections through its
+// Dialer, and the rest through its Fallback; as does any host whose QUIC handshake fails,
+// from then on, which shows up as Trail.HTTP3Fallback. Hosts aren't asked whether they
+// speak HTTP/3 (with Alt-Svc) first, they're just tried, like with curl's --http3.
+//
+// QUIC has no separate connect: the handshake is TLS's, so it's timed as TLSHandshaking,
+// and Connecting only covers opening a UDP socket. Everything else a Tracer records is
+// the same, but for the Tracer's ReadTimeout and WriteTimeout, which don't apply: QUIC
+// reads for all of a connection's streams at once. Requests through a SOCKS5 proxy always
+// go to the Fallback, as they can't be made over UDP.
+type HTTP3Transport struct {
+	Dialer   *Dialer
+	Fallback http.RoundTripper // Required; eg. an http.Transport using the same Dialer.
+
+	// Used for the QUIC handshake, with its NextProtos replaced by "h3"; and a
+	// ClientSessionCache added if it hasn't one, so connections can be resumed with 0-RTT.
+	TLSClientConfig *tls.Config
+
+	// How long a QUIC handshake may go without hearing from the server before giving up
+	// on it, and falling back; zero for quic-go's default of 5s.
+	HandshakeTimeout time.Duration
+
+	initOnce sync.Once
+	rt       *http3.RoundTripper
+
+	lock    sync.Mutex
+	conns   map[string]*Conn // QUIC connections' sockets, by the "host:port" dialed.
+	noHTTP3 map[string]bool  // Hosts whose QUIC handshakes failed.
+}
+
+// A QUIC handshake that failed, as opposed to a request that did once connected.
+type quicHandshakeError struct {
+	err error
+}
+
+func (e *quicHandshakeError) Error() string {
+	return "quic handshake failed: " + e.err.Error()
+}
+
+func (t *HTTP3Transport) init() {
+	tlsConf := &tls.Config{}
+	if t.TLSClientConfig != nil {
+		tlsConf = t.TLSClientConfig.Clone()
+	}
+	if tlsConf.ClientSessionCache == nil {
+		tlsConf.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	t.rt = &http3.RoundTripper{
+		TLSClientConfig: tlsConf,
+		QUICConfig:      &quic.Config{HandshakeIdleTimeout: t.HandshakeTimeout},
+		Dial:            t.dial,
+	}
+	t.conns = make(map[string]*Conn)
+	t.noHTTP3 = make(map[string]bool)
+}
+
+func (t *HTTP3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.initOnce.Do(t.init)
+
+	if req.URL.Scheme != "https" || len(t.Dialer.proxyChain()) > 0 {
+		return t.Fallback.RoundTrip(req)
+	}
+	addr := authority(req.URL.Host)
+	tracer, _ := req.Context().Value(ctxKeyTracer).(*Tracer)
+
+	t.lock.Lock()
+	noHTTP3, conn := t.noHTTP3[addr], t.conns[addr]
+	t.lock.Unlock()
+	if noHTTP3 {
+		if tracer != nil {
+			tracer.fellBackFromHTTP3(false)
+		}
+		return t.Fallback.RoundTrip(req)
+	}
+
+	h3req := req
+	if tracer != nil {
+		tracer.GetConn(addr)
+		if conn != nil {
+			tracer.gotQUICConn(conn, true)
+		}
+		if req.Body != nil {
+			h3req = new(http.Request)
+			*h3req = *req
+			h3req.Body = &http3Body{ReadCloser: req.Body, tracer: tracer}
+		}
+	}
+	res, err := t.rt.RoundTrip(h3req)
+	if _, ok := err.(*quicHandshakeError); ok {
+		t.lock.Lock()
+		t.noHTTP3[addr] = true
+		t.lock.Unlock()
+		if tracer != nil {
+			tracer.fellBackFromHTTP3(true)
+		}
+		return t.Fallback.RoundTrip(req)
+	}
+	if tracer != nil {
+		// Someone else's dial may have made the connection, while the request waited on it.
+		t.lock.Lock()
+		conn = t.conns[addr]
+		t.lock.Unlock()
+		tracer.gotHTTP3Response(conn, err)
+	}
+	return res, err
+}
+
+// CloseIdleConnections closes QUIC connections that aren't serving any requests, and
+// has the Fallback close its idle ones, if it can.
+func (t *HTTP3Transport) CloseIdleConnections() {
+	t.initOnce.Do(t.init)
+	t.rt.CloseIdleConnections()
+	if fallback, ok := t.Fallback.(interface{ CloseIdleConnections() }); ok {
+		fallback.CloseIdleConnections()
+	}
+}
+
+// Returns the "host:port" an HTTPS request to host goes to.
+func authority(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, "443")
+}
+
+// Makes a QUIC connection for the http3.RoundTripper, over a UDP socket from the Dialer,
+// and waits for its handshake to be done. A handshake that fails is a *quicHandshakeError.
+func (t *HTTP3Transport) dial(ctx context.Context, addr string, tlsConf *tls.Config, conf *quic.Config) (quic.EarlyConnection, error) {
+	d := t.Dialer
+	target, ipAddr, err := d.resolve(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	raddr, err := net.ResolveUDPAddr("udp", ipAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.acquireConn(ctx); err != nil {
+		return nil, err
+	}
+	connectStart := time.Now()
+	udpConn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		d.releaseConn()
+		return nil, err
+	}
+	connectDone := time.Now()
+	c := d.track(ctx, target, &Conn{Conn: udpConn})
+
+	tracer, _ := ctx.Value(ctxKeyTracer).(*Tracer)
+	if tracer != nil {
+		tracer.TLSHandshakeStart()
+	}
+	tr := &quic.Transport{Conn: &quicPacketConn{Conn: c, udpConn: udpConn, raddr: raddr}}
+	qconn, err := tr.DialEarly(ctx, raddr, tlsConf, conf)
+	if err == nil {
+		select {
+		case <-qconn.HandshakeComplete():
+		case <-qconn.Context().Done():
+			err = context.Cause(qconn.Context())
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if err != nil {
+		if qconn != nil {
+			_ = qconn.CloseWithError(0, "")
+		}
+		// Closing the socket first is what stops the transport reading from it.
+		_ = c.Close()
+		_ = tr.Close()
+		if tracer != nil {
+			tracer.TLSHandshakeDone(tls.ConnectionState{}, err)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &quicHandshakeError{err}
+	}
+
+	state := qconn.ConnectionState()
+	if tracer != nil {
+		tracer.TLSHandshakeDone(state.TLS, nil)
+		tracer.dialedQUICConn(c, connectStart, connectDone, state.Used0RTT)
+	}
+
+	t.lock.Lock()
+	t.conns[addr] = c
+	t.lock.Unlock()
+	go func() {
+		<-qconn.Context().Done()
+		t.lock.Lock()
+		if t.conns[addr] == c {
+			delete(t.conns, addr)
+		}
+		t.lock.Unlock()
+		atomic.CompareAndSwapInt32(&c.closeReason, 0, quicCloseCause(context.Cause(qconn.Context())))
+		_ = c.Close()
+		_ = tr.Close()
+	}()
+	return qconn, nil
+}
+
+// Works out why a QUIC connection ended, from the error it ended with. Unlike over TCP,
+// the client closing it is always deliberate: either idle, or being done with it.
+func quicCloseCause(err error) int32 {
+	var appErr *quic.ApplicationError
+	var transportErr *quic.TransportError
+	var idleErr *quic.IdleTimeoutError
+	switch {
+	case errors.As(err, &idleErr):
+		return connClosedIdle
+	case errors.As(err, &appErr) && appErr.Remote, errors.As(err, &transportErr) && transportErr.Remote:
+		return connClosedByServer
+	case errors.As(err, &appErr):
+		return connClosedIdle
+	default:
+		return connClosedOnError
+	}
+}
+
+// Presents a Conn, which is a connected UDP socket, as the net.PacketConn a quic.Transport
+// wants; the buffer and syscall methods let it tune the socket like it would its own.
+type quicPacketConn struct {
+	*Conn
+	udpConn *net.UDPConn
+	raddr   *net.UDPAddr
+}
+
+func (c *quicPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.raddr, err
+}
+
+func (c *quicPacketConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return c.Write(b)
+}
+
+func (c *quicPacketConn) SetReadBuffer(bytes int) error {
+	return c.udpConn.SetReadBuffer(bytes)
+}
+
+func (c *quicPacketConn) SetWriteBuffer(bytes int) error {
+	return c.udpConn.SetWriteBuffer(bytes)
+}
+
+func (c *quicPacketConn) SyscallConn() (syscall.RawConn, error) {
+	return c.udpConn.SyscallConn()
+}
+
+// A request body going out over HTTP/3, which has no WroteRequest hook; it's been written
+// once it's all been read.
+type http3Body struct {
+	io.ReadCloser
+	tracer *Tracer
+}
+
+func (b *http3Body) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.tracer.wroteHTTP3Body()
+	}
+	return n, err
+}
+
+// Like GotConn, for a QUIC connection that was already open. There's no net.Conn for
+// httptrace to report, so HTTP3Transport calls this itself.
+func (t *Tracer) gotQUICConn(conn *Conn, reused bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.setQUICConn(conn, reused)
+}
+
+// Records what dialing conn, a new QUIC connection, took, besides the handshake, which the
+// TLS hooks have; and that the request got it.
+func (t *Tracer) dialedQUICConn(conn *Conn, connectStart, connectDone time.Time, used0RTT bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.connectStart, t.connectDone = connectStart, connectDone
+	t.quic0RTT = used0RTT
+	t.setQUICConn(conn, false)
+}
+
+func (t *Tracer) setQUICConn(conn *Conn, reused bool) {
+	t.gotConn = time.Now()
+	t.connReused = reused
+	t.connRemoteAddr = conn.RemoteAddr()
+	t.http3 = true
+
+	// Anything written so far was the connection's own handshake, not this request.
+	atomic.StoreInt64(&t.firstWrite, 0)
+
+	t.conn = conn
+	t.connID = conn.ConnID
+	t.connWarmed = conn.warmed
+	conn.hooks.Store(t.counters())
+	atomic.StoreInt32(&conn.inRequest, 1)
+
+	if reused {
+		t.connectStart = t.gotConn
+		t.connectDone = t.gotConn
+	}
+}
+
+// Called once an HTTP/3 request's body has been read, to be sent.
+func (t *Tracer) wroteHTTP3Body() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.wroteRequest.IsZero() {
+		t.wroteRequest = time.Now()
+	}
+}
+
+// Fills in what HTTP/3 has no hooks for, once the round trip is over: the request went
+// out with the first write to the connection after getting it, or once its body had been
+// read, if that was later; and the response's first byte is its headers, the first frame
+// on the stream. A request that got a connection someone else dialed gets it now.
+func (t *Tracer) gotHTTP3Response(conn *Conn, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.gotConn.IsZero() && conn != nil {
+		t.setQUICConn(conn, true)
+	}
+	if err != nil {
+		t.protoError = err
+		return
+	}
+	if firstWrite := atomic.LoadInt64(&t.firstWrite); firstWrite != 0 {
+		t.wroteHeaders = time.Unix(0, firstWrite)
+	}
+	if t.wroteRequest.IsZero() {
+		t.wroteRequest = t.wroteHeaders
+		if t.wroteRequest.IsZero() {
+			t.wroteRequest = t.gotConn
+		}
+	}
+	t.gotFirstResponseByte = time.Now()
+}
+
+// Records that the request went to an HTTP3Transport's Fallback; if it tried, and failed,
+// to make a QUIC connection first, the time that took is counted as Blocked.
+func (t *Tracer) fellBackFromHTTP3(tried bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.http3Fallback = true
+	if tried {
+		t.http3Tried += time.Since(t.getConn)
+	}
+}
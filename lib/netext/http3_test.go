@@ -0,0 +1,182 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTP3Transport(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(w, r.Body)
+	})
+	// Serves HTTP/1.1 and HTTP/2 over TCP, for falling back to; and lends its certificate
+	// to an HTTP/3 server on a UDP port of its own.
+	srv := httptest.NewUnstartedServer(handler)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	h3srv := &http3.Server{Handler: handler, TLSConfig: srv.TLS.Clone()}
+	go func() { _ = h3srv.Serve(pc) }()
+	defer func() {
+		_ = h3srv.Close()
+		_ = pc.Close()
+	}()
+	h3URL := "https://" + pc.LocalAddr().String()
+
+	newTransport := func(dialer *Dialer) *HTTP3Transport {
+		return &HTTP3Transport{
+			Dialer: dialer,
+			Fallback: &http.Transport{
+				DialContext:       dialer.DialContext,
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				ForceAttemptHTTP2: true,
+			},
+			TLSClientConfig:  &tls.Config{InsecureSkipVerify: true},
+			HandshakeTimeout: time.Second,
+		}
+	}
+	do := func(transport *HTTP3Transport, method, url, body string) (Trail, string) {
+		tracer := &Tracer{OfferedProtocols: []string{"h2", "http/1.1"}}
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		assert.NoError(t, err)
+		if body == "" {
+			req.Body = nil
+		}
+		res, err := (&http.Client{Transport: transport}).Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if !assert.NoError(t, err) {
+			return tracer.Done(), ""
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		assert.NoError(t, err)
+		_ = res.Body.Close()
+		trail := tracer.Done()
+		trail.Proto = ProtoOf(res)
+		return trail, string(data)
+	}
+
+	t.Run("h3", func(t *testing.T) {
+		transport := newTransport(NewDialer(net.Dialer{}))
+		defer transport.CloseIdleConnections()
+
+		trail, _ := do(transport, "GET", h3URL, "")
+		assert.Equal(t, "h3", trail.Proto)
+		assert.Equal(t, "h3", trail.NegotiatedProtocol)
+		assert.False(t, trail.ALPNFallback)
+		assert.False(t, trail.HTTP2)
+		assert.False(t, trail.HTTP3Fallback)
+		assert.False(t, trail.ConnReused)
+		assert.False(t, trail.QUIC0RTT)
+		assert.NotEqual(t, uint64(0), trail.ConnID)
+		assert.True(t, trail.TLSHandshaking > 0)
+		assert.True(t, trail.TLSHandshaking <= trail.Sending)
+		assert.True(t, trail.TLSBytesRead > 0 && trail.TLSBytesRead < trail.BytesRead)
+		assert.True(t, trail.TLSBytesWritten > 0 && trail.TLSBytesWritten < trail.BytesWritten)
+		assert.True(t, trail.Waiting >= 0)
+
+		again, _ := do(transport, "GET", h3URL, "")
+		assert.Equal(t, "h3", again.Proto)
+		assert.True(t, again.ConnReused)
+		assert.Equal(t, trail.ConnID, again.ConnID)
+		assert.Equal(t, "", again.NegotiatedProtocol)
+		assert.Equal(t, time.Duration(0), again.Blocked)
+		assert.Equal(t, time.Duration(0), again.TLSHandshaking)
+		assert.True(t, again.BytesRead > 0 && again.BytesWritten > 0)
+	})
+	t.Run("body", func(t *testing.T) {
+		transport := newTransport(NewDialer(net.Dialer{}))
+		defer transport.CloseIdleConnections()
+
+		trail, data := do(transport, "POST", h3URL, "hello")
+		assert.Equal(t, "hello", data)
+		assert.Equal(t, "h3", trail.Proto)
+		assert.False(t, trail.Failed)
+		assert.True(t, trail.Sending >= 0)
+		assert.True(t, trail.Waiting >= 0)
+	})
+	t.Run("0-RTT", func(t *testing.T) {
+		closed := make(chan string, 1)
+		dialer := NewDialer(net.Dialer{})
+		dialer.OnConnClose = func(c *Conn) { closed <- c.CloseReason() }
+		transport := newTransport(dialer)
+		defer transport.CloseIdleConnections()
+
+		trail, _ := do(transport, "GET", h3URL, "")
+		assert.False(t, trail.QUIC0RTT)
+		transport.CloseIdleConnections()
+		assert.Equal(t, "idle", <-closed)
+
+		resumed, _ := do(transport, "GET", h3URL, "")
+		assert.Equal(t, "h3", resumed.Proto)
+		assert.False(t, resumed.ConnReused)
+		assert.NotEqual(t, trail.ConnID, resumed.ConnID)
+		assert.True(t, resumed.QUIC0RTT)
+	})
+	t.Run("fallback", func(t *testing.T) {
+		dialer := NewDialer(net.Dialer{})
+		transport := newTransport(dialer)
+		defer transport.CloseIdleConnections()
+
+		// Nothing's listening on the TCP server's port over UDP.
+		trail, data := do(transport, "POST", srv.URL, "hello")
+		assert.Equal(t, "hello", data)
+		assert.Equal(t, "h2", trail.Proto)
+		assert.True(t, trail.HTTP3Fallback)
+		assert.True(t, trail.HTTP2)
+		assert.Equal(t, "h2", trail.NegotiatedProtocol)
+		assert.False(t, trail.ALPNFallback)
+		assert.False(t, trail.QUIC0RTT)
+
+		again, _ := do(transport, "GET", srv.URL, "")
+		assert.Equal(t, "h2", again.Proto)
+		assert.True(t, again.HTTP3Fallback)
+		assert.True(t, again.ConnReused)
+		assert.Equal(t, trail.ConnID, again.ConnID)
+		assert.Equal(t, int64(1), dialer.PoolStats()[strings.TrimPrefix(srv.URL, "https://")].Open)
+	})
+	t.Run("plain", func(t *testing.T) {
+		plain := httptest.NewServer(handler)
+		defer plain.Close()
+		transport := newTransport(NewDialer(net.Dialer{}))
+		defer transport.CloseIdleConnections()
+
+		trail, _ := do(transport, "GET", plain.URL, "")
+		assert.Equal(t, "http/1.1", trail.Proto)
+		assert.False(t, trail.HTTP3Fallback)
+	})
+}
@@ -0,0 +1,282 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/loadimpact/k6/stats"
+)
+
+// How a Trail is marshalled. Keys are lowercase, and stay put even if the fields behind
+// them get renamed, since outputs store them; durations are in milliseconds, like they
+// are in samples. Caller-only settings, like MetricPrefix or BaseTags, are left out.
+type trailJSON struct {
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+
+	Method        string `json:"method,omitempty"`
+	URL           string `json:"url,omitempty"`
+	Priority      string `json:"priority,omitempty"`
+	Traceparent   string `json:"traceparent,omitempty"`
+	ErrorClass    string `json:"error_class,omitempty"`
+	Failed        bool   `json:"failed"`
+	TimedOut      bool   `json:"timed_out,omitempty"`
+	ConnectFailed bool   `json:"connect_failed,omitempty"`
+
+	Duration       float64 `json:"duration"`
+	Blocked        float64 `json:"blocked"`
+	Connecting     float64 `json:"connecting"`
+	Sending        float64 `json:"sending"`
+	Waiting        float64 `json:"waiting"`
+	Receiving      float64 `json:"receiving"`
+	LookingUp      float64 `json:"looking_up"`
+	TLSHandshaking float64 `json:"tls_handshaking"`
+
+	TLSQueued         float64 `json:"tls_queued,omitempty"`
+	ScriptReadDelay   float64 `json:"script_read_delay,omitempty"`
+	SerializationTime float64 `json:"serialization_time,omitempty"`
+	MaxReceiveGap     float64 `json:"max_receive_gap,omitempty"`
+	MeanReceiveGap    float64 `json:"mean_receive_gap,omitempty"`
+	WaitingHeaders    float64 `json:"waiting_headers,omitempty"`
+	EarlyHints        bool    `json:"early_hints,omitempty"`
+	EarlyHintsWaiting float64 `json:"early_hints_waiting,omitempty"`
+	StreamBlocked     float64 `json:"stream_blocked,omitempty"`
+	HTTP2             bool    `json:"http2,omitempty"`
+	PreWrite          float64 `json:"pre_write,omitempty"`
+	PoolLookup        float64 `json:"pool_lookup,omitempty"`
+	ExpectContinue    float64 `json:"expect_continue,omitempty"`
+
+	ConnReused     bool    `json:"conn_reused"`
+	ConnRemoteAddr string  `json:"conn_remote_addr,omitempty"`
+	ConnID         uint64  `json:"conn_id,omitempty"`
+	ConnWarmed     bool    `json:"conn_warmed,omitempty"`
+	DNSAnswerCount int     `json:"dns_answer_count,omitempty"`
+	DNSRecord      string  `json:"dns_record,omitempty"`
+	DNSError       string  `json:"dns_error,omitempty"`
+	DialRewrite    string  `json:"dial_rewrite,omitempty"`
+	ConnOverridden bool    `json:"conn_overridden,omitempty"`
+	HostOverride   string  `json:"host_override,omitempty"`
+	Proxy          string  `json:"proxy,omitempty"`
+	ProxyHandshake float64 `json:"proxy_handshake,omitempty"`
+	ProxyChain     int     `json:"proxy_chain,omitempty"`
+
+	BytesRead           int64 `json:"bytes_read"`
+	BytesWritten        int64 `json:"bytes_written"`
+	TLSBytesRead        int64 `json:"tls_bytes_read,omitempty"`
+	TLSBytesWritten     int64 `json:"tls_bytes_written,omitempty"`
+	ResponseHeaderBytes int   `json:"response_header_bytes,omitempty"`
+
+	ServerDate      *time.Time `json:"server_date,omitempty"`
+	ServerClockSkew float64    `json:"server_clock_skew,omitempty"`
+	RequestChunked  bool       `json:"request_chunked,omitempty"`
+
+	RedirectCount     int     `json:"redirect_count,omitempty"`
+	RedirectLimitHit  bool    `json:"redirect_limit_hit,omitempty"`
+	RetryCount        int     `json:"retry_count,omitempty"`
+	ConnectRetries    int     `json:"connect_retries,omitempty"`
+	ConnectRetryDelay float64 `json:"connect_retry_delay,omitempty"`
+	Hedged            bool    `json:"hedged,omitempty"`
+	HedgeWon          int     `json:"hedge_won,omitempty"`
+
+	ContentEncoding   string  `json:"content_encoding,omitempty"`
+	DecompressionTime float64 `json:"decompression_time,omitempty"`
+	DecompressedBytes int64   `json:"decompressed_bytes,omitempty"`
+	CacheStatus       string  `json:"cache_status,omitempty"`
+
+	ConnReset          bool     `json:"conn_reset,omitempty"`
+	EstimatedRTT       float64  `json:"estimated_rtt,omitempty"`
+	ConnClosedByClient bool     `json:"conn_closed_by_client,omitempty"`
+	ConnCloseReason    string   `json:"conn_close_reason,omitempty"`
+	BudgetExceeded     []string `json:"budget_exceeded,omitempty"`
+
+	PhaseTimestamps *phaseTimestampsJSON `json:"phase_timestamps,omitempty"`
+
+	Proto                string   `json:"proto,omitempty"`
+	NegotiatedProtocol   string   `json:"negotiated_protocol,omitempty"`
+	ALPNFallback         bool     `json:"alpn_fallback,omitempty"`
+	OfferedCipherSuites  []uint16 `json:"offered_cipher_suites,omitempty"`
+	CipherSuite          uint16   `json:"cipher_suite,omitempty"`
+	ServerCipherOverride bool     `json:"server_cipher_override,omitempty"`
+
+	SendQueueBytes         int  `json:"send_queue_bytes,omitempty"`
+	RecvQueueBytes         int  `json:"recv_queue_bytes,omitempty"`
+	SocketQueuesSampled    bool `json:"socket_queues_sampled,omitempty"`
+	TCPFastOpen            bool `json:"tcp_fast_open,omitempty"`
+	QUIC0RTT               bool `json:"quic_0rtt,omitempty"`
+	HTTP3Fallback          bool `json:"http3_fallback,omitempty"`
+	PossibleNagleDelay     bool `json:"possible_nagle_delay,omitempty"`
+	PossiblePMTUDBlackhole bool `json:"possible_pmtud_blackhole,omitempty"`
+
+	TLSRenegotiated      bool    `json:"tls_renegotiated,omitempty"`
+	TLSRenegotiation     float64 `json:"tls_renegotiation,omitempty"`
+	FramingAnomaly       bool    `json:"framing_anomaly,omitempty"`
+	FramingAnomalyReason string  `json:"framing_anomaly_reason,omitempty"`
+	HeaderAnomaly        bool    `json:"header_anomaly,omitempty"`
+	HeaderAnomalyReason  string  `json:"header_anomaly_reason,omitempty"`
+}
+
+// Phases that never happened are left out, rather than given the zero time.
+type phaseTimestampsJSON struct {
+	GetConn              *time.Time `json:"get_conn,omitempty"`
+	GotConn              *time.Time `json:"got_conn,omitempty"`
+	ConnectStart         *time.Time `json:"connect_start,omitempty"`
+	ConnectDone          *time.Time `json:"connect_done,omitempty"`
+	WroteRequest         *time.Time `json:"wrote_request,omitempty"`
+	GotFirstResponseByte *time.Time `json:"got_first_response_byte,omitempty"`
+	Done                 *time.Time `json:"done,omitempty"`
+}
+
+func newPhaseTimestampsJSON(ts PhaseTimestamps) *phaseTimestampsJSON {
+	at := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+	return &phaseTimestampsJSON{
+		GetConn:              at(ts.GetConn),
+		GotConn:              at(ts.GotConn),
+		ConnectStart:         at(ts.ConnectStart),
+		ConnectDone:          at(ts.ConnectDone),
+		WroteRequest:         at(ts.WroteRequest),
+		GotFirstResponseByte: at(ts.GotFirstResponseByte),
+		Done:                 at(ts.Done),
+	}
+}
+
+// MarshalJSON serializes a Trail with stable, lowercase keys; see trailJSON.
+func (tr Trail) MarshalJSON() ([]byte, error) {
+	out := trailJSON{
+		StartTime:     tr.StartTime,
+		EndTime:       tr.EndTime,
+		Method:        tr.Method,
+		URL:           tr.URL,
+		Priority:      tr.Priority,
+		ErrorClass:    tr.ErrorClass,
+		Failed:        tr.Failed,
+		TimedOut:      tr.TimedOut,
+		ConnectFailed: tr.ConnectFailed,
+
+		Duration:       stats.D(tr.Duration),
+		Blocked:        stats.D(tr.Blocked),
+		Connecting:     stats.D(tr.Connecting),
+		Sending:        stats.D(tr.Sending),
+		Waiting:        stats.D(tr.Waiting),
+		Receiving:      stats.D(tr.Receiving),
+		LookingUp:      stats.D(tr.LookingUp),
+		TLSHandshaking: stats.D(tr.TLSHandshaking),
+
+		TLSQueued:         stats.D(tr.TLSQueued),
+		ScriptReadDelay:   stats.D(tr.ScriptReadDelay),
+		SerializationTime: stats.D(tr.SerializationTime),
+		MaxReceiveGap:     stats.D(tr.MaxReceiveGap),
+		MeanReceiveGap:    stats.D(tr.MeanReceiveGap),
+		WaitingHeaders:    stats.D(tr.WaitingHeaders),
+		EarlyHints:        tr.EarlyHints,
+		EarlyHintsWaiting: stats.D(tr.EarlyHintsWaiting),
+		StreamBlocked:     stats.D(tr.StreamBlocked),
+		HTTP2:             tr.HTTP2,
+		PreWrite:          stats.D(tr.PreWrite),
+		PoolLookup:        stats.D(tr.PoolLookup),
+		ExpectContinue:    stats.D(tr.ExpectContinue),
+
+		ConnReused:     tr.ConnReused,
+		ConnID:         tr.ConnID,
+		ConnWarmed:     tr.ConnWarmed,
+		DNSAnswerCount: tr.DNSAnswerCount,
+		DNSError:       tr.DNSError,
+		DialRewrite:    tr.DialRewrite,
+		ConnOverridden: tr.ConnOverridden,
+		HostOverride:   tr.HostOverride,
+		Proxy:          tr.Proxy,
+		ProxyHandshake: stats.D(tr.ProxyHandshake),
+		ProxyChain:     tr.ProxyChain,
+
+		BytesRead:           tr.BytesRead,
+		BytesWritten:        tr.BytesWritten,
+		TLSBytesRead:        tr.TLSBytesRead,
+		TLSBytesWritten:     tr.TLSBytesWritten,
+		ResponseHeaderBytes: tr.ResponseHeaderBytes,
+
+		ServerClockSkew: stats.D(tr.ServerClockSkew),
+		RequestChunked:  tr.RequestChunked,
+
+		RedirectCount:     tr.RedirectCount,
+		RedirectLimitHit:  tr.RedirectLimitHit,
+		RetryCount:        tr.RetryCount,
+		ConnectRetries:    tr.ConnectRetries,
+		ConnectRetryDelay: stats.D(tr.ConnectRetryDelay),
+		Hedged:            tr.Hedged,
+		HedgeWon:          tr.HedgeWon,
+
+		ContentEncoding:   tr.ContentEncoding,
+		DecompressionTime: stats.D(tr.DecompressionTime),
+		DecompressedBytes: tr.DecompressedBytes,
+		CacheStatus:       tr.CacheStatus,
+
+		ConnReset:          tr.ConnReset,
+		EstimatedRTT:       stats.D(tr.EstimatedRTT),
+		ConnClosedByClient: tr.ConnClosedByClient,
+		ConnCloseReason:    tr.ConnCloseReason,
+		BudgetExceeded:     tr.BudgetExceeded,
+
+		Proto:                tr.Proto,
+		NegotiatedProtocol:   tr.NegotiatedProtocol,
+		ALPNFallback:         tr.ALPNFallback,
+		OfferedCipherSuites:  tr.OfferedCipherSuites,
+		CipherSuite:          tr.CipherSuite,
+		ServerCipherOverride: tr.ServerCipherOverride,
+
+		SendQueueBytes:         tr.SendQueueBytes,
+		RecvQueueBytes:         tr.RecvQueueBytes,
+		SocketQueuesSampled:    tr.SocketQueuesSampled,
+		TCPFastOpen:            tr.TCPFastOpen,
+		QUIC0RTT:               tr.QUIC0RTT,
+		HTTP3Fallback:          tr.HTTP3Fallback,
+		PossibleNagleDelay:     tr.PossibleNagleDelay,
+		PossiblePMTUDBlackhole: tr.PossiblePMTUDBlackhole,
+
+		TLSRenegotiated:      tr.TLSRenegotiated,
+		TLSRenegotiation:     stats.D(tr.TLSRenegotiation),
+		FramingAnomaly:       tr.FramingAnomaly,
+		FramingAnomalyReason: tr.FramingAnomalyReason,
+		HeaderAnomaly:        tr.HeaderAnomaly,
+		HeaderAnomalyReason:  tr.HeaderAnomalyReason,
+	}
+	if tr.TraceContext.Valid() {
+		out.Traceparent = tr.TraceContext.Traceparent()
+	}
+	if tr.ConnRemoteAddr != nil {
+		out.ConnRemoteAddr = tr.ConnRemoteAddr.String()
+	}
+	if tr.DNSRecord != nil {
+		out.DNSRecord = tr.DNSRecord.String()
+	}
+	if !tr.ServerDate.IsZero() {
+		out.ServerDate = &tr.ServerDate
+	}
+	if tr.PhaseTimestamps != nil {
+		out.PhaseTimestamps = newPhaseTimestampsJSON(*tr.PhaseTimestamps)
+	}
+	return json.Marshal(out)
+}
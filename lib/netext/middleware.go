@@ -0,0 +1,48 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"net/http"
+)
+
+// A Middleware wraps an http.RoundTripper, eg. to sign requests or add tracing headers.
+//
+// Timings and byte counts are collected by the Tracer in a request's context and the
+// Conns made by a Dialer, so they survive any middleware, as long as requests are
+// passed on with their original context (http.Request.WithContext keeps it).
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// Chain wraps base in the given middleware; the first one sees requests first.
+func Chain(base http.RoundTripper, middleware ...Middleware) http.RoundTripper {
+	rt := base
+	for i := len(middleware) - 1; i >= 0; i-- {
+		rt = middleware[i](rt)
+	}
+	return rt
+}
+
+// RoundTripperFunc adapts a function to an http.RoundTripper, for writing Middleware.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,74 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChain(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Order")))
+	}))
+	defer srv.Close()
+
+	addHeader := func(v string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				req = req.WithContext(req.Context()) // Copy; RoundTrippers mustn't modify requests.
+				req.Header.Set("X-Order", strings.TrimPrefix(req.Header.Get("X-Order")+","+v, ","))
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	base := &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}
+	client := http.Client{Transport: Chain(base, addHeader("a"), addHeader("b"))}
+
+	tracer := &Tracer{}
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	assert.NoError(t, err)
+	res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	assert.Equal(t, "a,b", string(body))
+
+	trail := tracer.Done()
+	assert.False(t, trail.Failed)
+	assert.True(t, trail.Waiting > 0)
+	assert.True(t, trail.BytesWritten > 0)
+	assert.True(t, trail.BytesRead > 0)
+	assert.NotEqual(t, uint64(0), trail.ConnID)
+
+	t.Run("empty", func(t *testing.T) {
+		assert.Equal(t, http.RoundTripper(base), Chain(base))
+	})
+}
@@ -0,0 +1,40 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"time"
+)
+
+// Receivers typically hold back ACKs for up to this long, hoping to piggyback them on a
+// reply (Linux' minimum delayed ACK timeout). If the sender meanwhile has Nagle's
+// algorithm holding back a small segment until everything before it is acknowledged,
+// neither side sends anything until the timer fires.
+const DelayedACKTimeout = 40 * time.Millisecond
+
+// How far past DelayedACKTimeout a wait can still be put down to it.
+const NagleDelaySlack = 5 * time.Millisecond
+
+// Reports whether a wait looks like it was caused by Nagle's algorithm meeting a delayed
+// ACK; a heuristic, as a server that just takes about that long looks the same.
+func possibleNagleDelay(d time.Duration) bool {
+	return d >= DelayedACKTimeout && d < DelayedACKTimeout+NagleDelaySlack
+}
@@ -0,0 +1,62 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialerNagle(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = l.Close() }()
+
+	for nagle, nodelay := range map[bool]int{false: 1, true: 0} {
+		t.Run(fmt.Sprint(nagle), func(t *testing.T) {
+			dialer := NewDialer(net.Dialer{})
+			dialer.Nagle = nagle
+			c, err := dialer.DialContext(context.Background(), "tcp", l.Addr().String())
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer func() { _ = c.Close() }()
+
+			raw, err := c.(*Conn).Conn.(*net.TCPConn).SyscallConn()
+			if !assert.NoError(t, err) {
+				return
+			}
+			var opt int
+			assert.NoError(t, raw.Control(func(fd uintptr) {
+				opt, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+			}))
+			assert.NoError(t, err)
+			assert.Equal(t, nodelay, opt)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPossibleNagleDelay(t *testing.T) {
+	testdata := map[time.Duration]bool{
+		0:                      false,
+		10 * time.Millisecond:  false,
+		39 * time.Millisecond:  false,
+		40 * time.Millisecond:  true,
+		42 * time.Millisecond:  true,
+		45 * time.Millisecond:  false,
+		200 * time.Millisecond: false,
+	}
+	for d, nagle := range testdata {
+		t.Run(fmt.Sprint(d), func(t *testing.T) {
+			assert.Equal(t, nagle, possibleNagleDelay(d))
+		})
+	}
+}
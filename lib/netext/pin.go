@@ -0,0 +1,68 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"net/http"
+	"time"
+)
+
+// A Pin keeps a sequence of requests on one connection per host, eg. to reproduce sticky
+// sessions against backends that keep state per connection. It's a RoundTripper with a
+// pool of its own, which holds on to no more than that connection; if it dies, the next
+// request simply dials a new one, and the Pin is bound to that instead.
+//
+// Requests through a Pin are expected to be made one at a time; concurrent ones will
+// each get a connection of their own, only one of which is kept afterwards.
+type Pin struct {
+	// ID of the connection the Pin is bound to, or 0 if none has been made yet.
+	ConnID uint64
+
+	transport *http.Transport
+}
+
+func NewPin(d *Dialer) *Pin {
+	return &Pin{transport: &http.Transport{
+		DialContext:           d.DialContext,
+		MaxIdleConnsPerHost:   1,
+		ExpectContinueTimeout: 1 * time.Second,
+	}}
+}
+
+func (p *Pin) RoundTrip(req *http.Request) (*http.Response, error) {
+	return p.transport.RoundTrip(req)
+}
+
+// Bind records the connection a request through the Pin went over, and reports whether it
+// replaced the one it was bound to, ie. whether that died.
+func (p *Pin) Bind(connID uint64) bool {
+	if connID == 0 {
+		return false
+	}
+	lost := p.ConnID != 0 && p.ConnID != connID
+	p.ConnID = connID
+	return lost
+}
+
+// Close closes the pinned connection, if idle; the Pin can still be used afterwards.
+func (p *Pin) Close() {
+	p.transport.CloseIdleConnections()
+}
@@ -0,0 +1,83 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	pin := NewPin(NewDialer(net.Dialer{}))
+	client := http.Client{Transport: pin}
+	get := func() Trail {
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+
+	first := get()
+	assert.False(t, pin.Bind(first.ConnID))
+	assert.Equal(t, first.ConnID, pin.ConnID)
+	for i := 0; i < 3; i++ {
+		trail := get()
+		assert.True(t, trail.ConnReused)
+		assert.Equal(t, first.ConnID, trail.ConnID)
+		assert.False(t, pin.Bind(trail.ConnID))
+	}
+
+	t.Run("Died", func(t *testing.T) {
+		srv.CloseClientConnections()
+		trail := get()
+		assert.False(t, trail.ConnReused)
+		assert.NotEqual(t, first.ConnID, trail.ConnID)
+		assert.True(t, pin.Bind(trail.ConnID))
+		assert.Equal(t, trail.ConnID, pin.ConnID)
+
+		again := get()
+		assert.Equal(t, trail.ConnID, again.ConnID)
+		assert.False(t, pin.Bind(again.ConnID))
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		prev := pin.ConnID
+		pin.Close()
+		trail := get()
+		assert.NotEqual(t, prev, trail.ConnID)
+	})
+}
@@ -0,0 +1,36 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+// The payload of a full-sized TCP segment on an Ethernet (1500 byte MTU) path, over IPv4
+// without options; the most that gets through before path MTU discovery has to work.
+const PMTUDSegmentSize = 1460
+
+// Reports whether a request looks like it ran into a path MTU discovery black hole: a
+// router on the path drops packets too big for the next hop, and the ICMP message that'd
+// tell us to send smaller ones is dropped by a firewall. The connection is set up fine, as
+// handshakes are small, but the first write of more than a segment's worth is never
+// acknowledged, and the request stalls until it times out. A heuristic, as a server that
+// never answers a large request looks the same; only new connections count, as ones that
+// were reused already got a response through.
+func possiblePMTUDBlackhole(newConn, stalled, gotFirstByte bool, written int64) bool {
+	return newConn && stalled && !gotFirstByte && written > PMTUDSegmentSize
+}
@@ -0,0 +1,99 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPossiblePMTUDBlackhole(t *testing.T) {
+	testdata := map[string]struct {
+		newConn, stalled, gotFirstByte bool
+		written                        int64
+		blackhole                      bool
+	}{
+		"stalled":        {true, true, false, 10000, true},
+		"small write":    {true, true, false, PMTUDSegmentSize, false},
+		"reused":         {false, true, false, 10000, false},
+		"got a response": {true, true, true, 10000, false},
+		"finished":       {true, false, false, 10000, false},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, data.blackhole, possiblePMTUDBlackhole(data.newConn, data.stalled, data.gotFirstByte, data.written))
+		})
+	}
+}
+
+func TestTracerPMTUDBlackhole(t *testing.T) {
+	// Accepts connections, but never reads from or answers them; like a path that drops
+	// every full-sized segment, once the socket buffers fill up.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				_ = conn.Close()
+			}
+		}()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+	post := func(size int, detect bool) Trail {
+		tracer := &Tracer{DetectPMTUDBlackholes: detect}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequest("POST", "http://"+l.Addr().String(), bytes.NewReader(make([]byte, size)))
+		assert.NoError(t, err)
+		_, err = client.Do(req.WithContext(WithTracer(ctx, tracer)))
+		assert.Error(t, err)
+		return tracer.Done()
+	}
+
+	trail := post(100000, true)
+	assert.True(t, trail.PossiblePMTUDBlackhole)
+	counted := false
+	for _, s := range trail.Samples(nil) {
+		counted = counted || s.Metric == metrics.HTTPReqPMTUDBlackhole
+	}
+	assert.True(t, counted)
+	assert.False(t, post(100, true).PossiblePMTUDBlackhole)
+	assert.False(t, post(100000, false).PossiblePMTUDBlackhole)
+}
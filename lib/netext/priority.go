@@ -0,0 +1,89 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Urgency of requests that don't say otherwise, per RFC 9218.
+const DefaultUrgency = 3
+
+// A Priority is a request's priority, as in RFC 9218: an urgency from 0 (most urgent) to
+// 7, and whether parts of the response are useful before all of it has arrived.
+//
+// It's sent in a Priority header, which is how HTTP/2 (and /3) servers learn of it now
+// that the original stream dependency scheme is deprecated; Go's client never sent that
+// anyway. Over HTTP/1.x it's harmless, though there's nothing to prioritize between.
+type Priority struct {
+	Urgency     int
+	Incremental bool
+}
+
+// ParsePriority parses a Priority header's value, eg. "u=1, i". Unknown parameters are
+// ignored, as the RFC says they must be.
+func ParsePriority(s string) (Priority, error) {
+	p := Priority{Urgency: DefaultUrgency}
+	for _, param := range strings.Split(s, ",") {
+		param = strings.TrimSpace(param)
+		key, value := param, ""
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			key, value = strings.TrimSpace(param[:i]), strings.TrimSpace(param[i+1:])
+		}
+		switch key {
+		case "u":
+			u, err := strconv.Atoi(value)
+			if err != nil {
+				return p, errors.Errorf("invalid priority urgency: %q", value)
+			}
+			p.Urgency = u
+		case "i":
+			p.Incremental = value == "" || value == "?1"
+		}
+	}
+	return p, p.Validate()
+}
+
+// Validate returns an error if the urgency is out of range.
+func (p Priority) Validate() error {
+	if p.Urgency < 0 || p.Urgency > 7 {
+		return errors.Errorf("priority urgency must be between 0 and 7: %d", p.Urgency)
+	}
+	return nil
+}
+
+// String returns the Priority as a header value, eg. "u=1, i".
+func (p Priority) String() string {
+	s := "u=" + strconv.Itoa(p.Urgency)
+	if p.Incremental {
+		s += ", i"
+	}
+	return s
+}
+
+// Apply sets req's Priority header to p.
+func (p Priority) Apply(req *http.Request) {
+	req.Header.Set("Priority", p.String())
+}
@@ -0,0 +1,111 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePriority(t *testing.T) {
+	testdata := map[string]struct {
+		priority Priority
+		err      string
+	}{
+		"":                {Priority{Urgency: DefaultUrgency}, ""},
+		"u=0":             {Priority{Urgency: 0}, ""},
+		"u=5, i":          {Priority{Urgency: 5, Incremental: true}, ""},
+		"i=?1,u=7":        {Priority{Urgency: 7, Incremental: true}, ""},
+		"u=2, i=?0":       {Priority{Urgency: 2}, ""},
+		"u=1, x=whatever": {Priority{Urgency: 1}, ""},
+		"u=8":             {Priority{Urgency: 8}, "priority urgency must be between 0 and 7: 8"},
+		"u=high":          {Priority{Urgency: DefaultUrgency}, `invalid priority urgency: "high"`},
+	}
+	for s, data := range testdata {
+		t.Run(s, func(t *testing.T) {
+			p, err := ParsePriority(s)
+			if data.err != "" {
+				assert.EqualError(t, err, data.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, data.priority, p)
+		})
+	}
+
+	assert.Equal(t, "u=3", Priority{Urgency: 3}.String())
+	assert.Equal(t, "u=0, i", Priority{Incremental: true}.String())
+}
+
+func TestPriorityHTTP2(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+		}
+		_, _ = w.Write([]byte(r.Header.Get("Priority")))
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	transport := srv.Client().Transport.(*http.Transport)
+	transport.DialContext = NewDialer(net.Dialer{}).DialContext
+	client := http.Client{Transport: transport}
+
+	// Concurrent requests of mixed priority, all multiplexed onto one connection.
+	priorities := []Priority{{Urgency: 0}, {Urgency: 7, Incremental: true}, {Urgency: 3}}
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	connIDs := map[uint64]bool{}
+	for i := 0; i < 12; i++ {
+		p := priorities[i%len(priorities)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tracer := &Tracer{}
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			assert.NoError(t, err)
+			p.Apply(req)
+			res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+			if !assert.NoError(t, err) {
+				return
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+			trail := tracer.Done()
+
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, p.String(), string(body))
+			lock.Lock()
+			connIDs[trail.ConnID] = true
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, connIDs, 1)
+}
@@ -0,0 +1,60 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+// QueueBytes returns how many bytes are sitting in the kernel's send buffer (written but
+// not yet acknowledged by the peer) and receive buffer (arrived but not yet read).
+func (c *Conn) QueueBytes() (send, recv int, err error) {
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return 0, 0, errors.New("not a socket")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var errno syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		if errno = ioctlInt(fd, syscall.TIOCOUTQ, &send); errno != 0 {
+			return
+		}
+		errno = ioctlInt(fd, syscall.TIOCINQ, &recv)
+	})
+	if err == nil && errno != 0 {
+		err = errno
+	}
+	return send, recv, err
+}
+
+// SIOCOUTQ/SIOCINQ are aliases of TIOCOUTQ/TIOCINQ, and store a C int.
+func ioctlInt(fd uintptr, req uint, v *int) syscall.Errno {
+	var n int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), uintptr(unsafe.Pointer(&n)))
+	*v = int(n)
+	return errno
+}
@@ -0,0 +1,70 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnQueueBytes(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = l.Close() }()
+
+	// Send some data, but never read anything; both sides' buffers fill up.
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write(make([]byte, 1000))
+		accepted <- conn
+	}()
+
+	c, err := NewDialer(net.Dialer{}).DialContext(context.Background(), "tcp", l.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = c.Close() }()
+	srv := <-accepted
+	defer func() { _ = srv.Close() }()
+
+	conn := c.(*Conn)
+	_ = conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	for {
+		if _, err := conn.Write(make([]byte, 64*1024)); err != nil {
+			break
+		}
+	}
+
+	send, recv, err := conn.QueueBytes()
+	assert.NoError(t, err)
+	assert.True(t, send > 0, "send queue: %d", send)
+	assert.Equal(t, 1000, recv)
+}
@@ -0,0 +1,48 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"net/http"
+)
+
+// Followed if nothing else is specified; same as an http.Client's default.
+const DefaultMaxRedirects = 10
+
+// A RedirectLimiter follows up to Max redirects for an http.Client, and then returns the
+// last (redirect) response as it is, rather than failing with an opaque error. Use one
+// per request; Count and LimitHit say what happened, for filling in the Trail.
+type RedirectLimiter struct {
+	Max int
+
+	Count    int  // Redirects followed.
+	LimitHit bool // Another redirect was returned after Max were followed.
+}
+
+// CheckRedirect is for http.Client.CheckRedirect.
+func (l *RedirectLimiter) CheckRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > l.Max {
+		l.LimitHit = true
+		return http.ErrUseLastResponse
+	}
+	l.Count = len(via)
+	return nil
+}
@@ -0,0 +1,111 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedirectLimiter(t *testing.T) {
+	// "/n" redirects to "/n-1", down to "/0"; "/loop" redirects to itself forever.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/loop" {
+			http.Redirect(w, r, "/loop", http.StatusFound)
+			return
+		}
+		n, _ := strconv.Atoi(r.URL.Path[1:])
+		if n > 0 {
+			http.Redirect(w, r, fmt.Sprintf("/%d", n-1), http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	transport := &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}
+	get := func(path string, max int) (*RedirectLimiter, *http.Response, Trail) {
+		limiter := &RedirectLimiter{Max: max}
+		client := http.Client{Transport: transport, CheckRedirect: limiter.CheckRedirect}
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL+path, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+		}
+		trail := tracer.Done()
+		trail.RedirectCount = limiter.Count
+		trail.RedirectLimitHit = limiter.LimitHit
+		return limiter, res, trail
+	}
+
+	_, _, direct := get("/0", 5)
+	assert.Equal(t, 0, direct.RedirectCount)
+	assert.False(t, direct.RedirectLimitHit)
+
+	t.Run("Under", func(t *testing.T) {
+		_, res, trail := get("/3", 5)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, 3, trail.RedirectCount)
+		assert.False(t, trail.RedirectLimitHit)
+		assert.True(t, trail.BytesRead > 3*direct.BytesRead, "%d bytes read", trail.BytesRead)
+		assert.True(t, trail.BytesWritten > 3*direct.BytesWritten, "%d bytes written", trail.BytesWritten)
+	})
+	t.Run("Exactly", func(t *testing.T) {
+		_, res, trail := get("/3", 3)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, 3, trail.RedirectCount)
+		assert.False(t, trail.RedirectLimitHit)
+	})
+	t.Run("Loop", func(t *testing.T) {
+		_, res, trail := get("/loop", 5)
+		assert.Equal(t, http.StatusFound, res.StatusCode)
+		assert.Equal(t, 5, trail.RedirectCount)
+		assert.True(t, trail.RedirectLimitHit)
+		assert.False(t, trail.Failed)
+		assert.True(t, trail.BytesWritten > 5*direct.BytesWritten, "%d bytes written", trail.BytesWritten)
+
+		seen := false
+		for _, s := range trail.Samples(nil) {
+			if s.Metric == metrics.HTTPReqRedirectLimit {
+				seen = true
+			}
+		}
+		assert.True(t, seen, "no redirect limit sample emitted")
+	})
+	t.Run("None", func(t *testing.T) {
+		_, res, trail := get("/loop", 0)
+		assert.Equal(t, http.StatusFound, res.StatusCode)
+		assert.Equal(t, 0, trail.RedirectCount)
+		assert.True(t, trail.RedirectLimitHit)
+	})
+}
@@ -0,0 +1,149 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TLS record content types; see RFC 5246, section 6.2.1.
+const (
+	tlsRecordHandshake   = 22
+	tlsRecordApplication = 23
+)
+
+// Length of a TLS record's header: a content type, a version and a 2 byte length.
+const tlsRecordHeaderLen = 5
+
+// A tlsRenegotiation spots TLS renegotiations on a connection from the records going over
+// it, which Go's TLS client gives no notice of: once application data has been exchanged,
+// any handshake record means one has started, and the next application data record that
+// it's done. Under TLS 1.2, content types are visible even in encrypted records; TLS 1.3
+// has no renegotiation, and disguises everything as application data anyway.
+type tlsRenegotiation struct {
+	lock sync.Mutex
+
+	checked, notTLS bool
+	established     bool
+	started         time.Time // Start of the ongoing renegotiation, if any.
+
+	read, written tlsRecordScanner
+}
+
+// Follows record boundaries in one direction of a TLS stream.
+type tlsRecordScanner struct {
+	header    [tlsRecordHeaderLen]byte
+	headerLen int
+	remaining int // Bytes left in the current record's body.
+}
+
+// Calls fn with the content type of every record whose header is completed by b.
+func (s *tlsRecordScanner) scan(b []byte, fn func(typ byte)) {
+	for len(b) > 0 {
+		if s.remaining > 0 {
+			n := s.remaining
+			if n > len(b) {
+				n = len(b)
+			}
+			s.remaining -= n
+			b = b[n:]
+			continue
+		}
+		n := copy(s.header[s.headerLen:], b)
+		s.headerLen += n
+		b = b[n:]
+		if s.headerLen == tlsRecordHeaderLen {
+			s.headerLen = 0
+			s.remaining = int(s.header[3])<<8 | int(s.header[4])
+			fn(s.header[0])
+		}
+	}
+}
+
+// Wrote and Read are fed everything written to and read from the connection; a finished
+// renegotiation is added to the counters, which may be nil.
+func (r *tlsRenegotiation) Wrote(b []byte, count, nanos *int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// Connections that don't start with a ClientHello aren't TLS.
+	if !r.checked && len(b) > 0 {
+		r.checked = true
+		r.notTLS = b[0] != tlsRecordHandshake
+	}
+	if !r.notTLS {
+		r.written.scan(b, func(typ byte) { r.saw(typ, count, nanos) })
+	}
+}
+
+func (r *tlsRenegotiation) Read(b []byte, count, nanos *int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.checked && !r.notTLS {
+		r.read.scan(b, func(typ byte) { r.saw(typ, count, nanos) })
+	}
+}
+
+func (r *tlsRenegotiation) saw(typ byte, count, nanos *int64) {
+	switch typ {
+	case tlsRecordApplication:
+		if !r.started.IsZero() {
+			if count != nil {
+				atomic.AddInt64(count, 1)
+			}
+			if nanos != nil {
+				atomic.AddInt64(nanos, int64(time.Since(r.started)))
+			}
+			r.started = time.Time{}
+		}
+		r.established = true
+	case tlsRecordHandshake:
+		if r.established && r.started.IsZero() {
+			r.started = time.Now()
+		}
+	}
+}
+
+// TLSRenegotiationConfig returns a copy of cfg (which may be nil) that lets servers
+// renegotiate; Go refuses to by default, failing the request, so the cost can't be seen.
+func TLSRenegotiationConfig(cfg *tls.Config) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+	cfg.Renegotiation = tls.RenegotiateFreelyAsClient
+	return cfg
+}
+
+// Returns the Conn underneath a connection, if any; TLS ones wrap the one that was dialed.
+func unwrapConn(c net.Conn) (*Conn, bool) {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	conn, ok := c.(*Conn)
+	return conn, ok
+}
@@ -0,0 +1,152 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a TLS record of the given type, with a body of n bytes.
+func tlsRecord(typ byte, n int) []byte {
+	rec := make([]byte, tlsRecordHeaderLen+n)
+	rec[0], rec[1], rec[2] = typ, 3, 3
+	rec[3], rec[4] = byte(n>>8), byte(n)
+	return rec
+}
+
+func TestTLSRecordScanner(t *testing.T) {
+	var stream []byte
+	stream = append(stream, tlsRecord(tlsRecordHandshake, 300)...)
+	stream = append(stream, tlsRecord(20, 1)...)
+	stream = append(stream, tlsRecord(tlsRecordApplication, 0)...)
+	stream = append(stream, tlsRecord(tlsRecordApplication, 2)...)
+	expected := []byte{tlsRecordHandshake, 20, tlsRecordApplication, tlsRecordApplication}
+
+	for _, size := range []int{1, 3, 5, 7, 100, len(stream)} {
+		var s tlsRecordScanner
+		var types []byte
+		for b := stream; len(b) > 0; {
+			n := size
+			if n > len(b) {
+				n = len(b)
+			}
+			s.scan(b[:n], func(typ byte) { types = append(types, typ) })
+			b = b[n:]
+		}
+		assert.Equal(t, expected, types, "chunks of %d", size)
+	}
+}
+
+func TestTLSRenegotiation(t *testing.T) {
+	t.Run("renegotiated", func(t *testing.T) {
+		var r tlsRenegotiation
+		var count, nanos int64
+		r.Wrote(tlsRecord(tlsRecordHandshake, 100), &count, &nanos) // ClientHello
+		r.Read(tlsRecord(tlsRecordHandshake, 1000), &count, &nanos) // ServerHello...
+		r.Wrote(tlsRecord(tlsRecordApplication, 50), &count, &nanos)
+		r.Read(tlsRecord(tlsRecordHandshake, 20), &count, &nanos) // HelloRequest
+		time.Sleep(10 * time.Millisecond)
+		r.Wrote(tlsRecord(tlsRecordHandshake, 100), &count, &nanos)
+		r.Read(tlsRecord(tlsRecordHandshake, 1000), &count, &nanos)
+		assert.Equal(t, int64(0), count)
+		r.Read(tlsRecord(tlsRecordApplication, 500), &count, &nanos)
+		assert.Equal(t, int64(1), count)
+		assert.True(t, time.Duration(nanos) >= 10*time.Millisecond)
+
+		// Carrying on doesn't count it again.
+		r.Read(tlsRecord(tlsRecordApplication, 500), &count, &nanos)
+		assert.Equal(t, int64(1), count)
+	})
+	t.Run("normal", func(t *testing.T) {
+		var r tlsRenegotiation
+		var count, nanos int64
+		r.Wrote(tlsRecord(tlsRecordHandshake, 100), &count, &nanos)
+		r.Read(tlsRecord(tlsRecordHandshake, 1000), &count, &nanos)
+		r.Wrote(tlsRecord(tlsRecordHandshake, 50), &count, &nanos)
+		r.Wrote(tlsRecord(tlsRecordApplication, 50), &count, &nanos)
+		r.Read(tlsRecord(tlsRecordApplication, 500), &count, &nanos)
+		assert.Equal(t, int64(0), count)
+		assert.Equal(t, int64(0), nanos)
+	})
+	t.Run("not tls", func(t *testing.T) {
+		var r tlsRenegotiation
+		var count, nanos int64
+		r.Wrote([]byte("GET / HTTP/1.1\r\n\r\n"), &count, &nanos)
+		r.Read(tlsRecord(tlsRecordApplication, 0), &count, &nanos)
+		r.Read(tlsRecord(tlsRecordHandshake, 0), &count, &nanos)
+		r.Read(tlsRecord(tlsRecordApplication, 0), &count, &nanos)
+		assert.Equal(t, int64(0), count)
+	})
+	t.Run("nil counters", func(t *testing.T) {
+		var r tlsRenegotiation
+		r.Wrote(tlsRecord(tlsRecordHandshake, 0), nil, nil)
+		r.Wrote(tlsRecord(tlsRecordApplication, 0), nil, nil)
+		r.Read(tlsRecord(tlsRecordHandshake, 0), nil, nil)
+		r.Read(tlsRecord(tlsRecordApplication, 0), nil, nil)
+	})
+}
+
+func TestTracerTLSRenegotiation(t *testing.T) {
+	// Go's TLS server can't renegotiate, so this only covers the normal case, end to end.
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext:     NewDialer(net.Dialer{}).DialContext,
+		TLSClientConfig: TLSRenegotiationConfig(&tls.Config{InsecureSkipVerify: true}),
+	}}
+	for _, reused := range []bool{false, true} {
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+		}
+		trail := tracer.Done()
+		assert.Equal(t, reused, trail.ConnReused)
+		assert.NotZero(t, trail.ConnID)
+		assert.False(t, trail.TLSRenegotiated)
+		assert.Equal(t, time.Duration(0), trail.TLSRenegotiation)
+	}
+}
+
+func TestTLSRenegotiationConfig(t *testing.T) {
+	assert.Equal(t, tls.RenegotiateFreelyAsClient, TLSRenegotiationConfig(nil).Renegotiation)
+
+	cfg := &tls.Config{ServerName: "example.com"}
+	c := TLSRenegotiationConfig(cfg)
+	assert.Equal(t, tls.RenegotiateFreelyAsClient, c.Renegotiation)
+	assert.Equal(t, "example.com", c.ServerName)
+	assert.Equal(t, tls.RenegotiateNever, cfg.Renegotiation)
+}
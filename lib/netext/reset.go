@@ -0,0 +1,56 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// Returns whether err is the peer resetting the connection, as opposed to eg. an EOF
+// from a graceful close. The errno differs per platform; see errConnReset.
+func isConnReset(err error) bool {
+	errno, ok := unwrapErrno(err)
+	return ok && errno == errConnReset
+}
+
+// Returns whether a failed connect is worth retrying: it timed out, or was refused, eg.
+// by a server that's restarting or has a full accept queue. Anything else, eg. no route
+// to the host, will just fail again.
+func isTransientDialError(err error) bool {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	errno, ok := unwrapErrno(err)
+	return ok && errno == errConnRefused
+}
+
+func unwrapErrno(err error) (syscall.Errno, bool) {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	errno, ok := err.(syscall.Errno)
+	return errno, ok
+}
@@ -0,0 +1,124 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"net/http"
+	"time"
+)
+
+// The longest a RetryPolicy, or a Dialer retrying a connect, waits before trying again,
+// however many tries there have been.
+const maxBackoff = 30 * time.Second
+
+// A RetryPolicy retries requests that got a 5xx response, up to Max times, waiting
+// Backoff before the first retry and twice as long before each one after that, up to
+// maxBackoff. Only
+// idempotent methods are retried; for others, a second try could do the thing twice.
+// Timings of all the tries end up in one Trail; see MergeTrails.
+type RetryPolicy struct {
+	Max     int
+	Backoff time.Duration
+}
+
+// IsIdempotent returns whether sending a request with method more than once has the same
+// effect as sending it once (RFC 7231, section 4.2.2).
+func IsIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// ShouldRetry returns whether to try again after the given try (counting from 1) of a
+// request with method got a response with status.
+func (p RetryPolicy) ShouldRetry(method string, status, try int) bool {
+	return try <= p.Max && status >= 500 && status <= 599 && IsIdempotent(method)
+}
+
+// Delay returns how long to wait after the given try (counting from 1) before the next.
+func (p RetryPolicy) Delay(try int) time.Duration {
+	return exponentialBackoff(p.Backoff, try)
+}
+
+// Returns base, doubled for each try (counting from 1) after the first, up to maxBackoff;
+// doubling stops there, so it can't overflow however many tries there have been.
+func exponentialBackoff(base time.Duration, try int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	d := base
+	for i := 1; i < try && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// MergeTrails combines the Trails of every try of a request, in order, into one. Time
+// spent and amounts counted are the total of all of them, MaxReceiveGap is the longest of
+// them, and StartTime is the first's. Everything else is the last one's, eg. the
+// connection, whether it failed, and estimates like MeanReceiveGap, ServerClockSkew and
+// EstimatedRTT. RetryCount is set to how many tries there were after the first.
+func MergeTrails(tries []Trail) Trail {
+	merged := tries[len(tries)-1]
+	for _, tr := range tries[:len(tries)-1] {
+		merged.Duration += tr.Duration
+		merged.Blocked += tr.Blocked
+		merged.Connecting += tr.Connecting
+		merged.Sending += tr.Sending
+		merged.Waiting += tr.Waiting
+		merged.Receiving += tr.Receiving
+		merged.LookingUp += tr.LookingUp
+		merged.TLSHandshaking += tr.TLSHandshaking
+		merged.TLSQueued += tr.TLSQueued
+		merged.ScriptReadDelay += tr.ScriptReadDelay
+		merged.SerializationTime += tr.SerializationTime
+		merged.WaitingHeaders += tr.WaitingHeaders
+		merged.EarlyHintsWaiting += tr.EarlyHintsWaiting
+		merged.StreamBlocked += tr.StreamBlocked
+		merged.PreWrite += tr.PreWrite
+		merged.PoolLookup += tr.PoolLookup
+		merged.ExpectContinue += tr.ExpectContinue
+		merged.ProxyHandshake += tr.ProxyHandshake
+		merged.ConnectRetryDelay += tr.ConnectRetryDelay
+		merged.DecompressionTime += tr.DecompressionTime
+		merged.TLSRenegotiation += tr.TLSRenegotiation
+		if tr.MaxReceiveGap > merged.MaxReceiveGap {
+			merged.MaxReceiveGap = tr.MaxReceiveGap
+		}
+
+		merged.BytesRead += tr.BytesRead
+		merged.BytesWritten += tr.BytesWritten
+		merged.TLSBytesRead += tr.TLSBytesRead
+		merged.TLSBytesWritten += tr.TLSBytesWritten
+		merged.ResponseHeaderBytes += tr.ResponseHeaderBytes
+		merged.DecompressedBytes += tr.DecompressedBytes
+		merged.ConnectRetries += tr.ConnectRetries
+	}
+	merged.StartTime = tries[0].StartTime
+	merged.RetryCount = len(tries) - 1
+	return merged
+}
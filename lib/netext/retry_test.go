@@ -0,0 +1,182 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{Max: 2, Backoff: 100 * time.Millisecond}
+	testdata := map[string]struct {
+		method string
+		status int
+		try    int
+		retry  bool
+	}{
+		"GET,503":          {"GET", 503, 1, true},
+		"GET,503,last":     {"GET", 503, 2, true},
+		"GET,503,over":     {"GET", 503, 3, false},
+		"GET,200":          {"GET", 200, 1, false},
+		"GET,404":          {"GET", 404, 1, false},
+		"HEAD,500":         {"HEAD", 500, 1, true},
+		"PUT,502":          {"PUT", 502, 1, true},
+		"DELETE,504":       {"DELETE", 504, 1, true},
+		"POST,503":         {"POST", 503, 1, false},
+		"PATCH,503":        {"PATCH", 503, 1, false},
+		"GET,600,not 5xx":  {"GET", 600, 1, false},
+		"GET,499,not 5xx":  {"GET", 499, 1, false},
+		"OPTIONS,500":      {"OPTIONS", 500, 1, true},
+		"CONNECT,500,nope": {"CONNECT", 500, 1, false},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, data.retry, p.ShouldRetry(data.method, data.status, data.try))
+		})
+	}
+	assert.False(t, RetryPolicy{}.ShouldRetry("GET", 503, 1))
+
+	assert.Equal(t, 100*time.Millisecond, p.Delay(1))
+	assert.Equal(t, 200*time.Millisecond, p.Delay(2))
+	assert.Equal(t, 400*time.Millisecond, p.Delay(3))
+
+	// Capped, and not overflowing.
+	assert.Equal(t, maxBackoff, p.Delay(10))
+	assert.Equal(t, maxBackoff, p.Delay(100))
+	assert.Equal(t, maxBackoff, RetryPolicy{Backoff: time.Hour}.Delay(1))
+	assert.Equal(t, time.Duration(0), RetryPolicy{}.Delay(100))
+}
+
+func TestMergeTrails(t *testing.T) {
+	start := time.Now()
+	tries := []Trail{
+		{StartTime: start, Duration: 100 * time.Millisecond, Waiting: 80 * time.Millisecond, BytesRead: 100, ConnID: 1},
+		{StartTime: start.Add(time.Second), Duration: 50 * time.Millisecond, Waiting: 40 * time.Millisecond, BytesRead: 100, ConnID: 1, ConnReused: true},
+		{StartTime: start.Add(2 * time.Second), Duration: 10 * time.Millisecond, Waiting: 5 * time.Millisecond, BytesRead: 200, ConnID: 2, Failed: true},
+	}
+	merged := MergeTrails(tries)
+	assert.Equal(t, start, merged.StartTime)
+	assert.Equal(t, 160*time.Millisecond, merged.Duration)
+	assert.Equal(t, 125*time.Millisecond, merged.Waiting)
+	assert.Equal(t, int64(400), merged.BytesRead)
+	assert.Equal(t, uint64(2), merged.ConnID)
+	assert.True(t, merged.Failed)
+	assert.Equal(t, 2, merged.RetryCount)
+
+	var retries float64
+	for _, s := range merged.Samples(nil) {
+		if s.Metric == metrics.HTTPReqRetries {
+			retries += s.Value
+		}
+	}
+	assert.Equal(t, 2.0, retries)
+
+	assert.Equal(t, 0, MergeTrails(tries[:1]).RetryCount)
+
+	// Every duration is a total, but for these.
+	notSummed := map[string]bool{"MaxReceiveGap": true, "MeanReceiveGap": true, "ServerClockSkew": true, "EstimatedRTT": true}
+	durationType := reflect.TypeOf(time.Duration(0))
+	var tr Trail
+	v := reflect.ValueOf(&tr).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Field(i); f.Type() == durationType && f.CanSet() {
+			f.SetInt(int64(time.Millisecond))
+		}
+	}
+	v = reflect.ValueOf(MergeTrails([]Trail{tr, tr}))
+	for i := 0; i < v.NumField(); i++ {
+		f, name := v.Field(i), v.Type().Field(i).Name
+		if f.Type() != durationType || !f.CanInterface() {
+			continue
+		}
+		if notSummed[name] {
+			assert.Equal(t, time.Millisecond, f.Interface(), name)
+		} else {
+			assert.Equal(t, 2*time.Millisecond, f.Interface(), name)
+		}
+	}
+}
+
+func TestRetryPolicyRoundTrips(t *testing.T) {
+	// Fails the first two requests of each method, then succeeds.
+	var gets, posts int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, "payload", string(body))
+		n := &gets
+		if r.Method == "POST" {
+			n = &posts
+		}
+		if atomic.AddInt64(n, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	policy := RetryPolicy{Max: 3, Backoff: time.Millisecond}
+	client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+	do := func(method string) (int, Trail) {
+		req, err := http.NewRequest(method, srv.URL, strings.NewReader("payload"))
+		assert.NoError(t, err)
+
+		var tries []Trail
+		tracer := &Tracer{}
+		for try := 1; ; try++ {
+			res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+			if !assert.NoError(t, err) {
+				return 0, Trail{}
+			}
+			_, _ = ioutil.ReadAll(tracer.Body(res.Body))
+			_ = res.Body.Close()
+			tries = append(tries, tracer.Done())
+			if !policy.ShouldRetry(method, res.StatusCode, try) {
+				return res.StatusCode, MergeTrails(tries)
+			}
+			time.Sleep(policy.Delay(try))
+			req.Body, _ = req.GetBody()
+		}
+	}
+
+	t.Run("retried", func(t *testing.T) {
+		status, trail := do("PUT")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, 2, trail.RetryCount)
+		assert.Equal(t, int64(0), atomic.LoadInt64(&posts))
+	})
+	t.Run("not retried", func(t *testing.T) {
+		status, trail := do("POST")
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, 0, trail.RetryCount)
+		assert.Equal(t, int64(1), atomic.LoadInt64(&posts))
+	})
+}
@@ -0,0 +1,56 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"math"
+	"time"
+
+	"github.com/loadimpact/k6/stats"
+)
+
+// A DurationRounding quantizes the durations Trail.Samples() emits, eg. to whole
+// microseconds, so that outputs don't have to; see Trail.Rounding.
+type DurationRounding interface {
+	Round(d time.Duration) time.Duration
+}
+
+// A Granularity rounds durations to the nearest multiple of itself; zero or less rounds
+// nothing. It's the usual DurationRounding: eg. Granularity(time.Microsecond).
+type Granularity time.Duration
+
+func (g Granularity) Round(d time.Duration) time.Duration {
+	return d.Round(time.Duration(g))
+}
+
+// Rounds the values of samples of time metrics with the Rounding, if there is one.
+func (tr Trail) rounded(samples []stats.Sample) []stats.Sample {
+	if tr.Rounding == nil {
+		return samples
+	}
+	for i, s := range samples {
+		if s.Metric.Contains == stats.Time {
+			d := time.Duration(math.Round(s.Value * float64(time.Millisecond)))
+			samples[i].Value = stats.D(tr.Rounding.Round(d))
+		}
+	}
+	return samples
+}
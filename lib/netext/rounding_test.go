@@ -0,0 +1,80 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/loadimpact/k6/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+// Rounds down rather than to the nearest, to show any DurationRounding will do.
+type truncation time.Duration
+
+func (t truncation) Round(d time.Duration) time.Duration { return d.Truncate(time.Duration(t)) }
+
+func TestTrailSamplesRounding(t *testing.T) {
+	trail := Trail{
+		Duration:  1234567 * time.Nanosecond,
+		Blocked:   499 * time.Nanosecond,
+		Waiting:   1500 * time.Nanosecond,
+		BytesRead: 1234,
+	}
+	values := func(tr Trail) map[*stats.Metric]float64 {
+		values := map[*stats.Metric]float64{}
+		for _, s := range tr.Samples(nil) {
+			values[s.Metric] = s.Value
+		}
+		return values
+	}
+
+	t.Run("none", func(t *testing.T) {
+		v := values(trail)
+		assert.Equal(t, stats.D(1234567*time.Nanosecond), v[metrics.HTTPReqDuration])
+		assert.Equal(t, stats.D(499*time.Nanosecond), v[metrics.HTTPReqBlocked])
+	})
+	t.Run("microseconds", func(t *testing.T) {
+		tr := trail
+		tr.Rounding = Granularity(time.Microsecond)
+		v := values(tr)
+		assert.Equal(t, stats.D(1235*time.Microsecond), v[metrics.HTTPReqDuration])
+		assert.Equal(t, 0.0, v[metrics.HTTPReqBlocked])
+		assert.Equal(t, stats.D(2*time.Microsecond), v[metrics.HTTPReqWaiting])
+		assert.Equal(t, 1234.0, v[metrics.DataReceived])
+		assert.Equal(t, 1.0, v[metrics.HTTPReqs])
+		assert.Equal(t, 1234567*time.Nanosecond, tr.Duration)
+	})
+	t.Run("custom", func(t *testing.T) {
+		tr := trail
+		tr.Rounding = truncation(time.Millisecond)
+		v := values(tr)
+		assert.Equal(t, 1.0, v[metrics.HTTPReqDuration])
+		assert.Equal(t, 0.0, v[metrics.HTTPReqWaiting])
+	})
+	t.Run("zero", func(t *testing.T) {
+		tr := trail
+		tr.Rounding = Granularity(0)
+		assert.Equal(t, stats.D(1234567*time.Nanosecond), values(tr)[metrics.HTTPReqDuration])
+	})
+}
@@ -0,0 +1,77 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// SizeBuckets are the boundaries, in bytes, between ranges of response sizes, for
+// stratifying timings by size with a bounded number of tag values; see Bucket.
+type SizeBuckets []int64
+
+// Some reasonable boundaries: "<1k", "1k-10k", "10k-100k" and ">100k".
+var DefaultSizeBuckets = SizeBuckets{1000, 10000, 100000}
+
+// Validate returns an error unless the boundaries are positive, and in ascending order.
+func (b SizeBuckets) Validate() error {
+	for i, bound := range b {
+		if bound <= 0 {
+			return errors.Errorf("size bucket boundaries must be positive: %d", bound)
+		}
+		if i > 0 && bound <= b[i-1] {
+			return errors.Errorf("size bucket boundaries must be ascending: %d after %d", bound, b[i-1])
+		}
+	}
+	return nil
+}
+
+// Bucket returns the name of the range n falls into, eg. "1k-10k"; ranges include their
+// lower bound. Empty if there are no boundaries.
+func (b SizeBuckets) Bucket(n int64) string {
+	if len(b) == 0 {
+		return ""
+	}
+	if n < b[0] {
+		return "<" + formatSize(b[0])
+	}
+	for i := 1; i < len(b); i++ {
+		if n < b[i] {
+			return formatSize(b[i-1]) + "-" + formatSize(b[i])
+		}
+	}
+	return ">" + formatSize(b[len(b)-1])
+}
+
+// Formats a size in bytes as short as it goes, in (decimal) units: 1000 is "1k".
+func formatSize(n int64) string {
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"G", 1e9}, {"M", 1e6}, {"k", 1e3}} {
+		if n%unit.size == 0 {
+			return strconv.FormatInt(n/unit.size, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
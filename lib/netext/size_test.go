@@ -0,0 +1,61 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSizeBuckets(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		testdata := map[int64]string{
+			0:       "<1k",
+			999:     "<1k",
+			1000:    "1k-10k",
+			5432:    "1k-10k",
+			10000:   "10k-100k",
+			99999:   "10k-100k",
+			100000:  ">100k",
+			7500000: ">100k",
+		}
+		for n, bucket := range testdata {
+			assert.Equal(t, bucket, DefaultSizeBuckets.Bucket(n), "%d", n)
+		}
+	})
+	t.Run("custom", func(t *testing.T) {
+		b := SizeBuckets{512, 1500000, 2000000000}
+		assert.NoError(t, b.Validate())
+		assert.Equal(t, "<512", b.Bucket(100))
+		assert.Equal(t, "512-1500k", b.Bucket(1024))
+		assert.Equal(t, "1500k-2G", b.Bucket(1500000))
+		assert.Equal(t, ">2G", b.Bucket(3000000000))
+	})
+	t.Run("none", func(t *testing.T) {
+		assert.Equal(t, "", SizeBuckets(nil).Bucket(100))
+	})
+	t.Run("invalid", func(t *testing.T) {
+		assert.EqualError(t, SizeBuckets{0, 10}.Validate(), "size bucket boundaries must be positive: 0")
+		assert.EqualError(t, SizeBuckets{10, 10}.Validate(), "size bucket boundaries must be ascending: 10 after 10")
+		assert.EqualError(t, SizeBuckets{10, 5}.Validate(), "size bucket boundaries must be ascending: 5 after 10")
+	})
+}
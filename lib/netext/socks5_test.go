@@ -0,0 +1,342 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A SOCKS5 proxy stub, supporting CONNECT, optionally requiring a username and password,
+// and taking Delay to answer each CONNECT; Targets records what it was asked to connect to.
+type socks5Stub struct {
+	net.Listener
+
+	Username, Password string
+	Reply              byte
+	Delay              time.Duration
+
+	targets []string
+	lock    sync.Mutex
+}
+
+func newSOCKS5Stub(t *testing.T) *socks5Stub {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	s := &socks5Stub{Listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socks5Stub) Targets() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.targets...)
+}
+
+func (s *socks5Stub) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil
+		}
+		return b
+	}
+	readString := func() string {
+		return string(read(int(read(1)[0])))
+	}
+
+	greeting := read(2)
+	methods := string(read(int(greeting[1])))
+	if s.Username == "" {
+		_, _ = conn.Write([]byte{5, 0})
+	} else if strings.IndexByte(methods, 2) < 0 {
+		_, _ = conn.Write([]byte{5, 0xff})
+		return
+	} else {
+		_, _ = conn.Write([]byte{5, 2})
+		_ = read(1)
+		if readString() != s.Username || readString() != s.Password {
+			_, _ = conn.Write([]byte{1, 1})
+			return
+		}
+		_, _ = conn.Write([]byte{1, 0})
+	}
+
+	req := read(4)
+	var host string
+	switch req[3] {
+	case 1:
+		host = net.IP(read(4)).String()
+	case 4:
+		host = net.IP(read(16)).String()
+	case 3:
+		host = readString()
+	}
+	port := binary.BigEndian.Uint16(read(2))
+	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	s.lock.Lock()
+	s.targets = append(s.targets, target)
+	s.lock.Unlock()
+
+	time.Sleep(s.Delay)
+	if s.Reply != 0 {
+		_, _ = conn.Write([]byte{5, s.Reply, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer func() { _ = upstream.Close() }()
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+
+	go func() { _, _ = io.Copy(upstream, conn) }()
+	_, _ = io.Copy(conn, upstream)
+}
+
+func TestParseSOCKS5Proxy(t *testing.T) {
+	p, err := ParseSOCKS5Proxy("socks5://localhost:1080")
+	assert.NoError(t, err)
+	assert.Equal(t, &SOCKS5Proxy{Addr: "localhost:1080"}, p)
+
+	p, err = ParseSOCKS5Proxy("socks5://user:p%40ss@[::1]:1080")
+	assert.NoError(t, err)
+	assert.Equal(t, &SOCKS5Proxy{Addr: "[::1]:1080", Username: "user", Password: "p@ss"}, p)
+
+	_, err = ParseSOCKS5Proxy("http://localhost:8080")
+	assert.EqualError(t, err, "unsupported proxy scheme: http")
+	_, err = ParseSOCKS5Proxy("socks5://localhost")
+	assert.EqualError(t, err, "proxy URL has no port: socks5://localhost")
+}
+
+func TestDialerSOCKS5(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	get := func(proxy *SOCKS5Proxy, url string) (Trail, error) {
+		dialer := NewDialer(net.Dialer{})
+		dialer.SOCKS5 = proxy
+		client := http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if err == nil {
+			body, _ := ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+			assert.Equal(t, "ok", string(body))
+		}
+		return tracer.Done(), err
+	}
+
+	t.Run("no auth", func(t *testing.T) {
+		stub := newSOCKS5Stub(t)
+		defer func() { _ = stub.Close() }()
+		stub.Delay = 50 * time.Millisecond
+
+		trail, err := get(&SOCKS5Proxy{Addr: stub.Addr().String()}, srv.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{srv.Listener.Addr().String()}, stub.Targets())
+		assert.Equal(t, stub.Addr().String(), trail.Proxy)
+		assert.Equal(t, stub.Addr().String(), trail.ConnRemoteAddr.String())
+		assert.True(t, trail.ProxyHandshake >= stub.Delay)
+		assert.True(t, trail.Sending < stub.Delay)
+		assert.True(t, trail.Connecting < stub.Delay)
+	})
+	t.Run("auth", func(t *testing.T) {
+		stub := newSOCKS5Stub(t)
+		defer func() { _ = stub.Close() }()
+		stub.Username, stub.Password = "user", "pass"
+
+		_, err := get(&SOCKS5Proxy{Addr: stub.Addr().String(), Username: "user", Password: "pass"}, srv.URL)
+		assert.NoError(t, err)
+
+		_, err = get(&SOCKS5Proxy{Addr: stub.Addr().String(), Username: "user", Password: "wrong"}, srv.URL)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "proxyconnect")
+			assert.Contains(t, err.Error(), "proxy authentication failed")
+		}
+
+		_, err = get(&SOCKS5Proxy{Addr: stub.Addr().String()}, srv.URL)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "proxy accepts none of the offered authentication methods")
+		}
+	})
+	t.Run("refused", func(t *testing.T) {
+		stub := newSOCKS5Stub(t)
+		defer func() { _ = stub.Close() }()
+		stub.Reply = 2
+
+		_, err := get(&SOCKS5Proxy{Addr: stub.Addr().String()}, srv.URL)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "proxy couldn't connect: connection not allowed by ruleset")
+		}
+	})
+	t.Run("ipv6", func(t *testing.T) {
+		l, err := net.Listen("tcp", "[::1]:0")
+		if err != nil {
+			t.Skip("no IPv6 loopback")
+		}
+		srv6 := httptest.NewUnstartedServer(srv.Config.Handler)
+		srv6.Listener = l
+		srv6.Start()
+		defer srv6.Close()
+
+		stub := newSOCKS5Stub(t)
+		defer func() { _ = stub.Close() }()
+
+		_, err = get(&SOCKS5Proxy{Addr: stub.Addr().String()}, srv6.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{l.Addr().String()}, stub.Targets())
+	})
+	t.Run("reused", func(t *testing.T) {
+		stub := newSOCKS5Stub(t)
+		defer func() { _ = stub.Close() }()
+
+		dialer := NewDialer(net.Dialer{})
+		dialer.SOCKS5 = &SOCKS5Proxy{Addr: stub.Addr().String()}
+		client := http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+		var trails []Trail
+		for i := 0; i < 2; i++ {
+			tracer := &Tracer{}
+			req, _ := http.NewRequest("GET", srv.URL, nil)
+			res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+			if assert.NoError(t, err) {
+				_, _ = ioutil.ReadAll(res.Body)
+				_ = res.Body.Close()
+			}
+			trails = append(trails, tracer.Done())
+		}
+		assert.Len(t, stub.Targets(), 1)
+		assert.True(t, trails[0].ProxyHandshake > 0)
+		assert.True(t, trails[1].ConnReused)
+		assert.Equal(t, time.Duration(0), trails[1].ProxyHandshake)
+	})
+}
+
+func TestParseSOCKS5ProxyChain(t *testing.T) {
+	chain, err := ParseSOCKS5ProxyChain("socks5://a:1080, socks5://user:pass@b:1081")
+	if assert.NoError(t, err) && assert.Len(t, chain, 2) {
+		assert.Equal(t, &SOCKS5Proxy{Addr: "a:1080"}, chain[0])
+		assert.Equal(t, &SOCKS5Proxy{Addr: "b:1081", Username: "user", Password: "pass"}, chain[1])
+	}
+	_, err = ParseSOCKS5ProxyChain("socks5://a:1080,http://b:8080")
+	assert.EqualError(t, err, "proxy 2 of 2: unsupported proxy scheme: http")
+	_, err = ParseSOCKS5ProxyChain("http://b:8080")
+	assert.EqualError(t, err, "unsupported proxy scheme: http")
+}
+
+func TestDialerProxyChain(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	stubs := func() (*socks5Stub, *socks5Stub, []*SOCKS5Proxy) {
+		first, second := newSOCKS5Stub(t), newSOCKS5Stub(t)
+		return first, second, []*SOCKS5Proxy{{Addr: first.Addr().String()}, {Addr: second.Addr().String()}}
+	}
+
+	t.Run("through both", func(t *testing.T) {
+		first, second, chain := stubs()
+		defer func() { _ = first.Close(); _ = second.Close() }()
+		first.Delay = 30 * time.Millisecond
+		second.Delay = 30 * time.Millisecond
+
+		dialer := NewDialer(net.Dialer{})
+		dialer.ProxyChain = chain
+		client := http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			body, _ := ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+			assert.Equal(t, "ok", string(body))
+		}
+		trail := tracer.Done()
+
+		assert.Equal(t, []string{second.Addr().String()}, first.Targets())
+		assert.Equal(t, []string{srv.Listener.Addr().String()}, second.Targets())
+		assert.Equal(t, first.Addr().String(), trail.Proxy)
+		assert.Equal(t, 2, trail.ProxyChain)
+		assert.True(t, trail.ProxyHandshake >= first.Delay+second.Delay)
+		assert.True(t, trail.Sending < first.Delay)
+		assert.Equal(t, "", trail.ErrorClass)
+	})
+
+	for hop, name := range []string{"first fails", "second fails"} {
+		hop := hop + 1
+		t.Run(name, func(t *testing.T) {
+			first, second, chain := stubs()
+			defer func() { _ = first.Close(); _ = second.Close() }()
+			[]*socks5Stub{first, second}[hop-1].Reply = 5
+
+			dialer := NewDialer(net.Dialer{})
+			dialer.ProxyChain = chain
+			tracer := &Tracer{}
+			_, err := dialer.DialContext(WithTracer(context.Background(), tracer), "tcp", srv.Listener.Addr().String())
+			if opErr, ok := err.(*net.OpError); assert.True(t, ok, "%v", err) {
+				assert.Equal(t, "proxyconnect", opErr.Op)
+				if proxyErr, ok := opErr.Err.(*ProxyError); assert.True(t, ok) {
+					assert.Equal(t, hop, proxyErr.Hop)
+					assert.Equal(t, 2, proxyErr.Hops)
+					assert.Equal(t, chain[hop-1].Addr, proxyErr.Proxy)
+				}
+				assert.Contains(t, err.Error(), "proxy "+strconv.Itoa(hop)+" of 2 ("+chain[hop-1].Addr+"): proxy couldn't connect: connection refused")
+			}
+			tracer.GetConn("")
+			trail := tracer.Done()
+			assert.True(t, trail.Failed)
+			assert.Equal(t, "proxy_failed", trail.ErrorClass)
+			assert.Equal(t, 2, trail.ProxyChain)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// A TaggedTrail is a Trail, along with the tags its samples were emitted with.
+type TaggedTrail struct {
+	Trail Trail
+	Tags  map[string]string
+}
+
+// A TrailStream passes the full Trail of every request on to its subscribers, for outputs
+// that want more than Trail.Samples() keeps, eg. ConnRemoteAddr or ConnReused.
+//
+// Publishing never blocks: if a subscriber's buffer is full, it just misses that Trail,
+// so a slow subscriber can't hold up requests. The zero value is ready to use.
+type TrailStream struct {
+	lock    sync.RWMutex
+	subs    []chan TaggedTrail
+	dropped int64
+}
+
+// Subscribe returns a channel that receives every Trail published from now on, until
+// it's unsubscribed; buffer is how many it can fall behind by before missing any.
+func (s *TrailStream) Subscribe(buffer int) <-chan TaggedTrail {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ch := make(chan TaggedTrail, buffer)
+	s.subs = append(s.subs, ch)
+	return ch
+}
+
+// Unsubscribe stops publishing to a channel returned by Subscribe, and closes it.
+func (s *TrailStream) Unsubscribe(ch <-chan TaggedTrail) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, sub := range s.subs {
+		if sub == ch {
+			s.subs = append(s.subs[:i], s.subs[i+1:]...)
+			close(sub)
+			return
+		}
+	}
+}
+
+func (s *TrailStream) Publish(trail Trail, tags map[string]string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, sub := range s.subs {
+		select {
+		case sub <- TaggedTrail{Trail: trail, Tags: tags}:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	}
+}
+
+// Dropped returns how many Trails subscribers have missed, in total.
+func (s *TrailStream) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
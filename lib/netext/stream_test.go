@@ -0,0 +1,64 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrailStream(t *testing.T) {
+	s := &TrailStream{}
+	s.Publish(Trail{}, nil) // Nobody's listening; nothing happens.
+
+	ch := s.Subscribe(2)
+	addr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 443}
+	s.Publish(Trail{ConnReused: true, ConnRemoteAddr: addr}, map[string]string{"name": "a"})
+	if tt := <-ch; assert.True(t, tt.Trail.ConnReused) {
+		assert.Equal(t, addr, tt.Trail.ConnRemoteAddr)
+		assert.Equal(t, "a", tt.Tags["name"])
+	}
+	assert.Equal(t, int64(0), s.Dropped())
+
+	t.Run("Full", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			s.Publish(Trail{}, nil)
+		}
+		assert.Len(t, ch, 2)
+		assert.Equal(t, int64(3), s.Dropped())
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		other := s.Subscribe(1)
+		s.Unsubscribe(ch)
+		s.Publish(Trail{}, nil)
+
+		n := 0
+		for range ch {
+			n++
+		}
+		assert.Equal(t, 2, n)
+		assert.Len(t, other, 1)
+		s.Unsubscribe(ch) // Already gone; no double close.
+	})
+}
@@ -0,0 +1,64 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	tcpFastOpenConnect = 30   // TCP_FASTOPEN_CONNECT, since Linux 4.11; not in syscall.
+	tcpiOptSynData     = 0x20 // TCPI_OPT_SYN_DATA: the SYN's data was acknowledged.
+)
+
+// A net.Dialer.Control func that turns on TCP Fast Open. With it, connecting returns
+// right away, and the handshake happens along with the first write instead.
+func enableFastOpen(network, address string, c syscall.RawConn) error {
+	return c.Control(func(fd uintptr) {
+		// Kernels that don't support it will just connect normally.
+		_ = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpenConnect, 1)
+	})
+}
+
+// Returns whether the connection's SYN carried data that the peer accepted.
+func (c *Conn) fastOpened() bool {
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	var info syscall.TCPInfo
+	var errno syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		size := uint32(unsafe.Sizeof(info))
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil || errno != 0 {
+		return false
+	}
+	return info.Options&tcpiOptSynData != 0
+}
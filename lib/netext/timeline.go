@@ -0,0 +1,173 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/loadimpact/k6/stats"
+)
+
+// How long a closed connection's timeline is held on to before it's written out, by default;
+// see ConnTimelineAggregator.Grace.
+const DefaultConnTimelineGrace = time.Second
+
+// A request over a connection, in a ConnTimeline. Times are in milliseconds.
+type ConnTimelineEntry struct {
+	Start    time.Time `json:"start"`
+	Age      float64   `json:"age"` // How long the connection had been open for.
+	Duration float64   `json:"duration"`
+	Waiting  float64   `json:"waiting"`
+	Method   string    `json:"method"`
+	URL      string    `json:"url"`
+	Failed   bool      `json:"failed"`
+}
+
+// A connection's timeline: every request that was made over it, in order.
+type ConnTimeline struct {
+	ConnID     uint64              `json:"conn_id"`
+	RemoteAddr string              `json:"remote_addr"`
+	Opened     time.Time           `json:"opened"`
+	Closed     time.Time           `json:"closed,omitempty"`
+	Requests   []ConnTimelineEntry `json:"requests"`
+}
+
+// A ConnTimelineAggregator groups Trails by the connection they were made over, and
+// writes each connection's timeline, as a line of JSON, once it's closed (see Dialer's
+// OnConnClose); so only those of connections still open are kept in memory. It's for
+// spotting connections that degrade over their lifetime. Safe for concurrent use.
+type ConnTimelineAggregator struct {
+	// How long a closed connection's timeline is held on to before it's written out, in
+	// case the last request over it is still being wrapped up; it can close first.
+	Grace time.Duration
+
+	enc *json.Encoder
+	err error // The first error writing, after which nothing more is written.
+
+	open   map[uint64]*ConnTimeline
+	closed []*ConnTimeline // Closed, but still within the Grace period.
+	lock   sync.Mutex
+}
+
+func NewConnTimelineAggregator(w io.Writer) *ConnTimelineAggregator {
+	return &ConnTimelineAggregator{
+		Grace: DefaultConnTimelineGrace,
+		enc:   json.NewEncoder(w),
+		open:  make(map[uint64]*ConnTimeline),
+	}
+}
+
+// Add adds a request to its connection's timeline; ignored if it didn't get one from a
+// Dialer (ConnID is zero).
+func (a *ConnTimelineAggregator) Add(tr Trail) {
+	if tr.ConnID == 0 {
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	tl := a.timeline(tr.ConnID)
+	if tl == nil {
+		tl = &ConnTimeline{ConnID: tr.ConnID, Opened: tr.StartTime.Add(-tr.Blocked)}
+		if tr.ConnReused {
+			// We never saw it open; this is as early as we know it was.
+			tl.Opened = tr.StartTime
+		}
+		a.open[tr.ConnID] = tl
+	}
+	if tl.RemoteAddr == "" && tr.ConnRemoteAddr != nil {
+		tl.RemoteAddr = tr.ConnRemoteAddr.String()
+	}
+	tl.Requests = append(tl.Requests, ConnTimelineEntry{
+		Start:    tr.StartTime,
+		Age:      stats.D(tr.StartTime.Sub(tl.Opened)),
+		Duration: stats.D(tr.Duration),
+		Waiting:  stats.D(tr.Waiting),
+		Method:   tr.Method,
+		URL:      tr.URL,
+		Failed:   tr.Failed,
+	})
+	a.flushClosed(time.Now())
+}
+
+// Closed marks a connection as closed; its timeline is written out once it's been for the
+// Grace period.
+func (a *ConnTimelineAggregator) Closed(connID uint64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	now := time.Now()
+	if tl, ok := a.open[connID]; ok {
+		delete(a.open, connID)
+		tl.Closed = now
+		a.closed = append(a.closed, tl)
+	}
+	a.flushClosed(now)
+}
+
+// Flush writes out every timeline still held, including those of open connections, eg.
+// at the end of a test; returns the first error writing any timeline so far.
+func (a *ConnTimelineAggregator) Flush() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, tl := range a.closed {
+		a.write(tl)
+	}
+	a.closed = nil
+	for id, tl := range a.open {
+		a.write(tl)
+		delete(a.open, id)
+	}
+	return a.err
+}
+
+// Returns the timeline of a connection that's open, or closed within the grace period.
+func (a *ConnTimelineAggregator) timeline(connID uint64) *ConnTimeline {
+	if tl, ok := a.open[connID]; ok {
+		return tl
+	}
+	for _, tl := range a.closed {
+		if tl.ConnID == connID {
+			return tl
+		}
+	}
+	return nil
+}
+
+// Writes out the timelines of connections closed at least the Grace period before now.
+func (a *ConnTimelineAggregator) flushClosed(now time.Time) {
+	n := 0
+	for ; n < len(a.closed) && now.Sub(a.closed[n].Closed) >= a.Grace; n++ {
+		a.write(a.closed[n])
+	}
+	a.closed = a.closed[n:]
+}
+
+func (a *ConnTimelineAggregator) write(tl *ConnTimeline) {
+	if a.err == nil {
+		a.err = a.enc.Encode(tl)
+	}
+}
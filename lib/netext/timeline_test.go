@@ -0,0 +1,126 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnTimelineAggregator(t *testing.T) {
+	read := func(buf *bytes.Buffer) (timelines []ConnTimeline) {
+		scanner := bufio.NewScanner(buf)
+		for scanner.Scan() {
+			var tl ConnTimeline
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &tl))
+			timelines = append(timelines, tl)
+		}
+		return timelines
+	}
+
+	var buf bytes.Buffer
+	agg := NewConnTimelineAggregator(&buf)
+	agg.Grace = 0
+
+	start := time.Now()
+	agg.Add(Trail{ConnID: 1, StartTime: start, Blocked: 10 * time.Millisecond, Waiting: 5 * time.Millisecond, URL: "http://example.com/1"})
+	agg.Add(Trail{ConnID: 2, StartTime: start, URL: "http://example.com/2"})
+	agg.Add(Trail{ConnID: 1, ConnReused: true, StartTime: start.Add(time.Second), Waiting: 50 * time.Millisecond, URL: "http://example.com/3"})
+	agg.Add(Trail{ConnID: 0, StartTime: start, URL: "http://example.com/no-dialer"})
+	assert.Empty(t, read(&buf), "written before they were closed")
+
+	agg.Closed(1)
+	timelines := read(&buf)
+	if assert.Len(t, timelines, 1) {
+		tl := timelines[0]
+		assert.Equal(t, uint64(1), tl.ConnID)
+		assert.False(t, tl.Closed.IsZero())
+		if assert.Len(t, tl.Requests, 2) {
+			assert.Equal(t, 10.0, tl.Requests[0].Age)
+			assert.Equal(t, 5.0, tl.Requests[0].Waiting)
+			assert.Equal(t, 1010.0, tl.Requests[1].Age)
+			assert.Equal(t, 50.0, tl.Requests[1].Waiting)
+			assert.Equal(t, "http://example.com/3", tl.Requests[1].URL)
+		}
+	}
+	assert.Len(t, agg.open, 1, "closed timeline not dropped")
+
+	assert.NoError(t, agg.Flush())
+	timelines = read(&buf)
+	if assert.Len(t, timelines, 1) {
+		assert.Equal(t, uint64(2), timelines[0].ConnID)
+		assert.True(t, timelines[0].Closed.IsZero())
+	}
+	assert.Empty(t, agg.open)
+
+	t.Run("grace", func(t *testing.T) {
+		var buf bytes.Buffer
+		agg := NewConnTimelineAggregator(&buf)
+		agg.Add(Trail{ConnID: 3, StartTime: start})
+		agg.Closed(3)
+		// The last request over it can come in after it's closed.
+		agg.Add(Trail{ConnID: 3, ConnReused: true, StartTime: start.Add(time.Second)})
+		assert.Empty(t, read(&buf))
+
+		assert.NoError(t, agg.Flush())
+		timelines := read(&buf)
+		if assert.Len(t, timelines, 1) {
+			assert.Len(t, timelines[0].Requests, 2)
+		}
+	})
+}
+
+func TestDialerOnConnClose(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	closed := make(chan uint64, 1)
+	dialer := NewDialer(net.Dialer{})
+	dialer.OnConnClose = func(c *Conn) { closed <- c.ConnID }
+	transport := &http.Transport{DialContext: dialer.DialContext}
+	client := http.Client{Transport: transport}
+
+	tracer := &Tracer{}
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	assert.NoError(t, err)
+	res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+	if assert.NoError(t, err) {
+		_ = res.Body.Close()
+	}
+	trail := tracer.Done()
+
+	transport.CloseIdleConnections()
+	select {
+	case id := <-closed:
+		assert.Equal(t, trail.ConnID, id)
+	case <-time.After(time.Second):
+		t.Error("OnConnClose wasn't called")
+	}
+}
@@ -0,0 +1,54 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import "time"
+
+// The wall-clock times of a request's phase transitions, as the Tracer's hooks saw them,
+// for rebuilding exact timelines rather than laying durations end to end. Zero for ones
+// that never happened: eg. connecting, for a reused connection, or anything past where a
+// request failed. The Trail's durations are worked out from these, but patched up for
+// cases like those, so they won't always add up to the same thing.
+type PhaseTimestamps struct {
+	GetConn, GotConn          time.Time
+	ConnectStart, ConnectDone time.Time
+	WroteRequest              time.Time
+	GotFirstResponseByte      time.Time
+	Done                      time.Time
+}
+
+// Takes the timestamps off t, before Done() patches anything up.
+func (t *Tracer) phaseTimestamps(done time.Time) *PhaseTimestamps {
+	ts := &PhaseTimestamps{
+		GetConn:              t.getConn,
+		GotConn:              t.gotConn,
+		ConnectStart:         t.connectStart,
+		ConnectDone:          t.connectDone,
+		WroteRequest:         t.wroteRequest,
+		GotFirstResponseByte: t.gotFirstResponseByte,
+		Done:                 done,
+	}
+	// GotConn sets these to when it got a reused connection, which is none.
+	if t.connReused {
+		ts.ConnectStart, ts.ConnectDone = time.Time{}, time.Time{}
+	}
+	return ts
+}
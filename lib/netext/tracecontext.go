@@ -0,0 +1,58 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// A TraceContext identifies a request in a distributed trace, as in the W3C Trace Context
+// spec: the trace it's part of, and the client's span for it, which spans on the server
+// then name as their parent. The zero value is none.
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// NewTraceContext starts a new trace, with random IDs.
+func NewTraceContext() TraceContext {
+	var tc TraceContext
+	_, _ = rand.Read(tc.TraceID[:])
+	_, _ = rand.Read(tc.SpanID[:])
+	return tc
+}
+
+// Valid reports whether the TraceContext isn't the zero value; all-zero IDs are invalid.
+func (tc TraceContext) Valid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+// Traceparent returns the value of a traceparent header for it, with the sampled flag set.
+func (tc TraceContext) Traceparent() string {
+	return "00-" + hex.EncodeToString(tc.TraceID[:]) + "-" + hex.EncodeToString(tc.SpanID[:]) + "-01"
+}
+
+// Apply sets req's traceparent header to tc.
+func (tc TraceContext) Apply(req *http.Request) {
+	req.Header.Set("traceparent", tc.Traceparent())
+}
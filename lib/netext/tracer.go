@@ -21,8 +21,16 @@
 package netext
 
 import (
+	"context"
+	"crypto/tls"
+	"io"
 	"net"
+	"net/http"
 	"net/http/httptrace"
+	"net/textproto"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/loadimpact/k6/lib/metrics"
@@ -35,6 +43,19 @@ type Trail struct {
 	StartTime time.Time
 	EndTime   time.Time
 
+	// The request's method and URL, after any redirects; set by the caller. These are for
+	// outputs that want to tell requests apart; URLs make for poor tags, as there are often
+	// too many different ones.
+	Method string
+	URL    string
+
+	// The Priority the request was sent with, as a header value (eg. "u=1, i"); empty if
+	// it wasn't given one. Set by the caller.
+	Priority string
+
+	// The trace context propagated with the request, if any; set by the caller.
+	TraceContext TraceContext
+
 	// Total request duration, excluding DNS lookup and connect time.
 	Duration time.Duration
 
@@ -44,49 +65,690 @@ type Trail struct {
 	Waiting    time.Duration // Waiting for first byte.
 	Receiving  time.Duration // Receiving response.
 
+	// Parts of Blocked spent looking up the host (zero if it was cached), and on the TLS
+	// handshake; both zero for reused connections. The handshake starts once Connecting
+	// is done, so it's also counted in Sending.
+	LookingUp      time.Duration
+	TLSHandshaking time.Duration
+
+	// Part of Blocked spent waiting for the Tracer's HandshakeLimiter to let the TLS
+	// handshake start; not counted in TLSHandshaking.
+	TLSQueued time.Duration
+
+	// Part of Receiving that the body spent waiting for the caller to read it, rather than
+	// the other way around; ie. time between reads, when the caller was doing something
+	// else. Zero if below ReadDelayResolution, or if the body wasn't read through Body().
+	ScriptReadDelay time.Duration
+
+	// Time the caller spent building the request from the script's values (headers, body
+	// and params) before sending it; client-side CPU, not the network. Set by the caller,
+	// and zero if below SerializationResolution.
+	SerializationTime time.Duration
+
+	// The longest, and mean, time the body spent waiting for data while being read through
+	// Body(): from the end of a read that got some, until the next one did. Time between
+	// reads, when the caller was doing something else, isn't part of it; so these are the
+	// gaps between chunks as they came in, showing stalls that a total Receiving hides.
+	MaxReceiveGap, MeanReceiveGap time.Duration
+
+	// Waiting for the response headers to be complete, from the same start as Waiting.
+	// The first byte is that of the status line, so the two usually arrive together; they
+	// drift apart when a server streams out its headers, or sends informational (1xx)
+	// responses first. Zero when indistinguishable from Waiting (see HeadersResolution),
+	// or if the caller didn't call Tracer.GotHeaders.
+	WaitingHeaders time.Duration
+
+	// The server sent a "103 Early Hints" response, and how long after the request was
+	// written that was complete. Waiting then ends with the first byte of the 103,
+	// rather than of the final response; WaitingHeaders still marks the latter.
+	EarlyHints        bool
+	EarlyHintsWaiting time.Duration
+
+	// Time between acquiring a connection and starting to write the request; under
+	// HTTP/2, this is mostly spent waiting for stream flow control windows. It's only
+	// emitted for HTTP/2 requests; over HTTP/1.x, there are no streams to be blocked on.
+	StreamBlocked time.Duration
+
+	// The request went over HTTP/2, on a new connection or a reused one.
+	HTTP2 bool
+
+	// Time between acquiring a connection and the first write to the socket; unlike
+	// StreamBlocked, this includes time the request spent buffered in the transport,
+	// so it grows when the load generator itself is starved for CPU or locks. Only
+	// measured if the Tracer's MeasurePreWrite is set.
+	PreWrite time.Duration
+
+	// Time the transport spent finding a connection in its pool: from asking for one, until
+	// it got an idle one to reuse, or decided to dial a new one. This is the pool's own
+	// overhead, which grows with its size and contention for it; on its own, it's part of
+	// Blocked (which is zero for reused connections), not the network. Only measured if the
+	// Tracer's MeasurePoolLookup is set, and for new connections, if they're made by a Dialer.
+	PoolLookup time.Duration
+
+	// Waiting for a "100 Continue" before sending the body; not included in Sending.
+	ExpectContinue time.Duration
+
 	// Detailed connection information.
 	ConnReused     bool
 	ConnRemoteAddr net.Addr
+	ConnID         uint64 // See Conn.ConnID; zero if the connection wasn't made by a Dialer.
+
+	// The connection was opened by a warm-up request, whether that was this one or it's
+	// being reused; see WithWarmup. Either way, it's not counted in http_conns_new.
+	ConnWarmed bool
+
+	// How many addresses the host resolved to, and which one was used; zero/nil if the
+	// host wasn't looked up for this request (cached, reused connection, or an IP). Every
+	// call to a Dialer's custom Lookup counts as a lookup.
+	DNSAnswerCount int
+	DNSRecord      net.IP
+
+	// If resolving the host failed, how; one of the DNSError* kinds, eg. "nxdomain".
+	DNSError string
+
+	// The "host:port" a Dialer's DialHook redirected the connection to; empty if it wasn't.
+	DialRewrite string
+
+	// Whether the host was one of the Dialer's HostOverrides, and the IP it was connected to
+	// instead of looking it up, in which case LookingUp is zero. Unset for reused connections.
+	ConnOverridden bool
+	HostOverride   string
+
+	// The "host:port" of the SOCKS5 proxy the connection was made through, if any, and how
+	// long it took to get it to connect to the target; not part of Connecting or Sending.
+	// Zero for reused connections. Through a chain of proxies, Proxy is the first, and the
+	// handshake covers them all; ProxyChain is how many there were.
+	Proxy          string
+	ProxyHandshake time.Duration
+	ProxyChain     int
 
 	// Bandwidth usage.
 	BytesRead, BytesWritten int64
+
+	// Parts of BytesRead and BytesWritten that were the TLS handshake, as counted on the
+	// raw connection; zero for plaintext and reused connections.
+	TLSBytesRead, TLSBytesWritten int64
+
+	// Part of BytesRead that was the response's status line and headers, up to the blank
+	// line ending them, and those of any 1xx responses before it; not the body, or chunk
+	// framing and trailers. Counted off the connection as read, so only known on plain
+	// HTTP/1.x connections, with Dialer.MeasureHeaderBytes or DetectFramingAnomalies.
+	ResponseHeaderBytes int
+
+	// The request failed at the protocol level; ConnectFailed if it never got a connection.
+	Failed        bool
+	ConnectFailed bool
+
+	// The response's Date header, if any, and how far ahead of local time it was when
+	// the response started arriving, in whole seconds. Set by the caller.
+	ServerDate      time.Time
+	ServerClockSkew time.Duration
+
+	// The request body was sent with "Transfer-Encoding: chunked" rather than a fixed
+	// Content-Length. Set by the caller, as it's not visible to the Tracer.
+	RequestChunked bool
+
+	// The request was aborted by its deadline; timings only cover the phases reached.
+	TimedOut bool
+
+	// Redirects followed to get to the final response, and whether there were more than
+	// allowed, in which case that is itself a redirect; see RedirectLimiter. Timings are
+	// of the last request, but byte counts include every one. Set by the caller.
+	RedirectCount    int
+	RedirectLimitHit bool
+
+	// Tries made after the first, if the request was retried; see RetryPolicy.
+	RetryCount int
+
+	// Connects the Dialer retried after they timed out or were refused, and the time it
+	// waited in between; see Dialer.ConnectRetries. Connecting includes the failed tries,
+	// but not the waits.
+	ConnectRetries    int
+	ConnectRetryDelay time.Duration
+
+	// A hedge was sent, and which request won: 0 for the original, 1 for the hedge; see
+	// HedgePolicy. Timings and byte counts are those of the winner. Set by the caller.
+	Hedged   bool
+	HedgeWon int
+
+	// Content-Encoding the response body was sent with, eg. "gzip"; empty if uncompressed.
+	// Set by the caller, from the response headers.
+	ContentEncoding string
+
+	// Time the caller spent decompressing the body, which is client-side CPU rather than
+	// network, and how large it was decompressed; zero if it didn't. Set by the caller.
+	DecompressionTime time.Duration
+	DecompressedBytes int64
+
+	// Whether a CDN or cache served the response ("hit"), or passed the request on to the
+	// origin ("miss"); "unknown" if its headers don't tell. Set by the caller, if enabled.
+	CacheStatus string
+
+	// The server reset the connection (sent a TCP RST) while the response was read,
+	// rather than closing it gracefully; typical of an overloaded server shedding load.
+	ConnReset bool
+
+	// A crude estimate of the round trip time to the server, as the time it took to
+	// connect: a TCP connect returns once the SYN-ACK is back, so that's one round trip,
+	// plus the kernel's overhead. Requests on a reused connection carry over the estimate
+	// from when it was made. It's a floor for latency at best, not a measurement: it's of
+	// the SYN only (which may be treated differently than data, eg. by load balancers
+	// that answer it themselves), it's the first proxy's if there's one, the first address
+	// tried's if dual-stack dialing raced several, and a single sample. Zero if unknown,
+	// eg. the connect failed or was retried, or used TCP Fast Open, which doesn't wait for
+	// the SYN-ACK.
+	EstimatedRTT time.Duration
+
+	// The connection was closed by the time the request was done, and why; see
+	// Conn.CloseReason. Closed by the client means by the transport, which does so as soon
+	// as the response is read if it won't reuse the connection; anything but the server.
+	ConnClosedByClient bool
+	ConnCloseReason    string
+
+	// When each phase started and ended; nil unless the Tracer's RecordPhaseTimestamps
+	// was set, as most don't need them.
+	PhaseTimestamps *PhaseTimestamps
+
+	// Why the request failed, if it's been classified: "read_timeout" or "write_timeout"
+	// when a Tracer's ReadTimeout or WriteTimeout was hit, "conn_reset", "proxy_failed"
+	// when a proxy couldn't connect to the next hop, or "dns_failed"; see DNSError.
+	ErrorClass string
+
+	// Phases that went over the Tracer's Budget; see Budget.Exceeded.
+	BudgetExceeded []string
+
+	// If set, Samples() leaves out http_req_blocked and http_req_connecting for reused
+	// connections, where they're always zero. Note that this changes what aggregates
+	// of them mean: eg. their average becomes that of new connections only, rather
+	// than of all requests. Set by the caller.
+	OmitReusedConnTimings bool
+
+	// If set, Samples() also emits http_req_setup, alongside http_req_blocked and
+	// http_req_connecting; see Setup. Set by the caller.
+	EmitSetup bool
+
+	// If set, Samples() only counts the request, towards http_reqs, data_sent/received,
+	// http_req_retries and http_conns_new, without its timings or anything else; for cutting
+	// down on samples of requests that aren't interesting, eg. ones that went fine. Set by
+	// the caller.
+	CountsOnly bool
+
+	// If set, Samples() emits to metrics with this prefix, eg. "warmup_" for ones named like
+	// "warmup_http_req_duration"; see metrics.Prefixed. Set by the caller.
+	MetricPrefix string
+
+	// Tags that Samples() adds to all of its samples, eg. a test run ID; those passed to it
+	// win if both have the same key. Set by the caller.
+	BaseTags map[string]string
+
+	// If set, Samples() rounds what it emits of time metrics with this, eg. to whole
+	// microseconds; the Trail's own durations are left as they are. Set by the caller.
+	Rounding DurationRounding
+
+	// The protocol agreed on with ALPN during the TLS handshake, eg. "h2"; and whether
+	// that fell back from HTTP/2, which the Tracer's OfferedProtocols included.
+	// Both are unset if there was no handshake (eg. plain HTTP, or a reused connection).
+	NegotiatedProtocol string
+	ALPNFallback       bool
+
+	// The cipher suites the client offered in the TLS handshake, in its order of preference,
+	// and the one the server picked; and whether that wasn't the client's first choice of
+	// the ones it could have used, ie. the server imposed its own preference. Unset if there
+	// was no handshake, like the above.
+	OfferedCipherSuites  []uint16
+	CipherSuite          uint16
+	ServerCipherOverride bool
+
+	// Bytes in the kernel's socket buffers once the request finished, waiting to be
+	// acknowledged by the peer or read by us; see Conn.QueueBytes. A full send queue
+	// points at the network or server, a full receive queue at the client.
+	// Only sampled if the Tracer's SampleSocketQueues is set, and only on Linux.
+	SendQueueBytes, RecvQueueBytes int
+	SocketQueuesSampled            bool
+
+	// The connection was opened with TCP Fast Open, and the server accepted the data
+	// sent along with its SYN; see Dialer.TCPFastOpen. Never set for reused connections.
+	TCPFastOpen bool
+
+	// The HTTP version of the response, as its ALPN ID: "http/1.0", "http/1.1", "h2" or
+	// "h3"; see ProtoOf. Unlike NegotiatedProtocol, it's there for plain HTTP and reused
+	// connections too. Set by the caller.
+	Proto string
+
+	// The QUIC connection was resumed with 0-RTT, which the server accepted, saving it a
+	// round trip; see HTTP3Transport. Never set for reused connections.
+	QUIC0RTT bool
+
+	// The request was meant to go over HTTP/3, but went to its HTTP3Transport's Fallback,
+	// as the host's QUIC handshake failed, now or before. The time a failed handshake took
+	// is counted as Blocked.
+	HTTP3Fallback bool
+
+	// Waiting was just over DelayedACKTimeout, a telltale sign of Nagle's algorithm on
+	// one end stalling on delayed ACKs from the other; see Dialer.Nagle. It's only a
+	// heuristic, so this is worth looking into if it's common, not for a single request.
+	PossibleNagleDelay bool
+
+	// The request was the first on its connection, wrote more than PMTUDSegmentSize, and
+	// then stalled until it timed out without a byte of response: the signature of a path
+	// MTU discovery black hole, eg. a firewall dropping ICMP. Also a heuristic; only set
+	// if the Tracer's DetectPMTUDBlackholes is.
+	PossiblePMTUDBlackhole bool
+
+	// The server renegotiated TLS on the connection while the request was using it, and
+	// how long that took in total. Servers do this eg. to ask for a client certificate for
+	// certain paths; it's costly, and under load often a sign of misconfiguration. Go only
+	// goes along with it with TLSRenegotiationConfig; otherwise the request fails.
+	TLSRenegotiated  bool
+	TLSRenegotiation time.Duration
+
+	// The response's framing looked like an attempt at request smuggling, and how: eg.
+	// "content_length_and_transfer_encoding", or "trailing_bytes" for data after the end
+	// of the body. Go's client copes with all of these, but other parsers along the way
+	// (proxies, load balancers) may not agree with it on where the response ends.
+	// Only detected on plain HTTP/1.x connections, with Dialer.DetectFramingAnomalies.
+	FramingAnomaly       bool
+	FramingAnomalyReason string
+
+	// The response repeated a header it should only have sent once, and why; see
+	// HeaderAnomaly. Set by the caller, if enabled.
+	HeaderAnomaly       bool
+	HeaderAnomalyReason string
+}
+
+// Names of a Trail's phases, in the order they happen, and Phases returns them.
+var PhaseNames = []string{"blocked", "connecting", "sending", "waiting", "receiving"}
+
+// Phases returns the time spent in each of PhaseNames, in milliseconds.
+func (tr Trail) Phases() []float64 {
+	return []float64{stats.D(tr.Blocked), stats.D(tr.Connecting), stats.D(tr.Sending), stats.D(tr.Waiting), stats.D(tr.Receiving)}
+}
+
+// Setup returns Blocked + LookingUp + Connecting + TLSHandshaking, as a single number for
+// the time before the request could start being sent. For new connections Blocked spans
+// the others as well, so it's a figure to threshold on rather than a stretch of time.
+func (tr Trail) Setup() time.Duration {
+	return tr.Blocked + tr.LookingUp + tr.Connecting + tr.TLSHandshaking
 }
 
 func (tr Trail) Samples(tags map[string]string) []stats.Sample {
-	return []stats.Sample{
+	tags = MergeTags(tr.BaseTags, tags)
+	if tr.CountsOnly {
+		samples := []stats.Sample{{Metric: metrics.HTTPReqs, Time: tr.EndTime, Tags: tags, Value: 1}}
+		samples = tr.dataSamples(tags, samples)
+		if tr.RetryCount > 0 {
+			samples = append(samples, stats.Sample{Metric: metrics.HTTPReqRetries, Time: tr.EndTime, Tags: tags, Value: float64(tr.RetryCount)})
+		}
+		return tr.prefixed(tr.newConnSamples(tags, samples))
+	}
+
+	samples := []stats.Sample{
 		{Metric: metrics.HTTPReqs, Time: tr.EndTime, Tags: tags, Value: 1},
 		{Metric: metrics.HTTPReqDuration, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.Duration)},
-		{Metric: metrics.HTTPReqBlocked, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.Blocked)},
-		{Metric: metrics.HTTPReqConnecting, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.Connecting)},
+	}
+	if !tr.OmitReusedConnTimings || !tr.ConnReused {
+		samples = append(samples,
+			stats.Sample{Metric: metrics.HTTPReqBlocked, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.Blocked)},
+			stats.Sample{Metric: metrics.HTTPReqConnecting, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.Connecting)},
+		)
+		if tr.EmitSetup {
+			samples = append(samples, stats.Sample{Metric: metrics.HTTPReqSetup, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.Setup())})
+		}
+	}
+	samples = append(samples, []stats.Sample{
 		{Metric: metrics.HTTPReqSending, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.Sending)},
 		{Metric: metrics.HTTPReqWaiting, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.Waiting)},
 		{Metric: metrics.HTTPReqReceiving, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.Receiving)},
-		{Metric: metrics.DataReceived, Time: tr.EndTime, Tags: tags, Value: float64(tr.BytesRead)},
-		{Metric: metrics.DataSent, Time: tr.EndTime, Tags: tags, Value: float64(tr.BytesWritten)},
+	}...)
+	samples = tr.dataSamples(tags, samples)
+	if tr.HTTP2 {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqStreamBlocked, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.StreamBlocked)})
+	}
+	if tr.ProxyHandshake > 0 {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqProxyHandshake, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.ProxyHandshake)})
+	}
+	if tr.ScriptReadDelay > 0 {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqScriptReadDelay, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.ScriptReadDelay)})
+	}
+	if tr.SerializationTime > 0 {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqSerialization, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.SerializationTime)})
 	}
+	if tr.DecompressionTime > 0 {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqDecompression, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.DecompressionTime)})
+	}
+	if tr.MaxReceiveGap > 0 {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqMaxReceiveGap, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.MaxReceiveGap)})
+	}
+	if tr.WaitingHeaders > 0 {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqWaitingHeaders, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.WaitingHeaders)})
+	}
+	if tr.EarlyHints {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqEarlyHints, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.EarlyHintsWaiting)})
+	}
+	if tr.PreWrite > 0 {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqPreWrite, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.PreWrite)})
+	}
+	if tr.PoolLookup > 0 {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqPoolLookup, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.PoolLookup)})
+	}
+	if tr.ExpectContinue > 0 {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqExpectContinue, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.ExpectContinue)})
+	}
+	if !tr.ServerDate.IsZero() {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqServerClockSkew, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.ServerClockSkew)})
+	}
+	if tr.TLSRenegotiated {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqTLSRenegotiated, Time: tr.EndTime, Tags: tags, Value: 1})
+	}
+	if tr.FramingAnomaly {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqFramingAnomaly, Time: tr.EndTime, Tags: tags, Value: 1})
+	}
+	if tr.HeaderAnomaly {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqHeaderAnomaly, Time: tr.EndTime, Tags: tags, Value: 1})
+	}
+	if tr.TimedOut {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqTimeouts, Time: tr.EndTime, Tags: tags, Value: 1})
+	}
+	if tr.RedirectLimitHit {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqRedirectLimit, Time: tr.EndTime, Tags: tags, Value: 1})
+	}
+	if tr.RetryCount > 0 {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqRetries, Time: tr.EndTime, Tags: tags, Value: float64(tr.RetryCount)})
+	}
+	if tr.ConnectRetries > 0 {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqConnectRetries, Time: tr.EndTime, Tags: tags, Value: float64(tr.ConnectRetries)})
+	}
+	if tr.Hedged {
+		hedgeTags := MergeTags(tags, map[string]string{"hedge_won": "original"})
+		if tr.HedgeWon == 1 {
+			hedgeTags["hedge_won"] = "hedge"
+		}
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqHedged, Time: tr.EndTime, Tags: hedgeTags, Value: 1})
+	}
+	if tr.SocketQueuesSampled {
+		samples = append(samples,
+			stats.Sample{Metric: metrics.HTTPReqSendQueue, Time: tr.EndTime, Tags: tags, Value: float64(tr.SendQueueBytes)},
+			stats.Sample{Metric: metrics.HTTPReqRecvQueue, Time: tr.EndTime, Tags: tags, Value: float64(tr.RecvQueueBytes)},
+		)
+	}
+	if tr.PossibleNagleDelay {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqNagleDelay, Time: tr.EndTime, Tags: tags, Value: 1})
+	}
+	if tr.PossiblePMTUDBlackhole {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqPMTUDBlackhole, Time: tr.EndTime, Tags: tags, Value: 1})
+	}
+	if tr.EstimatedRTT > 0 {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPConnEstimatedRTT, Time: tr.EndTime, Tags: tags, Value: stats.D(tr.EstimatedRTT)})
+	}
+	if tr.ResponseHeaderBytes > 0 {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqResHeaderBytes, Time: tr.EndTime, Tags: tags, Value: float64(tr.ResponseHeaderBytes)})
+	}
+	if tr.ConnReset {
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPConnReset, Time: tr.EndTime, Tags: tags, Value: 1})
+	}
+	if tr.ConnCloseReason != "" {
+		closeTags := MergeTags(tags, map[string]string{"close_reason": tr.ConnCloseReason})
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPConnsClosed, Time: tr.EndTime, Tags: closeTags, Value: 1})
+	}
+	samples = tr.newConnSamples(tags, samples)
+	if tr.DNSError != "" {
+		dnsTags := MergeTags(tags, map[string]string{"dns_error": tr.DNSError})
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqDNSFailed, Time: tr.EndTime, Tags: dnsTags, Value: 1})
+	}
+	for _, phase := range tr.BudgetExceeded {
+		phaseTags := MergeTags(tags, map[string]string{"phase": phase})
+		samples = append(samples, stats.Sample{Metric: metrics.HTTPReqBudgetExceeded, Time: tr.EndTime, Tags: phaseTags, Value: 1})
+	}
+	return tr.prefixed(tr.rounded(samples))
+}
+
+// Appends data_received and data_sent; the TLS handshake's share of them, if any, goes in
+// samples of their own tagged "tls_handshake", so that the totals still add up.
+func (tr Trail) dataSamples(tags map[string]string, samples []stats.Sample) []stats.Sample {
+	samples = append(samples,
+		stats.Sample{Metric: metrics.DataReceived, Time: tr.EndTime, Tags: tags, Value: float64(tr.BytesRead - tr.TLSBytesRead)},
+		stats.Sample{Metric: metrics.DataSent, Time: tr.EndTime, Tags: tags, Value: float64(tr.BytesWritten - tr.TLSBytesWritten)},
+	)
+	if tr.TLSBytesRead == 0 && tr.TLSBytesWritten == 0 {
+		return samples
+	}
+	tlsTags := MergeTags(tags, map[string]string{"tls_handshake": "true"})
+	return append(samples,
+		stats.Sample{Metric: metrics.DataReceived, Time: tr.EndTime, Tags: tlsTags, Value: float64(tr.TLSBytesRead)},
+		stats.Sample{Metric: metrics.DataSent, Time: tr.EndTime, Tags: tlsTags, Value: float64(tr.TLSBytesWritten)},
+	)
+}
+
+// Appends a http_conns_new sample to samples, if the request opened a connection.
+func (tr Trail) newConnSamples(tags map[string]string, samples []stats.Sample) []stats.Sample {
+	if tr.ConnReused || tr.ConnID == 0 || tr.ConnWarmed {
+		return samples
+	}
+	// Timestamped with when the connection was asked for, rather than the request's end.
+	opened := tr.StartTime.Add(-tr.Blocked)
+	return append(samples, stats.Sample{Metric: metrics.HTTPConnsNew, Time: opened, Tags: tags, Value: 1})
+}
+
+// MergeTags returns base with tags layered over it, without modifying either; if base is
+// empty, tags is returned as is.
+func MergeTags(base, tags map[string]string) map[string]string {
+	if len(base) == 0 {
+		return tags
+	}
+	merged := make(map[string]string, len(base)+len(tags))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return merged
+}
+
+// Moves samples to metrics with the MetricPrefix, if there is one.
+func (tr Trail) prefixed(samples []stats.Sample) []stats.Sample {
+	if tr.MetricPrefix != "" {
+		for i := range samples {
+			samples[i].Metric = metrics.Prefixed(tr.MetricPrefix, samples[i].Metric)
+		}
+	}
+	return samples
+}
+
+// EqualIgnoringTime compares two Trails, ignoring fields that vary between otherwise
+// identical runs: StartTime, EndTime and ServerDate (absolute timestamps),
+// ConnRemoteAddr (which includes an ephemeral port) and ConnID. Durations are still
+// compared.
+func EqualIgnoringTime(a, b Trail) bool {
+	for _, tr := range []*Trail{&a, &b} {
+		tr.StartTime = time.Time{}
+		tr.EndTime = time.Time{}
+		tr.ServerDate = time.Time{}
+		tr.ConnRemoteAddr = nil
+		tr.ConnID = 0
+	}
+	return reflect.DeepEqual(a, b)
 }
 
 // A Tracer wraps "net/http/httptrace" to collect granular timings for HTTP requests.
 // Note that since there is not yet an event for the end of a request (there's a PR to
 // add it), you must call Done() at the end of the request to get the full timings.
-// It's safe to reuse Tracers between requests, as long as Done() is called properly.
+// A Tracer is good for one request (and its redirects) only; make a new one for the next,
+// as hooks for this one may still be running after Done().
 // Cheers, love, the cavalry's here.
 type Tracer struct {
+	// Deadlines for each individual read from and write to the connection, not for the
+	// request as a whole; zero for none.
+	ReadTimeout, WriteTimeout time.Duration
+
+	// Per-phase timing budget that Done() checks the trail against.
+	Budget Budget
+
+	// ALPN protocols offered in TLS handshakes, for detecting fallbacks.
+	OfferedProtocols []string
+
+	// Record the connection's socket buffer occupancy in Done().
+	SampleSocketQueues bool
+
+	// Flag requests that look like they hit a path MTU discovery black hole.
+	DetectPMTUDBlackholes bool
+
+	// Measure the time spent finding a connection in the pool.
+	MeasurePoolLookup bool
+
+	// Time the first write to a connection once it's been acquired, for Trail.PreWrite.
+	MeasurePreWrite bool
+
+	// Keep the timestamps of phase transitions, as Trail.PhaseTimestamps.
+	RecordPhaseTimestamps bool
+
+	// Makes TLS handshakes wait for their turn if set.
+	Handshakes *HandshakeLimiter
+
+	ctx context.Context
+
+	// Held by the hooks, which the transport may call from goroutines of its own (the
+	// dial's, or HTTP/2's writing the request, which can outlast the request), and by Done().
+	lock sync.Mutex
+
 	getConn              time.Time
 	gotConn              time.Time
 	gotFirstResponseByte time.Time
 	connectStart         time.Time
 	connectDone          time.Time
+	dialStart            time.Time
+	wroteHeaders         time.Time
+	wait100Continue      time.Time
+	got100Continue       time.Time
 	wroteRequest         time.Time
+	gotHeaders           time.Time
+	gotEarlyHints        time.Time
+	tlsHandshakeStart    time.Time
+	tlsHandshakeEnd      time.Time
+
+	// Time spent waiting for a Handshakes slot, and whether one's held.
+	tlsQueued time.Duration
+	tlsSlot   bool
+
+	// Bytes transferred during the TLS handshake; see Trail.TLSBytesRead.
+	tlsBytesRead, tlsBytesWritten int64
+
+	lookingUp time.Duration
+
+	// Time spent between reads of the Body(), and when the last one ended.
+	readDelay time.Duration
+	lastRead  time.Time
+
+	// Time spent in reads of the Body() since the last that got data, and the gaps so far.
+	receiveGap                     time.Duration
+	maxReceiveGap, totalReceiveGap time.Duration
+	receiveGaps                    int
 
 	connReused     bool
 	connRemoteAddr net.Addr
+	http2          bool
+	http3          bool
+	quic0RTT       bool
+	estimatedRTT   time.Duration
+	connID         uint64
+	connWarmed     bool
+
+	dnsAnswerCount int
+	dnsRecord      net.IP
+	dnsError       string
+	dialRewrite    string
+	hostOverride   string
+	proxy          string
+	proxyHandshake time.Duration
+	proxyChain     int
+	proxyFailed    bool
+
+	tlsHandshakeDone   bool
+	negotiatedProtocol string
+	tlsVersion         uint16
+	cipherSuite        uint16
+	offeredCiphers     []uint16
+	cipherOverride     bool
+
+	protoError    error
+	connectFailed bool
 
-	protoError error
+	connectRetries    int
+	connectRetryDelay time.Duration
+
+	// Whether the request went to an HTTP3Transport's Fallback, and the time it spent
+	// trying to make a QUIC connection first.
+	http3Fallback bool
+	http3Tried    time.Duration
 
 	bytesRead, bytesWritten int64
+
+	// UnixNano of the first write after GotConn, set atomically by Conn.Write.
+	firstWrite int64
+
+	// TLS renegotiations during the request, and how long they took; set atomically.
+	renegotiations, renegotiationTime int64
+
+	// The connection the request went out on, and whether a deadline on it was hit.
+	conn    *Conn
+	ioError int32
+
+	// Why the response's framing was suspect, if it was, and the bytes of response heads
+	// read; set atomically.
+	framingAnomaly      int32
+	responseHeaderBytes int64
+
+	// Counted towards InFlight(), until Done().
+	inFlight bool
+}
+
+// ProtoOf returns the HTTP version res came in, as its ALPN ID; see Trail.Proto.
+func ProtoOf(res *http.Response) string {
+	switch {
+	case res.ProtoMajor == 3:
+		return "h3"
+	case res.ProtoMajor == 2:
+		return "h2"
+	case res.ProtoMajor == 1 && res.ProtoMinor == 0:
+		return "http/1.0"
+	default:
+		return "http/1.1"
+	}
 }
 
+// Requests currently in flight, across all Tracers; see InFlight().
+var inFlight int64
+
+// InFlight returns how many requests have started (asked for a connection), but not yet
+// been Done(). If this keeps climbing, requests are piling up faster than they finish.
+func InFlight() int64 {
+	return atomic.LoadInt64(&inFlight)
+}
+
+// Bytes sent and received by finished requests, across all Tracers; see Transferred().
+var transferred int64
+
+// Transferred returns the total of BytesRead and BytesWritten over every Trail so far.
+func Transferred() int64 {
+	return atomic.LoadInt64(&transferred)
+}
+
+// Headers arriving within this long of the first byte are considered to have come with it.
+const HeadersResolution = 1 * time.Millisecond
+
+// Less time than this between a body's reads is just the caller's overhead, not a delay.
+const ReadDelayResolution = 1 * time.Millisecond
+
+// Building a request quicker than this isn't worth reporting; see Trail.SerializationTime.
+const SerializationResolution = 1 * time.Millisecond
+
+// Values for Tracer.ioError.
+const (
+	ioReadTimeout int32 = iota + 1
+	ioWriteTimeout
+	ioConnReset
+)
+
 // Trace() returns a premade ClientTrace that calls all of the Tracer's hooks.
 func (t *Tracer) Trace() *httptrace.ClientTrace {
 	return &httptrace.ClientTrace{
@@ -95,13 +757,61 @@ func (t *Tracer) Trace() *httptrace.ClientTrace {
 		GotFirstResponseByte: t.GotFirstResponseByte,
 		ConnectStart:         t.ConnectStart,
 		ConnectDone:          t.ConnectDone,
+		WroteHeaders:         t.WroteHeaders,
+		Wait100Continue:      t.Wait100Continue,
+		Got100Continue:       t.Got100Continue,
+		Got1xxResponse:       t.Got1xxResponse,
 		WroteRequest:         t.WroteRequest,
+		TLSHandshakeStart:    t.TLSHandshakeStart,
+		TLSHandshakeDone:     t.TLSHandshakeDone,
 	}
 }
 
-// Call when the request is finished. Calculates metrics and resets the tracer.
+// Call when the request is finished, once. Calculates metrics.
 func (t *Tracer) Done() Trail {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
 	done := time.Now()
+	if t.inFlight {
+		t.inFlight = false
+		atomic.AddInt64(&inFlight, -1)
+	}
+
+	var timestamps *PhaseTimestamps
+	if t.RecordPhaseTimestamps {
+		timestamps = t.phaseTimestamps(done)
+	}
+
+	// If the request's deadline expired, cut off every phase that wasn't reached at the
+	// time of the abort, so that the ones that were report the time up to it.
+	timedOut := t.ctx != nil && t.ctx.Err() == context.DeadlineExceeded
+	ioError := atomic.LoadInt32(&t.ioError)
+	newConn := !t.connReused && !t.connectDone.IsZero() && !t.connectFailed
+	gotFirstByte := !t.gotFirstResponseByte.IsZero()
+	if timedOut || ioError != 0 {
+		if t.getConn.IsZero() {
+			t.getConn = done
+		}
+		if t.gotConn.IsZero() {
+			t.gotConn = done
+		}
+		if !t.connectStart.IsZero() && t.connectDone.IsZero() {
+			t.connectDone = done
+		}
+		if t.connectDone.IsZero() {
+			t.connectDone = t.gotConn
+		}
+		if t.wroteRequest.IsZero() {
+			t.wroteRequest = done
+		}
+	}
+
+	// Headers can't be complete before the first byte of them arrived.
+	gotHeaders := t.gotHeaders
+	if t.gotFirstResponseByte.IsZero() {
+		gotHeaders = time.Time{}
+	}
 
 	// Cover for if the server closed the connection without a response.
 	if t.gotFirstResponseByte.IsZero() {
@@ -120,21 +830,133 @@ func (t *Tracer) Done() Trail {
 		Waiting:    t.gotFirstResponseByte.Sub(t.wroteRequest),
 		Receiving:  done.Sub(t.gotFirstResponseByte),
 
+		StreamBlocked: t.wroteHeaders.Sub(t.gotConn),
+		HTTP2:         t.http2,
+
 		ConnReused:     t.connReused,
 		ConnRemoteAddr: t.connRemoteAddr,
+		EstimatedRTT:   t.estimatedRTT,
+		ConnID:         t.connID,
+		ConnWarmed:     t.connWarmed,
+
+		DNSAnswerCount: t.dnsAnswerCount,
+		DNSRecord:      t.dnsRecord,
+		DNSError:       t.dnsError,
+		DialRewrite:    t.dialRewrite,
+		ConnOverridden: t.hostOverride != "",
+		HostOverride:   t.hostOverride,
+		Proxy:          t.proxy,
+		ProxyChain:     t.proxyChain,
 
-		BytesRead:    t.bytesRead,
-		BytesWritten: t.bytesWritten,
+		BytesRead:    atomic.LoadInt64(&t.bytesRead),
+		BytesWritten: atomic.LoadInt64(&t.bytesWritten),
+
+		ConnectFailed: t.connectFailed,
+		HTTP3Fallback: t.http3Fallback,
+
+		TimedOut: timedOut,
+
+		PhaseTimestamps: timestamps,
 	}
 
 	// If the connection was reused, it never blocked.
 	if t.connReused {
 		trail.Blocked = 0
 		trail.Connecting = 0
+	} else {
+		trail.QUIC0RTT = t.quic0RTT
+		trail.LookingUp = t.lookingUp
+		if !t.tlsHandshakeStart.IsZero() && !t.tlsHandshakeEnd.IsZero() {
+			trail.TLSHandshaking = t.tlsHandshakeEnd.Sub(t.tlsHandshakeStart)
+		}
+		trail.TLSQueued = t.tlsQueued
+		// Negative if the handshake started but never finished; then we don't know.
+		if t.tlsBytesRead >= 0 && t.tlsBytesWritten >= 0 {
+			trail.TLSBytesRead, trail.TLSBytesWritten = t.tlsBytesRead, t.tlsBytesWritten
+		}
+		if t.connectRetries > 0 {
+			trail.ConnectRetries = t.connectRetries
+			trail.ConnectRetryDelay = t.connectRetryDelay
+			trail.Connecting -= t.connectRetryDelay
+			if trail.Connecting < 0 {
+				trail.Connecting = 0
+			}
+		}
+		if t.proxyHandshake > 0 {
+			// It happened between connecting and sending.
+			trail.ProxyHandshake = t.proxyHandshake
+			trail.Sending -= t.proxyHandshake
+			if trail.Sending < 0 {
+				trail.Sending = 0
+			}
+		}
+	}
+
+	// A failed QUIC handshake held up getting a connection just the same.
+	trail.Blocked += t.http3Tried
+
+	if atomic.LoadInt64(&t.renegotiations) > 0 {
+		trail.TLSRenegotiated = true
+		trail.TLSRenegotiation = time.Duration(atomic.LoadInt64(&t.renegotiationTime))
+	}
+
+	if t.MeasurePoolLookup {
+		if t.connReused {
+			trail.PoolLookup = t.gotConn.Sub(t.getConn)
+		} else if !t.dialStart.IsZero() {
+			trail.PoolLookup = t.dialStart.Sub(t.getConn)
+		}
+		if trail.PoolLookup < 0 {
+			trail.PoolLookup = 0
+		}
+	}
+
+	if t.MeasurePreWrite {
+		if firstWrite := atomic.LoadInt64(&t.firstWrite); firstWrite != 0 {
+			trail.PreWrite = time.Unix(0, firstWrite).Sub(t.gotConn)
+		}
+		if trail.PreWrite < 0 {
+			trail.PreWrite = 0
+		}
+	}
+
+	// Nothing was ever written, so there's nothing to have been blocked on.
+	if t.wroteHeaders.IsZero() || trail.StreamBlocked < 0 {
+		trail.StreamBlocked = 0
+	}
+
+	// The body isn't sent until the server says to, so that's not part of Sending. If it
+	// never answers, the transport sends it anyway after a timeout; we can't tell when.
+	if !t.wait100Continue.IsZero() && !t.got100Continue.IsZero() {
+		trail.ExpectContinue = t.got100Continue.Sub(t.wait100Continue)
+		trail.Sending -= trail.ExpectContinue
+	}
+
+	// A socket deadline or reset doesn't invalidate anything, it just cuts the request short.
+	switch ioError {
+	case ioReadTimeout:
+		trail.Failed = true
+		trail.ErrorClass = "read_timeout"
+	case ioWriteTimeout:
+		trail.Failed = true
+		trail.ErrorClass = "write_timeout"
+	case ioConnReset:
+		trail.Failed = true
+		trail.ConnReset = true
+		trail.ErrorClass = "conn_reset"
+	}
+	if t.proxyFailed {
+		trail.Failed = true
+		trail.ErrorClass = "proxy_failed"
+	}
+	if t.dnsError != "" {
+		trail.Failed = true
+		trail.ErrorClass = "dns_failed"
 	}
 
 	// If the connection failed, we'll never get any (meaningful) data for these.
-	if t.protoError != nil {
+	if t.protoError != nil && ioError == 0 {
+		trail.Failed = true
 		trail.Sending = 0
 		trail.Waiting = 0
 		trail.Receiving = 0
@@ -145,45 +967,267 @@ func (t *Tracer) Done() Trail {
 		}
 	}
 
+	trail.PossibleNagleDelay = !trail.Failed && possibleNagleDelay(trail.Waiting)
+	if t.DetectPMTUDBlackholes {
+		stalled := timedOut || ioError == ioReadTimeout || ioError == ioWriteTimeout
+		trail.PossiblePMTUDBlackhole = possiblePMTUDBlackhole(newConn, stalled, gotFirstByte, trail.BytesWritten)
+	}
+	if !t.gotEarlyHints.IsZero() {
+		trail.EarlyHints = true
+		trail.EarlyHintsWaiting = t.gotEarlyHints.Sub(t.wroteRequest)
+	}
+	if !gotHeaders.IsZero() && !trail.Failed && gotHeaders.Sub(t.gotFirstResponseByte) >= HeadersResolution {
+		trail.WaitingHeaders = gotHeaders.Sub(t.wroteRequest)
+	}
+	if t.readDelay >= ReadDelayResolution {
+		trail.ScriptReadDelay = t.readDelay
+	}
+	if t.receiveGaps > 0 {
+		trail.MaxReceiveGap = t.maxReceiveGap
+		trail.MeanReceiveGap = t.totalReceiveGap / time.Duration(t.receiveGaps)
+	}
+	if reason := atomic.LoadInt32(&t.framingAnomaly); reason != 0 {
+		trail.FramingAnomaly = true
+		trail.FramingAnomalyReason = framingAnomalyReasons[reason]
+	}
+	trail.ResponseHeaderBytes = int(atomic.LoadInt64(&t.responseHeaderBytes))
+
 	// Calculate total times using adjusted values.
 	trail.EndTime = done
-	trail.Duration = trail.Sending + trail.Waiting + trail.Receiving
+	trail.Duration = trail.Sending + trail.ExpectContinue + trail.Waiting + trail.Receiving
 	trail.StartTime = trail.EndTime.Add(-trail.Duration)
+	trail.BudgetExceeded = t.Budget.Exceeded(trail)
+
+	if t.tlsHandshakeDone {
+		trail.NegotiatedProtocol = t.negotiatedProtocol
+		trail.CipherSuite = t.cipherSuite
+		trail.OfferedCipherSuites = t.offeredCiphers
+		trail.ServerCipherOverride = t.cipherOverride
+		// HTTP/3 isn't a fallback from anything; ALPN just confirms it.
+		if trail.NegotiatedProtocol != "h2" && !t.http3 {
+			for _, proto := range t.OfferedProtocols {
+				if proto == "h2" {
+					trail.ALPNFallback = true
+				}
+			}
+		}
+	}
+
+	if t.conn != nil && t.conn.fastOpen && !t.connReused {
+		trail.TCPFastOpen = t.conn.fastOpened()
+	}
+
+	if t.conn != nil {
+		if reason := atomic.LoadInt32(&t.conn.closeReason); reason != 0 {
+			trail.ConnCloseReason = connCloseReasons[reason]
+			trail.ConnClosedByClient = reason != connClosedByServer
+		}
+	}
+
+	if t.SampleSocketQueues && t.conn != nil {
+		if send, recv, err := t.conn.QueueBytes(); err == nil {
+			trail.SendQueueBytes = send
+			trail.RecvQueueBytes = recv
+			trail.SocketQueuesSampled = true
+		}
+	}
+
+	atomic.AddInt64(&transferred, trail.BytesRead+trail.BytesWritten)
 
-	*t = Tracer{}
+	// Don't leave deadlines or hooks behind on a connection that goes back into the pool.
+	if t.conn != nil {
+		t.conn.hooks.Store(nil)
+		atomic.StoreInt32(&t.conn.inRequest, 0)
+		_ = t.conn.SetDeadline(time.Time{})
+	}
+	// Nor a handshake slot, if the handshake never finished.
+	if t.tlsSlot {
+		t.tlsSlot = false
+		t.Handshakes.release()
+	}
 	return trail
 }
 
+// Returns a Tracer with t's settings, that hasn't traced anything yet.
+func (t *Tracer) fresh() *Tracer {
+	return &Tracer{
+		ReadTimeout:      t.ReadTimeout,
+		WriteTimeout:     t.WriteTimeout,
+		Budget:           t.Budget,
+		OfferedProtocols: t.OfferedProtocols,
+
+		SampleSocketQueues:    t.SampleSocketQueues,
+		DetectPMTUDBlackholes: t.DetectPMTUDBlackholes,
+		MeasurePoolLookup:     t.MeasurePoolLookup,
+		MeasurePreWrite:       t.MeasurePreWrite,
+		RecordPhaseTimestamps: t.RecordPhaseTimestamps,
+		Handshakes:            t.Handshakes,
+	}
+}
+
 // GetConn event hook.
 func (t *Tracer) GetConn(hostPort string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
 	t.getConn = time.Now()
+	t.dialStart = time.Time{}
+
+	// Redirects and retries ask for another connection; it's still the same request.
+	if !t.inFlight {
+		t.inFlight = true
+		atomic.AddInt64(&inFlight, 1)
+	}
 }
 
 // GotConn event hook.
 func (t *Tracer) GotConn(info httptrace.GotConnInfo) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
 	t.gotConn = time.Now()
 	t.connReused = info.Reused
 	t.connRemoteAddr = info.Conn.RemoteAddr()
 
+	// HTTP/2 is only ever negotiated over TLS, with ALPN; reused connections included.
+	if tc, ok := info.Conn.(*tls.Conn); ok {
+		t.http2 = tc.ConnectionState().NegotiatedProtocol == "h2"
+	}
+
+	// Anything written so far was the connection's own handshake, not this request.
+	atomic.StoreInt64(&t.firstWrite, 0)
+
+	if !info.Reused && !t.connectStart.IsZero() && !t.connectDone.IsZero() && !t.connectFailed && t.connectRetries == 0 {
+		t.estimatedRTT = t.connectDone.Sub(t.connectStart)
+	}
+
+	if conn, ok := unwrapConn(info.Conn); ok {
+		if info.Reused {
+			t.estimatedRTT = time.Duration(atomic.LoadInt64(&conn.estimatedRTT))
+		} else {
+			if conn.fastOpen {
+				t.estimatedRTT = 0
+			}
+			atomic.StoreInt64(&conn.estimatedRTT, int64(t.estimatedRTT))
+		}
+		t.conn = conn
+		t.connID = conn.ConnID
+		t.connWarmed = conn.warmed
+		hooks := t.counters()
+		hooks.ReadTimeout = t.ReadTimeout
+		hooks.WriteTimeout = t.WriteTimeout
+		hooks.IOError = &t.ioError
+		hooks.FramingAnomaly = &t.framingAnomaly
+		hooks.ResponseHeaderBytes = &t.responseHeaderBytes
+		conn.hooks.Store(hooks)
+		atomic.StoreInt32(&conn.inRequest, 1)
+
+		// The handshake happens before we get the connection.
+		if t.tlsHandshakeDone && !info.Reused {
+			t.offeredCiphers = conn.offeredCipherSuites
+			t.cipherOverride = serverCipherOverride(t.offeredCiphers, t.tlsVersion, t.cipherSuite)
+		}
+	}
+
 	if t.connReused {
 		t.connectStart = t.gotConn
 		t.connectDone = t.gotConn
+	}
+}
 
-		// If the connection was reused, patch it to use this tracer's data counters.
-		if conn, ok := info.Conn.(*Conn); ok {
-			conn.BytesRead = &t.bytesRead
-			conn.BytesWritten = &t.bytesWritten
-		}
+// Hooks that count a connection's traffic and renegotiations, and time its first write,
+// for t.
+func (t *Tracer) counters() *connHooks {
+	return &connHooks{
+		BytesRead:         &t.bytesRead,
+		BytesWritten:      &t.bytesWritten,
+		FirstWrite:        &t.firstWrite,
+		Renegotiations:    &t.renegotiations,
+		RenegotiationTime: &t.renegotiationTime,
 	}
 }
 
 // GotFirstResponseByte hook.
 func (t *Tracer) GotFirstResponseByte() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
 	t.gotFirstResponseByte = time.Now()
 }
 
+// Got1xxResponse hook; also called for informational responses other than 103, and for
+// each of several 103s, of which only the first counts.
+func (t *Tracer) Got1xxResponse(code int, header textproto.MIMEHeader) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if code == 103 && t.gotEarlyHints.IsZero() {
+		t.gotEarlyHints = time.Now()
+	}
+	return nil
+}
+
+// GotHeaders should be called by the caller once the response headers have been read,
+// ie. when http.Client.Do() or a RoundTrip() returns; httptrace has no event for it.
+func (t *Tracer) GotHeaders() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.gotHeaders = time.Now()
+}
+
+// Body wraps a response body, to tell how long it waits for the caller to read it, and
+// the other way around; see Trail.ScriptReadDelay and Trail.MaxReceiveGap. That's from
+// when this is called, until its end is reached.
+func (t *Tracer) Body(body io.ReadCloser) io.ReadCloser {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.lastRead = time.Now()
+	return &tracedBody{ReadCloser: body, tracer: t}
+}
+
+type tracedBody struct {
+	io.ReadCloser
+	tracer *Tracer
+	eof    bool
+}
+
+// Times the read, holding the tracer's lock around it, but not while it's blocked.
+func (b *tracedBody) Read(p []byte) (int, error) {
+	if b.eof {
+		return b.ReadCloser.Read(p)
+	}
+	t := b.tracer
+
+	start := time.Now()
+	t.lock.Lock()
+	t.readDelay += start.Sub(t.lastRead)
+	t.lock.Unlock()
+
+	n, err := b.ReadCloser.Read(p)
+	b.eof = err != nil
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.lastRead = time.Now()
+	t.receiveGap += t.lastRead.Sub(start)
+	if n > 0 {
+		if t.receiveGap > t.maxReceiveGap {
+			t.maxReceiveGap = t.receiveGap
+		}
+		t.totalReceiveGap += t.receiveGap
+		t.receiveGaps++
+		t.receiveGap = 0
+	}
+	return n, err
+}
+
 // ConnectStart hook.
 func (t *Tracer) ConnectStart(network, addr string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
 	// If using dual-stack dialing, it's possible to get this multiple times.
 	if !t.connectStart.IsZero() {
 		return
@@ -193,6 +1237,9 @@ func (t *Tracer) ConnectStart(network, addr string) {
 
 // ConnectDone hook.
 func (t *Tracer) ConnectDone(network, addr string, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
 	// If using dual-stack dialing, it's possible to get this multiple times.
 	if !t.connectDone.IsZero() {
 		return
@@ -205,11 +1252,99 @@ func (t *Tracer) ConnectDone(network, addr string, err error) {
 
 	if err != nil {
 		t.protoError = err
+		t.connectFailed = true
+	}
+}
+
+// Called by a Dialer about to retry a connect that failed, after waiting delay; forgets
+// about the failure, so that the retry is what ConnectDone reports on. Connecting still
+// starts with the first try.
+func (t *Tracer) retryingConnect(delay time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.connectRetries++
+	t.connectRetryDelay += delay
+	if t.gotConn.Equal(t.connectDone) {
+		t.gotConn = time.Time{}
+	}
+	t.connectDone = time.Time{}
+	t.protoError = nil
+	t.connectFailed = false
+}
+
+// WroteHeaders hook.
+func (t *Tracer) WroteHeaders() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.wroteHeaders = time.Now()
+}
+
+// Wait100Continue hook.
+func (t *Tracer) Wait100Continue() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.wait100Continue = time.Now()
+}
+
+// Got100Continue hook.
+func (t *Tracer) Got100Continue() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.got100Continue = time.Now()
+}
+
+// TLSHandshakeStart hook.
+func (t *Tracer) TLSHandshakeStart() {
+	var slot bool
+	var queued time.Duration
+	if t.Handshakes != nil {
+		// Blocking here holds the handshake back until there's a slot for it.
+		start := time.Now()
+		slot = t.Handshakes.acquire(t.ctx)
+		queued = time.Since(start)
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.tlsSlot = slot
+	t.tlsQueued += queued
+	t.tlsHandshakeStart = time.Now()
+	// Nothing but the handshake goes over the connection until it's done.
+	t.tlsBytesRead = -atomic.LoadInt64(&t.bytesRead)
+	t.tlsBytesWritten = -atomic.LoadInt64(&t.bytesWritten)
+}
+
+// TLSHandshakeDone hook.
+func (t *Tracer) TLSHandshakeDone(state tls.ConnectionState, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.tlsSlot {
+		t.Handshakes.release()
+		t.tlsSlot = false
 	}
+	t.tlsBytesRead += atomic.LoadInt64(&t.bytesRead)
+	t.tlsBytesWritten += atomic.LoadInt64(&t.bytesWritten)
+	if err != nil {
+		return
+	}
+	t.tlsHandshakeDone = true
+	t.tlsHandshakeEnd = time.Now()
+	t.negotiatedProtocol = state.NegotiatedProtocol
+	t.tlsVersion = state.Version
+	t.cipherSuite = state.CipherSuite
 }
 
 // WroteRequest hook.
 func (t *Tracer) WroteRequest(info httptrace.WroteRequestInfo) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
 	t.wroteRequest = time.Now()
 	if info.Err != nil {
 		t.protoError = info.Err
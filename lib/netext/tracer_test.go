@@ -0,0 +1,1012 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netext
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/loadimpact/k6/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d, err := time.ParseDuration(r.URL.Query().Get("sleep")); err == nil {
+			time.Sleep(d)
+		}
+		_, _ = ioutil.ReadAll(r.Body)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	transport := &http.Transport{
+		DialContext:           NewDialer(net.Dialer{}).DialContext,
+		ExpectContinueTimeout: 10 * time.Second,
+	}
+	client := http.Client{Transport: transport}
+
+	t.Run("Timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL+"/?sleep=500ms", nil)
+		assert.NoError(t, err)
+		_, err = client.Do(req.WithContext(WithTracer(ctx, tracer)))
+		assert.Error(t, err)
+
+		trail := tracer.Done()
+		assert.True(t, trail.TimedOut)
+		assert.True(t, trail.Waiting > 0)
+		assert.Equal(t, time.Duration(0), trail.PreWrite, "measured without MeasurePreWrite")
+		assert.True(t, trail.Duration < 500*time.Millisecond)
+		assert.Equal(t, time.Duration(0), trail.Receiving)
+
+		seen := false
+		for _, s := range trail.Samples(nil) {
+			if s.Metric == metrics.HTTPReqTimeouts {
+				seen = true
+			}
+		}
+		assert.True(t, seen, "no timeout sample emitted")
+	})
+	t.Run("NoTimeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		tracer := &Tracer{MeasurePreWrite: true}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(ctx, tracer)))
+		if assert.NoError(t, err) {
+			_ = res.Body.Close()
+		}
+
+		trail := tracer.Done()
+		assert.False(t, trail.TimedOut)
+		assert.True(t, trail.StreamBlocked >= 0)
+		assert.True(t, trail.StreamBlocked <= trail.Sending)
+		assert.True(t, trail.PreWrite > 0)
+		assert.True(t, trail.PreWrite <= trail.Duration)
+		for _, s := range trail.Samples(nil) {
+			assert.NotEqual(t, metrics.HTTPReqTimeouts, s.Metric)
+		}
+	})
+	t.Run("ExpectContinue", func(t *testing.T) {
+		tracer := &Tracer{}
+		req, err := http.NewRequest("POST", srv.URL, strings.NewReader("data"))
+		assert.NoError(t, err)
+		req.Header.Set("Expect", "100-continue")
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_ = res.Body.Close()
+		}
+
+		trail := tracer.Done()
+		assert.True(t, trail.ExpectContinue > 0)
+		assert.True(t, trail.Duration >= trail.ExpectContinue)
+	})
+}
+
+func TestTracerEstimatedRTT(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+
+	get := func() Trail {
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+
+	first := get()
+	assert.False(t, first.ConnReused)
+	assert.True(t, first.EstimatedRTT > 0)
+	assert.Equal(t, first.Connecting, first.EstimatedRTT)
+
+	second := get()
+	assert.True(t, second.ConnReused)
+	assert.Equal(t, time.Duration(0), second.Connecting)
+	assert.Equal(t, first.EstimatedRTT, second.EstimatedRTT)
+}
+
+func TestTracerPoolLookup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+
+	get := func(measure bool) Trail {
+		tracer := &Tracer{MeasurePoolLookup: measure}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+
+	trail := get(true)
+	assert.False(t, trail.ConnReused)
+	assert.True(t, trail.PoolLookup > 0)
+	assert.True(t, trail.PoolLookup < trail.Blocked)
+
+	trail = get(true)
+	assert.True(t, trail.ConnReused)
+	assert.True(t, trail.PoolLookup > 0)
+	assert.Equal(t, time.Duration(0), trail.Blocked)
+
+	trail = get(false)
+	assert.Equal(t, time.Duration(0), trail.PoolLookup)
+}
+
+func TestTracerPhaseTimestamps(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+
+	get := func(record bool) Trail {
+		tracer := &Tracer{RecordPhaseTimestamps: record}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+
+	trail := get(true)
+	ts := trail.PhaseTimestamps
+	if assert.NotNil(t, ts) {
+		assert.False(t, trail.ConnReused)
+		assert.False(t, ts.GetConn.After(ts.ConnectStart))
+		assert.False(t, ts.ConnectDone.After(ts.GotConn))
+		assert.False(t, ts.GotFirstResponseByte.After(ts.Done))
+		assert.Equal(t, trail.Connecting, ts.ConnectDone.Sub(ts.ConnectStart))
+		assert.Equal(t, trail.Blocked, ts.GotConn.Sub(ts.GetConn))
+		assert.Equal(t, trail.Waiting, ts.GotFirstResponseByte.Sub(ts.WroteRequest))
+		assert.Equal(t, trail.EndTime, ts.Done)
+	}
+
+	trail = get(true)
+	ts = trail.PhaseTimestamps
+	if assert.NotNil(t, ts) {
+		assert.True(t, trail.ConnReused)
+		assert.True(t, ts.ConnectStart.IsZero())
+		assert.True(t, ts.ConnectDone.IsZero())
+		assert.False(t, ts.GetConn.After(ts.GotConn))
+		assert.False(t, ts.WroteRequest.After(ts.GotFirstResponseByte))
+	}
+
+	trail = get(false)
+	assert.Nil(t, trail.PhaseTimestamps)
+}
+
+func TestTracerIOTimeouts(t *testing.T) {
+	t.Run("read_timeout", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("slow"))
+			w.(http.Flusher).Flush()
+			time.Sleep(500 * time.Millisecond)
+			_, _ = w.Write([]byte("er"))
+		}))
+		defer srv.Close()
+		client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+
+		tracer := &Tracer{ReadTimeout: 100 * time.Millisecond}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_, err = ioutil.ReadAll(res.Body)
+			assert.Error(t, err)
+			_ = res.Body.Close()
+		}
+
+		trail := tracer.Done()
+		assert.True(t, trail.Failed)
+		assert.Equal(t, "read_timeout", trail.ErrorClass)
+		assert.True(t, trail.Waiting > 0)
+		assert.True(t, trail.Receiving >= 100*time.Millisecond)
+		assert.True(t, trail.Receiving < 500*time.Millisecond)
+		assert.Equal(t, 100*time.Millisecond, tracer.ReadTimeout)
+	})
+	t.Run("write_timeout", func(t *testing.T) {
+		// Accept connections, but never read from them, so the socket buffers fill up.
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer func() { _ = l.Close() }()
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer func() { _ = conn.Close() }()
+			}
+		}()
+		client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+
+		tracer := &Tracer{WriteTimeout: 100 * time.Millisecond}
+		body := bytes.NewReader(make([]byte, 64*1024*1024))
+		req, err := http.NewRequest("POST", "http://"+l.Addr().String(), body)
+		assert.NoError(t, err)
+		_, err = client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		assert.Error(t, err)
+
+		trail := tracer.Done()
+		assert.True(t, trail.Failed)
+		assert.Equal(t, "write_timeout", trail.ErrorClass)
+		assert.True(t, trail.Sending >= 100*time.Millisecond)
+		assert.True(t, trail.BytesWritten > 0)
+	})
+}
+
+func TestEqualIgnoringTime(t *testing.T) {
+	a := Trail{
+		StartTime:      time.Now(),
+		EndTime:        time.Now().Add(1 * time.Second),
+		Duration:       1 * time.Second,
+		Waiting:        1 * time.Second,
+		ConnRemoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234},
+		BytesRead:      100,
+	}
+	b := a
+	b.StartTime = a.StartTime.Add(1 * time.Hour)
+	b.EndTime = a.EndTime.Add(1 * time.Hour)
+	b.ConnRemoteAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4321}
+	assert.True(t, EqualIgnoringTime(a, b))
+
+	b.Waiting = 2 * time.Second
+	assert.False(t, EqualIgnoringTime(a, b))
+}
+
+func TestTracerConnReset(t *testing.T) {
+	// Reads the request, then closes the connection: with a 0 linger time, this sends
+	// an RST instead of a FIN.
+	serve := func(linger bool) (string, func()) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				buf := make([]byte, 4096)
+				_, _ = conn.Read(buf)
+				if !linger {
+					_ = conn.(*net.TCPConn).SetLinger(0)
+				}
+				_ = conn.Close()
+			}
+		}()
+		return "http://" + l.Addr().String(), func() { _ = l.Close() }
+	}
+	get := func(url string) Trail {
+		client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		_, err = client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		assert.Error(t, err)
+		return tracer.Done()
+	}
+
+	t.Run("reset", func(t *testing.T) {
+		url, stop := serve(false)
+		defer stop()
+
+		trail := get(url)
+		assert.True(t, trail.ConnReset)
+		assert.True(t, trail.Failed)
+		assert.Equal(t, "conn_reset", trail.ErrorClass)
+
+		seen := false
+		for _, s := range trail.Samples(nil) {
+			if s.Metric == metrics.HTTPConnReset {
+				seen = true
+			}
+		}
+		assert.True(t, seen, "no reset sample emitted")
+	})
+	t.Run("graceful", func(t *testing.T) {
+		url, stop := serve(true)
+		defer stop()
+
+		trail := get(url)
+		assert.False(t, trail.ConnReset)
+		assert.Equal(t, "", trail.ErrorClass)
+		for _, s := range trail.Samples(nil) {
+			assert.NotEqual(t, metrics.HTTPConnReset, s.Metric)
+		}
+	})
+}
+
+func TestTrailSamples(t *testing.T) {
+	has := func(samples []stats.Sample, m *stats.Metric) bool {
+		for _, s := range samples {
+			if s.Metric == m {
+				return true
+			}
+		}
+		return false
+	}
+
+	testdata := map[string]struct {
+		trail     Trail
+		connTimes bool
+	}{
+		"new":            {Trail{}, true},
+		"reused":         {Trail{ConnReused: true}, true},
+		"new,omitted":    {Trail{OmitReusedConnTimings: true}, true},
+		"reused,omitted": {Trail{ConnReused: true, OmitReusedConnTimings: true}, false},
+		"nagle":          {Trail{PossibleNagleDelay: true}, true},
+		"renegotiated":   {Trail{TLSRenegotiated: true}, true},
+		"framing":        {Trail{FramingAnomaly: true}, true},
+		"headers":        {Trail{HeaderAnomaly: true}, true},
+		"rtt":            {Trail{EstimatedRTT: time.Millisecond}, true},
+		"pool lookup":    {Trail{PoolLookup: time.Millisecond}, true},
+		"pre write":      {Trail{PreWrite: time.Millisecond}, true},
+		"connect retry":  {Trail{ConnectRetries: 1}, true},
+		"header bytes":   {Trail{ResponseHeaderBytes: 100}, true},
+		"http2":          {Trail{HTTP2: true}, true},
+		"setup":          {Trail{EmitSetup: true}, true},
+		"setup,omitted":  {Trail{EmitSetup: true, ConnReused: true, OmitReusedConnTimings: true}, false},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			samples := data.trail.Samples(nil)
+			assert.True(t, has(samples, metrics.HTTPReqs))
+			assert.True(t, has(samples, metrics.HTTPReqDuration))
+			assert.True(t, has(samples, metrics.HTTPReqWaiting))
+			assert.True(t, has(samples, metrics.DataSent))
+			assert.Equal(t, data.connTimes, has(samples, metrics.HTTPReqBlocked))
+			assert.Equal(t, data.connTimes, has(samples, metrics.HTTPReqConnecting))
+			assert.Equal(t, data.connTimes && data.trail.EmitSetup, has(samples, metrics.HTTPReqSetup))
+			assert.Equal(t, data.trail.PossibleNagleDelay, has(samples, metrics.HTTPReqNagleDelay))
+			assert.Equal(t, data.trail.TLSRenegotiated, has(samples, metrics.HTTPReqTLSRenegotiated))
+			assert.Equal(t, data.trail.FramingAnomaly, has(samples, metrics.HTTPReqFramingAnomaly))
+			assert.Equal(t, data.trail.HeaderAnomaly, has(samples, metrics.HTTPReqHeaderAnomaly))
+			assert.Equal(t, data.trail.EstimatedRTT > 0, has(samples, metrics.HTTPConnEstimatedRTT))
+			assert.Equal(t, data.trail.PoolLookup > 0, has(samples, metrics.HTTPReqPoolLookup))
+			assert.Equal(t, data.trail.PreWrite > 0, has(samples, metrics.HTTPReqPreWrite))
+			assert.Equal(t, data.trail.HTTP2, has(samples, metrics.HTTPReqStreamBlocked))
+		})
+	}
+}
+
+func TestTrailSamplesPrefix(t *testing.T) {
+	samples := Trail{MetricPrefix: "warmup_", TLSRenegotiated: true}.Samples(nil)
+	assert.NotEmpty(t, samples)
+	for _, s := range samples {
+		assert.True(t, strings.HasPrefix(s.Metric.Name, "warmup_http_") || strings.HasPrefix(s.Metric.Name, "warmup_data_"), s.Metric.Name)
+	}
+	assert.Equal(t, "warmup_http_req_duration", samples[1].Metric.Name)
+	assert.Equal(t, "http_req_duration", Trail{}.Samples(nil)[1].Metric.Name)
+}
+
+func TestTrailSamplesCountsOnly(t *testing.T) {
+	names := func(samples []stats.Sample) (names []string) {
+		for _, s := range samples {
+			names = append(names, s.Metric.Name)
+		}
+		return names
+	}
+	tr := Trail{ConnID: 1, BytesRead: 100, BytesWritten: 10, Duration: time.Second, TLSRenegotiated: true}
+	assert.True(t, len(tr.Samples(nil)) > 5)
+
+	tr.CountsOnly = true
+	samples := tr.Samples(nil)
+	assert.Equal(t, []string{"http_reqs", "data_received", "data_sent", "http_conns_new"}, names(samples))
+	assert.Equal(t, 100.0, samples[1].Value)
+
+	tr.ConnReused, tr.MetricPrefix = true, "warmup_"
+	assert.Equal(t, []string{"warmup_http_reqs", "warmup_data_received", "warmup_data_sent"}, names(tr.Samples(nil)))
+
+	tr.RetryCount = 2
+	assert.Equal(t, []string{"warmup_http_reqs", "warmup_data_received", "warmup_data_sent", "warmup_http_req_retries"}, names(tr.Samples(nil)))
+}
+
+func TestTrailSamplesBaseTags(t *testing.T) {
+	base := map[string]string{"run": "1234", "url": "base"}
+	tags := map[string]string{"url": "http://example.com/"}
+	samples := Trail{BaseTags: base}.Samples(tags)
+
+	seen := map[*stats.Metric]bool{}
+	for _, s := range samples {
+		seen[s.Metric] = true
+		assert.Equal(t, map[string]string{"run": "1234", "url": "http://example.com/"}, s.Tags, s.Metric.Name)
+	}
+	for _, m := range []*stats.Metric{
+		metrics.HTTPReqs, metrics.HTTPReqDuration, metrics.HTTPReqBlocked,
+		metrics.HTTPReqConnecting, metrics.HTTPReqSending, metrics.HTTPReqWaiting,
+		metrics.HTTPReqReceiving, metrics.DataReceived, metrics.DataSent,
+	} {
+		assert.True(t, seen[m], m.Name)
+	}
+	assert.Equal(t, map[string]string{"run": "1234", "url": "base"}, base)
+	assert.Equal(t, map[string]string{"url": "http://example.com/"}, tags)
+
+	assert.Equal(t, tags, Trail{}.Samples(tags)[0].Tags)
+}
+
+func TestTrailSamplesTLSBytes(t *testing.T) {
+	data := func(samples []stats.Sample) map[string]float64 {
+		values := map[string]float64{}
+		for _, s := range samples {
+			if s.Metric == metrics.DataReceived || s.Metric == metrics.DataSent {
+				values[s.Metric.Name+"/"+s.Tags["tls_handshake"]] += s.Value
+			}
+		}
+		return values
+	}
+	tags := map[string]string{"url": "https://example.com/"}
+	tr := Trail{BytesRead: 5000, BytesWritten: 800, TLSBytesRead: 3000, TLSBytesWritten: 500}
+	assert.Equal(t, map[string]float64{
+		"data_received/":     2000,
+		"data_received/true": 3000,
+		"data_sent/":         300,
+		"data_sent/true":     500,
+	}, data(tr.Samples(tags)))
+	assert.Equal(t, map[string]string{"url": "https://example.com/"}, tags)
+
+	tr.CountsOnly = true
+	assert.Len(t, data(tr.Samples(tags)), 4)
+
+	tr = Trail{BytesRead: 5000, BytesWritten: 800}
+	assert.Equal(t, map[string]float64{"data_received/": 5000, "data_sent/": 800}, data(tr.Samples(tags)))
+}
+
+func TestTrailSetup(t *testing.T) {
+	setup := func(tr Trail) float64 {
+		for _, s := range tr.Samples(nil) {
+			if s.Metric == metrics.HTTPReqSetup {
+				return s.Value
+			}
+		}
+		t.Fatal("no http_req_setup sample")
+		return 0
+	}
+
+	tr := Trail{
+		EmitSetup:      true,
+		Blocked:        10 * time.Millisecond,
+		LookingUp:      2 * time.Millisecond,
+		Connecting:     3 * time.Millisecond,
+		TLSHandshaking: 6 * time.Millisecond,
+
+		// Not part of it.
+		ProxyHandshake: 4 * time.Millisecond,
+		TLSQueued:      5 * time.Millisecond,
+	}
+	assert.Equal(t, tr.Blocked+tr.LookingUp+tr.Connecting+tr.TLSHandshaking, tr.Setup())
+	assert.Equal(t, 21.0, setup(tr))
+
+	assert.Equal(t, time.Duration(0), Trail{ConnReused: true}.Setup())
+}
+
+func TestTrailJSON(t *testing.T) {
+	data, err := json.Marshal(Trail{
+		Method:         "POST",
+		URL:            "http://example.com/login",
+		Waiting:        1500 * time.Microsecond,
+		ConnRemoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80},
+		BaseTags:       map[string]string{"tag": "value"},
+		Rounding:       Granularity(time.Millisecond),
+	})
+	assert.NoError(t, err)
+
+	var out map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &out))
+	assert.Equal(t, "POST", out["method"])
+	assert.Equal(t, "http://example.com/login", out["url"])
+	assert.Equal(t, 1.5, out["waiting"])
+	assert.Equal(t, 0.0, out["connecting"])
+	assert.Equal(t, "127.0.0.1:80", out["conn_remote_addr"])
+	assert.NotContains(t, out, "dns_error")
+	assert.NotContains(t, out, "BaseTags")
+	assert.NotContains(t, out, "Rounding")
+	assert.NotContains(t, out, "phase_timestamps")
+
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	data, err = json.Marshal(Trail{PhaseTimestamps: &PhaseTimestamps{GetConn: start, Done: start.Add(time.Second)}})
+	assert.NoError(t, err)
+	var ts struct {
+		PhaseTimestamps map[string]interface{} `json:"phase_timestamps"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &ts))
+	assert.Equal(t, map[string]interface{}{
+		"get_conn": "2017-01-01T00:00:00Z",
+		"done":     "2017-01-01T00:00:01Z",
+	}, ts.PhaseTimestamps)
+}
+
+func TestTracerALPNFallback(t *testing.T) {
+	offered := []string{"h2", "http/1.1"}
+	get := func(srv *httptest.Server) Trail {
+		client := http.Client{Transport: &http.Transport{
+			DialContext:       NewDialer(net.Dialer{}).DialContext,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		}}
+		tracer := &Tracer{OfferedProtocols: offered}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	t.Run("h2", func(t *testing.T) {
+		srv := httptest.NewUnstartedServer(handler)
+		srv.EnableHTTP2 = true
+		srv.StartTLS()
+		defer srv.Close()
+
+		trail := get(srv)
+		assert.Equal(t, "h2", trail.NegotiatedProtocol)
+		assert.False(t, trail.ALPNFallback)
+		assert.True(t, trail.HTTP2)
+	})
+	t.Run("fallback", func(t *testing.T) {
+		srv := httptest.NewTLSServer(handler)
+		defer srv.Close()
+
+		trail := get(srv)
+		assert.NotEqual(t, "h2", trail.NegotiatedProtocol)
+		assert.True(t, trail.ALPNFallback)
+		assert.False(t, trail.HTTP2)
+		assert.True(t, trail.TLSHandshaking > 0)
+		assert.True(t, trail.TLSHandshaking <= trail.Blocked)
+		assert.True(t, trail.TLSHandshaking <= trail.Sending)
+		assert.Equal(t, trail.Blocked+trail.LookingUp+trail.Connecting+trail.TLSHandshaking, trail.Setup())
+		assert.True(t, trail.TLSBytesRead > 0 && trail.TLSBytesRead < trail.BytesRead)
+		assert.True(t, trail.TLSBytesWritten > 0 && trail.TLSBytesWritten < trail.BytesWritten)
+	})
+	t.Run("plain", func(t *testing.T) {
+		srv := httptest.NewServer(handler)
+		defer srv.Close()
+
+		trail := get(srv)
+		assert.Equal(t, "", trail.NegotiatedProtocol)
+		assert.False(t, trail.ALPNFallback)
+		assert.Equal(t, time.Duration(0), trail.TLSHandshaking)
+		assert.Equal(t, int64(0), trail.TLSBytesRead)
+		assert.Equal(t, int64(0), trail.TLSBytesWritten)
+	})
+}
+
+func TestTracerHandshakeLimiter(t *testing.T) {
+	assert.Nil(t, NewHandshakeLimiter(0))
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// Called on the client mid-handshake; tracks how many are in the middle of one.
+	var current, peak int64
+	verify := func(tls.ConnectionState) error {
+		n := atomic.AddInt64(&current, 1)
+		for {
+			p := atomic.LoadInt64(&peak)
+			if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt64(&current, -1)
+		return nil
+	}
+	client := http.Client{Transport: &http.Transport{
+		DialContext:       NewDialer(net.Dialer{}).DialContext,
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true, VerifyConnection: verify},
+		DisableKeepAlives: true,
+	}}
+
+	limiter := NewHandshakeLimiter(1)
+	trails := make([]Trail, 5)
+	var wg sync.WaitGroup
+	for i := range trails {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tracer := &Tracer{Handshakes: limiter}
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			assert.NoError(t, err)
+			res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+			if assert.NoError(t, err) {
+				_ = res.Body.Close()
+			}
+			trails[i] = tracer.Done()
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&peak))
+	var queued time.Duration
+	for _, trail := range trails {
+		assert.True(t, trail.TLSHandshaking >= 20*time.Millisecond, trail.TLSHandshaking)
+		assert.True(t, trail.TLSQueued <= trail.Blocked)
+		queued += trail.TLSQueued
+	}
+	// Each waited for the ones before it; at least 4 handshakes' worth between them.
+	assert.True(t, queued >= 4*20*time.Millisecond, queued)
+}
+
+func TestTracerWaitingHeaders(t *testing.T) {
+	// A server that takes its time with the headers, after sending the status line.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _ = http.ReadRequest(bufio.NewReader(conn))
+				_, _ = conn.Write([]byte("HTTP/1.1 200 OK\r\n"))
+				time.Sleep(50 * time.Millisecond)
+				_, _ = conn.Write([]byte("Content-Length: 2\r\n\r\nok"))
+			}()
+		}
+	}()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+	get := func(url string, gotHeaders bool) Trail {
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			if gotHeaders {
+				tracer.GotHeaders()
+			}
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+
+	t.Run("Slow", func(t *testing.T) {
+		trail := get("http://"+l.Addr().String(), true)
+		assert.True(t, trail.WaitingHeaders >= trail.Waiting+50*time.Millisecond, "%s", trail.WaitingHeaders)
+
+		seen := false
+		for _, s := range trail.Samples(nil) {
+			if s.Metric == metrics.HTTPReqWaitingHeaders {
+				assert.Equal(t, stats.D(trail.WaitingHeaders), s.Value)
+				seen = true
+			}
+		}
+		assert.True(t, seen, "no waiting headers sample emitted")
+	})
+	t.Run("Fast", func(t *testing.T) {
+		trail := get(srv.URL, true)
+		assert.Equal(t, time.Duration(0), trail.WaitingHeaders)
+		for _, s := range trail.Samples(nil) {
+			assert.NotEqual(t, metrics.HTTPReqWaitingHeaders, s.Metric)
+		}
+	})
+	t.Run("NotCalled", func(t *testing.T) {
+		trail := get("http://"+l.Addr().String(), false)
+		assert.Equal(t, time.Duration(0), trail.WaitingHeaders)
+	})
+}
+
+func TestTracerInFlight(t *testing.T) {
+	before := InFlight()
+	var during int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.StoreInt64(&during, InFlight())
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		if d, err := time.ParseDuration(r.URL.Query().Get("sleep")); err == nil {
+			time.Sleep(d)
+		}
+	}))
+	defer srv.Close()
+
+	client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+	do := func(ctx context.Context, url string) error {
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(ctx, tracer)))
+		if err == nil {
+			_ = res.Body.Close()
+		}
+		tracer.Done()
+		return err
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		assert.NoError(t, do(context.Background(), srv.URL))
+		assert.Equal(t, before+1, atomic.LoadInt64(&during))
+		assert.Equal(t, before, InFlight())
+	})
+	t.Run("Redirect", func(t *testing.T) {
+		assert.NoError(t, do(context.Background(), srv.URL+"/redirect"))
+		assert.Equal(t, before+1, atomic.LoadInt64(&during))
+		assert.Equal(t, before, InFlight())
+	})
+	t.Run("Refused", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		addr := l.Addr().String()
+		_ = l.Close()
+		assert.Error(t, do(context.Background(), "http://"+addr))
+		assert.Equal(t, before, InFlight())
+	})
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.Error(t, do(ctx, srv.URL+"/?sleep=200ms"))
+		assert.Equal(t, before, InFlight())
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		assert.Error(t, do(context.Background(), "http://"))
+		assert.Equal(t, before, InFlight())
+	})
+}
+
+func TestTracerEarlyHints(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _ = http.ReadRequest(bufio.NewReader(conn))
+				_, _ = conn.Write([]byte("HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n"))
+				time.Sleep(50 * time.Millisecond)
+				_, _ = conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+			}()
+		}
+	}()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+	get := func(url string) Trail {
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			tracer.GotHeaders()
+			assert.Equal(t, 200, res.StatusCode)
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+
+	t.Run("Sent", func(t *testing.T) {
+		trail := get("http://" + l.Addr().String())
+		assert.True(t, trail.EarlyHints)
+		assert.True(t, trail.EarlyHintsWaiting > 0)
+		assert.True(t, trail.WaitingHeaders >= trail.EarlyHintsWaiting+50*time.Millisecond,
+			"hints: %s, headers: %s", trail.EarlyHintsWaiting, trail.WaitingHeaders)
+
+		seen := false
+		for _, s := range trail.Samples(nil) {
+			if s.Metric == metrics.HTTPReqEarlyHints {
+				assert.Equal(t, stats.D(trail.EarlyHintsWaiting), s.Value)
+				seen = true
+			}
+		}
+		assert.True(t, seen, "no early hints sample emitted")
+	})
+	t.Run("NotSent", func(t *testing.T) {
+		trail := get(srv.URL)
+		assert.False(t, trail.EarlyHints)
+		assert.Equal(t, time.Duration(0), trail.EarlyHintsWaiting)
+		for _, s := range trail.Samples(nil) {
+			assert.NotEqual(t, metrics.HTTPReqEarlyHints, s.Metric)
+		}
+	})
+}
+
+func TestTracerScriptReadDelay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bytes.Repeat([]byte("x"), 100))
+	}))
+	defer srv.Close()
+
+	client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+	get := func(read func(io.Reader)) Trail {
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			tracer.GotHeaders()
+			read(tracer.Body(res.Body))
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+
+	t.Run("eager", func(t *testing.T) {
+		trail := get(func(r io.Reader) { _, _ = ioutil.ReadAll(r) })
+		assert.Equal(t, time.Duration(0), trail.ScriptReadDelay)
+	})
+	t.Run("delayed", func(t *testing.T) {
+		trail := get(func(r io.Reader) {
+			time.Sleep(20 * time.Millisecond)
+			buf := make([]byte, 10)
+			for {
+				if _, err := r.Read(buf); err != nil {
+					break
+				}
+				time.Sleep(2 * time.Millisecond)
+			}
+			// Anything after the end doesn't count.
+			time.Sleep(50 * time.Millisecond)
+		})
+		assert.True(t, trail.ScriptReadDelay >= 30*time.Millisecond, "%s", trail.ScriptReadDelay)
+		assert.True(t, trail.ScriptReadDelay < 70*time.Millisecond, "%s", trail.ScriptReadDelay)
+		assert.True(t, trail.Receiving >= trail.ScriptReadDelay)
+	})
+}
+
+func TestTracerReceiveGap(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stall, _ := time.ParseDuration(r.URL.Query().Get("stall"))
+		for i := 0; i < 3; i++ {
+			if i == 2 {
+				time.Sleep(stall)
+			}
+			_, _ = w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+
+	client := http.Client{Transport: &http.Transport{DialContext: NewDialer(net.Dialer{}).DialContext}}
+	get := func(query string, delay time.Duration) Trail {
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL+"/?"+query, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if assert.NoError(t, err) {
+			tracer.GotHeaders()
+			body := tracer.Body(res.Body)
+			buf := make([]byte, 5)
+			for {
+				if _, err := body.Read(buf); err != nil {
+					break
+				}
+				time.Sleep(delay)
+			}
+			_ = res.Body.Close()
+		}
+		return tracer.Done()
+	}
+
+	t.Run("stall", func(t *testing.T) {
+		trail := get("stall=50ms", 0)
+		assert.True(t, trail.MaxReceiveGap >= 40*time.Millisecond, "%s", trail.MaxReceiveGap)
+		assert.True(t, trail.MeanReceiveGap < trail.MaxReceiveGap)
+		assert.True(t, trail.Receiving >= trail.MaxReceiveGap)
+
+		seen := false
+		for _, s := range trail.Samples(nil) {
+			if s.Metric == metrics.HTTPReqMaxReceiveGap {
+				seen = true
+				assert.Equal(t, stats.D(trail.MaxReceiveGap), s.Value)
+			}
+		}
+		assert.True(t, seen, "no max receive gap sample emitted")
+	})
+	t.Run("slow reader", func(t *testing.T) {
+		// Chunks that were already in when the caller got around to reading them weren't
+		// waited for.
+		trail := get("stall=0", 20*time.Millisecond)
+		assert.True(t, trail.MaxReceiveGap < 10*time.Millisecond, "%s", trail.MaxReceiveGap)
+		assert.True(t, trail.ScriptReadDelay >= 40*time.Millisecond, "%s", trail.ScriptReadDelay)
+	})
+	t.Run("concurrent", func(t *testing.T) {
+		// Eg. a hedged request's loser is done with while its body is still being drained.
+		tracer := &Tracer{}
+		req, err := http.NewRequest("GET", srv.URL+"/?stall=20ms", nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(WithTracer(context.Background(), tracer)))
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer func() { _ = res.Body.Close() }()
+
+		read := make(chan struct{})
+		go func() {
+			tracer.GotHeaders()
+			_, _ = ioutil.ReadAll(tracer.Body(res.Body))
+			close(read)
+		}()
+		for {
+			_ = tracer.Done()
+			select {
+			case <-read:
+				assert.True(t, tracer.Done().MaxReceiveGap > 0)
+				return
+			default:
+				time.Sleep(time.Millisecond)
+			}
+		}
+	})
+}
@@ -24,6 +24,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/loadimpact/k6/lib/netext"
 	"github.com/loadimpact/k6/stats"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/guregu/null.v3"
@@ -66,16 +67,115 @@ func TestOptionsApply(t *testing.T) {
 		assert.True(t, opts.Linger.Valid)
 		assert.True(t, opts.Linger.Bool)
 	})
+	t.Run("InFlightRequests", func(t *testing.T) {
+		opts := Options{}.Apply(Options{InFlightRequests: null.BoolFrom(true)})
+		assert.True(t, opts.InFlightRequests.Valid)
+		assert.True(t, opts.InFlightRequests.Bool)
+	})
+	t.Run("IterationPacing", func(t *testing.T) {
+		opts := Options{}.Apply(Options{IterationPacing: null.StringFrom("5s")})
+		assert.True(t, opts.IterationPacing.Valid)
+		assert.Equal(t, "5s", opts.IterationPacing.String)
+	})
+	t.Run("ArrivalRate", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ArrivalRate: null.IntFrom(50)})
+		assert.True(t, opts.ArrivalRate.Valid)
+		assert.Equal(t, int64(50), opts.ArrivalRate.Int64)
+	})
+	t.Run("OutputBuffer", func(t *testing.T) {
+		opts := Options{}.Apply(Options{OutputBuffer: null.IntFrom(1000)})
+		assert.True(t, opts.OutputBuffer.Valid)
+		assert.Equal(t, int64(1000), opts.OutputBuffer.Int64)
+	})
+	t.Run("OutputBufferOverflow", func(t *testing.T) {
+		opts := Options{}.Apply(Options{OutputBufferOverflow: null.StringFrom("dropOldest")})
+		assert.True(t, opts.OutputBufferOverflow.Valid)
+		assert.Equal(t, "dropOldest", opts.OutputBufferOverflow.String)
+	})
+	t.Run("PhaseCorrelation", func(t *testing.T) {
+		opts := Options{}.Apply(Options{PhaseCorrelation: null.BoolFrom(true)})
+		assert.True(t, opts.PhaseCorrelation.Valid)
+		assert.True(t, opts.PhaseCorrelation.Bool)
+	})
+	t.Run("Availability", func(t *testing.T) {
+		opts := Options{}.Apply(Options{Availability: null.BoolFrom(true)})
+		assert.True(t, opts.Availability.Valid)
+		assert.True(t, opts.Availability.Bool)
+	})
+	t.Run("ConnEfficiency", func(t *testing.T) {
+		weights := stats.EfficiencyWeights{Reuse: 2}
+		opts := Options{}.Apply(Options{ConnEfficiency: &weights})
+		assert.Equal(t, &weights, opts.ConnEfficiency)
+	})
+	t.Run("GroupTrailsBy", func(t *testing.T) {
+		opts := Options{}.Apply(Options{GroupTrailsBy: null.StringFrom("method+status")})
+		assert.True(t, opts.GroupTrailsBy.Valid)
+		assert.Equal(t, "method+status", opts.GroupTrailsBy.String)
+	})
+	t.Run("ConnTimelines", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ConnTimelines: null.StringFrom("conns.jsonl")})
+		assert.True(t, opts.ConnTimelines.Valid)
+		assert.Equal(t, "conns.jsonl", opts.ConnTimelines.String)
+	})
 	t.Run("MaxRedirects", func(t *testing.T) {
 		opts := Options{}.Apply(Options{MaxRedirects: null.IntFrom(12345)})
 		assert.True(t, opts.MaxRedirects.Valid)
 		assert.Equal(t, int64(12345), opts.MaxRedirects.Int64)
 	})
+	t.Run("SizeBuckets", func(t *testing.T) {
+		opts := Options{}.Apply(Options{SizeBuckets: netext.SizeBuckets{1000, 10000}})
+		assert.Equal(t, netext.SizeBuckets{1000, 10000}, opts.SizeBuckets)
+	})
+	t.Run("CacheStatus", func(t *testing.T) {
+		opts := Options{}.Apply(Options{CacheStatus: null.BoolFrom(true)})
+		assert.True(t, opts.CacheStatus.Valid)
+		assert.True(t, opts.CacheStatus.Bool)
+	})
+	t.Run("ResponseTrail", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ResponseTrail: null.BoolFrom(true)})
+		assert.True(t, opts.ResponseTrail.Valid)
+		assert.True(t, opts.ResponseTrail.Bool)
+	})
+	t.Run("PhaseTimestamps", func(t *testing.T) {
+		opts := Options{}.Apply(Options{PhaseTimestamps: null.BoolFrom(true)})
+		assert.True(t, opts.PhaseTimestamps.Valid)
+		assert.True(t, opts.PhaseTimestamps.Bool)
+	})
+	t.Run("DurationPrecision", func(t *testing.T) {
+		opts := Options{}.Apply(Options{DurationPrecision: null.StringFrom("1us")})
+		assert.True(t, opts.DurationPrecision.Valid)
+		assert.Equal(t, "1us", opts.DurationPrecision.String)
+	})
 	t.Run("InsecureSkipTLSVerify", func(t *testing.T) {
 		opts := Options{}.Apply(Options{InsecureSkipTLSVerify: null.BoolFrom(true)})
 		assert.True(t, opts.InsecureSkipTLSVerify.Valid)
 		assert.True(t, opts.InsecureSkipTLSVerify.Bool)
 	})
+	t.Run("SOCKS5Proxy", func(t *testing.T) {
+		opts := Options{}.Apply(Options{SOCKS5Proxy: null.StringFrom("socks5://localhost:1080")})
+		assert.True(t, opts.SOCKS5Proxy.Valid)
+		assert.Equal(t, "socks5://localhost:1080", opts.SOCKS5Proxy.String)
+	})
+	t.Run("DetectFramingAnomalies", func(t *testing.T) {
+		opts := Options{}.Apply(Options{DetectFramingAnomalies: null.BoolFrom(true)})
+		assert.True(t, opts.DetectFramingAnomalies.Valid)
+		assert.True(t, opts.DetectFramingAnomalies.Bool)
+	})
+	t.Run("MeasureHeaderBytes", func(t *testing.T) {
+		opts := Options{}.Apply(Options{MeasureHeaderBytes: null.BoolFrom(true)})
+		assert.True(t, opts.MeasureHeaderBytes.Valid)
+		assert.True(t, opts.MeasureHeaderBytes.Bool)
+	})
+	t.Run("DetectHeaderAnomalies", func(t *testing.T) {
+		opts := Options{}.Apply(Options{DetectHeaderAnomalies: null.BoolFrom(true)})
+		assert.True(t, opts.DetectHeaderAnomalies.Valid)
+		assert.True(t, opts.DetectHeaderAnomalies.Bool)
+	})
+	t.Run("TLSRenegotiation", func(t *testing.T) {
+		opts := Options{}.Apply(Options{TLSRenegotiation: null.BoolFrom(true)})
+		assert.True(t, opts.TLSRenegotiation.Valid)
+		assert.True(t, opts.TLSRenegotiation.Bool)
+	})
 	t.Run("Thresholds", func(t *testing.T) {
 		opts := Options{}.Apply(Options{Thresholds: map[string]stats.Thresholds{
 			"metric": {
@@ -94,4 +194,151 @@ func TestOptionsApply(t *testing.T) {
 		assert.True(t, opts.NoUsageReport.Valid)
 		assert.True(t, opts.NoUsageReport.Bool)
 	})
+	t.Run("MaxDataTransfer", func(t *testing.T) {
+		opts := Options{}.Apply(Options{MaxDataTransfer: null.IntFrom(1 << 20)})
+		assert.True(t, opts.MaxDataTransfer.Valid)
+		assert.Equal(t, int64(1<<20), opts.MaxDataTransfer.Int64)
+	})
+	t.Run("SteadyStateAfter", func(t *testing.T) {
+		opts := Options{}.Apply(Options{SteadyStateAfter: null.StringFrom("30s")})
+		assert.True(t, opts.SteadyStateAfter.Valid)
+		assert.Equal(t, "30s", opts.SteadyStateAfter.String)
+	})
+	t.Run("RuntimeMetrics", func(t *testing.T) {
+		opts := Options{}.Apply(Options{RuntimeMetrics: null.BoolFrom(true)})
+		assert.True(t, opts.RuntimeMetrics.Valid)
+		assert.True(t, opts.RuntimeMetrics.Bool)
+	})
+	t.Run("SchedulerLatency", func(t *testing.T) {
+		opts := Options{}.Apply(Options{SchedulerLatency: null.BoolFrom(true)})
+		assert.True(t, opts.SchedulerLatency.Valid)
+		assert.True(t, opts.SchedulerLatency.Bool)
+	})
+	t.Run("BaselineWarmup", func(t *testing.T) {
+		opts := Options{}.Apply(Options{BaselineWarmup: null.StringFrom("30s")})
+		assert.True(t, opts.BaselineWarmup.Valid)
+		assert.Equal(t, "30s", opts.BaselineWarmup.String)
+	})
+	t.Run("ApdexThreshold", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ApdexThreshold: null.StringFrom("500ms")})
+		assert.True(t, opts.ApdexThreshold.Valid)
+		assert.Equal(t, "500ms", opts.ApdexThreshold.String)
+	})
+	t.Run("OmitReusedConnTimings", func(t *testing.T) {
+		opts := Options{}.Apply(Options{OmitReusedConnTimings: null.BoolFrom(true)})
+		assert.True(t, opts.OmitReusedConnTimings.Valid)
+		assert.True(t, opts.OmitReusedConnTimings.Bool)
+	})
+	t.Run("SetupTiming", func(t *testing.T) {
+		opts := Options{}.Apply(Options{SetupTiming: null.BoolFrom(true)})
+		assert.True(t, opts.SetupTiming.Valid)
+		assert.True(t, opts.SetupTiming.Bool)
+	})
+	t.Run("DetailOnlyFailed", func(t *testing.T) {
+		opts := Options{}.Apply(Options{DetailOnlyFailed: null.BoolFrom(true)})
+		assert.True(t, opts.DetailOnlyFailed.Valid)
+		assert.True(t, opts.DetailOnlyFailed.Bool)
+	})
+	t.Run("DetectPMTUDBlackholes", func(t *testing.T) {
+		opts := Options{}.Apply(Options{DetectPMTUDBlackholes: null.BoolFrom(true)})
+		assert.True(t, opts.DetectPMTUDBlackholes.Valid)
+		assert.True(t, opts.DetectPMTUDBlackholes.Bool)
+	})
+	t.Run("TraceContext", func(t *testing.T) {
+		opts := Options{}.Apply(Options{TraceContext: null.BoolFrom(true)})
+		assert.True(t, opts.TraceContext.Valid)
+		assert.True(t, opts.TraceContext.Bool)
+	})
+	t.Run("SocketQueues", func(t *testing.T) {
+		opts := Options{}.Apply(Options{SocketQueues: null.BoolFrom(true)})
+		assert.True(t, opts.SocketQueues.Valid)
+		assert.True(t, opts.SocketQueues.Bool)
+	})
+	t.Run("PoolLookup", func(t *testing.T) {
+		opts := Options{}.Apply(Options{PoolLookup: null.BoolFrom(true)})
+		assert.True(t, opts.PoolLookup.Valid)
+		assert.True(t, opts.PoolLookup.Bool)
+	})
+	t.Run("PreWrite", func(t *testing.T) {
+		opts := Options{}.Apply(Options{PreWrite: null.BoolFrom(true)})
+		assert.True(t, opts.PreWrite.Valid)
+		assert.True(t, opts.PreWrite.Bool)
+	})
+	t.Run("ResponseHeaderTags", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ResponseHeaderTags: map[string]string{"X-Served-By": "backend"}})
+		assert.Equal(t, map[string]string{"X-Served-By": "backend"}, opts.ResponseHeaderTags)
+	})
+	t.Run("BaseTags", func(t *testing.T) {
+		opts := Options{}.Apply(Options{BaseTags: map[string]string{"run": "1234"}})
+		assert.Equal(t, map[string]string{"run": "1234"}, opts.BaseTags)
+	})
+	t.Run("InstanceID", func(t *testing.T) {
+		opts := Options{}.Apply(Options{InstanceID: null.StringFrom("gen-1")})
+		assert.True(t, opts.InstanceID.Valid)
+		assert.Equal(t, "gen-1", opts.InstanceID.String)
+	})
+	t.Run("TagWhitelist", func(t *testing.T) {
+		opts := Options{}.Apply(Options{TagWhitelist: []string{"method", "status"}})
+		assert.Equal(t, []string{"method", "status"}, opts.TagWhitelist)
+	})
+	t.Run("NormalizeURLTags", func(t *testing.T) {
+		opts := Options{}.Apply(Options{NormalizeURLTags: null.BoolFrom(true)})
+		assert.True(t, opts.NormalizeURLTags.Valid)
+		assert.True(t, opts.NormalizeURLTags.Bool)
+	})
+	t.Run("TCPFastOpen", func(t *testing.T) {
+		opts := Options{}.Apply(Options{TCPFastOpen: null.BoolFrom(true)})
+		assert.True(t, opts.TCPFastOpen.Valid)
+		assert.True(t, opts.TCPFastOpen.Bool)
+	})
+	t.Run("HTTP3", func(t *testing.T) {
+		opts := Options{}.Apply(Options{HTTP3: null.BoolFrom(true)})
+		assert.True(t, opts.HTTP3.Valid)
+		assert.True(t, opts.HTTP3.Bool)
+	})
+	t.Run("TCPNoDelay", func(t *testing.T) {
+		opts := Options{}.Apply(Options{TCPNoDelay: null.BoolFrom(false)})
+		assert.True(t, opts.TCPNoDelay.Valid)
+		assert.False(t, opts.TCPNoDelay.Bool)
+	})
+	t.Run("HostOverrides", func(t *testing.T) {
+		opts := Options{}.Apply(Options{HostOverrides: map[string]string{"staging.example.com": "127.0.0.1"}})
+		assert.Equal(t, map[string]string{"staging.example.com": "127.0.0.1"}, opts.HostOverrides)
+	})
+	t.Run("MaxOpenConns", func(t *testing.T) {
+		opts := Options{}.Apply(Options{MaxOpenConns: null.IntFrom(100)})
+		assert.True(t, opts.MaxOpenConns.Valid)
+		assert.Equal(t, int64(100), opts.MaxOpenConns.Int64)
+	})
+	t.Run("ConnectRetries", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ConnectRetries: null.IntFrom(3)})
+		assert.True(t, opts.ConnectRetries.Valid)
+		assert.Equal(t, int64(3), opts.ConnectRetries.Int64)
+	})
+	t.Run("ConnectRetryBackoff", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ConnectRetryBackoff: null.StringFrom("100ms")})
+		assert.True(t, opts.ConnectRetryBackoff.Valid)
+		assert.Equal(t, "100ms", opts.ConnectRetryBackoff.String)
+	})
+	t.Run("MaxTLSHandshakes", func(t *testing.T) {
+		opts := Options{}.Apply(Options{MaxTLSHandshakes: null.IntFrom(8)})
+		assert.True(t, opts.MaxTLSHandshakes.Valid)
+		assert.Equal(t, int64(8), opts.MaxTLSHandshakes.Int64)
+	})
+	t.Run("ServerClockSkew", func(t *testing.T) {
+		opts := Options{}.Apply(Options{ServerClockSkew: null.BoolFrom(true)})
+		assert.True(t, opts.ServerClockSkew.Valid)
+		assert.True(t, opts.ServerClockSkew.Bool)
+	})
+}
+
+func TestOptionsSampleBaseTags(t *testing.T) {
+	base := map[string]string{"run": "1234"}
+	assert.Equal(t, base, Options{BaseTags: base}.SampleBaseTags())
+	assert.Nil(t, Options{}.SampleBaseTags())
+
+	tags := Options{BaseTags: base, InstanceID: null.StringFrom("gen-1")}.SampleBaseTags()
+	assert.Equal(t, map[string]string{"run": "1234", "instance_id": "gen-1"}, tags)
+	assert.Equal(t, map[string]string{"run": "1234"}, base, "modified the original")
+	assert.Equal(t, map[string]string{"instance_id": "gen-1"}, Options{InstanceID: null.StringFrom("gen-1")}.SampleBaseTags())
 }
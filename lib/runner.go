@@ -23,6 +23,7 @@ package lib
 import (
 	"context"
 
+	"github.com/loadimpact/k6/lib/netext"
 	"github.com/loadimpact/k6/stats"
 )
 
@@ -41,8 +42,33 @@ type Runner interface {
 	// Returns the option set.
 	GetOptions() Options
 
-	// Applies a set of options.
-	ApplyOptions(opts Options)
+	// Applies a set of options; errors if they're invalid in a way that must not be ignored.
+	ApplyOptions(opts Options) error
+}
+
+// A SummarySampler is a Runner that has samples of its own to contribute once a test has
+// finished, eg. statistics that are only meaningful for the test as a whole.
+type SummarySampler interface {
+	SummarySamples() []stats.Sample
+}
+
+// A TrailSource is a Runner whose HTTP requests' Trails can be subscribed to.
+type TrailSource interface {
+	TrailStream() *netext.TrailStream
+}
+
+// A MetricReader reads metrics' aggregates while a test is running, eg. for a script to
+// back off if latency goes up; an Engine is one.
+type MetricReader interface {
+	// Returns the named metric's current aggregates, as its Sink formats them (eg. "p95"
+	// for a trend); false if nothing's been recorded to it yet.
+	MetricValues(name string) (map[string]float64, bool)
+}
+
+// A MetricConsumer is a Runner that wants to read metrics while a test is running; the
+// Engine gives it itself to read them from.
+type MetricConsumer interface {
+	SetMetricReader(r MetricReader)
 }
 
 // A VU is a Virtual User.
@@ -75,7 +101,8 @@ func (fn RunnerFunc) GetOptions() Options {
 	return Options{}
 }
 
-func (fn RunnerFunc) ApplyOptions(opts Options) {
+func (fn RunnerFunc) ApplyOptions(opts Options) error {
+	return nil
 }
 
 type RunnerFuncVU struct {
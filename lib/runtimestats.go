@@ -0,0 +1,107 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lib
+
+import (
+	"math"
+	rtmetrics "runtime/metrics"
+	"sync"
+	"time"
+
+	"github.com/loadimpact/k6/lib/metrics"
+	"github.com/loadimpact/k6/stats"
+)
+
+// What a runtimeSampler reads; see the runtime/metrics docs.
+const (
+	runtimeGoroutines = "/sched/goroutines:goroutines"
+	runtimeHeapInUse  = "/memory/classes/heap/objects:bytes"
+	runtimeGCPauses   = "/gc/pauses:seconds"
+)
+
+// A runtimeSampler reads the load generator's own goroutine count, heap in use and GC
+// pauses from runtime/metrics, which (unlike runtime.ReadMemStats) doesn't stop the world
+// to do so. GC pauses come as a histogram of all of them so far, so it remembers the last
+// one it saw, to tell which are new.
+type runtimeSampler struct {
+	lock       sync.Mutex
+	samples    []rtmetrics.Sample
+	lastPauses []uint64
+}
+
+func newRuntimeSampler() *runtimeSampler {
+	return &runtimeSampler{samples: []rtmetrics.Sample{
+		{Name: runtimeGoroutines},
+		{Name: runtimeHeapInUse},
+		{Name: runtimeGCPauses},
+	}}
+}
+
+// Samples returns gauges of the goroutine count and heap in use, and of the longest GC
+// pause since the last call, if there's been any; metrics the runtime doesn't have (they
+// do get renamed) are left out.
+func (s *runtimeSampler) Samples(t time.Time) []stats.Sample {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	rtmetrics.Read(s.samples)
+	var samples []stats.Sample
+	for _, sample := range s.samples {
+		switch sample.Value.Kind() {
+		case rtmetrics.KindUint64:
+			m := metrics.Goroutines
+			if sample.Name == runtimeHeapInUse {
+				m = metrics.HeapInUse
+			}
+			samples = append(samples, stats.Sample{Time: t, Metric: m, Value: float64(sample.Value.Uint64())})
+		case rtmetrics.KindFloat64Histogram:
+			if pause, ok := s.longestPause(sample.Value.Float64Histogram()); ok {
+				samples = append(samples, stats.Sample{Time: t, Metric: metrics.GCPause, Value: stats.D(pause)})
+			}
+		}
+	}
+	return samples
+}
+
+// Returns the upper bound of the highest bucket that's gained pauses since last time, which
+// is as close to the longest of them as the histogram gets; or the lower one, if that's +Inf.
+func (s *runtimeSampler) longestPause(h *rtmetrics.Float64Histogram) (time.Duration, bool) {
+	longest := -1
+	for i, n := range h.Counts {
+		var last uint64
+		if i < len(s.lastPauses) {
+			last = s.lastPauses[i]
+		}
+		if n > last {
+			longest = i
+		}
+	}
+	s.lastPauses = append(s.lastPauses[:0], h.Counts...)
+	if longest < 0 {
+		return 0, false
+	}
+
+	seconds := h.Buckets[longest+1]
+	if math.IsInf(seconds, 1) {
+		seconds = h.Buckets[longest]
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
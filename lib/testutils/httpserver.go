@@ -0,0 +1,217 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package testutils has helpers for tests that need something to make requests against.
+package testutils
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// An HTTPServer builds an httptest.Server whose endpoints misbehave in configurable ways,
+// for deterministic tests of how requests are timed and how they fail:
+//
+//	srv := testutils.NewHTTPServer().
+//		Route("/slow").Delay(100 * time.Millisecond).
+//		Route("/dribble").Body("hello, world").Dribble(1, 10*time.Millisecond).
+//		Route("/reset").Reset().
+//		StartTLS()
+//	defer srv.Close()
+//
+// Paths that weren't routed are 404s. Don't change its routes once it's started.
+type HTTPServer struct {
+	lock   sync.RWMutex
+	routes map[string]*Route
+
+	http2     bool
+	tlsConfig *tls.Config
+}
+
+// NewHTTPServer returns an HTTPServer with no routes.
+func NewHTTPServer() *HTTPServer {
+	return &HTTPServer{routes: make(map[string]*Route)}
+}
+
+// Route adds an endpoint at path, which answers with an empty 200 until told otherwise; or
+// returns the one that's already there. The Route's own methods then configure it.
+func (s *HTTPServer) Route(path string) *Route {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if r, ok := s.routes[path]; ok {
+		return r
+	}
+	r := &Route{HTTPServer: s, status: http.StatusOK, header: make(http.Header)}
+	s.routes[path] = r
+	return r
+}
+
+// HTTP2 lets clients negotiate HTTP/2, which only happens over TLS.
+func (s *HTTPServer) HTTP2() *HTTPServer {
+	s.http2 = true
+	return s
+}
+
+// TLSConfig sets what StartTLS's listener is configured with, eg. a MaxVersion; it gets
+// the certificate of httptest's own unless it has one.
+func (s *HTTPServer) TLSConfig(config *tls.Config) *HTTPServer {
+	s.tlsConfig = config
+	return s
+}
+
+// Start starts the server on plain HTTP.
+func (s *HTTPServer) Start() *httptest.Server {
+	srv := httptest.NewUnstartedServer(s)
+	srv.Start()
+	return srv
+}
+
+// StartTLS starts the server on HTTPS, with a self-signed certificate; see Client on
+// httptest.Server for one that trusts it, or use InsecureSkipVerify.
+func (s *HTTPServer) StartTLS() *httptest.Server {
+	srv := httptest.NewUnstartedServer(s)
+	srv.EnableHTTP2 = s.http2
+	if s.tlsConfig != nil {
+		srv.TLS = s.tlsConfig.Clone()
+	}
+	srv.StartTLS()
+	return srv
+}
+
+func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.lock.RLock()
+	r, ok := s.routes[req.URL.Path]
+	s.lock.RUnlock()
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	r.serve(w, req)
+}
+
+// A Route is an endpoint of an HTTPServer. Its methods return it, to chain configuring
+// it; and it embeds its server, so that the chain can go on to the next one.
+type Route struct {
+	*HTTPServer
+
+	status int
+	header http.Header
+	body   []byte
+
+	delay       time.Duration
+	chunkSize   int
+	chunkPeriod time.Duration
+	reset       bool
+}
+
+// Status sets the response's status code.
+func (r *Route) Status(code int) *Route {
+	r.status = code
+	return r
+}
+
+// Header adds a header to the response; it can be given more than once.
+func (r *Route) Header(key, value string) *Route {
+	r.header.Add(key, value)
+	return r
+}
+
+// Body sets the response's body.
+func (r *Route) Body(body string) *Route {
+	r.body = []byte(body)
+	return r
+}
+
+// Delay waits this long after reading the request before answering it, as if processing
+// it; it'll show up as time spent Waiting.
+func (r *Route) Delay(d time.Duration) *Route {
+	r.delay = d
+	return r
+}
+
+// Dribble sends the body size bytes at a time, period apart, instead of all at once; it's
+// sent chunked, so that each one goes out as soon as it's written, and it'll show up as
+// time spent Receiving.
+func (r *Route) Dribble(size int, period time.Duration) *Route {
+	r.chunkSize, r.chunkPeriod = size, period
+	return r
+}
+
+// Reset resets the connection (with a TCP RST) once it's read the request, after any Delay,
+// instead of answering it. Only works on HTTP/1.x, where the connection can be hijacked.
+func (r *Route) Reset() *Route {
+	r.reset = true
+	return r
+}
+
+func (r *Route) serve(w http.ResponseWriter, req *http.Request) {
+	if r.delay > 0 {
+		time.Sleep(r.delay)
+	}
+
+	if r.reset {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "can't reset an HTTP/2 connection", http.StatusInternalServerError)
+			return
+		}
+		conn, _, err := hj.Hijack()
+		if err != nil {
+			return
+		}
+		// With a 0 linger time, closing sends an RST instead of a FIN.
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			_ = tcp.SetLinger(0)
+		}
+		_ = conn.Close()
+		return
+	}
+
+	for k, vs := range r.header {
+		w.Header()[k] = vs
+	}
+	w.WriteHeader(r.status)
+	if r.chunkSize <= 0 {
+		_, _ = w.Write(r.body)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	for body := r.body; len(body) > 0; {
+		n := r.chunkSize
+		if n > len(body) {
+			n = len(body)
+		}
+		if _, err := w.Write(body[:n]); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if body = body[n:]; len(body) > 0 {
+			time.Sleep(r.chunkPeriod)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package testutils
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/lib/netext"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPServer(t *testing.T) {
+	server := NewHTTPServer().
+		Route("/ok").Status(http.StatusCreated).Header("X-Test", "1").Body("hello").
+		Route("/slow").Delay(50*time.Millisecond).
+		Route("/dribble").Body("abcde").Dribble(1, 10*time.Millisecond).
+		Route("/reset").Reset().
+		HTTP2()
+
+	get := func(client *http.Client, url string) (*http.Response, string, netext.Trail) {
+		tracer := &netext.Tracer{}
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req.WithContext(netext.WithTracer(context.Background(), tracer)))
+		var body []byte
+		if err == nil {
+			tracer.GotHeaders()
+			body, err = ioutil.ReadAll(tracer.Body(res.Body))
+			assert.NoError(t, err)
+			_ = res.Body.Close()
+		}
+		return res, string(body), tracer.Done()
+	}
+
+	t.Run("plain", func(t *testing.T) {
+		srv := server.Start()
+		defer srv.Close()
+		client := &http.Client{Transport: &http.Transport{DialContext: netext.NewDialer(net.Dialer{}).DialContext}}
+
+		res, body, _ := get(client, srv.URL+"/ok")
+		if assert.NotNil(t, res) {
+			assert.Equal(t, http.StatusCreated, res.StatusCode)
+			assert.Equal(t, "1", res.Header.Get("X-Test"))
+			assert.Equal(t, "hello", body)
+		}
+
+		res, _, _ = get(client, srv.URL+"/missing")
+		if assert.NotNil(t, res) {
+			assert.Equal(t, http.StatusNotFound, res.StatusCode)
+		}
+
+		// The delay starts once the server's read the request, which can be before the
+		// WroteRequest hook gets to run; it's only sure to be within Sending and Waiting.
+		_, _, trail := get(client, srv.URL+"/slow")
+		assert.True(t, trail.Sending+trail.Waiting >= 50*time.Millisecond, "sending: %s, waiting: %s", trail.Sending, trail.Waiting)
+
+		_, body, trail = get(client, srv.URL+"/dribble")
+		assert.Equal(t, "abcde", body)
+		assert.True(t, trail.Receiving >= 40*time.Millisecond, "receiving: %s", trail.Receiving)
+
+		res, _, trail = get(client, srv.URL+"/reset")
+		assert.Nil(t, res)
+		assert.True(t, trail.ConnReset)
+	})
+	t.Run("tls", func(t *testing.T) {
+		srv := server.TLSConfig(&tls.Config{MaxVersion: tls.VersionTLS12}).StartTLS()
+		defer srv.Close()
+		client := &http.Client{Transport: &http.Transport{
+			DialContext:       netext.NewDialer(net.Dialer{}).DialContext,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		}}
+
+		res, body, trail := get(client, srv.URL+"/ok")
+		if assert.NotNil(t, res) {
+			assert.Equal(t, "hello", body)
+			assert.Equal(t, 2, res.ProtoMajor)
+			assert.Equal(t, uint16(tls.VersionTLS12), res.TLS.Version)
+		}
+		assert.True(t, trail.TLSHandshaking > 0)
+		assert.Equal(t, "h2", trail.NegotiatedProtocol)
+	})
+}
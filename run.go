@@ -45,11 +45,16 @@ import (
 	"github.com/loadimpact/k6/api"
 	"github.com/loadimpact/k6/js"
 	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/lib/metrics"
 	"github.com/loadimpact/k6/loader"
 	"github.com/loadimpact/k6/simple"
 	"github.com/loadimpact/k6/stats"
+	"github.com/loadimpact/k6/stats/grpc"
 	"github.com/loadimpact/k6/stats/influxdb"
 	"github.com/loadimpact/k6/stats/json"
+	"github.com/loadimpact/k6/stats/otel"
+	"github.com/loadimpact/k6/stats/sqlite"
+	"github.com/loadimpact/k6/stats/statsd"
 	"github.com/loadimpact/k6/ui"
 	"github.com/spf13/afero"
 	"gopkg.in/guregu/null.v3"
@@ -71,7 +76,7 @@ var commandRun = cli.Command{
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "quiet, q",
-			Usage: "hide the progress bar",
+			Usage: "hide the progress bar, estimate trend percentiles in bounded memory",
 		},
 		cli.Int64Flag{
 			Name:  "vus, u",
@@ -237,6 +242,14 @@ func makeCollector(s string, src *lib.SourceData, opts lib.Options) (lib.Collect
 		return influxdb.New(p, opts)
 	case "json":
 		return json.New(p, afero.NewOsFs(), opts)
+	case "sqlite":
+		return sqlite.New(p, opts)
+	case "statsd":
+		return statsd.New(p, opts)
+	case "grpc":
+		return grpc.New(p, opts)
+	case "otel":
+		return otel.New(p, opts)
 	default:
 		return nil, errors.New("Unknown output type: " + t)
 	}
@@ -341,7 +354,10 @@ func actionRun(cc *cli.Context) error {
 	}
 
 	// Update the runner's options.
-	runner.ApplyOptions(opts)
+	if err := runner.ApplyOptions(opts); err != nil {
+		log.WithError(err).Error("Invalid options")
+		return err
+	}
 
 	// Make the metric collector, if requested.
 	var collector lib.Collector
@@ -388,6 +404,7 @@ func actionRun(cc *cli.Context) error {
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	engine.Collector = collector
+	engine.ApproxTrends = quiet
 
 	// Send usage report, if we're allowed to
 	if opts.NoUsageReport.Valid && !opts.NoUsageReport.Bool {
@@ -606,6 +623,22 @@ loop:
 			color.New(color.Faint).Sprint(namePadding+":"),
 			val,
 		)
+
+		// A multimodal duration makes the aggregates above misleading; point it out.
+		if ts, ok := m.Sink.(*stats.TrendSink); ok && m.Name == metrics.HTTPReqDuration.Name {
+			var modality stats.ModalitySink
+			for _, v := range ts.Values {
+				modality.Add(stats.Sample{Value: v})
+			}
+			if modes := modality.Modes(); len(modes) > 1 {
+				centers := make([]string, len(modes))
+				for i, v := range modes {
+					centers[i] = "~" + m.HumanizeValue(v)
+				}
+				fmt.Fprintf(color.Output, "      %s\n",
+					color.New(color.Faint).Sprintf("(multimodal, modes at %s)", strings.Join(centers, ", ")))
+			}
+		}
 	}
 
 	if opts.Linger.Bool {
@@ -74,7 +74,6 @@ func (r *Runner) NewVU() (lib.VU, error) {
 		Client: &http.Client{
 			Transport: r.Transport,
 		},
-		tracer: &netext.Tracer{},
 	}, nil
 }
 
@@ -86,9 +85,10 @@ func (r Runner) GetOptions() lib.Options {
 	return r.Options
 }
 
-func (r *Runner) ApplyOptions(opts lib.Options) {
+func (r *Runner) ApplyOptions(opts lib.Options) error {
 	r.Options = r.Options.Apply(opts)
 	r.Transport.TLSClientConfig.InsecureSkipVerify = opts.InsecureSkipTLSVerify.Bool
+	return nil
 }
 
 type VU struct {
@@ -99,8 +99,6 @@ type VU struct {
 	URLString string
 	Request   *http.Request
 	Client    *http.Client
-
-	tracer *netext.Tracer
 }
 
 func (u *VU) RunOnce(ctx context.Context) ([]stats.Sample, error) {
@@ -109,20 +107,22 @@ func (u *VU) RunOnce(ctx context.Context) ([]stats.Sample, error) {
 		"status": "0",
 		"method": "GET",
 		"url":    u.URLString,
+		"name":   u.URLString,
 	}
 
-	resp, err := u.Client.Do(u.Request.WithContext(netext.WithTracer(ctx, u.tracer)))
+	tracer := &netext.Tracer{}
+	resp, err := u.Client.Do(u.Request.WithContext(netext.WithTracer(ctx, tracer)))
 	if err != nil {
-		return u.tracer.Done().Samples(tags), err
+		return tracer.Done().Samples(tags), err
 	}
 	tags["status"] = strconv.Itoa(resp.StatusCode)
 
 	if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
-		return u.tracer.Done().Samples(tags), err
+		return tracer.Done().Samples(tags), err
 	}
 	_ = resp.Body.Close()
 
-	return u.tracer.Done().Samples(tags), nil
+	return tracer.Done().Samples(tags), nil
 }
 
 func (u *VU) Reconfigure(id int64) error {
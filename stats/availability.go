@@ -0,0 +1,98 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// An AvailabilityAggregator keeps the fraction of requests that succeeded, per group
+// (eg. per host), over the whole test: a single, SLO-friendly number for each backend.
+// What counts as success is up to the caller, eg. netext.IsExpectedResponse.
+// It's safe for concurrent use.
+type AvailabilityAggregator struct {
+	// A Rate, that every request gets a sample of; tagged with Tag, set to its group.
+	Metric *Metric
+	Tag    string
+
+	groups map[string]*RateSink
+	lock   sync.Mutex
+}
+
+func NewAvailabilityAggregator(m *Metric, tag string) *AvailabilityAggregator {
+	return &AvailabilityAggregator{
+		Metric: m,
+		Tag:    tag,
+		groups: make(map[string]*RateSink),
+	}
+}
+
+// Add records whether a request in the group succeeded, and returns a sample of Metric
+// for it: 1 if it did, 0 if not.
+func (a *AvailabilityAggregator) Add(group string, ok bool, t time.Time) Sample {
+	s := Sample{Metric: a.Metric, Time: t, Tags: map[string]string{a.Tag: group}}
+	if ok {
+		s.Value = 1
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	sink, found := a.groups[group]
+	if !found {
+		sink = &RateSink{}
+		a.groups[group] = sink
+	}
+	sink.Add(s)
+	return s
+}
+
+// Availability returns the fraction of each group's requests that succeeded.
+func (a *AvailabilityAggregator) Availability() map[string]float64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	availability := make(map[string]float64, len(a.groups))
+	for group, sink := range a.groups {
+		availability[group] = sink.Format()["rate"]
+	}
+	return availability
+}
+
+// Samples returns a gauge of each group's availability, named after Metric, Tag and the
+// group, eg. "http_availability{host:example.com}"; sorted by group.
+func (a *AvailabilityAggregator) Samples(t time.Time) []Sample {
+	availability := a.Availability()
+	groups := make([]string, 0, len(availability))
+	for group := range availability {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	samples := make([]Sample, 0, len(groups))
+	for _, group := range groups {
+		m := New(a.Metric.Name+"{"+a.Tag+":"+group+"}", Gauge)
+		samples = append(samples, Sample{Metric: m, Time: t, Value: availability[group]})
+	}
+	return samples
+}
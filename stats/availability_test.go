@@ -0,0 +1,77 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAvailabilityAggregator(t *testing.T) {
+	m := New("http_availability", Rate)
+	now := time.Now()
+
+	t.Run("empty", func(t *testing.T) {
+		a := NewAvailabilityAggregator(m, "host")
+		assert.Empty(t, a.Availability())
+		assert.Empty(t, a.Samples(now))
+	})
+	t.Run("mixed", func(t *testing.T) {
+		a := NewAvailabilityAggregator(m, "host")
+		for i := 0; i < 10; i++ {
+			a.Add("up.example.com", true, now)
+			a.Add("flaky.example.com", i%4 != 0, now)
+			a.Add("down.example.com", false, now)
+		}
+		assert.Equal(t, map[string]float64{
+			"up.example.com":    1,
+			"flaky.example.com": 0.7,
+			"down.example.com":  0,
+		}, a.Availability())
+
+		samples := a.Samples(now)
+		if assert.Len(t, samples, 3) {
+			assert.Equal(t, "http_availability{host:down.example.com}", samples[0].Metric.Name)
+			assert.Equal(t, 0.0, samples[0].Value)
+			assert.Equal(t, "http_availability{host:flaky.example.com}", samples[1].Metric.Name)
+			assert.Equal(t, 0.7, samples[1].Value)
+			assert.Equal(t, "http_availability{host:up.example.com}", samples[2].Metric.Name)
+			assert.Equal(t, 1.0, samples[2].Value)
+			for _, s := range samples {
+				assert.Equal(t, Gauge, s.Metric.Type)
+				assert.Equal(t, now, s.Time)
+			}
+		}
+	})
+	t.Run("sample", func(t *testing.T) {
+		a := NewAvailabilityAggregator(m, "host")
+		s := a.Add("example.com", true, now)
+		assert.Equal(t, m, s.Metric)
+		assert.Equal(t, 1.0, s.Value)
+		assert.Equal(t, map[string]string{"host": "example.com"}, s.Tags)
+
+		s = a.Add("example.com", false, now)
+		assert.Equal(t, 0.0, s.Value)
+		assert.Equal(t, map[string]float64{"example.com": 0.5}, a.Availability())
+	})
+}
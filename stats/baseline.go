@@ -0,0 +1,99 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+type baseline struct {
+	start time.Time
+	sum   float64
+	count int64
+}
+
+// A BaselineAggregator learns a baseline for each key (eg. a host) from the samples it
+// sees during the first Warmup after that key's first sample: their mean. After that,
+// every sample yields another, of Metric, with its relative deviation from the
+// baseline: 0.5 for a sample 50% above it, -0.2 for 20% below it, etc. This makes
+// regressions comparable between keys with very different absolute values.
+// It's safe for concurrent use.
+type BaselineAggregator struct {
+	Warmup time.Duration
+	Metric *Metric
+
+	baselines map[string]*baseline
+	lock      sync.Mutex
+}
+
+func NewBaselineAggregator(warmup time.Duration, m *Metric) *BaselineAggregator {
+	return &BaselineAggregator{
+		Warmup:    warmup,
+		Metric:    m,
+		baselines: make(map[string]*baseline),
+	}
+}
+
+// Add feeds a sample into key's baseline, or, once that's been learned, returns its
+// deviation from it. The returned bool is false while warming up.
+func (a *BaselineAggregator) Add(key string, s Sample) (Sample, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	b, ok := a.baselines[key]
+	if !ok {
+		b = &baseline{start: s.Time}
+		a.baselines[key] = b
+	}
+	if s.Time.Sub(b.start) < a.Warmup {
+		b.sum += s.Value
+		b.count++
+		return Sample{}, false
+	}
+
+	base, ok := b.value()
+	if !ok {
+		return Sample{}, false
+	}
+	return Sample{Metric: a.Metric, Time: s.Time, Tags: s.Tags, Value: s.Value/base - 1}, true
+}
+
+// Baseline returns key's baseline, and whether there is one yet. Until its warmup is
+// over, this is the mean of the samples seen so far.
+func (a *BaselineAggregator) Baseline(key string) (float64, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	b, ok := a.baselines[key]
+	if !ok {
+		return 0, false
+	}
+	return b.value()
+}
+
+// A baseline of zero can't be deviated from relatively, so it counts as none.
+func (b *baseline) value() (float64, bool) {
+	if b.count == 0 || b.sum == 0 {
+		return 0, false
+	}
+	return b.sum / float64(b.count), true
+}
@@ -0,0 +1,93 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBaselineAggregator(t *testing.T) {
+	m := New("test_deviation", Trend)
+	start := time.Now()
+	at := func(d time.Duration, v float64) Sample {
+		return Sample{Time: start.Add(d), Value: v, Tags: map[string]string{"url": "a"}}
+	}
+
+	t.Run("warmup", func(t *testing.T) {
+		a := NewBaselineAggregator(10*time.Second, m)
+		_, ok := a.Baseline("a.example.com")
+		assert.False(t, ok)
+
+		for i, v := range []float64{100, 200, 300} {
+			_, ok := a.Add("a.example.com", at(time.Duration(i)*time.Second, v))
+			assert.False(t, ok)
+		}
+		base, ok := a.Baseline("a.example.com")
+		assert.True(t, ok)
+		assert.Equal(t, 200.0, base)
+	})
+	t.Run("deviation", func(t *testing.T) {
+		a := NewBaselineAggregator(10*time.Second, m)
+		a.Add("a.example.com", at(0, 100))
+		a.Add("a.example.com", at(9999*time.Millisecond, 300))
+
+		// The warmup window is half-open; this is the first sample after it.
+		s, ok := a.Add("a.example.com", at(10*time.Second, 300))
+		if assert.True(t, ok) {
+			assert.Equal(t, m, s.Metric)
+			assert.Equal(t, 0.5, s.Value)
+			assert.Equal(t, "a", s.Tags["url"])
+		}
+		s, ok = a.Add("a.example.com", at(time.Minute, 100))
+		if assert.True(t, ok) {
+			assert.Equal(t, -0.5, s.Value)
+		}
+
+		// The baseline is frozen after the warmup.
+		base, _ := a.Baseline("a.example.com")
+		assert.Equal(t, 200.0, base)
+	})
+	t.Run("keys", func(t *testing.T) {
+		a := NewBaselineAggregator(10*time.Second, m)
+		a.Add("a.example.com", at(0, 100))
+		a.Add("b.example.com", at(5*time.Second, 1000))
+
+		// b's warmup started later, so it's not over yet.
+		_, ok := a.Add("b.example.com", at(12*time.Second, 1000))
+		assert.False(t, ok)
+		s, ok := a.Add("a.example.com", at(12*time.Second, 100))
+		if assert.True(t, ok) {
+			assert.Equal(t, 0.0, s.Value)
+		}
+
+		base, _ := a.Baseline("b.example.com")
+		assert.Equal(t, 1000.0, base)
+	})
+	t.Run("zero", func(t *testing.T) {
+		a := NewBaselineAggregator(10*time.Second, m)
+		a.Add("a.example.com", at(0, 0))
+		_, ok := a.Add("a.example.com", at(time.Minute, 100))
+		assert.False(t, ok)
+	})
+}
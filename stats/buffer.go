@@ -0,0 +1,139 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// What a SampleBuffer does when it's full.
+type OverflowPolicy int
+
+// Possible values for OverflowPolicy.
+const (
+	Block      = OverflowPolicy(iota) // Wait for room; this slows down the caller
+	DropOldest                        // Discard the oldest buffered sample to make room
+)
+
+// A SampleBuffer decouples emitting samples from processing them: samples are handed
+// to a background goroutine, which flushes them in batches when enough have piled up,
+// or when an interval has passed, whichever comes first.
+type SampleBuffer struct {
+	Overflow OverflowPolicy
+
+	size     int
+	interval time.Duration
+	ch       chan Sample
+	done     chan struct{}
+	dropped  int64
+}
+
+// NewSampleBuffer creates a buffer holding up to size samples, flushed at least every
+// interval. Overflow may be changed before the buffer is used; it defaults to Block.
+func NewSampleBuffer(size int, interval time.Duration) *SampleBuffer {
+	return &SampleBuffer{
+		size:     size,
+		interval: interval,
+		ch:       make(chan Sample, size),
+		done:     make(chan struct{}),
+	}
+}
+
+// Add buffers samples for the next flush. Once Run has returned, there won't be one, so
+// they're dropped instead, rather than blocking for room forever.
+func (b *SampleBuffer) Add(samples ...Sample) {
+	for _, s := range samples {
+		select {
+		case <-b.done:
+			atomic.AddInt64(&b.dropped, 1)
+			continue
+		default:
+		}
+
+		if b.Overflow == Block {
+			select {
+			case b.ch <- s:
+			case <-b.done:
+				atomic.AddInt64(&b.dropped, 1)
+			}
+			continue
+		}
+
+		for sent := false; !sent; {
+			select {
+			case b.ch <- s:
+				sent = true
+			default:
+				select {
+				case <-b.ch:
+					atomic.AddInt64(&b.dropped, 1)
+				default:
+				}
+			}
+		}
+	}
+}
+
+// Dropped returns the number of samples discarded because the buffer was full, or
+// because they were added after Run returned.
+func (b *SampleBuffer) Dropped() int64 {
+	return atomic.LoadInt64(&b.dropped)
+}
+
+// Run flushes samples until the context is terminated, and then one last time with
+// whatever was left in the buffer. It may only be called once.
+func (b *SampleBuffer) Run(ctx context.Context, flush func([]Sample)) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	defer close(b.done)
+
+	batch := make([]Sample, 0, b.size)
+	doFlush := func() {
+		if len(batch) > 0 {
+			flush(batch)
+			batch = make([]Sample, 0, b.size)
+		}
+	}
+
+	for {
+		select {
+		case s := <-b.ch:
+			batch = append(batch, s)
+			if len(batch) >= b.size {
+				doFlush()
+			}
+		case <-ticker.C:
+			doFlush()
+		case <-ctx.Done():
+			for {
+				select {
+				case s := <-b.ch:
+					batch = append(batch, s)
+				default:
+					doFlush()
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleBuffer(t *testing.T) {
+	run := func(b *SampleBuffer) (context.CancelFunc, <-chan []Sample, *sync.WaitGroup) {
+		ctx, cancel := context.WithCancel(context.Background())
+		flushes := make(chan []Sample, 100)
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			b.Run(ctx, func(samples []Sample) { flushes <- samples })
+			wg.Done()
+		}()
+		return cancel, flushes, wg
+	}
+
+	t.Run("size", func(t *testing.T) {
+		b := NewSampleBuffer(2, 1*time.Hour)
+		cancel, flushes, wg := run(b)
+		defer cancel()
+
+		b.Add(Sample{Value: 1}, Sample{Value: 2})
+		select {
+		case samples := <-flushes:
+			assert.Equal(t, []Sample{{Value: 1}, {Value: 2}}, samples)
+		case <-time.After(1 * time.Second):
+			assert.Fail(t, "not flushed")
+		}
+		cancel()
+		wg.Wait()
+	})
+	t.Run("interval", func(t *testing.T) {
+		b := NewSampleBuffer(100, 10*time.Millisecond)
+		cancel, flushes, wg := run(b)
+		defer cancel()
+
+		b.Add(Sample{Value: 1})
+		select {
+		case samples := <-flushes:
+			assert.Equal(t, []Sample{{Value: 1}}, samples)
+		case <-time.After(1 * time.Second):
+			assert.Fail(t, "not flushed")
+		}
+		cancel()
+		wg.Wait()
+	})
+	t.Run("cancel", func(t *testing.T) {
+		b := NewSampleBuffer(100, 1*time.Hour)
+		cancel, flushes, wg := run(b)
+
+		b.Add(Sample{Value: 1})
+		cancel()
+		wg.Wait()
+		assert.Equal(t, []Sample{{Value: 1}}, <-flushes)
+	})
+	t.Run("DropOldest", func(t *testing.T) {
+		b := NewSampleBuffer(2, 1*time.Hour)
+		b.Overflow = DropOldest
+		b.Add(Sample{Value: 1}, Sample{Value: 2}, Sample{Value: 3})
+		assert.Equal(t, int64(1), b.Dropped())
+
+		cancel, flushes, wg := run(b)
+		cancel()
+		wg.Wait()
+		var values []float64
+		for len(flushes) > 0 {
+			for _, s := range <-flushes {
+				values = append(values, s.Value)
+			}
+		}
+		assert.Equal(t, []float64{2, 3}, values)
+	})
+	t.Run("after Run", func(t *testing.T) {
+		b := NewSampleBuffer(1, 1*time.Hour)
+		cancel, flushes, wg := run(b)
+		cancel()
+		wg.Wait()
+
+		added := make(chan struct{})
+		go func() {
+			b.Add(Sample{Value: 1}, Sample{Value: 2})
+			close(added)
+		}()
+		select {
+		case <-added:
+		case <-time.After(1 * time.Second):
+			assert.Fail(t, "blocked")
+		}
+		assert.Equal(t, int64(2), b.Dropped())
+		assert.Len(t, flushes, 0)
+	})
+}
+
+// A slow consumer, eg. a collector writing to a remote database.
+func slowFlush(samples []Sample) {
+	time.Sleep(time.Duration(len(samples)) * time.Microsecond)
+}
+
+func BenchmarkSampleEmission(b *testing.B) {
+	samples := make([]Sample, 10)
+
+	b.Run("Sync", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			slowFlush(samples)
+		}
+	})
+	b.Run("Buffered", func(b *testing.B) {
+		buf := NewSampleBuffer(1000, 100*time.Millisecond)
+		buf.Overflow = DropOldest
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go buf.Run(ctx, slowFlush)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			buf.Add(samples...)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// A CorrelationAggregator tracks how strongly a number of variables measured together
+// (eg. the phases of a request) correlate with each other, ie. whether they tend to be
+// high or low at the same time. Covariances are accumulated online, with Welford's
+// algorithm, so nothing is retained. It's safe for concurrent use.
+type CorrelationAggregator struct {
+	Names []string
+
+	n        float64
+	mean     []float64
+	comoment [][]float64 // Sums of products of deviations from the mean; upper half only.
+	delta    []float64
+	lock     sync.Mutex
+}
+
+func NewCorrelationAggregator(names ...string) *CorrelationAggregator {
+	a := &CorrelationAggregator{
+		Names:    names,
+		mean:     make([]float64, len(names)),
+		comoment: make([][]float64, len(names)),
+		delta:    make([]float64, len(names)),
+	}
+	for i := range a.comoment {
+		a.comoment[i] = make([]float64, len(names))
+	}
+	return a
+}
+
+// Add records one observation; values are in the same order as Names, and anything
+// with the wrong number of them is ignored.
+func (a *CorrelationAggregator) Add(values ...float64) {
+	if len(values) != len(a.Names) {
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.n++
+	for i, v := range values {
+		a.delta[i] = v - a.mean[i]
+		a.mean[i] += a.delta[i] / a.n
+	}
+	for i := range values {
+		for j := i; j < len(values); j++ {
+			a.comoment[i][j] += a.delta[i] * (values[j] - a.mean[j])
+		}
+	}
+}
+
+// Correlation returns the Pearson correlation coefficient between two of the variables,
+// by index into Names: 1 if they rise and fall together, -1 if one rises as the other
+// falls, 0 if they're unrelated. The bool is false if it's undefined, ie. with fewer than
+// two observations, or if either variable never changed.
+func (a *CorrelationAggregator) Correlation(i, j int) (float64, bool) {
+	if i > j {
+		i, j = j, i
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.n < 2 || a.comoment[i][i] == 0 || a.comoment[j][j] == 0 {
+		return 0, false
+	}
+	r := a.comoment[i][j] / math.Sqrt(a.comoment[i][i]*a.comoment[j][j])
+	return math.Max(-1, math.Min(1, r)), true
+}
+
+// Samples returns one gauge per pair of variables, with their correlation, named eg.
+// "<prefix>connecting_waiting"; undefined ones are left out.
+func (a *CorrelationAggregator) Samples(prefix string, t time.Time) []Sample {
+	var samples []Sample
+	for i := range a.Names {
+		for j := i + 1; j < len(a.Names); j++ {
+			if r, ok := a.Correlation(i, j); ok {
+				m := New(prefix+a.Names[i]+"_"+a.Names[j], Gauge)
+				samples = append(samples, Sample{Metric: m, Time: t, Value: r})
+			}
+		}
+	}
+	return samples
+}
@@ -0,0 +1,125 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrelationAggregator(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	t.Run("empty", func(t *testing.T) {
+		a := NewCorrelationAggregator("x", "y")
+		_, ok := a.Correlation(0, 1)
+		assert.False(t, ok)
+		a.Add(1, 2)
+		_, ok = a.Correlation(0, 1)
+		assert.False(t, ok)
+		assert.Empty(t, a.Samples("", time.Now()))
+	})
+	t.Run("wrong arity", func(t *testing.T) {
+		a := NewCorrelationAggregator("x", "y")
+		a.Add(1, 2, 3)
+		a.Add(1)
+		assert.Equal(t, 0.0, a.n)
+	})
+	t.Run("constant", func(t *testing.T) {
+		a := NewCorrelationAggregator("x", "y")
+		for i := 0; i < 10; i++ {
+			a.Add(float64(i), 5)
+		}
+		_, ok := a.Correlation(0, 1)
+		assert.False(t, ok)
+	})
+	t.Run("linear", func(t *testing.T) {
+		a := NewCorrelationAggregator("x", "up", "down")
+		for i := 0; i < 100; i++ {
+			x := r.Float64() * 100
+			a.Add(x, 2*x+1, 50-x)
+		}
+		up, ok := a.Correlation(0, 1)
+		assert.True(t, ok)
+		assert.InDelta(t, 1, up, 1e-9)
+		down, _ := a.Correlation(0, 2)
+		assert.InDelta(t, -1, down, 1e-9)
+		reversed, _ := a.Correlation(2, 0)
+		assert.Equal(t, down, reversed)
+		self, _ := a.Correlation(1, 1)
+		assert.InDelta(t, 1, self, 1e-9)
+	})
+	t.Run("independent", func(t *testing.T) {
+		a := NewCorrelationAggregator("x", "y")
+		for i := 0; i < 10000; i++ {
+			a.Add(r.NormFloat64(), r.NormFloat64())
+		}
+		c, ok := a.Correlation(0, 1)
+		assert.True(t, ok)
+		assert.InDelta(t, 0, c, 0.05)
+	})
+	t.Run("two-pass", func(t *testing.T) {
+		// Compare to the textbook formula, on correlated data with a large offset,
+		// which naive one-pass sums would lose precision on.
+		var xs, ys []float64
+		a := NewCorrelationAggregator("x", "y")
+		for i := 0; i < 1000; i++ {
+			x := 1e6 + r.NormFloat64()
+			y := 1e6 + 0.5*x - 0.5e6 + r.NormFloat64()
+			xs, ys = append(xs, x), append(ys, y)
+			a.Add(x, y)
+		}
+
+		var mx, my float64
+		for i := range xs {
+			mx += xs[i] / float64(len(xs))
+			my += ys[i] / float64(len(ys))
+		}
+		var sxy, sxx, syy float64
+		for i := range xs {
+			sxy += (xs[i] - mx) * (ys[i] - my)
+			sxx += (xs[i] - mx) * (xs[i] - mx)
+			syy += (ys[i] - my) * (ys[i] - my)
+		}
+		c, _ := a.Correlation(0, 1)
+		assert.InDelta(t, sxy/math.Sqrt(sxx*syy), c, 1e-9)
+	})
+	t.Run("samples", func(t *testing.T) {
+		a := NewCorrelationAggregator("a", "b", "c")
+		for i := 0; i < 10; i++ {
+			a.Add(float64(i), float64(i*i), float64(-i))
+		}
+		now := time.Now()
+		samples := a.Samples("corr_", now)
+		if assert.Len(t, samples, 3) {
+			assert.Equal(t, "corr_a_b", samples[0].Metric.Name)
+			assert.Equal(t, Gauge, samples[0].Metric.Type)
+			assert.Equal(t, "corr_a_c", samples[1].Metric.Name)
+			assert.InDelta(t, -1, samples[1].Value, 1e-9)
+			assert.Equal(t, "corr_b_c", samples[2].Metric.Name)
+			assert.Equal(t, now, samples[2].Time)
+		}
+	})
+}
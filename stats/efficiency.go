@@ -0,0 +1,209 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// EfficiencyWeights configures how a host's connection efficiency score is calculated.
+//
+// The score is a weighted average of three ratios, scaled to 0-100, where higher is better:
+//
+//	reuse   = reused connections / requests
+//	connect = 1 / (1 + average connect time / ConnectReference)
+//	success = 1 - failed requests / requests
+//
+// The connect ratio is 1 for instant connections, and 0.5 at ConnectReference.
+type EfficiencyWeights struct {
+	Reuse, Connect, Success float64
+
+	ConnectReference time.Duration
+}
+
+// DefaultEfficiencyWeights weighs all ratios equally.
+var DefaultEfficiencyWeights = EfficiencyWeights{
+	Reuse:   1,
+	Connect: 1,
+	Success: 1,
+
+	ConnectReference: 100 * time.Millisecond,
+}
+
+// UnmarshalJSON reads weights as eg. {"reuse": 2, "connectReference": "200ms"}; anything
+// left out keeps its value from DefaultEfficiencyWeights.
+func (w *EfficiencyWeights) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Reuse, Connect, Success *float64
+		ConnectReference        *string
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	weights := DefaultEfficiencyWeights
+	if raw.Reuse != nil {
+		weights.Reuse = *raw.Reuse
+	}
+	if raw.Connect != nil {
+		weights.Connect = *raw.Connect
+	}
+	if raw.Success != nil {
+		weights.Success = *raw.Success
+	}
+	if weights.Reuse < 0 || weights.Connect < 0 || weights.Success < 0 {
+		return errors.New("efficiency weights can't be negative")
+	}
+	if raw.ConnectReference != nil {
+		d, err := time.ParseDuration(*raw.ConnectReference)
+		if err != nil {
+			return err
+		}
+		weights.ConnectReference = d
+	}
+	*w = weights
+	return nil
+}
+
+type hostEfficiency struct {
+	requests, reused, failed, connects int64
+	connecting                         time.Duration
+}
+
+// An EfficiencyAggregator calculates a connection efficiency score per group of requests
+// (eg. per host), from whether they reused a connection, how long connecting took if they
+// didn't, and whether they failed. It's safe for concurrent use.
+type EfficiencyAggregator struct {
+	// A Gauge, that samples of the scores are emitted to; tagged with Tag, set to the group.
+	Metric *Metric
+	Tag    string
+
+	Weights EfficiencyWeights
+
+	// Minimum time between two emissions from Tick().
+	Interval time.Duration
+
+	groups   map[string]*hostEfficiency
+	lastTick time.Time
+	lock     sync.Mutex
+}
+
+func NewEfficiencyAggregator(m *Metric, tag string, weights EfficiencyWeights, interval time.Duration) *EfficiencyAggregator {
+	return &EfficiencyAggregator{
+		Metric:   m,
+		Tag:      tag,
+		Weights:  weights,
+		Interval: interval,
+		groups:   make(map[string]*hostEfficiency),
+	}
+}
+
+// Add accounts for a request in the given group.
+func (a *EfficiencyAggregator) Add(group string, reused, failed bool, connecting time.Duration) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	h, ok := a.groups[group]
+	if !ok {
+		h = &hostEfficiency{}
+		a.groups[group] = h
+	}
+	h.requests++
+	if failed {
+		h.failed++
+	}
+	if reused {
+		h.reused++
+	} else if connecting > 0 {
+		h.connects++
+		h.connecting += connecting
+	}
+}
+
+// Scores returns the current score for every group seen so far.
+func (a *EfficiencyAggregator) Scores() map[string]float64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	scores := make(map[string]float64, len(a.groups))
+	for group, h := range a.groups {
+		scores[group] = a.score(h)
+	}
+	return scores
+}
+
+// Tick returns Samples(t), if at least Interval has passed since the last time it did
+// so; otherwise, it returns nothing.
+func (a *EfficiencyAggregator) Tick(t time.Time) []Sample {
+	a.lock.Lock()
+	if t.Sub(a.lastTick) < a.Interval {
+		a.lock.Unlock()
+		return nil
+	}
+	a.lastTick = t
+	a.lock.Unlock()
+
+	return a.Samples(t)
+}
+
+// Samples returns one sample of every group's current score, sorted by group. Tick() only
+// runs as requests finish, so this is also what flushes the last scores at the end.
+func (a *EfficiencyAggregator) Samples(t time.Time) []Sample {
+	scores := a.Scores()
+	groups := make([]string, 0, len(scores))
+	for group := range scores {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	samples := make([]Sample, 0, len(groups))
+	for _, group := range groups {
+		samples = append(samples, Sample{
+			Metric: a.Metric,
+			Time:   t,
+			Tags:   map[string]string{a.Tag: group},
+			Value:  scores[group],
+		})
+	}
+	return samples
+}
+
+func (a *EfficiencyAggregator) score(h *hostEfficiency) float64 {
+	w := a.Weights
+	total := w.Reuse + w.Connect + w.Success
+	if h.requests == 0 || total <= 0 {
+		return 0
+	}
+
+	reuse := float64(h.reused) / float64(h.requests)
+	success := 1 - float64(h.failed)/float64(h.requests)
+	connect := 1.0
+	if h.connects > 0 && w.ConnectReference > 0 {
+		avg := h.connecting / time.Duration(h.connects)
+		connect = 1 / (1 + float64(avg)/float64(w.ConnectReference))
+	}
+	return 100 * (w.Reuse*reuse + w.Connect*connect + w.Success*success) / total
+}
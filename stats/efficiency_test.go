@@ -0,0 +1,97 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEfficiencyWeightsUnmarshalJSON(t *testing.T) {
+	var w EfficiencyWeights
+	assert.NoError(t, json.Unmarshal([]byte(`{"reuse": 2, "connectReference": "200ms"}`), &w))
+	assert.Equal(t, EfficiencyWeights{Reuse: 2, Connect: 1, Success: 1, ConnectReference: 200 * time.Millisecond}, w)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"success": -1}`), &w))
+	assert.Error(t, json.Unmarshal([]byte(`{"connectReference": "soon"}`), &w))
+}
+
+func TestEfficiencyAggregator(t *testing.T) {
+	m := New("efficiency", Gauge)
+	t.Run("perfect", func(t *testing.T) {
+		a := NewEfficiencyAggregator(m, "host", DefaultEfficiencyWeights, time.Second)
+		a.Add("example.com", true, false, 0)
+		assert.InDelta(t, 100.0, a.Scores()["example.com"], 0.001)
+	})
+	t.Run("formula", func(t *testing.T) {
+		a := NewEfficiencyAggregator(m, "host", DefaultEfficiencyWeights, time.Second)
+		a.Add("example.com", false, false, 100*time.Millisecond)
+		a.Add("example.com", true, true, 0)
+
+		// reuse = 0.5, connect = 0.5, success = 0.5
+		assert.InDelta(t, 50.0, a.Scores()["example.com"], 0.001)
+	})
+	t.Run("weights", func(t *testing.T) {
+		a := NewEfficiencyAggregator(m, "host", EfficiencyWeights{Success: 1}, time.Second)
+		a.Add("example.com", false, true, 0)
+		a.Add("example.com", false, false, 0)
+		a.Add("example.com", false, false, 0)
+		a.Add("example.com", false, false, 0)
+		assert.InDelta(t, 75.0, a.Scores()["example.com"], 0.001)
+	})
+	t.Run("groups", func(t *testing.T) {
+		a := NewEfficiencyAggregator(m, "host", DefaultEfficiencyWeights, time.Second)
+		a.Add("a.example.com", true, false, 0)
+		a.Add("b.example.com", false, true, 0)
+		scores := a.Scores()
+		assert.Len(t, scores, 2)
+		assert.True(t, scores["a.example.com"] > scores["b.example.com"])
+	})
+	t.Run("Tick", func(t *testing.T) {
+		a := NewEfficiencyAggregator(m, "host", DefaultEfficiencyWeights, time.Second)
+		a.Add("example.com", true, false, 0)
+
+		now := time.Now()
+		samples := a.Tick(now)
+		if assert.Len(t, samples, 1) {
+			assert.Equal(t, m, samples[0].Metric)
+			assert.Equal(t, "example.com", samples[0].Tags["host"])
+		}
+		assert.Len(t, a.Tick(now.Add(500*time.Millisecond)), 0)
+		assert.Len(t, a.Tick(now.Add(1*time.Second)), 1)
+	})
+	t.Run("Samples", func(t *testing.T) {
+		a := NewEfficiencyAggregator(m, "host", DefaultEfficiencyWeights, time.Second)
+		a.Add("b.example.com", true, false, 0)
+		a.Tick(time.Now())
+		a.Add("a.example.com", true, false, 0)
+
+		// Flushes whatever Tick() hasn't had a chance to emit yet.
+		samples := a.Samples(time.Now())
+		if assert.Len(t, samples, 2) {
+			assert.Equal(t, "a.example.com", samples[0].Tags["host"])
+			assert.Equal(t, "b.example.com", samples[1].Tags["host"])
+		}
+	})
+}
@@ -0,0 +1,87 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"sort"
+	"sync"
+)
+
+// A FairnessAggregator tracks the longest wait each of a number of contenders (eg. VUs
+// waiting for a connection) has had, to tell whether some of them are being starved.
+// It's safe for concurrent use.
+type FairnessAggregator struct {
+	worst map[string]float64
+	lock  sync.Mutex
+}
+
+func NewFairnessAggregator() *FairnessAggregator {
+	return &FairnessAggregator{worst: make(map[string]float64)}
+}
+
+// Add records that key had to wait for v.
+func (a *FairnessAggregator) Add(key string, v float64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if w, ok := a.worst[key]; !ok || v > w {
+		a.worst[key] = v
+	}
+}
+
+// Worst returns the longest wait each key has had.
+func (a *FairnessAggregator) Worst() map[string]float64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	worst := make(map[string]float64, len(a.worst))
+	for k, v := range a.worst {
+		worst[k] = v
+	}
+	return worst
+}
+
+// Ratio returns the worst wait of the worst-off key, divided by the median key's worst
+// wait: 1 if everyone is treated alike, higher the more skewed it is. The bool is
+// false if it's undefined, ie. when there's no data or the median wait is zero.
+func (a *FairnessAggregator) Ratio() (float64, bool) {
+	worst := a.Worst()
+	if len(worst) == 0 {
+		return 0, false
+	}
+
+	values := make([]float64, 0, len(worst))
+	for _, v := range worst {
+		values = append(values, v)
+	}
+	sort.Float64s(values)
+
+	var median float64
+	if l := len(values); l%2 == 0 {
+		median = (values[l/2-1] + values[l/2]) / 2
+	} else {
+		median = values[l/2]
+	}
+	if median == 0 {
+		return 0, false
+	}
+	return values[len(values)-1] / median, true
+}
@@ -0,0 +1,73 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFairnessAggregator(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		_, ok := NewFairnessAggregator().Ratio()
+		assert.False(t, ok)
+	})
+	t.Run("fair", func(t *testing.T) {
+		a := NewFairnessAggregator()
+		for _, key := range []string{"1", "2", "3"} {
+			a.Add(key, 5)
+			a.Add(key, 10)
+		}
+		ratio, ok := a.Ratio()
+		assert.True(t, ok)
+		assert.Equal(t, 1.0, ratio)
+	})
+	t.Run("starved", func(t *testing.T) {
+		a := NewFairnessAggregator()
+		a.Add("1", 10)
+		a.Add("2", 20)
+		a.Add("3", 10)
+		a.Add("3", 100)
+		a.Add("3", 50)
+		assert.Equal(t, map[string]float64{"1": 10, "2": 20, "3": 100}, a.Worst())
+
+		ratio, ok := a.Ratio()
+		assert.True(t, ok)
+		assert.Equal(t, 5.0, ratio)
+	})
+	t.Run("even", func(t *testing.T) {
+		a := NewFairnessAggregator()
+		a.Add("1", 10)
+		a.Add("2", 30)
+		ratio, ok := a.Ratio()
+		assert.True(t, ok)
+		assert.Equal(t, 1.5, ratio)
+	})
+	t.Run("zero median", func(t *testing.T) {
+		a := NewFairnessAggregator()
+		a.Add("1", 0)
+		a.Add("2", 0)
+		a.Add("3", 10)
+		_, ok := a.Ratio()
+		assert.False(t, ok)
+	})
+}
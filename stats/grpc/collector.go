@@ -0,0 +1,374 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/lib/netext"
+	"github.com/loadimpact/k6/stats"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+)
+
+const pushInterval = 1 * time.Second
+
+const (
+	// Trails per TrailBatch, at most; a full batch is pushed right away.
+	defaultBatchSize = 500
+
+	// Trails buffered while the collector is unavailable, or not keeping up; any more
+	// are dropped, and counted in the next TrailBatch.
+	defaultBufferSize = 10000
+)
+
+// Waits between attempts to reach the collector double from the first up to the last.
+const (
+	reconnectMin = 100 * time.Millisecond
+	reconnectMax = 10 * time.Second
+)
+
+// How long the rest of the trails get to go out at the end of the test.
+const shutdownTimeout = 10 * time.Second
+
+// The RPC trails are pushed with; see trail.proto.
+const pushMethod = "/k6.TrailCollector/Push"
+
+// Collector streams the Trail of every request to a gRPC collector, as protobuf messages
+// in a long-running client streaming call (see trail.proto). Trails are batched, and
+// buffered while the collector is down or not keeping up, which makes the stream block;
+// past the buffer size, they're dropped, with a warning. A failed stream is reopened.
+//
+// It's configured as "host:port?tls=true&insecure_skip_verify=false&batch_size=500&
+// buffer_size=10000", where all parameters are optional; without TLS, it speaks h2c.
+type Collector struct {
+	url    string
+	client *http.Client
+
+	batchSize, bufferSize int
+	pushInterval          time.Duration
+
+	trails <-chan netext.TaggedTrail
+
+	queue      []netext.TaggedTrail
+	dropped    int64 // In total.
+	unreported int64 // Not yet counted in a batch.
+	queueLock  sync.Mutex
+	full       chan struct{} // Signalled when a full batch is waiting.
+
+	// Streams are opened under ctx, which is cancelled once time's up at the end.
+	ctx     context.Context
+	stream  *stream
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// Why a stream's writes fail after the collector replied with an OK status.
+var errStreamEnded = errors.New("grpc output: collector ended the stream")
+
+// A single Push call; messages are written to w, and done is closed once it's over.
+type stream struct {
+	w    *io.PipeWriter
+	done chan struct{}
+	err  error // Why it failed, if it did; set before done is closed.
+}
+
+func New(s string, opts lib.Options) (*Collector, error) {
+	addr, query := s, ""
+	if i := strings.IndexByte(s, '?'); i >= 0 {
+		addr, query = s[:i], s[i+1:]
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	useTLS, insecure := true, false
+	c := &Collector{
+		batchSize:    defaultBatchSize,
+		bufferSize:   defaultBufferSize,
+		pushInterval: pushInterval,
+		full:         make(chan struct{}, 1),
+	}
+	for name, dst := range map[string]*bool{"tls": &useTLS, "insecure_skip_verify": &insecure} {
+		if v := params.Get(name); v != "" {
+			if *dst, err = strconv.ParseBool(v); err != nil {
+				return nil, errors.Wrapf(err, "grpc output: invalid %s", name)
+			}
+		}
+	}
+	for name, dst := range map[string]*int{"batch_size": &c.batchSize, "buffer_size": &c.bufferSize} {
+		if v := params.Get(name); v != "" {
+			if *dst, err = strconv.Atoi(v); err != nil || *dst < 1 {
+				return nil, errors.Errorf("grpc output: invalid %s: %s", name, v)
+			}
+		}
+	}
+
+	if useTLS {
+		c.url = "https://" + addr + pushMethod
+		c.client = &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: insecure},
+			ForceAttemptHTTP2: true,
+		}}
+	} else {
+		// net/http's client doesn't do h2c, so this takes x/net's, over a plain connection.
+		c.url = "http://" + addr + pushMethod
+		c.client = &http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		}}
+	}
+	return c, nil
+}
+
+func (c *Collector) Init() {
+}
+
+func (c *Collector) String() string {
+	return fmt.Sprintf("grpc (%s)", strings.TrimSuffix(c.url, pushMethod))
+}
+
+// Samples aren't pushed; only trails are.
+func (c *Collector) Collect(samples []stats.Sample) {
+}
+
+func (c *Collector) CollectTrails(trails <-chan netext.TaggedTrail) {
+	c.trails = trails
+}
+
+func (c *Collector) Run(ctx context.Context) {
+	var cancel context.CancelFunc
+	c.ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	// Give up on a collector that's holding things up, once the test is over.
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		<-ctx.Done()
+		select {
+		case <-time.After(shutdownTimeout):
+			cancel()
+		case <-finished:
+		}
+	}()
+
+	received := make(chan struct{})
+	go func() {
+		c.receive()
+		close(received)
+	}()
+
+	ticker := time.NewTicker(c.pushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.shutdown(received)
+			return
+		case <-ticker.C:
+		case <-c.full:
+		}
+		if time.Now().Before(c.retryAt) {
+			continue
+		}
+		if err := c.flush(); err != nil {
+			c.fail(err)
+		}
+	}
+}
+
+// Dropped returns how many trails have been dropped so far.
+func (c *Collector) Dropped() int64 {
+	c.queueLock.Lock()
+	defer c.queueLock.Unlock()
+	return c.dropped
+}
+
+// Moves trails from the channel to the queue, until it's closed.
+func (c *Collector) receive() {
+	if c.trails == nil {
+		return
+	}
+	for tt := range c.trails {
+		c.queueLock.Lock()
+		if len(c.queue) < c.bufferSize {
+			c.queue = append(c.queue, tt)
+		} else {
+			c.dropped++
+			c.unreported++
+		}
+		full := len(c.queue) >= c.batchSize
+		c.queueLock.Unlock()
+
+		if full {
+			select {
+			case c.full <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Pushes everything that's queued up, a batch at a time.
+func (c *Collector) flush() error {
+	for {
+		c.queueLock.Lock()
+		n := len(c.queue)
+		if n > c.batchSize {
+			n = c.batchSize
+		}
+		batch := c.queue[:n:n]
+		c.queue = c.queue[n:]
+		dropped := c.unreported
+		c.unreported = 0
+		c.queueLock.Unlock()
+
+		if len(batch) == 0 && dropped == 0 {
+			return nil
+		}
+		if dropped > 0 {
+			log.WithField("dropped", dropped).Warn("grpc output: dropped trails that the collector couldn't take")
+		}
+		if err := c.send(encodeBatch(batch, dropped)); err != nil {
+			c.requeue(batch, dropped)
+			return err
+		}
+		c.backoff = 0
+	}
+}
+
+// Puts a batch that couldn't be sent back at the front of the queue, as far as it fits.
+func (c *Collector) requeue(batch []netext.TaggedTrail, dropped int64) {
+	c.queueLock.Lock()
+	defer c.queueLock.Unlock()
+
+	if room := c.bufferSize - len(c.queue); len(batch) > room {
+		if room < 0 {
+			room = 0
+		}
+		c.dropped += int64(len(batch) - room)
+		dropped += int64(len(batch) - room)
+		batch = batch[:room]
+	}
+	c.queue = append(batch, c.queue...)
+	c.unreported += dropped
+}
+
+// Writes a message to the stream, opening one if there isn't one, or it's over.
+func (c *Collector) send(msg []byte) error {
+	if c.stream != nil {
+		select {
+		case <-c.stream.done:
+			err := c.stream.err
+			c.stream = nil
+			if err != nil {
+				return err
+			}
+		default:
+		}
+	}
+	if c.stream == nil {
+		c.stream = c.open()
+	}
+	if _, err := c.stream.w.Write(netext.GRPCFrame(msg)); err != nil {
+		c.stream = nil
+		return err
+	}
+	return nil
+}
+
+func (c *Collector) open() *stream {
+	pr, pw := io.Pipe()
+	s := &stream{w: pw, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		s.err = c.push(pr)
+		if s.err != nil {
+			_ = pr.CloseWithError(s.err)
+		} else {
+			_ = pr.CloseWithError(errStreamEnded)
+		}
+	}()
+	return s
+}
+
+// Makes a Push call with messages from body; returns nil if it ended with an OK status.
+func (c *Collector) push(body io.Reader) error {
+	req, err := netext.NewGRPCStreamRequest(c.ctx, c.url, body, nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	call, err := netext.ReadGRPCCall(res, 0)
+	if err != nil {
+		return err
+	}
+	if call.Status != netext.GRPCStatusOK {
+		return errors.Errorf("grpc output: collector replied with status %d: %s", call.Status, call.StatusMessage)
+	}
+	return nil
+}
+
+// Backs off after a failed push, before trying again.
+func (c *Collector) fail(err error) {
+	if c.backoff == 0 {
+		c.backoff = reconnectMin
+	} else if c.backoff *= 2; c.backoff > reconnectMax {
+		c.backoff = reconnectMax
+	}
+	c.retryAt = time.Now().Add(c.backoff)
+	log.WithError(err).WithField("retry", c.backoff).Warn("grpc output: couldn't push trails")
+}
+
+// Pushes whatever's left once all trails are in, and ends the stream.
+func (c *Collector) shutdown(received <-chan struct{}) {
+	<-received
+	err := c.flush()
+	if s := c.stream; s != nil {
+		_ = s.w.Close()
+		<-s.done
+		if err == nil {
+			err = s.err
+		}
+	}
+	if err != nil {
+		log.WithError(err).WithField("dropped", len(c.queue)).Warn("grpc output: couldn't push the last trails")
+	}
+}
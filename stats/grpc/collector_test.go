@@ -0,0 +1,382 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package grpc
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/lib/netext"
+	"github.com/stretchr/testify/assert"
+)
+
+// A decoded protobuf field; varint for varints, bytes for anything length-delimited.
+type field struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+func decode(t *testing.T, b []byte) []field {
+	var fields []field
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if !assert.True(t, n > 0, "bad key") {
+			return nil
+		}
+		b = b[n:]
+		f := field{num: int(key >> 3)}
+		switch key & 7 {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(b)
+			b = b[n:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			f.bytes = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// A decoded TrailBatch: the URLs of its trails (from their tags), and the dropped count.
+type batch struct {
+	urls    []string
+	dropped uint64
+}
+
+func decodeBatch(t *testing.T, b []byte) batch {
+	var res batch
+	for _, f := range decode(t, b) {
+		switch f.num {
+		case 1:
+			for _, tf := range decode(t, f.bytes) {
+				if tf.num == 17 {
+					entry := decode(t, tf.bytes)
+					if string(entry[0].bytes) == "url" {
+						res.urls = append(res.urls, string(entry[1].bytes))
+					}
+				}
+			}
+		case 2:
+			res.dropped = f.varint
+		}
+	}
+	return res
+}
+
+// A gRPC collector implementing TrailCollector.Push, recording the batches of each stream.
+// fail, if set, is called after each batch, and may return a status to end the stream with.
+type testCollector struct {
+	*httptest.Server
+
+	fail    func(stream, batches int) int
+	streams [][]batch
+	lock    sync.Mutex
+}
+
+func newTestCollector(t *testing.T) *testCollector {
+	tc := &testCollector{}
+	tc.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, pushMethod, r.URL.Path)
+		assert.Equal(t, "application/grpc", r.Header.Get("Content-Type"))
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+
+		tc.lock.Lock()
+		stream := len(tc.streams)
+		tc.streams = append(tc.streams, nil)
+		tc.lock.Unlock()
+
+		status := 0
+		for status == 0 {
+			var prefix [5]byte
+			if _, err := io.ReadFull(r.Body, prefix[:]); err != nil {
+				assert.Equal(t, io.EOF, err)
+				break
+			}
+			msg := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+			_, err := io.ReadFull(r.Body, msg)
+			assert.NoError(t, err)
+
+			tc.lock.Lock()
+			tc.streams[stream] = append(tc.streams[stream], decodeBatch(t, msg))
+			if tc.fail != nil {
+				status = tc.fail(stream, len(tc.streams[stream]))
+			}
+			tc.lock.Unlock()
+		}
+		if status == 0 {
+			_, _ = w.Write(netext.GRPCFrame(nil))
+		}
+		w.Header().Set("Grpc-Status", "0")
+		if status != 0 {
+			w.Header().Set("Grpc-Status", "14")
+		}
+	}))
+	tc.EnableHTTP2 = true
+	tc.StartTLS()
+	return tc
+}
+
+func (tc *testCollector) Addr() string {
+	return tc.Listener.Addr().String()
+}
+
+// Returns the URLs pushed on each stream so far.
+func (tc *testCollector) URLs() [][]string {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+
+	urls := make([][]string, len(tc.streams))
+	for i, batches := range tc.streams {
+		for _, b := range batches {
+			urls[i] = append(urls[i], b.urls...)
+		}
+	}
+	return urls
+}
+
+func trail(url string) netext.TaggedTrail {
+	return netext.TaggedTrail{
+		Trail: netext.Trail{StartTime: time.Now(), EndTime: time.Now(), Duration: time.Second},
+		Tags:  map[string]string{"url": url, "method": "GET"},
+	}
+}
+
+func TestNew(t *testing.T) {
+	c, err := New("localhost:4317", lib.Options{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://localhost:4317"+pushMethod, c.url)
+		assert.Equal(t, defaultBatchSize, c.batchSize)
+		assert.Equal(t, defaultBufferSize, c.bufferSize)
+		assert.Equal(t, "grpc (https://localhost:4317)", c.String())
+	}
+
+	c, err = New("localhost:4317?tls=false&batch_size=10&buffer_size=20", lib.Options{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "http://localhost:4317"+pushMethod, c.url)
+		assert.Equal(t, 10, c.batchSize)
+		assert.Equal(t, 20, c.bufferSize)
+	}
+
+	_, err = New("localhost:4317?tls=maybe", lib.Options{})
+	assert.Error(t, err)
+	_, err = New("localhost:4317?batch_size=0", lib.Options{})
+	assert.EqualError(t, err, "grpc output: invalid batch_size: 0")
+}
+
+func TestEncodeTrail(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	tt := netext.TaggedTrail{
+		Trail: netext.Trail{
+			StartTime:      start,
+			Duration:       150 * time.Millisecond,
+			Waiting:        100 * time.Millisecond,
+			ConnID:         7,
+			ConnReused:     true,
+			ConnRemoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 443},
+			ErrorClass:     "read_timeout",
+		},
+		Tags: map[string]string{"status": "200", "method": "GET"},
+	}
+	fields := decode(t, encodeTrail(tt))
+	if !assert.Len(t, fields, 9) {
+		return
+	}
+	assert.Equal(t, field{num: 1, varint: uint64(start.UnixNano())}, fields[0])
+	assert.Equal(t, field{num: 3, varint: uint64(150 * time.Millisecond)}, fields[1])
+	assert.Equal(t, field{num: 7, varint: uint64(100 * time.Millisecond)}, fields[2])
+	assert.Equal(t, field{num: 9, varint: 7}, fields[3])
+	assert.Equal(t, field{num: 10, varint: 1}, fields[4])
+	assert.Equal(t, field{num: 11, bytes: []byte("127.0.0.1:443")}, fields[5])
+	assert.Equal(t, field{num: 16, bytes: []byte("read_timeout")}, fields[6])
+
+	// Tags are sorted map entries.
+	assert.Equal(t, 17, fields[7].num)
+	entry := decode(t, fields[7].bytes)
+	assert.Equal(t, "method", string(entry[0].bytes))
+	assert.Equal(t, "GET", string(entry[1].bytes))
+	assert.Equal(t, "status", string(decode(t, fields[8].bytes)[0].bytes))
+
+	tt.Trail = netext.Trail{
+		Method:          "GET",
+		URL:             "https://example.com/",
+		LookingUp:       1,
+		ProxyHandshake:  2,
+		TLSHandshaking:  3,
+		TLSQueued:       4,
+		ExpectContinue:  5,
+		StreamBlocked:   6,
+		TLSBytesRead:    7,
+		TLSBytesWritten: 8,
+		TimedOut:        true,
+		RetryCount:      2,
+		ConnectRetries:  3,
+		RedirectCount:   1,
+		Proto:           "h3",
+		QUIC0RTT:        true,
+		HTTP3Fallback:   true,
+	}
+	tt.Tags = nil
+	assert.Equal(t, []field{
+		{num: 18, bytes: []byte("GET")},
+		{num: 19, bytes: []byte("https://example.com/")},
+		{num: 20, varint: 1},
+		{num: 21, varint: 2},
+		{num: 22, varint: 3},
+		{num: 23, varint: 4},
+		{num: 24, varint: 5},
+		{num: 25, varint: 6},
+		{num: 26, varint: 7},
+		{num: 27, varint: 8},
+		{num: 28, varint: 1},
+		{num: 29, varint: 2},
+		{num: 30, varint: 3},
+		{num: 31, varint: 1},
+		{num: 32, bytes: []byte("h3")},
+		{num: 33, varint: 1},
+		{num: 34, varint: 1},
+	}, decode(t, encodeTrail(tt)))
+}
+
+func TestCollector(t *testing.T) {
+	// Runs a collector against addr, feeding it trails from the returned channel; the
+	// returned func closes that, and waits for the collector to finish.
+	run := func(addr string) (*Collector, chan<- netext.TaggedTrail, func()) {
+		c, err := New(addr+"?insecure_skip_verify=true&batch_size=2", lib.Options{})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		c.pushInterval = 10 * time.Millisecond
+		trails := make(chan netext.TaggedTrail, 100)
+		c.CollectTrails(trails)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			c.Run(ctx)
+			close(done)
+		}()
+		return c, trails, func() {
+			close(trails)
+			cancel()
+			<-done
+		}
+	}
+	// Waits for cond to become true.
+	eventually := func(cond func() bool) bool {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			if cond() {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+
+	t.Run("push", func(t *testing.T) {
+		tc := newTestCollector(t)
+		defer tc.Close()
+
+		c, trails, stop := run(tc.Addr())
+		for _, url := range []string{"/a", "/b", "/c"} {
+			trails <- trail(url)
+		}
+		assert.True(t, eventually(func() bool { return len(tc.URLs()) > 0 && len(tc.URLs()[0]) == 3 }))
+		trails <- trail("/d")
+		stop()
+
+		assert.Equal(t, [][]string{{"/a", "/b", "/c", "/d"}}, tc.URLs())
+		assert.Equal(t, int64(0), c.Dropped())
+	})
+	t.Run("reconnect", func(t *testing.T) {
+		tc := newTestCollector(t)
+		defer tc.Close()
+		tc.fail = func(stream, batches int) int {
+			if stream == 0 {
+				return 14
+			}
+			return 0
+		}
+
+		_, trails, stop := run(tc.Addr())
+		defer stop()
+		trails <- trail("/first")
+		assert.True(t, eventually(func() bool { return len(tc.URLs()) == 1 }))
+
+		// Whatever went out on the failed stream after the failure may be lost; keep
+		// going until something makes it onto the next one.
+		assert.True(t, eventually(func() bool {
+			trails <- trail("/again")
+			return len(tc.URLs()) == 2 && len(tc.URLs()[1]) > 0
+		}))
+		assert.Equal(t, []string{"/first"}, tc.URLs()[0])
+	})
+	t.Run("unavailable", func(t *testing.T) {
+		tc := newTestCollector(t)
+		defer tc.Close()
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		_ = l.Close()
+
+		c, err := New(l.Addr().String()+"?buffer_size=3", lib.Options{})
+		assert.NoError(t, err)
+		c.ctx = context.Background()
+		trails := make(chan netext.TaggedTrail, 10)
+		for i := 0; i < 10; i++ {
+			trails <- trail(strings.Repeat("/", i+1))
+		}
+		close(trails)
+		c.CollectTrails(trails)
+		c.receive()
+		assert.Equal(t, int64(7), c.Dropped())
+
+		// Buffered trails are kept while the collector is down...
+		assert.Error(t, c.flush())
+		assert.Len(t, c.queue, 3)
+
+		// ...and pushed once it's back, along with how many were dropped.
+		c.url = "https://" + tc.Addr() + pushMethod
+		c.client = tc.Client()
+		assert.NoError(t, c.flush())
+		_ = c.stream.w.Close()
+		<-c.stream.done
+		assert.NoError(t, c.stream.err)
+		if assert.Len(t, tc.streams, 1) && assert.Len(t, tc.streams[0], 1) {
+			assert.Equal(t, []string{"/", "//", "///"}, tc.streams[0][0].urls)
+			assert.Equal(t, uint64(7), tc.streams[0][0].dropped)
+		}
+	})
+}
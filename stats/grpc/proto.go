@@ -0,0 +1,146 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package grpc
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/loadimpact/k6/lib/netext"
+)
+
+// A minimal protobuf encoder, for the messages in trail.proto; field numbers must match.
+
+// Protobuf wire types.
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func appendKey(b []byte, field, wire int) []byte {
+	return appendVarint(b, uint64(field<<3|wire))
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// Fields with zero values are left out, as proto3 does.
+func appendUint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return appendVarint(appendKey(b, field, wireVarint), v)
+}
+
+func appendInt(b []byte, field int, v int64) []byte {
+	return appendUint(b, field, uint64(v))
+}
+
+func appendBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendUint(b, field, 1)
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = appendVarint(appendKey(b, field, wireBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendString(b []byte, field int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	return appendBytes(b, field, []byte(v))
+}
+
+// Encodes a TrailBatch.
+func encodeBatch(trails []netext.TaggedTrail, dropped int64) []byte {
+	var b []byte
+	for _, tt := range trails {
+		b = appendBytes(b, 1, encodeTrail(tt))
+	}
+	return appendUint(b, 2, uint64(dropped))
+}
+
+// Encodes a Trail.
+func encodeTrail(tt netext.TaggedTrail) []byte {
+	tr := tt.Trail
+
+	var b []byte
+	if !tr.StartTime.IsZero() {
+		b = appendInt(b, 1, tr.StartTime.UnixNano())
+	}
+	if !tr.EndTime.IsZero() {
+		b = appendInt(b, 2, tr.EndTime.UnixNano())
+	}
+	b = appendInt(b, 3, int64(tr.Duration))
+	b = appendInt(b, 4, int64(tr.Blocked))
+	b = appendInt(b, 5, int64(tr.Connecting))
+	b = appendInt(b, 6, int64(tr.Sending))
+	b = appendInt(b, 7, int64(tr.Waiting))
+	b = appendInt(b, 8, int64(tr.Receiving))
+	b = appendUint(b, 9, tr.ConnID)
+	b = appendBool(b, 10, tr.ConnReused)
+	if tr.ConnRemoteAddr != nil {
+		b = appendString(b, 11, tr.ConnRemoteAddr.String())
+	}
+	b = appendString(b, 12, tr.NegotiatedProtocol)
+	b = appendInt(b, 13, tr.BytesRead)
+	b = appendInt(b, 14, tr.BytesWritten)
+	b = appendBool(b, 15, tr.Failed)
+	b = appendString(b, 16, tr.ErrorClass)
+
+	// Maps are encoded as repeated key/value messages; sorted, so output is deterministic.
+	keys := make([]string, 0, len(tt.Tags))
+	for k := range tt.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var entry []byte
+		entry = appendBytes(entry, 1, []byte(k))
+		entry = appendBytes(entry, 2, []byte(tt.Tags[k]))
+		b = appendBytes(b, 17, entry)
+	}
+
+	b = appendString(b, 18, tr.Method)
+	b = appendString(b, 19, tr.URL)
+	b = appendInt(b, 20, int64(tr.LookingUp))
+	b = appendInt(b, 21, int64(tr.ProxyHandshake))
+	b = appendInt(b, 22, int64(tr.TLSHandshaking))
+	b = appendInt(b, 23, int64(tr.TLSQueued))
+	b = appendInt(b, 24, int64(tr.ExpectContinue))
+	b = appendInt(b, 25, int64(tr.StreamBlocked))
+	b = appendInt(b, 26, tr.TLSBytesRead)
+	b = appendInt(b, 27, tr.TLSBytesWritten)
+	b = appendBool(b, 28, tr.TimedOut)
+	b = appendInt(b, 29, int64(tr.RetryCount))
+	b = appendInt(b, 30, int64(tr.ConnectRetries))
+	b = appendInt(b, 31, int64(tr.RedirectCount))
+	b = appendString(b, 32, tr.Proto)
+	b = appendBool(b, 33, tr.QUIC0RTT)
+	b = appendBool(b, 34, tr.HTTP3Fallback)
+	return b
+}
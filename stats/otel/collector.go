@@ -0,0 +1,222 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package otel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/lib/netext"
+	"github.com/loadimpact/k6/stats"
+	"github.com/pkg/errors"
+)
+
+const pushInterval = 1 * time.Second
+
+const (
+	// Trails exported per request, at most; a full batch is exported right away.
+	defaultBatchSize = 500
+
+	// Trails buffered while the collector isn't keeping up; any more are dropped.
+	defaultBufferSize = 10000
+)
+
+// How long an export gets.
+const exportTimeout = 10 * time.Second
+
+// Where OTLP/HTTP collectors take traces, unless configured otherwise.
+const tracesPath = "/v1/traces"
+
+// Collector exports every request as an OpenTelemetry trace, over OTLP/HTTP with JSON
+// encoding: a client span for the request, with a child span for each of its phases
+// (blocked, dns, connect, proxy, tls, send, wait, receive) that took any time. With the
+// traceContext option on, the request's span is the one whose traceparent the request
+// was sent with, so the server's spans fall under it.
+//
+// It's configured with the collector's URL; a bare "host:port" is taken as plain HTTP,
+// and without a path, traces go to /v1/traces. A batch that fails to export is dropped,
+// with a warning.
+type Collector struct {
+	url    string
+	client *http.Client
+
+	batchSize, bufferSize int
+	pushInterval          time.Duration
+
+	trails <-chan netext.TaggedTrail
+
+	queue     []netext.TaggedTrail
+	dropped   int64
+	queueLock sync.Mutex
+	full      chan struct{} // Signalled when a full batch is waiting.
+}
+
+func New(s string, opts lib.Options) (*Collector, error) {
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "otel output: invalid URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("otel output: unsupported scheme: %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.Errorf("otel output: no host in URL: %s", s)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = tracesPath
+	}
+	return &Collector{
+		url:          u.String(),
+		client:       &http.Client{Timeout: exportTimeout},
+		batchSize:    defaultBatchSize,
+		bufferSize:   defaultBufferSize,
+		pushInterval: pushInterval,
+		full:         make(chan struct{}, 1),
+	}, nil
+}
+
+func (c *Collector) Init() {
+}
+
+func (c *Collector) String() string {
+	return fmt.Sprintf("otel (%s)", c.url)
+}
+
+// Samples aren't exported; only trails are.
+func (c *Collector) Collect(samples []stats.Sample) {
+}
+
+func (c *Collector) CollectTrails(trails <-chan netext.TaggedTrail) {
+	c.trails = trails
+}
+
+func (c *Collector) Run(ctx context.Context) {
+	received := make(chan struct{})
+	go func() {
+		c.receive()
+		close(received)
+	}()
+
+	ticker := time.NewTicker(c.pushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			<-received
+			c.flush()
+			return
+		case <-ticker.C:
+		case <-c.full:
+		}
+		c.flush()
+	}
+}
+
+// Dropped returns how many trails have been dropped so far, for want of room or because
+// their export failed.
+func (c *Collector) Dropped() int64 {
+	c.queueLock.Lock()
+	defer c.queueLock.Unlock()
+	return c.dropped
+}
+
+// Moves trails from the channel to the queue, until it's closed.
+func (c *Collector) receive() {
+	if c.trails == nil {
+		return
+	}
+	for tt := range c.trails {
+		c.queueLock.Lock()
+		if len(c.queue) < c.bufferSize {
+			c.queue = append(c.queue, tt)
+		} else {
+			c.dropped++
+		}
+		full := len(c.queue) >= c.batchSize
+		c.queueLock.Unlock()
+
+		if full {
+			select {
+			case c.full <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Exports everything that's queued up, a batch at a time.
+func (c *Collector) flush() {
+	for {
+		c.queueLock.Lock()
+		n := len(c.queue)
+		if n > c.batchSize {
+			n = c.batchSize
+		}
+		batch := c.queue[:n:n]
+		c.queue = c.queue[n:]
+		c.queueLock.Unlock()
+
+		if len(batch) == 0 {
+			return
+		}
+		if err := c.export(batch); err != nil {
+			c.queueLock.Lock()
+			c.dropped += int64(len(batch))
+			c.queueLock.Unlock()
+			log.WithError(err).WithField("dropped", len(batch)).Warn("otel output: couldn't export trails")
+		}
+	}
+}
+
+func (c *Collector) export(batch []netext.TaggedTrail) error {
+	var all []span
+	for _, tt := range batch {
+		all = append(all, spans(tt)...)
+	}
+	body, err := json.Marshal(exportSpans(all))
+	if err != nil {
+		return err
+	}
+	res, err := c.client.Post(c.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.Errorf("otel output: collector replied with %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package otel
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/lib/netext"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	c, err := New("localhost:4318", lib.Options{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "http://localhost:4318/v1/traces", c.url)
+		assert.Equal(t, "otel (http://localhost:4318/v1/traces)", c.String())
+	}
+	c, err = New("https://otel.example.com/custom/traces", lib.Options{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://otel.example.com/custom/traces", c.url)
+	}
+	_, err = New("ftp://localhost:4318", lib.Options{})
+	assert.EqualError(t, err, "otel output: unsupported scheme: ftp")
+}
+
+func TestSpans(t *testing.T) {
+	end := time.Unix(1500000000, 0)
+	ms := time.Millisecond
+	names := func(spans []span) (names []string) {
+		for _, s := range spans {
+			names = append(names, s.Name)
+		}
+		return names
+	}
+
+	t.Run("new connection", func(t *testing.T) {
+		tc := netext.NewTraceContext()
+		tt := netext.TaggedTrail{
+			Trail: netext.Trail{
+				EndTime:        end,
+				Method:         "GET",
+				URL:            "https://example.com/",
+				TraceContext:   tc,
+				Blocked:        40 * ms,
+				LookingUp:      5 * ms,
+				Connecting:     10 * ms,
+				TLSHandshaking: 20 * ms,
+				Sending:        22 * ms,
+				Waiting:        30 * ms,
+				Receiving:      8 * ms,
+			},
+			Tags: map[string]string{"method": "GET", "status": "503"},
+		}
+		spans := spans(tt)
+		assert.Equal(t, []string{"HTTP GET", "blocked", "dns", "connect", "tls", "send", "wait", "receive"}, names(spans))
+
+		root := spans[0]
+		assert.Equal(t, tc.TraceID, root.TraceID)
+		assert.Equal(t, tc.SpanID, root.SpanID)
+		assert.True(t, root.Client)
+		assert.True(t, root.Failed)
+		assert.Equal(t, 503, root.StatusCode)
+		assert.Equal(t, "https://example.com/", root.Attributes["url.full"])
+		assert.Equal(t, "GET", root.Attributes["k6.tag.method"])
+		assert.Equal(t, end, root.End)
+		assert.Equal(t, end.Add(-80*ms), root.Start)
+
+		durations := []time.Duration{5 * ms, 5 * ms, 10 * ms, 20 * ms, 2 * ms, 30 * ms, 8 * ms}
+		prev := root.Start
+		for i, s := range spans[1:] {
+			assert.Equal(t, tc.TraceID, s.TraceID, s.Name)
+			assert.Equal(t, root.SpanID, s.ParentID, s.Name)
+			assert.NotEqual(t, root.SpanID, s.SpanID, s.Name)
+			assert.Equal(t, prev, s.Start, s.Name)
+			assert.Equal(t, durations[i], s.End.Sub(s.Start), s.Name)
+			prev = s.End
+		}
+		assert.Equal(t, end, prev)
+	})
+
+	t.Run("reused connection", func(t *testing.T) {
+		tt := netext.TaggedTrail{
+			Trail: netext.Trail{EndTime: end, Sending: ms, Waiting: 10 * ms, Receiving: 2 * ms},
+			Tags:  map[string]string{"method": "POST", "status": "200"},
+		}
+		spans := spans(tt)
+		assert.Equal(t, []string{"HTTP POST", "send", "wait", "receive"}, names(spans))
+		assert.False(t, spans[0].Failed)
+		assert.NotEqual(t, [16]byte{}, spans[0].TraceID)
+	})
+
+	t.Run("clamped", func(t *testing.T) {
+		// Timings that don't add up, eg. from a clock step, can't make spans go backwards.
+		tt := netext.TaggedTrail{
+			Trail: netext.Trail{
+				EndTime:        end,
+				Blocked:        5 * ms,
+				Connecting:     10 * ms,
+				TLSHandshaking: 20 * ms,
+				Sending:        -3 * ms,
+				Waiting:        -ms,
+				Receiving:      4 * ms,
+			},
+			Tags: map[string]string{"method": "GET"},
+		}
+		spans := spans(tt)
+		assert.Equal(t, []string{"HTTP GET", "connect", "tls", "receive"}, names(spans))
+		prev := spans[0].Start
+		for _, s := range spans[1:] {
+			assert.False(t, s.Start.Before(prev), s.Name)
+			assert.False(t, s.End.Before(s.Start), s.Name)
+			prev = s.End
+		}
+		assert.Equal(t, end.Add(-34*ms), spans[0].Start)
+	})
+}
+
+func TestCollector(t *testing.T) {
+	var requests []exportRequest
+	var lock sync.Mutex
+	failing := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, tracesPath, r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var req exportRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		lock.Lock()
+		defer lock.Unlock()
+		if failing {
+			failing = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		requests = append(requests, req)
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, lib.Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	c.batchSize = 2
+	c.pushInterval = time.Hour
+	trails := make(chan netext.TaggedTrail, 10)
+	c.CollectTrails(trails)
+
+	tc := netext.NewTraceContext()
+	for i := 0; i < 5; i++ {
+		trails <- netext.TaggedTrail{
+			Trail: netext.Trail{EndTime: time.Now(), TraceContext: tc, Waiting: time.Millisecond},
+			Tags:  map[string]string{"method": "GET", "status": strconv.Itoa(200 + i)},
+		}
+	}
+	close(trails)
+
+	// Everything gets exported when the test ends, but the first batch is refused.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Run(ctx)
+	assert.Equal(t, int64(2), c.Dropped())
+
+	lock.Lock()
+	defer lock.Unlock()
+	var got []jsonSpan
+	for _, req := range requests {
+		if assert.Len(t, req.ResourceSpans, 1) && assert.Len(t, req.ResourceSpans[0].ScopeSpans, 1) {
+			assert.Equal(t, "service.name", req.ResourceSpans[0].Resource.Attributes[0].Key)
+			got = append(got, req.ResourceSpans[0].ScopeSpans[0].Spans...)
+		}
+	}
+	if !assert.Len(t, got, 6) {
+		return
+	}
+	root, wait := got[0], got[1]
+	assert.Equal(t, hex.EncodeToString(tc.TraceID[:]), root.TraceID)
+	assert.Equal(t, hex.EncodeToString(tc.SpanID[:]), root.SpanID)
+	assert.Equal(t, "", root.ParentSpanID)
+	assert.Equal(t, spanKindClient, root.Kind)
+	assert.Equal(t, "HTTP GET", root.Name)
+	assert.Equal(t, "wait", wait.Name)
+	assert.Equal(t, root.SpanID, wait.ParentSpanID)
+	assert.Equal(t, spanKindInternal, wait.Kind)
+	assert.Equal(t, root.EndTimeUnixNano, wait.EndTimeUnixNano)
+
+	last := root.Attributes[len(root.Attributes)-1]
+	assert.Equal(t, "http.response.status_code", last.Key)
+	assert.Equal(t, "202", *last.Value.IntValue)
+}
@@ -0,0 +1,247 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package otel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/loadimpact/k6/lib/netext"
+)
+
+// A span, before it's encoded. Requests become a client span, with one child per phase.
+type span struct {
+	Name             string
+	TraceID          [16]byte
+	SpanID, ParentID [8]byte
+	Client           bool
+	Start, End       time.Time
+	Attributes       map[string]string
+	StatusCode       int // Of the response, on the request's span; zero if there was none.
+	Failed           bool
+	hasParent        bool
+}
+
+// Phases of a request, in order; their spans are laid end to end, as the Trail only has
+// durations to go by.
+var phaseNames = []string{"blocked", "dns", "connect", "proxy", "tls", "send", "wait", "receive"}
+
+// Returns how long each of phaseNames took. Blocked covers everything up to getting a
+// connection, including making a new one, and Sending includes its TLS handshake; so
+// those are taken out, to leave only the waiting for a connection and the actual writing.
+// Anything that comes out negative is clamped to zero.
+func phaseDurations(tr netext.Trail) []time.Duration {
+	setup := tr.LookingUp + tr.Connecting + tr.ProxyHandshake + tr.TLSHandshaking
+	durations := []time.Duration{
+		tr.Blocked - setup,
+		tr.LookingUp,
+		tr.Connecting,
+		tr.ProxyHandshake,
+		tr.TLSHandshaking,
+		tr.Sending + tr.ExpectContinue - tr.TLSHandshaking,
+		tr.Waiting,
+		tr.Receiving,
+	}
+	for i, d := range durations {
+		if d < 0 {
+			durations[i] = 0
+		}
+	}
+	return durations
+}
+
+// Turns a request into spans: the request's own, first, then one for each phase that took
+// any time. Phases are laid out backwards from the trail's end, so their starts and ends
+// only ever move forward, however the durations add up; the request's span covers them.
+func spans(tt netext.TaggedTrail) []span {
+	tr := tt.Trail
+	root := span{
+		Name:       "HTTP " + tt.Tags["method"],
+		Client:     true,
+		End:        tr.EndTime,
+		Attributes: attributes(tt),
+		Failed:     tr.Failed,
+	}
+	if tr.Method != "" {
+		root.Name = "HTTP " + tr.Method
+	}
+	if tc := tr.TraceContext; tc.Valid() {
+		root.TraceID, root.SpanID = tc.TraceID, tc.SpanID
+	} else {
+		root.TraceID, root.SpanID = randomTraceID(), randomSpanID()
+	}
+	if status, err := strconv.Atoi(tt.Tags["status"]); err == nil && status > 0 {
+		root.StatusCode = status
+		root.Failed = root.Failed || status >= 400
+	}
+
+	durations := phaseDurations(tr)
+	phases := make([]span, 0, len(durations))
+	end := tr.EndTime
+	for i := len(durations) - 1; i >= 0; i-- {
+		if durations[i] == 0 {
+			continue
+		}
+		start := end.Add(-durations[i])
+		phases = append(phases, span{
+			Name:     phaseNames[i],
+			TraceID:  root.TraceID,
+			SpanID:   randomSpanID(),
+			ParentID: root.SpanID,
+			Start:    start,
+			End:      end,
+
+			hasParent: true,
+		})
+		end = start
+	}
+	root.Start = end
+
+	// They were made backwards.
+	result := []span{root}
+	for i := len(phases) - 1; i >= 0; i-- {
+		result = append(result, phases[i])
+	}
+	return result
+}
+
+// The request's span gets the usual HTTP attributes, and its tags under "k6.tag.".
+func attributes(tt netext.TaggedTrail) map[string]string {
+	attrs := make(map[string]string, len(tt.Tags)+2)
+	for k, v := range tt.Tags {
+		attrs["k6.tag."+k] = v
+	}
+	if tt.Trail.Method != "" {
+		attrs["http.request.method"] = tt.Trail.Method
+	}
+	if tt.Trail.URL != "" {
+		attrs["url.full"] = tt.Trail.URL
+	}
+	return attrs
+}
+
+func randomTraceID() (id [16]byte) {
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func randomSpanID() (id [8]byte) {
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+// OTLP's JSON encoding of an ExportTraceServiceRequest, as far as it's used here. IDs
+// are hex, and 64 bit integers decimal strings.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+	scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []jsonSpan `json:"spans"`
+	}
+	scope struct {
+		Name string `json:"name"`
+	}
+	jsonSpan struct {
+		TraceID           string      `json:"traceId"`
+		SpanID            string      `json:"spanId"`
+		ParentSpanID      string      `json:"parentSpanId,omitempty"`
+		Name              string      `json:"name"`
+		Kind              int         `json:"kind"`
+		StartTimeUnixNano string      `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+		Attributes        []keyValue  `json:"attributes,omitempty"`
+		Status            *spanStatus `json:"status,omitempty"`
+	}
+	keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+	anyValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+	spanStatus struct {
+		Code int `json:"code"`
+	}
+)
+
+// Span kinds and status codes, from the OTLP protos.
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+
+	statusCodeError = 2
+)
+
+func stringValue(s string) anyValue { return anyValue{StringValue: &s} }
+
+// Builds the request that exports spans, as coming from a "k6" service.
+func exportSpans(spans []span) exportRequest {
+	out := make([]jsonSpan, len(spans))
+	for i, s := range spans {
+		js := jsonSpan{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			Name:              s.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if s.hasParent {
+			js.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+		}
+		if s.Client {
+			js.Kind = spanKindClient
+		}
+		keys := make([]string, 0, len(s.Attributes))
+		for k := range s.Attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			js.Attributes = append(js.Attributes, keyValue{Key: k, Value: stringValue(s.Attributes[k])})
+		}
+		if s.StatusCode != 0 {
+			code := strconv.Itoa(s.StatusCode)
+			js.Attributes = append(js.Attributes, keyValue{Key: "http.response.status_code", Value: anyValue{IntValue: &code}})
+		}
+		if s.Failed {
+			js.Status = &spanStatus{Code: statusCodeError}
+		}
+		out[i] = js
+	}
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: []keyValue{{Key: "service.name", Value: stringValue("k6")}}},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: "k6"}, Spans: out}},
+	}}}
+}
@@ -22,12 +22,19 @@ package stats
 
 import (
 	"errors"
+	"math"
 	"sort"
+	"strconv"
+	"time"
 )
 
 type Sink interface {
 	Add(s Sample)
 	Format() map[string]float64
+
+	// Reset discards everything that's been added, as if the sink was new, but keeps its
+	// configuration, eg. an ApdexSink's T.
+	Reset()
 }
 
 type CounterSink struct {
@@ -42,6 +49,10 @@ func (c *CounterSink) Format() map[string]float64 {
 	return map[string]float64{"count": c.Value}
 }
 
+func (c *CounterSink) Reset() {
+	*c = CounterSink{}
+}
+
 type GaugeSink struct {
 	Value float64
 }
@@ -54,6 +65,10 @@ func (g *GaugeSink) Format() map[string]float64 {
 	return map[string]float64{"value": g.Value}
 }
 
+func (g *GaugeSink) Reset() {
+	*g = GaugeSink{}
+}
+
 type TrendSink struct {
 	Values []float64
 
@@ -117,6 +132,233 @@ func (t *TrendSink) Format() map[string]float64 {
 	}
 }
 
+func (t *TrendSink) Reset() {
+	*t = TrendSink{}
+}
+
+// Relative error of percentiles estimated by an ApproxTrendSink.
+const approxTrendPrecision = 0.01
+
+// An ApproxTrendSink is like a TrendSink, but doesn't retain its values; percentiles are
+// estimated from logarithmically sized buckets instead, making its memory use bounded.
+type ApproxTrendSink struct {
+	count    uint64
+	min, max float64
+	sum      float64
+
+	nonPositive uint64
+	buckets     map[int]uint64
+}
+
+func (t *ApproxTrendSink) Add(s Sample) {
+	if t.count == 0 || s.Value < t.min {
+		t.min = s.Value
+	}
+	if t.count == 0 || s.Value > t.max {
+		t.max = s.Value
+	}
+	t.count++
+	t.sum += s.Value
+
+	if s.Value <= 0 {
+		t.nonPositive++
+		return
+	}
+	if t.buckets == nil {
+		t.buckets = make(map[int]uint64)
+	}
+	t.buckets[int(math.Ceil(math.Log(s.Value)/math.Log1p(approxTrendPrecision)))]++
+}
+
+func (t *ApproxTrendSink) P(pct float64) float64 {
+	if t.count == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(pct * float64(t.count)))
+	if rank <= t.nonPositive {
+		return t.min
+	}
+	seen := t.nonPositive
+
+	keys := make([]int, 0, len(t.buckets))
+	for k := range t.buckets {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	for _, k := range keys {
+		seen += t.buckets[k]
+		if seen >= rank {
+			return math.Min(math.Max(math.Pow(1+approxTrendPrecision, float64(k)), t.min), t.max)
+		}
+	}
+	return t.max
+}
+
+func (t *ApproxTrendSink) Format() map[string]float64 {
+	var avg float64
+	if t.count > 0 {
+		avg = t.sum / float64(t.count)
+	}
+	return map[string]float64{
+		"min": t.min,
+		"max": t.max,
+		"avg": avg,
+		"med": t.P(0.50),
+		"p90": t.P(0.90),
+		"p95": t.P(0.95),
+		"p99": t.P(0.99),
+	}
+}
+
+func (t *ApproxTrendSink) Reset() {
+	*t = ApproxTrendSink{}
+}
+
+const (
+	// Relative width of a ModalitySink's buckets; coarse, so noise doesn't look like modes.
+	modalityPrecision = 0.1
+
+	// Peaks lower than this fraction of the highest one are noise.
+	modalityMinPeak = 0.05
+
+	// Two peaks are separate modes if the lowest point between them is below this
+	// fraction of the lower one.
+	modalityMaxDip = 0.5
+)
+
+// A ModalitySink detects multimodal distributions, eg. fast cache hits and slow misses,
+// where averages and percentiles are misleading. Like an ApproxTrendSink, it only keeps
+// logarithmically sized buckets; modes are peaks in the (smoothed) histogram that are
+// separated by a deep enough dip.
+type ModalitySink struct {
+	buckets map[int]uint64
+}
+
+func (m *ModalitySink) Add(s Sample) {
+	if s.Value <= 0 {
+		return
+	}
+	if m.buckets == nil {
+		m.buckets = make(map[int]uint64)
+	}
+	m.buckets[int(math.Floor(math.Log(s.Value)/math.Log1p(modalityPrecision)))]++
+}
+
+// Modes returns the approximate centers of the distribution's modes, lowest first.
+func (m *ModalitySink) Modes() []float64 {
+	if len(m.buckets) == 0 {
+		return nil
+	}
+
+	lo, hi := math.MaxInt32, math.MinInt32
+	for k := range m.buckets {
+		if k < lo {
+			lo = k
+		}
+		if k > hi {
+			hi = k
+		}
+	}
+
+	// Smooth with a 3-bucket moving average, padded so edge buckets can be peaks.
+	hist := make([]float64, hi-lo+3)
+	for k, n := range m.buckets {
+		hist[k-lo+1] = float64(n)
+	}
+	smooth := make([]float64, len(hist))
+	var top float64
+	for i := range hist {
+		for j := i - 1; j <= i+1; j++ {
+			if j >= 0 && j < len(hist) {
+				smooth[i] += hist[j] / 3
+			}
+		}
+		top = math.Max(top, smooth[i])
+	}
+
+	// Walk the peaks in order, merging each with the last mode unless there's a dip.
+	var modes []int
+	dip := math.Inf(1)
+	for i := 1; i < len(smooth)-1; i++ {
+		dip = math.Min(dip, smooth[i])
+		if smooth[i] < smooth[i-1] || smooth[i] < smooth[i+1] || smooth[i] < top*modalityMinPeak {
+			continue
+		}
+		if len(modes) > 0 {
+			last := modes[len(modes)-1]
+			if dip >= modalityMaxDip*math.Min(smooth[last], smooth[i]) {
+				if smooth[i] > smooth[last] {
+					modes[len(modes)-1] = i
+				}
+				dip = smooth[i]
+				continue
+			}
+		}
+		modes = append(modes, i)
+		dip = smooth[i]
+	}
+
+	// Smoothing flattens sharp peaks, so center each mode on the fullest raw bucket.
+	centers := make([]float64, len(modes))
+	for i, idx := range modes {
+		if hist[idx-1] > hist[idx] && hist[idx-1] >= hist[idx+1] {
+			idx--
+		} else if hist[idx+1] > hist[idx] {
+			idx++
+		}
+		centers[i] = math.Pow(1+modalityPrecision, float64(idx+lo-1)+0.5)
+	}
+	return centers
+}
+
+func (m *ModalitySink) Format() map[string]float64 {
+	modes := m.Modes()
+	format := map[string]float64{"modes": float64(len(modes))}
+	for i, c := range modes {
+		format["mode"+strconv.Itoa(i+1)] = c
+	}
+	return format
+}
+
+func (m *ModalitySink) Reset() {
+	*m = ModalitySink{}
+}
+
+// An ApdexSink scores time samples (in milliseconds) against a target T, as
+// (satisfied + tolerating/2) / total; satisfied means <= T, tolerating <= 4T.
+type ApdexSink struct {
+	T time.Duration
+
+	Satisfied, Tolerating, Total int64
+}
+
+func (a *ApdexSink) Add(s Sample) {
+	a.Total++
+	switch t := D(a.T); {
+	case s.Value <= t:
+		a.Satisfied++
+	case s.Value <= 4*t:
+		a.Tolerating++
+	}
+}
+
+// Score returns the Apdex score, from 0 (everyone frustrated) to 1; 0 if there's no data.
+func (a *ApdexSink) Score() float64 {
+	if a.Total == 0 {
+		return 0
+	}
+	return (float64(a.Satisfied) + float64(a.Tolerating)/2) / float64(a.Total)
+}
+
+func (a *ApdexSink) Format() map[string]float64 {
+	return map[string]float64{"apdex": a.Score()}
+}
+
+func (a *ApdexSink) Reset() {
+	*a = ApdexSink{T: a.T}
+}
+
 type RateSink struct {
 	Trues int64
 	Total int64
@@ -133,6 +375,10 @@ func (r RateSink) Format() map[string]float64 {
 	return map[string]float64{"rate": float64(r.Trues) / float64(r.Total)}
 }
 
+func (r *RateSink) Reset() {
+	*r = RateSink{}
+}
+
 type DummySink map[string]float64
 
 func (d DummySink) Add(s Sample) {
@@ -142,3 +388,6 @@ func (d DummySink) Add(s Sample) {
 func (d DummySink) Format() map[string]float64 {
 	return map[string]float64(d)
 }
+
+// Reset is a no-op; a dummy sink is a fixed snapshot, not something that's added to.
+func (d DummySink) Reset() {}
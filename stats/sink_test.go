@@ -21,7 +21,11 @@
 package stats
 
 import (
+	"math"
+	"math/rand"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -35,3 +39,159 @@ func TestDummySinkAddPanics(t *testing.T) {
 func TestDummySinkFormatReturnsItself(t *testing.T) {
 	assert.Equal(t, map[string]float64{"a": 1}, DummySink{"a": 1}.Format())
 }
+
+func TestApproxTrendSink(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		sink := &ApproxTrendSink{}
+		assert.Equal(t, 0.0, sink.P(0.5))
+		assert.Equal(t, 0.0, sink.Format()["avg"])
+	})
+	t.Run("values", func(t *testing.T) {
+		sink := &ApproxTrendSink{}
+		values := make([]float64, 10000)
+		for i := range values {
+			values[i] = rand.ExpFloat64() * 100
+			sink.Add(Sample{Value: values[i]})
+		}
+		sort.Float64s(values)
+
+		format := sink.Format()
+		assert.Equal(t, values[0], format["min"])
+		assert.Equal(t, values[len(values)-1], format["max"])
+		for name, pct := range map[string]float64{"med": 0.5, "p90": 0.9, "p95": 0.95, "p99": 0.99} {
+			exact := values[int(pct*float64(len(values)))-1]
+			assert.InDelta(t, exact, format[name], exact*approxTrendPrecision*2, name)
+		}
+		assert.True(t, len(sink.buckets) < len(values)/2, "too many buckets: %d", len(sink.buckets))
+	})
+	t.Run("zero", func(t *testing.T) {
+		sink := &ApproxTrendSink{}
+		sink.Add(Sample{Value: 0})
+		sink.Add(Sample{Value: 0})
+		sink.Add(Sample{Value: 10})
+		assert.Equal(t, 0.0, sink.P(0.5))
+		assert.InDelta(t, 10.0, sink.P(1.0), 10*approxTrendPrecision)
+	})
+}
+
+func TestApdexSink(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		sink := &ApdexSink{T: 100 * time.Millisecond}
+		assert.Equal(t, 0.0, sink.Score())
+	})
+	t.Run("boundaries", func(t *testing.T) {
+		testdata := map[float64][2]int64{
+			0:     {1, 0},
+			100:   {1, 0},
+			100.1: {0, 1},
+			400:   {0, 1},
+			400.1: {0, 0},
+		}
+		for v, counts := range testdata {
+			sink := &ApdexSink{T: 100 * time.Millisecond}
+			sink.Add(Sample{Value: v})
+			assert.Equal(t, counts[0], sink.Satisfied, "satisfied: %v", v)
+			assert.Equal(t, counts[1], sink.Tolerating, "tolerating: %v", v)
+			assert.Equal(t, int64(1), sink.Total)
+		}
+	})
+	t.Run("score", func(t *testing.T) {
+		sink := &ApdexSink{T: 100 * time.Millisecond}
+		for _, v := range []float64{50, 100, 200, 1000} {
+			sink.Add(Sample{Value: v})
+		}
+		assert.Equal(t, 0.625, sink.Score())
+		assert.Equal(t, map[string]float64{"apdex": 0.625}, sink.Format())
+	})
+}
+
+func TestModalitySink(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	normal := func(mean, stddev float64) Sample {
+		return Sample{Value: math.Max(0.1, r.NormFloat64()*stddev+mean)}
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		sink := &ModalitySink{}
+		assert.Empty(t, sink.Modes())
+		assert.Equal(t, map[string]float64{"modes": 0}, sink.Format())
+	})
+	t.Run("unimodal", func(t *testing.T) {
+		sink := &ModalitySink{}
+		for i := 0; i < 10000; i++ {
+			sink.Add(normal(100, 15))
+		}
+		modes := sink.Modes()
+		if assert.Len(t, modes, 1) {
+			assert.InDelta(t, 100, modes[0], 15)
+		}
+	})
+	t.Run("exponential", func(t *testing.T) {
+		sink := &ModalitySink{}
+		for i := 0; i < 10000; i++ {
+			sink.Add(Sample{Value: r.ExpFloat64() * 100})
+		}
+		assert.Len(t, sink.Modes(), 1)
+	})
+	t.Run("bimodal", func(t *testing.T) {
+		sink := &ModalitySink{}
+		for i := 0; i < 8000; i++ {
+			sink.Add(normal(10, 1.5))
+		}
+		for i := 0; i < 2000; i++ {
+			sink.Add(normal(200, 30))
+		}
+		modes := sink.Modes()
+		if assert.Len(t, modes, 2) {
+			assert.InDelta(t, 10, modes[0], 1.5)
+			assert.InDelta(t, 200, modes[1], 30)
+		}
+		format := sink.Format()
+		assert.Equal(t, 2.0, format["modes"])
+		assert.Equal(t, modes[1], format["mode2"])
+	})
+	t.Run("zero", func(t *testing.T) {
+		sink := &ModalitySink{}
+		sink.Add(Sample{Value: 0})
+		assert.Empty(t, sink.Modes())
+	})
+}
+
+func TestSinkReset(t *testing.T) {
+	sinks := map[string]Sink{
+		"counter":      &CounterSink{},
+		"gauge":        &GaugeSink{},
+		"trend":        &TrendSink{},
+		"approx trend": &ApproxTrendSink{},
+		"modality":     &ModalitySink{},
+		"apdex":        &ApdexSink{T: 100 * time.Millisecond},
+		"rate":         &RateSink{},
+	}
+	for name, sink := range sinks {
+		t.Run(name, func(t *testing.T) {
+			empty := sink.Format()
+			for i := 1; i <= 10; i++ {
+				sink.Add(Sample{Value: float64(i * 100)})
+			}
+			assert.NotEqual(t, empty, sink.Format())
+
+			sink.Reset()
+			format := sink.Format()
+			for k, v := range empty {
+				if math.IsNaN(v) {
+					assert.True(t, math.IsNaN(format[k]), k)
+				} else {
+					assert.Equal(t, v, format[k], k)
+				}
+			}
+		})
+	}
+
+	t.Run("apdex keeps T", func(t *testing.T) {
+		sink := &ApdexSink{T: 100 * time.Millisecond}
+		sink.Add(Sample{Value: 50})
+		sink.Reset()
+		sink.Add(Sample{Value: 50})
+		assert.Equal(t, 1.0, sink.Score())
+	})
+}
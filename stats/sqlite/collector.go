@@ -0,0 +1,298 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/lib/netext"
+	"github.com/loadimpact/k6/stats"
+	"github.com/pkg/errors"
+)
+
+const pushInterval = 1 * time.Second
+
+// Rows of each kind buffered while a push is slow, or failing; any more are dropped, with a
+// warning at the next push.
+const defaultBufferSize = 100000
+
+// Times are stored as nanoseconds since the epoch; durations in milliseconds.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS http_reqs (
+		time INTEGER NOT NULL,
+		duration REAL, blocked REAL, connecting REAL, sending REAL, waiting REAL, receiving REAL,
+		data_sent INTEGER, data_received INTEGER,
+		status INTEGER,
+		tags TEXT,
+		trail TEXT
+	)`,
+	`CREATE INDEX IF NOT EXISTS http_reqs_time ON http_reqs (time)`,
+	`CREATE TABLE IF NOT EXISTS samples (
+		time INTEGER NOT NULL,
+		metric TEXT NOT NULL,
+		value REAL,
+		tags TEXT
+	)`,
+	`CREATE INDEX IF NOT EXISTS samples_time ON samples (time)`,
+}
+
+// Collector writes the Trail of every HTTP request to the http_reqs table, with the
+// whole of it marshalled into the trail column, and every sample to the samples table.
+// Both are buffered and written in a single transaction per push, so a slow disk never
+// blocks the VUs; past the buffer size, rows are dropped instead, and counted.
+//
+// The driver needs cgo; see driver.go.
+type Collector struct {
+	path       string
+	db         *sql.DB
+	trails     <-chan netext.TaggedTrail
+	bufferSize int
+
+	buffer     []stats.Sample
+	trailQueue []netext.TaggedTrail
+	dropped    int64 // In total.
+	unreported int64 // Not yet warned about.
+	bufferLock sync.Mutex
+}
+
+func New(path string, opts lib.Options) (*Collector, error) {
+	if !haveDriver {
+		return nil, errors.New("sqlite output: this build of k6 has no SQLite driver, it needs cgo")
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	return newCollector(path, db)
+}
+
+// Creates the tables in db, if they aren't there yet, and returns a Collector writing to it.
+func newCollector(path string, db *sql.DB) (*Collector, error) {
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	return &Collector{path: path, db: db, bufferSize: defaultBufferSize}, nil
+}
+
+func (c *Collector) Init() {
+}
+
+func (c *Collector) String() string {
+	return fmt.Sprintf("sqlite (%s)", c.path)
+}
+
+func (c *Collector) Run(ctx context.Context) {
+	log.WithField("filename", c.path).Debug("SQLite: Writing metrics")
+
+	// The stream's closed once the last request is done, which is before ctx is.
+	received := make(chan struct{})
+	go func() {
+		c.receive()
+		close(received)
+	}()
+
+	ticker := time.NewTicker(pushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.commit()
+		case <-ctx.Done():
+			<-received
+			c.commit()
+			_ = c.db.Close()
+			return
+		}
+	}
+}
+
+func (c *Collector) Collect(samples []stats.Sample) {
+	c.bufferLock.Lock()
+	if room := c.bufferSize - len(c.buffer); len(samples) > room {
+		c.dropped += int64(len(samples) - room)
+		c.unreported += int64(len(samples) - room)
+		samples = samples[:room]
+	}
+	c.buffer = append(c.buffer, samples...)
+	c.bufferLock.Unlock()
+}
+
+func (c *Collector) CollectTrails(trails <-chan netext.TaggedTrail) {
+	c.trails = trails
+}
+
+// Moves trails from the channel to the queue, until it's closed.
+func (c *Collector) receive() {
+	if c.trails == nil {
+		return
+	}
+	for tt := range c.trails {
+		c.bufferLock.Lock()
+		if len(c.trailQueue) < c.bufferSize {
+			c.trailQueue = append(c.trailQueue, tt)
+		} else {
+			c.dropped++
+			c.unreported++
+		}
+		c.bufferLock.Unlock()
+	}
+}
+
+// Dropped returns how many rows, of either table, have been dropped so far.
+func (c *Collector) Dropped() int64 {
+	c.bufferLock.Lock()
+	defer c.bufferLock.Unlock()
+	return c.dropped
+}
+
+func (c *Collector) commit() {
+	c.bufferLock.Lock()
+	samples, trails := c.buffer, c.trailQueue
+	c.buffer, c.trailQueue = nil, nil
+	dropped := c.unreported
+	c.unreported = 0
+	c.bufferLock.Unlock()
+
+	if dropped > 0 {
+		log.WithField("dropped", dropped).Warn("SQLite: Dropped rows that couldn't be written in time")
+	}
+	if len(samples) == 0 && len(trails) == 0 {
+		return
+	}
+
+	startTime := time.Now()
+	if err := c.write(trails, samples); err != nil {
+		log.WithError(err).Error("SQLite: Couldn't write stats")
+		c.bufferLock.Lock()
+		c.dropped += int64(len(trails) + len(samples))
+		c.bufferLock.Unlock()
+		return
+	}
+	log.WithFields(log.Fields{
+		"t":       time.Since(startTime),
+		"trails":  len(trails),
+		"samples": len(samples),
+	}).Debug("SQLite: Batch written!")
+}
+
+func (c *Collector) write(trails []netext.TaggedTrail, samples []stats.Sample) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	reqStmt, err := tx.Prepare(`INSERT INTO http_reqs (time, duration, blocked, connecting, sending, waiting, receiving, data_sent, data_received, status, tags, trail) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	for _, tt := range trails {
+		row, err := newTrailRow(tt)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if _, err := reqStmt.Exec(
+			row.Time.UnixNano(),
+			row.Duration, row.Blocked, row.Connecting, row.Sending, row.Waiting, row.Receiving,
+			row.DataSent, row.DataReceived,
+			row.Status,
+			row.Tags,
+			row.Trail,
+		); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	sampleStmt, err := tx.Prepare(`INSERT INTO samples (time, metric, value, tags) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	for _, sample := range samples {
+		if _, err := sampleStmt.Exec(sample.Time.UnixNano(), sample.Metric.Name, sample.Value, encodeTags(sample.Tags)); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// A trailRow is a request's row in the http_reqs table.
+type trailRow struct {
+	Time time.Time
+
+	Duration, Blocked, Connecting, Sending, Waiting, Receiving float64
+	DataSent, DataReceived                                     int64
+
+	Status int
+	Tags   string
+	Trail  string
+}
+
+// Takes the columns off a trail; the status is the one it was tagged with, if it was.
+func newTrailRow(tt netext.TaggedTrail) (trailRow, error) {
+	tr := tt.Trail
+	data, err := json.Marshal(tr)
+	if err != nil {
+		return trailRow{}, err
+	}
+	status, _ := strconv.Atoi(tt.Tags["status"])
+	return trailRow{
+		Time:         tr.EndTime,
+		Duration:     stats.D(tr.Duration),
+		Blocked:      stats.D(tr.Blocked),
+		Connecting:   stats.D(tr.Connecting),
+		Sending:      stats.D(tr.Sending),
+		Waiting:      stats.D(tr.Waiting),
+		Receiving:    stats.D(tr.Receiving),
+		DataSent:     tr.BytesWritten,
+		DataReceived: tr.BytesRead,
+		Status:       status,
+		Tags:         encodeTags(tt.Tags),
+		Trail:        string(data),
+	}, nil
+}
+
+// Maps are marshalled with sorted keys, so equal tag sets always encode the same.
+func encodeTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return "{}"
+	}
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
@@ -0,0 +1,186 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sqlite
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/lib/netext"
+	"github.com/loadimpact/k6/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTrailRow(t *testing.T) {
+	now := time.Now()
+	row, err := newTrailRow(netext.TaggedTrail{
+		Trail: netext.Trail{
+			EndTime:      now,
+			Method:       "GET",
+			Duration:     100 * time.Millisecond,
+			Waiting:      60 * time.Millisecond,
+			Receiving:    40 * time.Millisecond,
+			BytesRead:    1024,
+			BytesWritten: 128,
+			MetricPrefix: "api_",
+		},
+		Tags: map[string]string{"url": "http://example.com/1", "status": "404"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, now, row.Time)
+	assert.Equal(t, 100.0, row.Duration)
+	assert.Equal(t, 60.0, row.Waiting)
+	assert.Equal(t, 40.0, row.Receiving)
+	assert.Equal(t, int64(1024), row.DataReceived)
+	assert.Equal(t, int64(128), row.DataSent)
+	assert.Equal(t, 404, row.Status)
+	assert.Equal(t, `{"status":"404","url":"http://example.com/1"}`, row.Tags)
+
+	var trail map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(row.Trail), &trail))
+	assert.Equal(t, "GET", trail["method"])
+	assert.Equal(t, 60.0, trail["waiting"])
+}
+
+func TestCollector(t *testing.T) {
+	db, log := openFakeDB(t)
+	c, err := newCollector("test.db", db)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, schema, log.take(), "schema")
+
+	now := time.Now()
+	trails := make(chan netext.TaggedTrail, 3)
+	for i := 0; i < 3; i++ {
+		trails <- netext.TaggedTrail{Trail: netext.Trail{EndTime: now, Duration: time.Second}, Tags: map[string]string{"status": "200"}}
+	}
+	close(trails)
+	c.CollectTrails(trails)
+	c.bufferSize = 2
+	c.receive()
+	c.Collect([]stats.Sample{
+		{Metric: stats.New("my_counter", stats.Counter), Time: now, Value: 1},
+		{Metric: stats.New("my_counter", stats.Counter), Time: now, Value: 2},
+		{Metric: stats.New("my_counter", stats.Counter), Time: now, Value: 3},
+	})
+	assert.Equal(t, int64(2), c.Dropped())
+
+	// Everything buffered goes out in one transaction.
+	c.commit()
+	stmts := log.take()
+	if assert.Len(t, stmts, 6) {
+		assert.Equal(t, "BEGIN", stmts[0])
+		for _, stmt := range stmts[1:3] {
+			assert.Contains(t, stmt, "INSERT INTO http_reqs")
+			assert.Contains(t, stmt, fmt.Sprintf("[%d 1000 ", now.UnixNano()))
+		}
+		for i, stmt := range stmts[3:5] {
+			assert.Contains(t, stmt, "INSERT INTO samples")
+			assert.Contains(t, stmt, fmt.Sprintf("[%d my_counter %d {}]", now.UnixNano(), i+1))
+		}
+		assert.Equal(t, "COMMIT", stmts[5])
+	}
+
+	// And once it's gone, there's nothing left to push.
+	c.commit()
+	assert.Empty(t, log.take())
+}
+
+// A database/sql driver that logs what it's asked to do, instead of doing it.
+type fakeDriver struct{ log *fakeLog }
+
+type fakeLog struct {
+	lock  sync.Mutex
+	stmts []string
+}
+
+func (l *fakeLog) add(stmt string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.stmts = append(l.stmts, stmt)
+}
+
+// Returns what's been logged since the last call.
+func (l *fakeLog) take() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	stmts := l.stmts
+	l.stmts = nil
+	return stmts
+}
+
+var fakeDrivers int
+
+func openFakeDB(t *testing.T) (*sql.DB, *fakeLog) {
+	log := &fakeLog{}
+	fakeDrivers++
+	name := fmt.Sprintf("sqlite_fake_%d", fakeDrivers)
+	sql.Register(name, fakeDriver{log})
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, log
+}
+
+func (d fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{d.log}, nil }
+
+type fakeConn struct{ log *fakeLog }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{c.log, query}, nil
+}
+func (c fakeConn) Close() error { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) {
+	c.log.add("BEGIN")
+	return fakeTx{c.log}, nil
+}
+
+type fakeTx struct{ log *fakeLog }
+
+func (tx fakeTx) Commit() error   { tx.log.add("COMMIT"); return nil }
+func (tx fakeTx) Rollback() error { tx.log.add("ROLLBACK"); return nil }
+
+type fakeStmt struct {
+	log   *fakeLog
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if len(args) == 0 {
+		s.log.add(s.query)
+	} else {
+		s.log.add(fmt.Sprintf("%s %v", s.query, args))
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
@@ -0,0 +1,30 @@
+//go:build cgo
+// +build cgo
+
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sqlite
+
+// The driver's a cgo binding for the SQLite C library, so builds without cgo, like the
+// cross-compiled ones from build-release.sh, go without it; see driver_nocgo.go.
+import _ "github.com/mattn/go-sqlite3"
+
+const haveDriver = true
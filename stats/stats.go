@@ -174,6 +174,7 @@ type Metric struct {
 	Name       string      `json:"-"`
 	Type       MetricType  `json:"type"`
 	Contains   ValueType   `json:"contains"`
+	Unit       Unit        `json:"unit"`
 	Tainted    null.Bool   `json:"tainted"`
 	Sink       Sink        `json:"sink"`
 	Thresholds Thresholds  `json:"thresholds"`
@@ -198,7 +199,7 @@ func New(name string, typ MetricType, t ...ValueType) *Metric {
 	default:
 		return nil
 	}
-	return &Metric{Name: name, Type: typ, Contains: vt, Sink: sink}
+	return &Metric{Name: name, Type: typ, Contains: vt, Unit: UnitFor(typ, vt), Sink: sink}
 }
 
 func (m Metric) HumanizeValue(v float64) string {
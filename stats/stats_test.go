@@ -129,11 +129,12 @@ func TestNew(t *testing.T) {
 	testdata := map[string]struct {
 		Type     MetricType
 		SinkType Sink
+		Unit     Unit
 	}{
-		"Counter": {Counter, &CounterSink{}},
-		"Gauge":   {Gauge, &GaugeSink{}},
-		"Trend":   {Trend, &TrendSink{}},
-		"Rate":    {Rate, &RateSink{}},
+		"Counter": {Counter, &CounterSink{}, UnitCount},
+		"Gauge":   {Gauge, &GaugeSink{}, UnitCount},
+		"Trend":   {Trend, &TrendSink{}, UnitCount},
+		"Rate":    {Rate, &RateSink{}, UnitRate},
 	}
 
 	for name, data := range testdata {
@@ -141,6 +142,7 @@ func TestNew(t *testing.T) {
 			m := New("my_metric", data.Type)
 			assert.Equal(t, "my_metric", m.Name)
 			assert.IsType(t, data.SinkType, m.Sink)
+			assert.Equal(t, data.Unit, m.Unit)
 		})
 	}
 }
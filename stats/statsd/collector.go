@@ -0,0 +1,232 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/stats"
+)
+
+const pushInterval = 1 * time.Second
+
+// Datagrams are filled up to this many bytes by default; small enough to not be
+// fragmented on a typical network, as recommended by Datadog.
+const defaultPayloadSize = 1432
+
+// How a sample's tags are encoded; vanilla StatsD has no notion of tags at all.
+type TagFormat string
+
+const (
+	TagFormatDatadog TagFormat = "datadog" // name:1|c|#k:v,k2:v2
+	TagFormatInflux  TagFormat = "influx"  // name,k=v,k2=v2:1|c (Telegraf)
+	TagFormatNone    TagFormat = "none"    // name:1|c
+)
+
+// Collector sends samples to a StatsD server over UDP, as timers for time trends,
+// histograms for other trends, counters for counters and rates, and gauges for gauges.
+// Samples are buffered, and packed into as few datagrams as possible on every push.
+//
+// It's configured as "host:port?tag_format=datadog&namespace=k6.&payload_size=1432",
+// where all parameters are optional.
+type Collector struct {
+	addr        string
+	conn        net.Conn
+	tagFormat   TagFormat
+	namespace   string
+	payloadSize int
+
+	buffer     []stats.Sample
+	bufferLock sync.Mutex
+}
+
+func New(s string, opts lib.Options) (*Collector, error) {
+	addr, query := s, ""
+	if i := strings.IndexByte(s, '?'); i >= 0 {
+		addr, query = s[:i], s[i+1:]
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Collector{
+		addr:        addr,
+		tagFormat:   TagFormatDatadog,
+		namespace:   params.Get("namespace"),
+		payloadSize: defaultPayloadSize,
+	}
+	if f := params.Get("tag_format"); f != "" {
+		switch TagFormat(f) {
+		case TagFormatDatadog, TagFormatInflux, TagFormatNone:
+			c.tagFormat = TagFormat(f)
+		default:
+			return nil, fmt.Errorf("statsd output: unknown tag format: %s", f)
+		}
+	}
+	if ps := params.Get("payload_size"); ps != "" {
+		size, err := strconv.Atoi(ps)
+		if err != nil {
+			return nil, err
+		}
+		c.payloadSize = size
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return c, nil
+}
+
+func (c *Collector) Init() {
+}
+
+func (c *Collector) String() string {
+	return fmt.Sprintf("statsd (%s)", c.addr)
+}
+
+func (c *Collector) Run(ctx context.Context) {
+	ticker := time.NewTicker(pushInterval)
+	for {
+		select {
+		case <-ticker.C:
+			c.commit()
+		case <-ctx.Done():
+			c.commit()
+			_ = c.conn.Close()
+			return
+		}
+	}
+}
+
+func (c *Collector) Collect(samples []stats.Sample) {
+	c.bufferLock.Lock()
+	c.buffer = append(c.buffer, samples...)
+	c.bufferLock.Unlock()
+}
+
+func (c *Collector) commit() {
+	c.bufferLock.Lock()
+	samples := c.buffer
+	c.buffer = nil
+	c.bufferLock.Unlock()
+
+	lines := make([]string, len(samples))
+	for i, sample := range samples {
+		lines[i] = formatLine(sample, c.namespace, c.tagFormat)
+	}
+	for _, packet := range pack(lines, c.payloadSize) {
+		if _, err := c.conn.Write(packet); err != nil {
+			log.WithError(err).Error("StatsD: Couldn't send metrics")
+			return
+		}
+	}
+}
+
+// Formats a sample as a StatsD line, without a trailing newline.
+func formatLine(sample stats.Sample, namespace string, format TagFormat) string {
+	var typ string
+	switch sample.Metric.Type {
+	case stats.Counter, stats.Rate:
+		typ = "c"
+	case stats.Gauge:
+		typ = "g"
+	case stats.Trend:
+		typ = "h"
+		if sample.Metric.Contains == stats.Time {
+			typ = "ms"
+		}
+	}
+
+	keys := make([]string, 0, len(sample.Tags))
+	for k := range sample.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	buf.WriteString(sanitize(namespace + sample.Metric.Name))
+	if format == TagFormatInflux {
+		for _, k := range keys {
+			buf.WriteString("," + sanitize(k) + "=" + sanitize(sample.Tags[k]))
+		}
+	}
+	buf.WriteString(":" + strconv.FormatFloat(sample.Value, 'f', -1, 64) + "|" + typ)
+	if format == TagFormatDatadog && len(keys) > 0 {
+		for i, k := range keys {
+			if i == 0 {
+				buf.WriteString("|#")
+			} else {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(sanitize(k) + ":" + sanitizeValue(sample.Tags[k]))
+		}
+	}
+	return buf.String()
+}
+
+// Replaces anything that means something in a StatsD line (or either tag format).
+var sanitizer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "=", "_", " ", "_", "\n", "_")
+
+func sanitize(s string) string {
+	return sanitizer.Replace(s)
+}
+
+// Datadog tag values may contain colons, so URLs can be kept as they are.
+var valueSanitizer = strings.NewReplacer("|", "_", "@", "_", "#", "_", ",", "_", "\n", "_")
+
+func sanitizeValue(s string) string {
+	return valueSanitizer.Replace(s)
+}
+
+// Packs newline-separated lines into datagrams of at most size bytes; a line that's too
+// long on its own is sent by itself anyway, as it might still get through.
+func pack(lines []string, size int) [][]byte {
+	var packets [][]byte
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > size {
+			packets = append(packets, append([]byte(nil), buf.Bytes()...))
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		packets = append(packets, buf.Bytes())
+	}
+	return packets
+}
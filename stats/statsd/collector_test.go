@@ -0,0 +1,147 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package statsd
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/lib"
+	"github.com/loadimpact/k6/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatLine(t *testing.T) {
+	duration := stats.New("http_req_duration", stats.Trend, stats.Time)
+	tags := map[string]string{"status": "200", "url": "http://example.com/a,b"}
+
+	testdata := map[string]struct {
+		sample stats.Sample
+		format TagFormat
+		line   string
+	}{
+		"datadog": {
+			stats.Sample{Metric: duration, Value: 12.5, Tags: tags},
+			TagFormatDatadog, "k6.http_req_duration:12.5|ms|#status:200,url:http://example.com/a_b",
+		},
+		"influx": {
+			stats.Sample{Metric: duration, Value: 12.5, Tags: tags},
+			TagFormatInflux, "k6.http_req_duration,status=200,url=http_//example.com/a_b:12.5|ms",
+		},
+		"none": {
+			stats.Sample{Metric: duration, Value: 12.5, Tags: tags},
+			TagFormatNone, "k6.http_req_duration:12.5|ms",
+		},
+		"untagged": {
+			stats.Sample{Metric: duration, Value: 1},
+			TagFormatDatadog, "k6.http_req_duration:1|ms",
+		},
+		"counter": {
+			stats.Sample{Metric: stats.New("data_sent", stats.Counter, stats.Data), Value: 1024},
+			TagFormatDatadog, "k6.data_sent:1024|c",
+		},
+		"gauge": {
+			stats.Sample{Metric: stats.New("vus", stats.Gauge), Value: 10},
+			TagFormatDatadog, "k6.vus:10|g",
+		},
+		"trend": {
+			stats.Sample{Metric: stats.New("my_trend", stats.Trend), Value: 0.25},
+			TagFormatDatadog, "k6.my_trend:0.25|h",
+		},
+		"rate": {
+			stats.Sample{Metric: stats.New("checks", stats.Rate), Value: 1},
+			TagFormatDatadog, "k6.checks:1|c",
+		},
+	}
+	for name, data := range testdata {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, data.line, formatLine(data.sample, "k6.", data.format))
+		})
+	}
+}
+
+func TestPack(t *testing.T) {
+	assert.Empty(t, pack(nil, 10))
+
+	packets := pack([]string{"aaa", "bbb", "ccc", "a-much-longer-line", "d"}, 8)
+	var got []string
+	for _, p := range packets {
+		got = append(got, string(p))
+	}
+	assert.Equal(t, []string{"aaa\nbbb", "ccc", "a-much-longer-line", "d"}, got)
+}
+
+func TestCollector(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = l.Close() }()
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := New(l.LocalAddr().String()+"?tag_format=xml", lib.Options{})
+		assert.Error(t, err)
+		_, err = New(l.LocalAddr().String()+"?payload_size=big", lib.Options{})
+		assert.Error(t, err)
+	})
+
+	c, err := New(l.LocalAddr().String()+"?namespace=k6.&payload_size=100", lib.Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "statsd ("+l.LocalAddr().String()+")", c.String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	reqs := stats.New("http_reqs", stats.Counter)
+	var samples []stats.Sample
+	for i := 0; i < 10; i++ {
+		samples = append(samples, stats.Sample{Metric: reqs, Value: 1, Tags: map[string]string{"status": "200"}})
+	}
+	c.Collect(samples)
+	cancel()
+	<-done
+
+	// 10 lines of 28 bytes each fit three to a 100 byte datagram.
+	var lines []string
+	buf := make([]byte, 1024)
+	for len(lines) < 10 {
+		_ = l.SetReadDeadline(time.Now().Add(1 * time.Second))
+		n, _, err := l.ReadFrom(buf)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, n <= 100, "datagram too big: %d", n)
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+	assert.Len(t, lines, 10)
+	for _, line := range lines {
+		assert.Equal(t, "k6.http_reqs:1|c|#status:200", line)
+	}
+}
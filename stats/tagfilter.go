@@ -0,0 +1,128 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"regexp"
+	"strings"
+)
+
+// What TagFilter.Normalize replaces IDs in URLs with.
+const NormalizedID = ":id"
+
+// Tags whose values are URLs, which TagFilter normalizes if told to.
+var urlTags = []string{"url", "name"}
+
+// Path segments that look like IDs: numbers, and UUIDs.
+var idSegment = regexp.MustCompile(`^(\d+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})$`)
+
+// A TagFilter cuts down on the tags samples carry before they reach outputs, some of which
+// (eg. InfluxDB) keep an index entry per distinct set of tags, and struggle if there are
+// too many: it drops any that aren't in Allowed (unless that's nil), and normalizes URLs
+// in the "url" and "name" tags, if Normalize is set; see NormalizeURL.
+type TagFilter struct {
+	Allowed   map[string]bool
+	Normalize bool
+}
+
+// NewTagFilter returns a TagFilter that keeps only the given tags (all of them, if there
+// are none), or nil if it wouldn't have anything to do.
+func NewTagFilter(allowed []string, normalize bool) *TagFilter {
+	if len(allowed) == 0 && !normalize {
+		return nil
+	}
+	f := &TagFilter{Normalize: normalize}
+	if len(allowed) > 0 {
+		f.Allowed = make(map[string]bool, len(allowed))
+		for _, k := range allowed {
+			f.Allowed[k] = true
+		}
+	}
+	return f
+}
+
+// Apply returns tags as filtered; the same map if there's nothing to change, otherwise a
+// copy, as samples often share theirs. A nil TagFilter lets everything through.
+func (f *TagFilter) Apply(tags map[string]string) map[string]string {
+	if f == nil || !f.changes(tags) {
+		return tags
+	}
+	filtered := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if f.Allowed != nil && !f.Allowed[k] {
+			continue
+		}
+		filtered[k] = v
+	}
+	if f.Normalize {
+		for _, k := range urlTags {
+			if v, ok := filtered[k]; ok {
+				filtered[k] = NormalizeURL(v)
+			}
+		}
+	}
+	return filtered
+}
+
+func (f *TagFilter) changes(tags map[string]string) bool {
+	for k := range tags {
+		if f.Allowed != nil && !f.Allowed[k] {
+			return true
+		}
+	}
+	if f.Normalize {
+		for _, k := range urlTags {
+			if v, ok := tags[k]; ok && NormalizeURL(v) != v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NormalizeURL replaces path segments of a URL that look like IDs, numbers or UUIDs, with
+// NormalizedID, and drops its query string and fragment, so that requests for different
+// resources of the same kind share the same value; eg. both "https://example.com/users/1"
+// and "https://example.com/users/2?expand=true" become "https://example.com/users/:id".
+func NormalizeURL(url string) string {
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		url = url[:i]
+	}
+
+	// Leave the scheme and host alone; a host can be all digits, too (in an IP).
+	start := 0
+	if i := strings.Index(url, "://"); i >= 0 {
+		start = i + len("://")
+		if j := strings.IndexByte(url[start:], '/'); j >= 0 {
+			start += j
+		} else {
+			return url
+		}
+	}
+
+	segments := strings.Split(url[start:], "/")
+	for i, seg := range segments {
+		if idSegment.MatchString(seg) {
+			segments[i] = NormalizedID
+		}
+	}
+	return url[:start] + strings.Join(segments, "/")
+}
@@ -0,0 +1,92 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTagFilter(t *testing.T) {
+	assert.Nil(t, NewTagFilter(nil, false))
+	assert.Equal(t, &TagFilter{Normalize: true}, NewTagFilter(nil, true))
+	assert.Equal(t, &TagFilter{Allowed: map[string]bool{"method": true}}, NewTagFilter([]string{"method"}, false))
+}
+
+func TestTagFilterApply(t *testing.T) {
+	tags := map[string]string{
+		"method": "GET",
+		"status": "200",
+		"url":    "https://example.com/users/123?expand=true",
+		"name":   "https://example.com/users/123?expand=true",
+		"vu":     "5",
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		var f *TagFilter
+		assert.Equal(t, tags, f.Apply(tags))
+	})
+	t.Run("allowed", func(t *testing.T) {
+		f := NewTagFilter([]string{"method", "status", "group"}, false)
+		assert.Equal(t, map[string]string{"method": "GET", "status": "200"}, f.Apply(tags))
+		assert.Len(t, tags, 5, "modified the original")
+	})
+	t.Run("normalize", func(t *testing.T) {
+		f := NewTagFilter(nil, true)
+		filtered := f.Apply(tags)
+		assert.Equal(t, "https://example.com/users/:id", filtered["url"])
+		assert.Equal(t, "https://example.com/users/:id", filtered["name"])
+		assert.Equal(t, "5", filtered["vu"])
+		assert.Equal(t, "https://example.com/users/123?expand=true", tags["url"], "modified the original")
+	})
+	t.Run("both", func(t *testing.T) {
+		f := NewTagFilter([]string{"method", "name"}, true)
+		assert.Equal(t, map[string]string{"method": "GET", "name": "https://example.com/users/:id"}, f.Apply(tags))
+	})
+	t.Run("unchanged", func(t *testing.T) {
+		f := NewTagFilter([]string{"method", "url"}, true)
+		unchanged := map[string]string{"method": "GET", "url": "https://example.com/"}
+		filtered := f.Apply(unchanged)
+		filtered["method"] = "POST"
+		assert.Equal(t, "POST", unchanged["method"], "needlessly copied")
+	})
+}
+
+func TestNormalizeURL(t *testing.T) {
+	testdata := map[string]string{
+		"https://example.com/":                                              "https://example.com/",
+		"https://example.com":                                               "https://example.com",
+		"https://example.com/users/42":                                      "https://example.com/users/:id",
+		"https://example.com/users/42/posts/7":                              "https://example.com/users/:id/posts/:id",
+		"https://example.com/users/42?page=2":                               "https://example.com/users/:id",
+		"https://example.com/users#top":                                     "https://example.com/users",
+		"https://example.com/v2/items/b3c1d2e4-5f6a-4b7c-8d9e-0f1a2b3c4d5e": "https://example.com/v2/items/:id",
+		"http://10.0.0.1:8080/42":                                           "http://10.0.0.1:8080/:id",
+		"/users/42":                                                         "/users/:id",
+		"https://example.com/users/42abc":                                   "https://example.com/users/42abc",
+	}
+	for url, normalized := range testdata {
+		t.Run(url, func(t *testing.T) {
+			assert.Equal(t, normalized, NormalizeURL(url))
+		})
+	}
+}
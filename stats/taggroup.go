@@ -0,0 +1,166 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Groups a TagGroupAggregator keeps by default, before lumping the rest together.
+const DefaultMaxTagGroups = 100
+
+// Name of the group that samples end up in once MaxGroups is hit; real groups' names
+// always contain a ":", so they can't clash with it.
+const OverflowTagGroup = "other"
+
+// Aggregates of each group that TagGroupAggregator.Samples reports.
+var tagGroupKeys = []string{"med", "p90", "p95", "p99"}
+
+// ParseTagGroupSpec parses a group-by spec: tag keys joined by "+", eg. "method+status".
+func ParseTagGroupSpec(spec string) ([]string, error) {
+	keys := strings.Split(spec, "+")
+	seen := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, errors.Errorf("invalid group-by spec '%s': empty tag key", spec)
+		}
+		if seen[key] {
+			return nil, errors.Errorf("invalid group-by spec '%s': '%s' is repeated", spec, key)
+		}
+		seen[key] = true
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+// A TagGroupAggregator groups samples by the values of some of their tags, eg. method and
+// status, and keeps a duration distribution per group, in an ApproxTrendSink; so memory use
+// is bounded by MaxGroups, past which new groups are lumped into OverflowTagGroup, rather
+// than letting a high-cardinality tag (eg. an URL with IDs in it) run away with it.
+// It's safe for concurrent use.
+type TagGroupAggregator struct {
+	Keys      []string
+	MaxGroups int
+
+	groups     map[string]*ApproxTrendSink
+	overflow   ApproxTrendSink
+	overflowed int64
+	lock       sync.Mutex
+}
+
+func NewTagGroupAggregator(spec string, maxGroups int) (*TagGroupAggregator, error) {
+	keys, err := ParseTagGroupSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &TagGroupAggregator{
+		Keys:      keys,
+		MaxGroups: maxGroups,
+		groups:    make(map[string]*ApproxTrendSink),
+	}, nil
+}
+
+// Group returns the name of the group a sample with the given tags belongs in, eg.
+// "method:GET,status:200"; missing tags count as empty.
+func (a *TagGroupAggregator) Group(tags map[string]string) string {
+	parts := make([]string, len(a.Keys))
+	for i, key := range a.Keys {
+		parts[i] = key + ":" + tags[key]
+	}
+	return strings.Join(parts, ",")
+}
+
+func (a *TagGroupAggregator) Add(s Sample) {
+	group := a.Group(s.Tags)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	sink, ok := a.groups[group]
+	if !ok {
+		if a.MaxGroups > 0 && len(a.groups) >= a.MaxGroups {
+			a.overflowed++
+			sink = &a.overflow
+		} else {
+			sink = &ApproxTrendSink{}
+			a.groups[group] = sink
+		}
+	}
+	sink.Add(s)
+}
+
+// Groups returns the names of the groups seen so far, sorted; OverflowTagGroup comes last.
+func (a *TagGroupAggregator) Groups() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	names := make([]string, 0, len(a.groups))
+	for name := range a.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if a.overflowed > 0 {
+		names = append(names, OverflowTagGroup)
+	}
+	return names
+}
+
+// Overflowed returns how many samples were lumped into OverflowTagGroup.
+func (a *TagGroupAggregator) Overflowed() int64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.overflowed
+}
+
+// Format returns a group's aggregates, as an ApproxTrendSink would; nil if it doesn't exist.
+func (a *TagGroupAggregator) Format(group string) map[string]float64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if group == OverflowTagGroup && a.overflowed > 0 {
+		return a.overflow.Format()
+	}
+	sink, ok := a.groups[group]
+	if !ok {
+		return nil
+	}
+	return sink.Format()
+}
+
+// Samples returns a gauge for each group's median and upper percentiles, named after m and
+// the group, eg. "http_req_duration{method:GET,status:200}.p95", of the same value type.
+func (a *TagGroupAggregator) Samples(m *Metric, t time.Time) []Sample {
+	var samples []Sample
+	for _, group := range a.Groups() {
+		format := a.Format(group)
+		for _, key := range tagGroupKeys {
+			gm := New(m.Name+"{"+group+"}."+key, Gauge, m.Contains)
+			samples = append(samples, Sample{Metric: gm, Time: t, Value: format[key]})
+		}
+	}
+	return samples
+}
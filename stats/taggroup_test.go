@@ -0,0 +1,112 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2016 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package stats
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTagGroupSpec(t *testing.T) {
+	keys, err := ParseTagGroupSpec("method+status")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"method", "status"}, keys)
+
+	keys, err = ParseTagGroupSpec(" url ")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"url"}, keys)
+
+	_, err = ParseTagGroupSpec("")
+	assert.EqualError(t, err, "invalid group-by spec '': empty tag key")
+	_, err = ParseTagGroupSpec("method++status")
+	assert.EqualError(t, err, "invalid group-by spec 'method++status': empty tag key")
+	_, err = ParseTagGroupSpec("method+method")
+	assert.EqualError(t, err, "invalid group-by spec 'method+method': 'method' is repeated")
+}
+
+func TestTagGroupAggregator(t *testing.T) {
+	sample := func(v float64, tags map[string]string) Sample {
+		return Sample{Time: time.Now(), Tags: tags, Value: v}
+	}
+
+	t.Run("multi-key", func(t *testing.T) {
+		a, err := NewTagGroupAggregator("method+status", DefaultMaxTagGroups)
+		assert.NoError(t, err)
+		for i := 1; i <= 100; i++ {
+			a.Add(sample(float64(i), map[string]string{"method": "GET", "status": "200", "url": strconv.Itoa(i)}))
+			a.Add(sample(float64(1000+i), map[string]string{"method": "GET", "status": "500"}))
+		}
+		a.Add(sample(5, map[string]string{"method": "POST"}))
+
+		assert.Equal(t, []string{"method:GET,status:200", "method:GET,status:500", "method:POST,status:"}, a.Groups())
+		assert.InDelta(t, 50, a.Format("method:GET,status:200")["med"], 1)
+		assert.InDelta(t, 1095, a.Format("method:GET,status:500")["p95"], 11)
+		assert.Equal(t, 5.0, a.Format("method:POST,status:")["max"])
+		assert.Nil(t, a.Format("method:PUT,status:"))
+		assert.Equal(t, int64(0), a.Overflowed())
+	})
+	t.Run("cardinality guard", func(t *testing.T) {
+		a, err := NewTagGroupAggregator("url", 10)
+		assert.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			a.Add(sample(float64(i), map[string]string{"url": "/item/" + strconv.Itoa(i)}))
+		}
+		// Existing groups keep getting their samples.
+		a.Add(sample(1, map[string]string{"url": "/item/0"}))
+
+		groups := a.Groups()
+		assert.Len(t, groups, 11)
+		assert.Equal(t, OverflowTagGroup, groups[10])
+		assert.Equal(t, int64(990), a.Overflowed())
+		assert.Equal(t, 10.0, a.Format(OverflowTagGroup)["min"])
+		assert.Equal(t, 999.0, a.Format(OverflowTagGroup)["max"])
+		assert.Equal(t, 1.0, a.Format("url:/item/0")["max"])
+	})
+	t.Run("unlimited", func(t *testing.T) {
+		a, _ := NewTagGroupAggregator("url", 0)
+		for i := 0; i < 200; i++ {
+			a.Add(sample(1, map[string]string{"url": strconv.Itoa(i)}))
+		}
+		assert.Len(t, a.Groups(), 200)
+		assert.Equal(t, int64(0), a.Overflowed())
+	})
+	t.Run("samples", func(t *testing.T) {
+		a, _ := NewTagGroupAggregator("status", 1)
+		a.Add(sample(10, map[string]string{"status": "200"}))
+		a.Add(sample(20, map[string]string{"status": "404"}))
+
+		m := New("my_duration", Trend, Time)
+		now := time.Now()
+		samples := a.Samples(m, now)
+		if assert.Len(t, samples, 2*len(tagGroupKeys)) {
+			assert.Equal(t, "my_duration{status:200}.med", samples[0].Metric.Name)
+			assert.Equal(t, Gauge, samples[0].Metric.Type)
+			assert.Equal(t, Time, samples[0].Metric.Contains)
+			assert.Equal(t, now, samples[0].Time)
+			assert.Equal(t, "my_duration{status:200}.p99", samples[3].Metric.Name)
+			assert.Equal(t, "my_duration{other}.med", samples[4].Metric.Name)
+			assert.Equal(t, 20.0, samples[4].Value)
+		}
+	})
+}
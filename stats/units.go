@@ -6,6 +6,32 @@ import (
 
 const timeUnit = time.Millisecond
 
+// A Unit is the unit of measurement a metric's values are emitted in.
+type Unit string
+
+// Possible values for Unit.
+const (
+	UnitCount        Unit = "count" // Plain numbers
+	UnitMilliseconds Unit = "ms"    // Durations, as emitted by D()
+	UnitBytes        Unit = "bytes" // Data amounts
+	UnitRate         Unit = "rate"  // Fractions of non-zero values, 0-1
+)
+
+// UnitFor returns the unit values of the given metric and value types are emitted in.
+func UnitFor(typ MetricType, vt ValueType) Unit {
+	if typ == Rate {
+		return UnitRate
+	}
+	switch vt {
+	case Time:
+		return UnitMilliseconds
+	case Data:
+		return UnitBytes
+	default:
+		return UnitCount
+	}
+}
+
 // D formats a duration for emission.
 // The reverse of D() is ToD().
 func D(d time.Duration) float64 {